@@ -2,9 +2,10 @@ package api
 
 import (
     "context"
-    "crypto/sha256"
-    "encoding/hex"
+    "crypto/ed25519"
     "encoding/json"
+    "errors"
+    "io"
     "net/http"
     "os"
     "strconv"
@@ -13,6 +14,7 @@ import (
 
     "github.com/student/decentralized-wallet/internal/blockchain"
     "github.com/student/decentralized-wallet/internal/db"
+    "github.com/student/decentralized-wallet/internal/miner"
     "github.com/student/decentralized-wallet/internal/utxo"
 )
 
@@ -55,60 +57,86 @@ func makeAdminHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 
+// minerWorkers returns how many goroutines search for a nonce (MINER_WORKERS, default 0 = every core).
+func minerWorkers() int {
+    if v, err := strconv.Atoi(os.Getenv("MINER_WORKERS")); err == nil && v > 0 {
+        return v
+    }
+    return 0
+}
+
+// mineReq is the body of /api/admin/mine. The target comes from the
+// retarget rules, so there is no difficulty to pass.
 type mineReq struct {
-    Difficulty int `json:"difficulty"`
+    // MinerWallet receives the coinbase, as an address or wallet ID; defaults to MINER_WALLET.
+    MinerWallet string `json:"miner_wallet"`
 }
 
-func adminMineHandler(w http.ResponseWriter, r *http.Request) {
-    // collect pending tx ids
-    txIDs, err := db.GetAllPendingTxIDs()
-    if err != nil {
-        http.Error(w, "failed to fetch pending txs: "+err.Error(), http.StatusInternalServerError)
+// adminMineHandler mines one block synchronously. Mining stops when the client
+// goes away or after MINE_TIMEOUT (default 2m); use the background miner
+// (/api/admin/miner/start) for anything long-running.
+func (s *Server) adminMineHandler(w http.ResponseWriter, r *http.Request) {
+    var req mineReq
+    // an empty body is fine: everything has a default
+    dec := json.NewDecoder(r.Body)
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&req); err != nil && err != io.EOF {
+        http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
         return
     }
-    if len(txIDs) == 0 {
-        json.NewEncoder(w).Encode(map[string]string{"status": "no pending txs"})
+    minerWallet := req.MinerWallet
+    if minerWallet == "" {
+        minerWallet = os.Getenv("MINER_WALLET")
+    }
+    wallet, err := s.MinerWalletID(minerWallet)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
 
-    // determine difficulty
-    diff := 5
-    if v := os.Getenv("POW_DIFFICULTY"); v != "" {
-        if d, err := strconv.Atoi(v); err == nil {
-            diff = d
-        }
+    tmpl, err := s.NewTemplate(wallet, false)
+    if err != nil {
+        http.Error(w, "failed to build block template: "+err.Error(), http.StatusInternalServerError)
+        return
     }
-
-    // get latest block index and previous hash from DB (if available)
-    prevHash := ""
-    var index int64 = 1
-    if db.FSClient != nil {
-        if idx, h, err := db.GetLatestBlock(); err == nil {
-            if idx > 0 {
-                index = idx + 1
-                prevHash = h
-            }
-        }
+    if tmpl == nil {
+        json.NewEncoder(w).Encode(map[string]string{"status": "no pending txs"})
+        return
     }
 
-    block := blockchain.CreateBlock(index, prevHash, txIDs, diff)
-
-    // persist block
-    err = db.AddBlock(block.Index, block.Timestamp, block.PreviousHash, block.Hash, block.MerkleRoot, txIDs)
-    if err != nil {
-        http.Error(w, "failed to persist block: "+err.Error(), http.StatusInternalServerError)
+    timeout := 2 * time.Minute
+    if d, err := time.ParseDuration(os.Getenv("MINE_TIMEOUT")); err == nil && d > 0 {
+        timeout = d
+    }
+    ctx, cancel := context.WithTimeout(r.Context(), timeout)
+    defer cancel()
+    if _, err := blockchain.MineBlockParallel(ctx, tmpl.Block, blockchain.MineOptions{Workers: minerWorkers()}, nil); err != nil {
+        http.Error(w, "mining stopped: "+err.Error(), http.StatusServiceUnavailable)
         return
     }
-
-    // move pending txs to mined transactions
-    if err := db.MovePendingToMined(txIDs, block.Hash, block.Index); err != nil {
-        http.Error(w, "failed to move pending txs: "+err.Error(), http.StatusInternalServerError)
+    if err := s.SubmitBlock(tmpl); err != nil {
+        status := http.StatusInternalServerError
+        if errors.Is(err, miner.ErrStaleTemplate) {
+            status = http.StatusConflict
+        }
+        http.Error(w, "failed to commit block: "+err.Error(), status)
         return
     }
 
-    json.NewEncoder(w).Encode(map[string]interface{}{"status": "mined", "block_index": block.Index, "block_hash": block.Hash})
+    block := tmpl.Block
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "status":      "mined",
+        "block_index": block.Index,
+        "block_hash":  block.Hash,
+        "coinbase":    block.Transactions[0].ID,
+        "reward":      tmpl.Reward,
+        "fees":        tmpl.Fees,
+    })
 }
 
+// errNoFundingAuthority is returned when a networked node cannot sign admin funding.
+var errNoFundingAuthority = errors.New("admin funding on a networked node must be mined and needs the network authority's key (AUTHORITY_PRIVATE_KEY)")
+
 type fundReq struct {
     WalletID string `json:"wallet_id"`
     Amount   int64  `json:"amount"`
@@ -145,7 +173,7 @@ func isAdminRequest(r *http.Request) bool {
     return false
 }
 
-func adminFundHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) adminFundHandler(w http.ResponseWriter, r *http.Request) {
     if !isAdminRequest(r) {
         http.Error(w, "admin only", http.StatusForbidden)
         return
@@ -160,31 +188,52 @@ func adminFundHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // create tx id
-    h := sha256.New()
-    h.Write([]byte(fr.WalletID))
-    h.Write([]byte(strconv.FormatInt(fr.Amount, 10)))
-    h.Write([]byte(time.Now().UTC().Format(time.RFC3339Nano)))
-    txid := hex.EncodeToString(h.Sum(nil))
-
-    // create UTXO (index 0)
-    u := utxo.CreateUTXO(txid, 0, fr.WalletID, fr.Amount)
-    // persist utxo
-    _ = db.CreateUTXO(u)
-
-    // create a transaction record (system fund)
+    // create a transaction record (system fund); its id is the canonical txid
+    now := time.Now().UTC()
     t := &utxo.Transaction{
-        ID:        txid,
-        Sender:    "system",
+        Sender:    utxo.SystemSender,
         Receiver:  fr.WalletID,
         Amount:    fr.Amount,
-        Note:      "admin_funding",
-        Timestamp: time.Now().UTC(),
+        Note:      utxo.FundingNote,
+        Timestamp: now,
         Inputs:    []string{},
         Outputs:   []utxo.TxOutput{{Recipient: fr.WalletID, Amount: fr.Amount}},
+        ClientTimestamp: now.Format(time.RFC3339Nano),
+        ChainID:   s.params.ChainID,
+    }
+    t.ID = t.ComputeID()
+    txid := t.ID
+    // create UTXO (index 0)
+    u := t.OutputUTXOs()[0]
+
+    // with the authority's key the funding is mined like any other tx, so
+    // every node learns of it from the block
+    if s.authority != nil {
+        t.Signature = ed25519.Sign(s.authority, t.SigningBytes())
+        if err := s.commitPending(t); err != nil {
+            pendingError(w, err)
+            return
+        }
+        json.NewEncoder(w).Encode(map[string]string{"status": "pending", "tx_id": txid, "utxo_id": u.ID})
+        return
+    }
+    // otherwise it is recorded outside blocks, which only a node on its own
+    // may do: peers would reject its blocks spending the output
+    if s.node != nil {
+        http.Error(w, errNoFundingAuthority.Error(), http.StatusConflict)
+        return
+    }
+
+    // persist utxo
+    if err := s.store.CreateUTXO(u); err != nil {
+        http.Error(w, "failed to persist utxo: "+err.Error(), http.StatusInternalServerError)
+        return
     }
+    utxo.AddUTXO(u)
     // persist transaction record (blockless, block_index=0)
-    _ = db.AddTransactionRecord(t, "", 0)
+    _ = s.store.AddTransactionRecord(t, "", 0)
+    // the cached chain validator's UTXO set predates this allocation
+    s.resetValidator()
 
     json.NewEncoder(w).Encode(map[string]string{"status": "ok", "tx_id": txid, "utxo_id": u.ID})
 }
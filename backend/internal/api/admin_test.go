@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/student/decentralized-wallet/wallet"
+)
+
+func TestAdminMineRefusesDifficulty(t *testing.T) {
+	n := newTestNet(t).node(t, true)
+	miner := newTestWallet(t).id
+	n.fund(t, newTestWallet(t).id, 100)
+	code, body := request(t, n.handler, "POST", "/api/admin/mine", map[string]interface{}{"miner_wallet": miner, "difficulty": 3})
+	if code != http.StatusBadRequest || !strings.Contains(body, "difficulty") {
+		t.Errorf("mine with a difficulty: %d %s", code, body)
+	}
+	if n.height() != 0 {
+		t.Fatalf("a block was mined at height %d", n.height())
+	}
+	if code, body := request(t, n.handler, "POST", "/api/admin/mine", map[string]interface{}{"miner_wallet": miner}); code != http.StatusOK || !strings.Contains(body, `"mined"`) {
+		t.Errorf("mine: %d %s", code, body)
+	}
+	if n.height() != 1 {
+		t.Errorf("height %d after mining, want 1", n.height())
+	}
+}
+
+func TestAdminMineChecksMinerWallet(t *testing.T) {
+	n := newTestNet(t).node(t, true)
+	miner := newTestWallet(t).id
+	addr, err := wallet.EncodeAddress(n.params.AddressPrefix, miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := wallet.EncodeAddress("other", miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MINER_WALLET", "")
+	for _, bad := range []string{"", "miner", miner[:40], addr[:len(addr)-1] + "x", other} {
+		n.fund(t, newTestWallet(t).id, 100)
+		code, body := request(t, n.handler, "POST", "/api/admin/mine", map[string]interface{}{"miner_wallet": bad})
+		if code != http.StatusBadRequest {
+			t.Errorf("mine paying %q: %d %s", bad, code, body)
+		}
+	}
+	if n.height() != 0 {
+		t.Fatalf("a block was mined at height %d", n.height())
+	}
+
+	// an address pays the wallet it carries
+	code, body := request(t, n.handler, "POST", "/api/admin/mine", map[string]interface{}{"miner_wallet": addr})
+	if code != http.StatusOK {
+		t.Fatalf("mine paying an address: %d %s", code, body)
+	}
+	b, err := n.store.GetBlockByIndex(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Transactions[0].Outputs[0].Recipient; got != miner {
+		t.Errorf("coinbase pays %s, want %s", got, miner)
+	}
+}
@@ -0,0 +1,545 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+// ErrInvalidBlock wraps the consensus rule a received block breaks.
+var ErrInvalidBlock = errors.New("invalid block")
+
+// AcceptStatus says what AcceptBlock did with a block.
+type AcceptStatus string
+
+const (
+	// BlockConnected means the block extended the main chain.
+	BlockConnected AcceptStatus = "connected"
+	// BlockSideChain means the block was stored on a branch with less work than the main chain.
+	BlockSideChain AcceptStatus = "side_chain"
+	// BlockReorganized means the block's branch overtook the main chain and replaced it.
+	BlockReorganized AcceptStatus = "reorganized"
+	// BlockDuplicate means the block was already known.
+	BlockDuplicate AcceptStatus = "duplicate"
+)
+
+// loadBlockIndex indexes every stored block, main chain first so it wins
+// ties in work against side branches, and points the tip at the main chain's last block.
+func (s *Server) loadBlockIndex() error {
+	s.index = blockchain.NewBlockIndex()
+	err := s.store.ForEachBlock(func(b *blockchain.Block) error {
+		e, err := s.index.Add(b.Header())
+		if err != nil {
+			return fmt.Errorf("index block %d: %w", b.Index, err)
+		}
+		s.tip = e
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	side, err := s.store.ListSideBlocks()
+	if err != nil {
+		return err
+	}
+	for _, b := range side {
+		if _, err := s.index.Add(b.Header()); err != nil && !errors.Is(err, blockchain.ErrKnownBlock) {
+			log.Printf("chain: skipping side block %d %s: %v", b.Index, b.Hash, err)
+		}
+	}
+	return nil
+}
+
+// validationParams returns the consensus rules for this network. Admin
+// funding transactions are blockless, so their outputs seed the UTXO set.
+func (s *Server) validationParams() (blockchain.ValidationParams, error) {
+	records, err := s.store.GetAllTransactions()
+	if err != nil {
+		return blockchain.ValidationParams{}, err
+	}
+	var allocations []utxo.Transaction
+	for _, t := range records {
+		if t.BlockHash == "" && t.Sender == utxo.SystemSender {
+			allocations = append(allocations, t.Transaction)
+		}
+	}
+	cfg := s.pool.Config()
+	return blockchain.ValidationParams{
+		Genesis:       s.params.Genesis(),
+		Target:        s.params.TargetRules(),
+		ChainID:       s.params.ChainID,
+		AuthorityKey:  s.params.AuthorityKey,
+		ZakatPool:     s.params.ZakatPoolWallet,
+		Rewards:       s.params.RewardSchedule(),
+		MaxBlockTxs:   cfg.MaxBlockTxs,
+		MaxBlockBytes: cfg.MaxBlockBytes,
+		Allocations:   allocations,
+	}, nil
+}
+
+// txParams returns the rules a single transaction is checked against.
+func (s *Server) txParams() blockchain.ValidationParams {
+	return blockchain.ValidationParams{
+		ChainID:      s.params.ChainID,
+		AuthorityKey: s.params.AuthorityKey,
+		ZakatPool:    s.params.ZakatPoolWallet,
+	}
+}
+
+// errReplayDone stops replayMainChain's walk over the stored blocks.
+var errReplayDone = errors.New("replay reached target height")
+
+// replayMainChain returns a validator that has checked the main chain up to
+// and including height.
+func (s *Server) replayMainChain(height int64) (*blockchain.ChainValidator, error) {
+	params, err := s.validationParams()
+	if err != nil {
+		return nil, err
+	}
+	v := blockchain.NewChainValidator(params)
+	err = s.store.ForEachBlock(func(b *blockchain.Block) error {
+		if b.Index > height {
+			return errReplayDone
+		}
+		return v.Check(b)
+	})
+	if r := v.Report(); !r.OK {
+		return nil, fmt.Errorf("stored chain is invalid: %v", invalidBlockError(r))
+	}
+	if err != nil && !errors.Is(err, errReplayDone) {
+		return nil, err
+	}
+	return v, nil
+}
+
+// tipValidator returns the validator positioned at the main chain's tip,
+// replaying the chain if there is none yet.
+func (s *Server) tipValidator() (*blockchain.ChainValidator, error) {
+	if s.validator == nil {
+		v, err := s.replayMainChain(s.tip.Height())
+		if err != nil {
+			return nil, err
+		}
+		s.validator = v
+	}
+	return s.validator, nil
+}
+
+// resetValidator discards the cached validator, e.g. after a funding
+// transaction changed the UTXO set it starts from.
+func (s *Server) resetValidator() {
+	s.chainMu.Lock()
+	s.validator = nil
+	s.chainMu.Unlock()
+}
+
+// blockError is an ErrInvalidBlock naming the transactions at fault, if any.
+type blockError struct {
+	msg   string
+	txIDs []string
+}
+
+func (e *blockError) Error() string { return e.msg }
+
+func (e *blockError) Is(target error) bool { return target == ErrInvalidBlock }
+
+func invalidBlockError(r *blockchain.ValidationReport) error {
+	reasons := make([]string, 0, len(r.Problems))
+	var txIDs []string
+	for _, p := range r.Problems {
+		if p.TxID != "" {
+			reasons = append(reasons, "tx "+p.TxID+": "+p.Reason)
+			txIDs = append(txIDs, p.TxID)
+		} else {
+			reasons = append(reasons, p.Reason)
+		}
+	}
+	var height int64
+	if r.FirstInvalidHeight != nil {
+		height = *r.FirstInvalidHeight
+	}
+	return &blockError{
+		msg:   fmt.Sprintf("%v at height %d: %s", ErrInvalidBlock, height, strings.Join(reasons, "; ")),
+		txIDs: txIDs,
+	}
+}
+
+// AcceptBlock takes a solved block from a miner or peer. A block extending
+// the main chain is validated in full and connected. A block on another
+// branch is stored, and if that branch now has more cumulative work than the
+// main chain the node reorganizes onto it. Blocks whose parent is unknown
+// fail with blockchain.ErrOrphanBlock; consensus failures wrap ErrInvalidBlock.
+func (s *Server) AcceptBlock(b *blockchain.Block) (AcceptStatus, error) {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+	return s.acceptBlockLocked(b)
+}
+
+func (s *Server) acceptBlockLocked(b *blockchain.Block) (AcceptStatus, error) {
+	if s.tip == nil {
+		return "", errors.New("block index is not loaded")
+	}
+	if e := s.index.Get(b.Hash); e != nil {
+		if e.Invalid {
+			return "", fmt.Errorf("%w: block %s was rejected before", ErrInvalidBlock, b.Hash)
+		}
+		return BlockDuplicate, nil
+	}
+	parent := s.index.Get(b.PreviousHash)
+	if parent == nil {
+		return "", fmt.Errorf("block %d (%s): %w", b.Index, b.Hash, blockchain.ErrOrphanBlock)
+	}
+	if parent.Invalid {
+		return "", fmt.Errorf("%w: parent %s was rejected", ErrInvalidBlock, parent.Hash())
+	}
+	if err := s.checkBlockHeader(b, parent); err != nil {
+		return "", err
+	}
+
+	if parent == s.tip {
+		entry, err := s.index.Add(b.Header())
+		if err != nil {
+			return "", err
+		}
+		if err := s.connectTip(b, entry); err != nil {
+			// a block that failed for a reason other than its validity may come again
+			if !errors.Is(err, ErrInvalidBlock) {
+				s.index.Remove(b.Hash)
+			}
+			return "", err
+		}
+		s.miner.NotifyTip(s.tip.Hash())
+		return BlockConnected, nil
+	}
+
+	// a competing branch: keep the block, then follow the most work
+	if err := s.store.StoreSideBlock(b); err != nil {
+		return "", errors.New("failed to persist side block: " + err.Error())
+	}
+	entry, err := s.index.Add(b.Header())
+	if err != nil {
+		return "", err
+	}
+	var invalid error
+	for {
+		best := s.index.Best()
+		if best == nil || best == s.tip || best.ChainWork.Cmp(s.tip.ChainWork) <= 0 {
+			break
+		}
+		// each failure marks a block invalid or drops it, so Best moves on and the loop ends
+		if err := s.reorganize(best); err != nil {
+			if !errors.Is(err, ErrInvalidBlock) {
+				return "", err
+			}
+			invalid = err
+		}
+	}
+	if entry.Invalid || s.index.Get(b.Hash) != entry {
+		return "", invalid
+	}
+	if s.tip == entry {
+		s.miner.NotifyTip(s.tip.Hash())
+		return BlockReorganized, nil
+	}
+	return BlockSideChain, nil
+}
+
+// mainChainHeaders returns up to count main-chain headers from height from,
+// ascending. chainMu must be held.
+func (s *Server) mainChainHeaders(from int64, count int) []blockchain.BlockHeader {
+	if s.tip == nil || count <= 0 || from < 0 || from > s.tip.Height() {
+		return nil
+	}
+	last := from + int64(count) - 1
+	if last > s.tip.Height() {
+		last = s.tip.Height()
+	}
+	headers := make([]blockchain.BlockHeader, last-from+1)
+	for e := s.tip.Ancestor(last); e != nil && e.Height() >= from; e = e.Parent {
+		headers[e.Height()-from] = e.Header
+	}
+	return headers
+}
+
+// checkBlockHeader runs the checks that need only the header chain: hash,
+// proof of work, the target the retarget schedule sets on this branch, and
+// that the txids and the Merkle and witness roots tie the body to the
+// header. They are cheap, so blocks failing them are never stored, and their
+// hash is not held against the genuine block.
+func (s *Server) checkBlockHeader(b *blockchain.Block, parent *blockchain.IndexEntry) error {
+	h := b.Header()
+	if err := s.checkHeader(&h, &parent.Header, indexAncestor(parent)); err != nil {
+		return err
+	}
+	if !b.VerifyTxIDs() {
+		return fmt.Errorf("%w: a transaction id does not match its contents", ErrInvalidBlock)
+	}
+	if !b.VerifyMerkleRoot() {
+		return fmt.Errorf("%w: merkle root does not match transactions", ErrInvalidBlock)
+	}
+	if !b.VerifyWitnessRoot() {
+		return fmt.Errorf("%w: witness root does not match transactions", ErrInvalidBlock)
+	}
+	return nil
+}
+
+// checkHeader validates h as the child of prev. ancestor resolves earlier
+// headers on the same branch for the retarget schedule.
+func (s *Server) checkHeader(h, prev *blockchain.BlockHeader, ancestor func(int64) (*blockchain.BlockHeader, error)) error {
+	err := blockchain.CheckHeader(h, prev, s.params.TargetRules(), ancestor)
+	if errors.Is(err, blockchain.ErrInvalidHeader) {
+		return fmt.Errorf("%w: %v", ErrInvalidBlock, err)
+	}
+	return err
+}
+
+// indexAncestor resolves headers on e's branch by height.
+func indexAncestor(e *blockchain.IndexEntry) func(int64) (*blockchain.BlockHeader, error) {
+	return func(height int64) (*blockchain.BlockHeader, error) {
+		if a := e.Ancestor(height); a != nil {
+			h := a.Header
+			return &h, nil
+		}
+		return nil, fmt.Errorf("block %d not indexed", height)
+	}
+}
+
+// connectTip validates b against the main chain's UTXO set and appends it.
+func (s *Server) connectTip(b *blockchain.Block, entry *blockchain.IndexEntry) error {
+	v, err := s.tipValidator()
+	if err != nil {
+		return err
+	}
+	if err := v.Check(b); err != nil {
+		// a validator stops at its first invalid block, so start a fresh one next time
+		s.validator = nil
+		s.rejectBlock(b)
+		return invalidBlockError(v.Report())
+	}
+	if err := s.connectBlock(b); err != nil {
+		s.validator = nil
+		return err
+	}
+	s.tip = entry
+	return nil
+}
+
+// rejectBlock records that b failed full validation. Its hash commits to
+// everything validation reads, so the hash is invalid for good, with its
+// descendants.
+func (s *Server) rejectBlock(b *blockchain.Block) {
+	s.index.Invalidate(b.Hash)
+}
+
+// reorganize makes the branch ending at to the main chain. The branch is
+// validated from the fork point before anything is touched; if a block fails,
+// it is rejected (see rejectBlock) and the main chain stays as it was.
+// Otherwise the old blocks are disconnected tip first, returning their
+// transactions to the mempool, and the new ones connected. If the switch fails
+// part way, e.g. on a store error, the old branch is connected again.
+func (s *Server) reorganize(to *blockchain.IndexEntry) error {
+	fork := blockchain.FindFork(s.tip, to)
+	if fork == nil {
+		return errors.New("branch does not share a genesis block with the main chain")
+	}
+	branch := blockchain.Branch(fork, to)
+	blocks := make([]*blockchain.Block, 0, len(branch))
+	for _, e := range branch {
+		b, err := s.store.GetBlockByHash(e.Hash())
+		if err != nil {
+			return fmt.Errorf("load block %s: %w", e.Hash(), err)
+		}
+		blocks = append(blocks, b)
+	}
+	v, err := s.replayMainChain(fork.Height())
+	if err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		if err := v.Check(b); err != nil {
+			s.rejectBlock(b)
+			return invalidBlockError(v.Report())
+		}
+	}
+
+	from := s.tip
+	old := blockchain.Branch(fork, s.tip)
+	oldBlocks := make([]*blockchain.Block, 0, len(old))
+	for _, e := range old {
+		b, err := s.store.GetBlockByIndex(e.Height())
+		if err != nil {
+			return fmt.Errorf("load block %d: %w", e.Height(), err)
+		}
+		oldBlocks = append(oldBlocks, b)
+	}
+	// the cached validator is at the old tip; v replaces it once the switch is done
+	s.validator = nil
+	if err := s.switchBranch(fork, branch, blocks); err != nil {
+		// the old branch was valid before, so it can be put back
+		if undo := s.switchBranch(fork, old, oldBlocks); undo != nil {
+			log.Printf("chain: failed to restore the chain at %d %s after a failed reorganization: %v", s.tip.Height(), s.tip.Hash(), undo)
+			return fmt.Errorf("%v; restoring the old chain: %v", err, undo)
+		}
+		return err
+	}
+	s.validator = v
+	log.Printf("chain: reorganized from %d %s to %d %s: fork at %d, %d blocks disconnected, %d connected",
+		from.Height(), from.Hash(), to.Height(), to.Hash(), fork.Height(), len(old), len(blocks))
+	return nil
+}
+
+// switchBranch disconnects the main chain down to fork, tip first, then
+// connects blocks, whose index entries are branch. s.tip follows every step,
+// so after a failure it is where the switch stopped.
+func (s *Server) switchBranch(fork *blockchain.IndexEntry, branch []*blockchain.IndexEntry, blocks []*blockchain.Block) error {
+	for s.tip != fork {
+		b, err := s.store.GetBlockByIndex(s.tip.Height())
+		if err != nil {
+			return fmt.Errorf("load block %d: %w", s.tip.Height(), err)
+		}
+		if b.Hash != s.tip.Hash() {
+			return fmt.Errorf("stored block %d is %s, not the tip %s", b.Index, b.Hash, s.tip.Hash())
+		}
+		if err := s.disconnectBlock(b); err != nil {
+			return fmt.Errorf("disconnect block %d: %w", b.Index, err)
+		}
+		s.tip = s.tip.Parent
+	}
+	for i, b := range blocks {
+		if err := s.connectBlock(b); err != nil {
+			return fmt.Errorf("connect block %d: %w", b.Index, err)
+		}
+		s.tip = branch[i]
+	}
+	return nil
+}
+
+// connectBlock applies a validated block that extends the main chain. Its
+// transactions already in the mempool move to mined; any others are applied
+// from scratch, after dropping pending transactions that conflict with them.
+func (s *Server) connectBlock(b *blockchain.Block) error {
+	if err := s.store.AddBlock(b); err != nil {
+		return errors.New("failed to persist block: " + err.Error())
+	}
+	var mined []string
+	for i := range b.Transactions {
+		t := &b.Transactions[i]
+		pooled := false
+		if !t.IsCoinbase() {
+			if _, pooled = s.pool.Get(t.ID); pooled {
+				s.pool.Remove([]string{t.ID})
+				mined = append(mined, t.ID)
+			}
+			s.dropPending(s.pool.RemoveConflicts(t))
+		}
+		if pooled {
+			continue
+		}
+		// track the nonce of senders who registered on another node
+		if err := s.learnWallet(t); err != nil {
+			return err
+		}
+		if err := s.store.ConfirmTx(t, b.Hash, b.Index); err != nil {
+			return fmt.Errorf("failed to apply tx %s: %w", t.ID, err)
+		}
+		for _, id := range t.Inputs {
+			utxo.MarkUTXOSpent(id)
+		}
+		for _, u := range t.OutputUTXOs() {
+			utxo.AddUTXO(u)
+		}
+	}
+	if len(mined) > 0 {
+		if err := s.store.MovePendingToMined(mined, b.Hash, b.Index); err != nil {
+			return errors.New("failed to move pending txs: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// disconnectBlock takes the main chain's tip block off the chain. Its
+// transactions go back to the mempool, as far as its limits allow; its
+// coinbase is undone, together with any pending transactions spending it.
+func (s *Server) disconnectBlock(b *blockchain.Block) error {
+	var coinbase *utxo.Transaction
+	txs := make([]*utxo.Transaction, 0, len(b.Transactions))
+	for i := range b.Transactions {
+		if t := &b.Transactions[i]; t.IsCoinbase() {
+			coinbase = t
+		} else {
+			txs = append(txs, t)
+		}
+	}
+	if len(txs) > 0 {
+		ids := make([]string, 0, len(txs))
+		for _, t := range txs {
+			ids = append(ids, t.ID)
+		}
+		if err := s.store.MoveMinedToPending(ids); err != nil {
+			return errors.New("failed to return txs to pending: " + err.Error())
+		}
+		s.dropPending(s.pool.Load(txs))
+	}
+	if coinbase != nil {
+		outs := make([]string, 0, len(coinbase.Outputs))
+		for i := range coinbase.Outputs {
+			outs = append(outs, coinbase.OutputUTXOID(i))
+		}
+		s.dropPending(s.pool.RemoveSpenders(outs))
+		if err := s.store.RevertTx(coinbase.ID); err != nil {
+			return errors.New("failed to revert coinbase: " + err.Error())
+		}
+		for _, id := range outs {
+			utxo.RemoveUTXO(id)
+		}
+	}
+	return s.store.DisconnectBlock(b.Index)
+}
+
+// chainTip describes the end of one branch in the block index.
+type chainTip struct {
+	Height    int64  `json:"height"`
+	Hash      string `json:"hash"`
+	ChainWork string `json:"chain_work"`
+	// BranchLength is how many blocks the branch has past the main chain (0 for the main chain).
+	BranchLength int64 `json:"branch_length"`
+	// Status is "active" (the main chain), "valid-fork" or "invalid".
+	Status string `json:"status"`
+}
+
+// chainTipsHandler lists the tip of every known branch, highest first.
+func (s *Server) chainTipsHandler(w http.ResponseWriter, r *http.Request) {
+	s.chainMu.Lock()
+	tips := make([]chainTip, 0)
+	if s.tip != nil {
+		// the active tip has children of its own when a block on it was rejected
+		entries := append([]*blockchain.IndexEntry{s.tip}, s.index.Tips()...)
+		for _, e := range entries {
+			if e == s.tip && len(tips) > 0 {
+				continue
+			}
+			t := chainTip{Height: e.Height(), Hash: e.Hash(), ChainWork: e.ChainWork.String(), Status: "valid-fork"}
+			if fork := blockchain.FindFork(s.tip, e); fork != nil {
+				t.BranchLength = e.Height() - fork.Height()
+			}
+			switch {
+			case e == s.tip:
+				t.Status = "active"
+			case e.Invalid:
+				t.Status = "invalid"
+			}
+			tips = append(tips, t)
+		}
+	}
+	s.chainMu.Unlock()
+	sort.Slice(tips, func(i, j int) bool { return tips[i].Height > tips[j].Height })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tips)
+}
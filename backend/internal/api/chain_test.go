@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/db"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+// withBody returns a copy of b sharing its header, with mutate applied to a
+// copy of its funding transaction.
+func withBody(b *blockchain.Block, mutate func(tx *utxo.Transaction)) *blockchain.Block {
+	c := *b
+	c.Transactions = append([]utxo.Transaction(nil), b.Transactions...)
+	tx := &c.Transactions[1]
+	tx.Outputs = append([]utxo.TxOutput(nil), tx.Outputs...)
+	mutate(tx)
+	return &c
+}
+
+// relayMutated offers b several copies of blk with altered bodies, each of
+// which must be refused without condemning blk's hash, then blk itself.
+func relayMutated(t *testing.T, b *testNode, blk *blockchain.Block) {
+	t.Helper()
+	mutations := map[string]func(tx *utxo.Transaction){
+		"signature": func(tx *utxo.Transaction) { tx.Signature = blk.Transactions[0].Signature },
+		"fee":       func(tx *utxo.Transaction) { tx.Fee++ },
+		"output":    func(tx *utxo.Transaction) { tx.Outputs[0].Amount++ },
+	}
+	for name, mutate := range mutations {
+		if _, err := b.AcceptBlock(withBody(blk, mutate)); !errors.Is(err, ErrInvalidBlock) {
+			t.Fatalf("block with a mutated %s: err = %v, want ErrInvalidBlock", name, err)
+		}
+		if e := b.index.Get(blk.Hash); e != nil && e.Invalid {
+			t.Fatalf("block with a mutated %s marked the real block's hash invalid", name)
+		}
+	}
+	status, err := b.AcceptBlock(blk)
+	if err != nil || status != BlockConnected {
+		t.Fatalf("real block: %s, %v", status, err)
+	}
+	if got := b.height(); got != blk.Index {
+		t.Fatalf("height %d, want %d", got, blk.Index)
+	}
+}
+
+func TestMutatedBodyDoesNotBlockRealBlock(t *testing.T) {
+	net := newTestNet(t)
+	a, b := net.node(t, true), net.node(t, false)
+	a.fund(t, "alice", 1000)
+	blk := a.mine(t)
+	if len(blk.Transactions) != 2 {
+		t.Fatalf("block has %d txs, want the coinbase and funding", len(blk.Transactions))
+	}
+	relayMutated(t, b, blk)
+	b.validate(t)
+}
+
+func TestBlockHeaderHandler(t *testing.T) {
+	n := newTestNet(t).node(t, true)
+	n.fund(t, "alice", 1000)
+	blk := n.mine(t)
+
+	code, body := request(t, n.handler, "GET", "/api/blocks/1/header", nil)
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %s", code, body)
+	}
+	var h blockchain.BlockHeader
+	if err := json.Unmarshal([]byte(body), &h); err != nil {
+		t.Fatal(err)
+	}
+	if h != blk.Header() {
+		t.Errorf("header %+v, want %+v", h, blk.Header())
+	}
+	if h.Nonce != blk.Nonce || h.Bits != blk.Bits || h.Version != blk.Version {
+		t.Errorf("nonce %d bits %08x version %d, want %d %08x %d", h.Nonce, h.Bits, h.Version, blk.Nonce, blk.Bits, blk.Version)
+	}
+	if h.MerkleRoot != blk.MerkleRoot || h.WitnessRoot != blk.ComputeWitnessRoot() {
+		t.Errorf("merkle root %s witness root %s, want %s %s", h.MerkleRoot, h.WitnessRoot, blk.MerkleRoot, blk.ComputeWitnessRoot())
+	}
+	// a light client can check the work from the header alone
+	if got := h.ComputeHash(); got != blk.Hash {
+		t.Errorf("header hashes to %s, want %s", got, blk.Hash)
+	}
+
+	if code, body := request(t, n.handler, "GET", "/api/blocks/2/header", nil); code != http.StatusNotFound {
+		t.Errorf("unknown index: status %d: %s", code, body)
+	}
+}
+
+// failingStore fails AddBlock for the blocks in fail.
+type failingStore struct {
+	db.Store
+	fail map[string]bool
+}
+
+func (f *failingStore) AddBlock(b *blockchain.Block) error {
+	if f.fail[b.Hash] {
+		return errors.New("disk full")
+	}
+	return f.Store.AddBlock(b)
+}
+
+func TestStoreFailureDoesNotBlockRealBlock(t *testing.T) {
+	net := newTestNet(t)
+	a, b := net.node(t, true), net.node(t, false)
+	blk := b.mine(t)
+	store := &failingStore{Store: a.store, fail: map[string]bool{blk.Hash: true}}
+	a.store = store
+
+	if _, err := a.AcceptBlock(blk); err == nil || errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("err = %v, want the store error", err)
+	}
+	if a.index.Get(blk.Hash) != nil {
+		t.Fatal("block that failed to connect left in the index")
+	}
+	delete(store.fail, blk.Hash)
+	if status, err := a.AcceptBlock(blk); err != nil || status != BlockConnected {
+		t.Fatalf("block offered again: %s, %v", status, err)
+	}
+	a.validate(t)
+}
+
+func TestFailedReorgRestoresOldChain(t *testing.T) {
+	net := newTestNet(t)
+	a, b := net.node(t, true), net.node(t, false)
+	a.fund(t, "alice", 1000)
+	mainBlk := a.mine(t)
+	side := []*blockchain.Block{b.mine(t), b.mine(t)}
+	a.store = &failingStore{Store: a.store, fail: map[string]bool{side[1].Hash: true}}
+
+	if status, err := a.AcceptBlock(side[0]); err != nil || status != BlockSideChain {
+		t.Fatalf("first side block: %s, %v", status, err)
+	}
+	// the switch disconnects mainBlk, connects side[0], then fails on side[1]
+	if _, err := a.AcceptBlock(side[1]); err == nil || errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("err = %v, want the store error", err)
+	}
+	if h, hash := a.Tip(); h != 1 || hash != mainBlk.Hash {
+		t.Fatalf("tip %d %s, want the old block %s", h, hash, mainBlk.Hash)
+	}
+	stored, err := a.store.GetBlockByIndex(1)
+	if err != nil || stored.Hash != mainBlk.Hash {
+		t.Fatalf("stored block 1: %v, %v", stored, err)
+	}
+	if got := a.unspent(t, "alice"); got != 1000 {
+		t.Errorf("alice has %d, want the funding back", got)
+	}
+	a.validate(t)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// challengeTTL is how long a registration challenge may be answered.
+	challengeTTL = 5 * time.Minute
+	// maxChallenges and maxUserChallenges cap the challenges outstanding in
+	// total and per user, so requesting them cannot exhaust memory.
+	maxChallenges     = 10000
+	maxUserChallenges = 5
+)
+
+var (
+	errChallengeUnknown  = errors.New("unknown, used or expired challenge")
+	errTooManyChallenges = errors.New("too many outstanding challenges")
+)
+
+type challenge struct {
+	uid     string
+	expires time.Time
+}
+
+// challengeStore holds the registration nonces the node has issued. Each is
+// bound to the user it was issued to and can be answered once.
+type challengeStore struct {
+	mu      sync.Mutex
+	byNonce map[string]challenge
+}
+
+func newChallengeStore() *challengeStore {
+	return &challengeStore{byNonce: map[string]challenge{}}
+}
+
+// issue returns a fresh nonce for uid.
+func (c *challengeStore) issue(uid string, now time.Time) (string, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	outstanding := 0
+	for n, ch := range c.byNonce {
+		switch {
+		case !now.Before(ch.expires):
+			delete(c.byNonce, n)
+		case ch.uid == uid:
+			outstanding++
+		}
+	}
+	if outstanding >= maxUserChallenges || len(c.byNonce) >= maxChallenges {
+		return "", time.Time{}, errTooManyChallenges
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	nonce := hex.EncodeToString(b)
+	expires := now.Add(challengeTTL)
+	c.byNonce[nonce] = challenge{uid: uid, expires: expires}
+	return nonce, expires, nil
+}
+
+// take consumes the nonce if it was issued to uid and has not expired. A
+// nonce is spent by any attempt to answer it, so a bad signature cannot be
+// retried against the same challenge.
+func (c *challengeStore) take(nonce, uid string, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, ok := c.byNonce[nonce]
+	if !ok || ch.uid != uid {
+		return errChallengeUnknown
+	}
+	delete(c.byNonce, nonce)
+	if !now.Before(ch.expires) {
+		return errChallengeUnknown
+	}
+	return nil
+}
+
+type challengeReq struct {
+	// UserID names the user when auth is not configured (local dev only).
+	UserID string `json:"user_id,omitempty"`
+}
+
+// challengeHandler issues a registration challenge to the authenticated
+// user. The keys being registered sign wallet.RegistrationMessage over it.
+func (s *Server) challengeHandler(w http.ResponseWriter, r *http.Request) {
+	var req challengeReq
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	uid := requestUID(r, req.UserID)
+	if uid == "" {
+		http.Error(w, "uid required", http.StatusUnauthorized)
+		return
+	}
+	nonce, expires, err := s.challenges.issue(uid, time.Now())
+	if err != nil {
+		if errors.Is(err, errTooManyChallenges) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		http.Error(w, "failed to issue challenge: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"nonce":      nonce,
+		"user_id":    uid,
+		"chain_id":   s.params.ChainID,
+		"expires_at": expires.UTC(),
+	})
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"strings"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/utxo"
+	"github.com/student/decentralized-wallet/wallet"
+)
+
+// spendPointAt checks spending conditions as if in a block at height whose
+// parent's median time past is at. Outputs count as confirmed once their transaction is in the
+// store's confirmed records.
+func (s *Server) spendPointAt(height int64, at time.Time) blockchain.SpendPoint {
+	return blockchain.SpendPoint{Height: height, Time: at, Confirmed: func(txID string) (int64, bool) {
+		r, err := s.store.GetTransactionByID(txID)
+		if err != nil {
+			return 0, false
+		}
+		return r.BlockIndex, true
+	}}
+}
+
+// nextSpendPoint is where a transaction accepted now would be mined: the
+// block after the tip, at the tip's median time past.
+func (s *Server) nextSpendPoint() (blockchain.SpendPoint, error) {
+	index, _, err := s.store.GetLatestBlock()
+	if err != nil {
+		return blockchain.SpendPoint{}, err
+	}
+	mtp, err := s.tipMedianTimePast()
+	if err != nil {
+		return blockchain.SpendPoint{}, err
+	}
+	return s.spendPointAt(index+1, mtp), nil
+}
+
+// resolveCondition returns a copy of c with the addresses in its signer
+// leaves replaced by the wallet IDs they decode to, as for output recipients.
+func (s *Server) resolveCondition(c *utxo.Condition) (*utxo.Condition, error) {
+	res := *c
+	if c.Signer != "" {
+		id, err := wallet.DecodeAddress(s.params.AddressPrefix, strings.TrimSpace(c.Signer))
+		if err != nil {
+			return nil, err
+		}
+		res.Signer = id
+	}
+	for _, subs := range []*[]utxo.Condition{&res.All, &res.Any} {
+		if *subs == nil {
+			continue
+		}
+		resolved := make([]utxo.Condition, len(*subs))
+		for i := range *subs {
+			sub, err := s.resolveCondition(&(*subs)[i])
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = *sub
+		}
+		*subs = resolved
+	}
+	return &res, nil
+}
+
+// unlockedTxs drops from txs, in mempool order, those whose spending
+// conditions do not hold at the point at, and those spending their outputs.
+// Pool transactions were checked on admission, but a reorg can move the tip
+// back below a timelock.
+func (s *Server) unlockedTxs(txs []*utxo.Transaction, at blockchain.SpendPoint) []*utxo.Transaction {
+	res := make([]*utxo.Transaction, 0, len(txs))
+	dropped := map[string]bool{}
+	for _, t := range txs {
+		inputs := make([]*utxo.UTXO, 0, len(t.Inputs))
+		conditioned, orphaned := false, false
+		for _, id := range t.Inputs {
+			u, ok := utxo.GetUTXO(id)
+			if !ok {
+				continue
+			}
+			inputs = append(inputs, u)
+			conditioned = conditioned || u.Condition != nil
+			orphaned = orphaned || dropped[u.TxID]
+		}
+		if orphaned || (conditioned && len(blockchain.CheckSpends(t, inputs, at)) > 0) {
+			dropped[t.ID] = true
+			continue
+		}
+		res = append(res, t)
+	}
+	return res
+}
@@ -5,11 +5,10 @@ import (
     "net/http"
     "os"
 
-    "github.com/student/decentralized-wallet/internal/db"
 )
 
 // createLogHandler allows authenticated services/handlers to write structured logs.
-func createLogHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) createLogHandler(w http.ResponseWriter, r *http.Request) {
     var in struct{
         Level string `json:"level"`
         Message string `json:"message"`
@@ -25,7 +24,7 @@ func createLogHandler(w http.ResponseWriter, r *http.Request) {
         in.Meta["actor_uid"] = uid
     }
     if in.Level == "" { in.Level = "info" }
-    if err := db.AddLog(in.Level, in.Message, in.Meta); err != nil {
+    if err := s.store.AddLog(in.Level, in.Message, in.Meta); err != nil {
         http.Error(w, "failed to write log: "+err.Error(), http.StatusInternalServerError)
         return
     }
@@ -33,7 +32,7 @@ func createLogHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // listLogsHandler returns recent logs. Restricted to admin users.
-func listLogsHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) listLogsHandler(w http.ResponseWriter, r *http.Request) {
     // ensure admin: either custom claim "admin"==true or ADMIN_UID env var
     uid, _ := r.Context().Value("uid").(string)
     isAdmin := false
@@ -55,7 +54,7 @@ func listLogsHandler(w http.ResponseWriter, r *http.Request) {
     if l := r.URL.Query().Get("limit"); l != "" {
         // ignore error and use default
     }
-    logs, err := db.ListLogs(limit)
+    logs, err := s.store.ListLogs(limit)
     if err != nil {
         http.Error(w, "failed to list logs: "+err.Error(), http.StatusInternalServerError)
         return
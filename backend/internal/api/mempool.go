@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/chainparams"
+	"github.com/student/decentralized-wallet/internal/mempool"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+const errCodeMempoolFull = "MEMPOOL_FULL"
+
+// mempoolConfig returns the mempool limits (MEMPOOL_MAX_TXS, MEMPOOL_MAX_BYTES,
+// MEMPOOL_EXPIRY) and the chain's block limits.
+func mempoolConfig(params *chainparams.Params) mempool.Config {
+	cfg := mempool.DefaultConfig
+	envInt := func(name string, dst *int) {
+		if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v >= 0 {
+			*dst = v
+		}
+	}
+	envInt("MEMPOOL_MAX_TXS", &cfg.MaxTxs)
+	envInt("MEMPOOL_MAX_BYTES", &cfg.MaxBytes)
+	cfg.MaxBlockTxs = params.BlockLimits.MaxTxs
+	cfg.MaxBlockBytes = params.BlockLimits.MaxBytes
+	if d, err := time.ParseDuration(os.Getenv("MEMPOOL_EXPIRY")); err == nil {
+		cfg.Expiry = d
+	}
+	return cfg
+}
+
+// commitPending admits t to the mempool and, once it is known to fit, spends
+// its inputs, creates its outputs and records it as pending in one atomic
+// store operation. If the store refuses t the pool is left untouched.
+// Transactions evicted to make room are dropped once t is committed.
+func (s *Server) commitPending(t *utxo.Transaction) error {
+	outputs := t.OutputUTXOs()
+	evicted, err := s.pool.AddWithCommit(t, func() error {
+		return s.store.CreatePendingTxAtomic(t, t.Inputs, outputs)
+	})
+	if err != nil {
+		return err
+	}
+	// persist succeeded; mirror into the in-memory UTXO set and release evicted txs
+	for _, id := range t.Inputs {
+		utxo.MarkUTXOSpent(id)
+	}
+	for _, o := range outputs {
+		utxo.AddUTXO(o)
+	}
+	s.dropPending(evicted)
+	s.miner.Notify()
+	return nil
+}
+
+// pendingError writes the response for a commitPending failure.
+func pendingError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, mempool.ErrDuplicate):
+		txError(w, http.StatusConflict, errCodeReplayedTx, err.Error())
+	case errors.Is(err, mempool.ErrPoolFull):
+		txError(w, http.StatusServiceUnavailable, errCodeMempoolFull, err.Error())
+	case errors.Is(err, mempool.ErrTooLarge):
+		http.Error(w, "rejected by mempool: "+err.Error(), http.StatusBadRequest)
+	case nonceError(w, err):
+	default:
+		http.Error(w, "failed to persist transaction atomically: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// dropPending undoes the store side of transactions that left the mempool
+// without being mined, returning their inputs to the unspent set. txs must be
+// ordered descendants first, as the pool returns them.
+func (s *Server) dropPending(txs []*utxo.Transaction) {
+	for _, t := range txs {
+		if err := s.store.DropPendingTx(t.ID); err != nil {
+			log.Printf("mempool: failed to drop pending tx %s: %v", t.ID, err)
+			continue
+		}
+		for i := range t.Outputs {
+			utxo.RemoveUTXO(t.OutputUTXOID(i))
+		}
+		for _, id := range t.Inputs {
+			utxo.MarkUTXOUnspent(id)
+		}
+	}
+}
+
+// ExpireMempool drops transactions that have sat in the mempool longer than
+// the configured expiry and returns how many were dropped.
+func (s *Server) ExpireMempool() int {
+	expired := s.pool.Expire()
+	s.dropPending(expired)
+	return len(expired)
+}
+
+// mempoolHandler returns mempool stats and the best-paying pending transactions.
+func (s *Server) mempoolHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil {
+		limit = v
+	}
+	resp := map[string]interface{}{
+		"stats": s.pool.Stats(),
+		"txs":   s.pool.Entries(limit),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/miner"
+	"github.com/student/decentralized-wallet/internal/utxo"
+	"github.com/student/decentralized-wallet/wallet"
+)
+
+// Server implements miner.Backend.
+var _ miner.Backend = (*Server)(nil)
+
+// Miner returns the node's background miner.
+func (s *Server) Miner() *miner.Service {
+	return s.miner
+}
+
+// NewTemplate builds the next block on the current tip: a coinbase paying
+// minerWallet the subsidy plus fees, followed by the best fee-rate mempool
+// transactions that fit the block limits. It returns nil when the mempool is
+// empty and allowEmpty is false.
+func (s *Server) NewTemplate(minerWallet string, allowEmpty bool) (*miner.Template, error) {
+	index, prevHash, err := s.store.GetLatestBlock()
+	if err != nil {
+		return nil, err
+	}
+	bits, err := s.nextBits(index + 1)
+	if err != nil {
+		return nil, err
+	}
+	mtp, err := s.tipMedianTimePast()
+	if err != nil {
+		return nil, err
+	}
+	index++
+
+	// drop stale txs, then take the best-paying ones that fit in a block
+	s.ExpireMempool()
+	now := time.Now().UTC()
+	cfg := s.pool.Config()
+	maxTxs := 0
+	if cfg.MaxBlockTxs > 0 {
+		maxTxs = cfg.MaxBlockTxs - 1 // one slot is the coinbase
+	}
+	// the coinbase's size does not depend on its value, so reserve it up front
+	reserved := blockchain.NewCoinbase(s.params.ChainID, index, minerWallet, 0, now)
+	reserved.Outputs = []utxo.TxOutput{{Recipient: minerWallet}}
+	pending := s.unlockedTxs(s.pool.SelectForBlock(maxTxs, reserved.Size()), s.spendPointAt(index, mtp))
+	if len(pending) == 0 && !allowEmpty {
+		return nil, nil
+	}
+
+	// coinbase first, paying the subsidy plus every fee in the block
+	txs := make([]utxo.Transaction, 1, len(pending)+1)
+	for _, t := range pending {
+		txs = append(txs, *t)
+	}
+	fees := blockchain.BlockFees(txs[1:])
+	reward := s.params.RewardSchedule().Subsidy(index) + fees
+	txs[0] = *blockchain.NewCoinbase(s.params.ChainID, index, minerWallet, reward, now)
+
+	b := blockchain.NewBlockTemplate(index, prevHash, bits, txs)
+	// the miner stamps the block after this, as consensus requires
+	b.Timestamp = mtp
+	return &miner.Template{
+		Block:  b,
+		Fees:   fees,
+		Reward: reward,
+	}, nil
+}
+
+// tipMedianTimePast returns the median-time-past of the main chain's tip,
+// which the next block's timestamp must be after.
+func (s *Server) tipMedianTimePast() (time.Time, error) {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+	if s.tip == nil {
+		return time.Time{}, nil
+	}
+	return blockchain.MedianTimePast(&s.tip.Header, indexAncestor(s.tip))
+}
+
+// nextBits returns the target the block at height must meet, per targetRules.
+func (s *Server) nextBits(height int64) (uint32, error) {
+	header := func(h int64) (*blockchain.BlockHeader, error) {
+		b, err := s.store.GetBlockByIndex(h)
+		if err != nil {
+			return nil, err
+		}
+		hdr := b.Header()
+		return &hdr, nil
+	}
+	var prev *blockchain.BlockHeader
+	if height > 1 {
+		var err error
+		if prev, err = header(height - 1); err != nil {
+			return 0, err
+		}
+	}
+	return s.params.TargetRules().NextBits(height, prev, header)
+}
+
+// SubmitBlock commits a solved template through AcceptBlock and announces it
+// to peers. It returns miner.ErrStaleTemplate if the tip moved or a
+// transaction left the mempool since the template was built. If validation
+// rejects the block for some of its transactions, they leave the mempool with
+// their descendants, so the next template does not carry them again.
+func (s *Server) SubmitBlock(t *miner.Template) error {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+
+	block := t.Block
+	if s.tip == nil || block.PreviousHash != s.tip.Hash() {
+		return miner.ErrStaleTemplate
+	}
+	for _, tx := range block.Transactions[1:] {
+		if _, ok := s.pool.Get(tx.ID); !ok {
+			return miner.ErrStaleTemplate
+		}
+	}
+	if _, err := s.acceptBlockLocked(block); err != nil {
+		var rejected *blockError
+		if errors.As(err, &rejected) && len(rejected.txIDs) > 0 {
+			s.dropPending(s.pool.RemoveWithDescendants(rejected.txIDs))
+		}
+		return err
+	}
+	s.announceBlock(block)
+	return nil
+}
+
+// MinerWalletID resolves the wallet a coinbase pays, given as an address of
+// this network or as a raw wallet ID.
+func (s *Server) MinerWalletID(w string) (string, error) {
+	w = strings.TrimSpace(w)
+	if w == "" {
+		return "", errors.New("no miner wallet: pass miner_wallet or set MINER_WALLET")
+	}
+	if strings.HasPrefix(strings.ToLower(w), s.params.AddressPrefix+"1") {
+		id, err := wallet.DecodeAddress(s.params.AddressPrefix, w)
+		if err != nil {
+			return "", fmt.Errorf("miner wallet: %w", err)
+		}
+		return id, nil
+	}
+	// a wallet ID is what its address carries
+	if _, err := wallet.EncodeAddress(s.params.AddressPrefix, w); err != nil {
+		return "", fmt.Errorf("miner wallet %q: %w", w, err)
+	}
+	return strings.ToLower(w), nil
+}
+
+type minerStartReq struct {
+	MinerWallet string `json:"miner_wallet"`
+	// IntervalSeconds mines a block at least this often, even with an empty mempool (0 = only when txs arrive).
+	IntervalSeconds int `json:"interval_seconds"`
+	// Workers is the number of PoW goroutines (default MINER_WORKERS, else every core).
+	Workers int `json:"workers"`
+}
+
+// minerStartHandler starts the background miner.
+func (s *Server) minerStartHandler(w http.ResponseWriter, r *http.Request) {
+	var req minerStartReq
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	if req.MinerWallet == "" {
+		req.MinerWallet = os.Getenv("MINER_WALLET")
+	}
+	id, err := s.MinerWalletID(req.MinerWallet)
+	if err != nil {
+		http.Error(w, "failed to start miner: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.MinerWallet = id
+	if req.Workers <= 0 {
+		req.Workers = minerWorkers()
+	}
+	cfg := miner.Config{MinerWallet: req.MinerWallet, Interval: time.Duration(req.IntervalSeconds) * time.Second, Workers: req.Workers}
+	// the miner outlives this request, so it must not inherit its context
+	if err := s.miner.Start(context.Background(), cfg); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, miner.ErrRunning) {
+			status = http.StatusConflict
+		}
+		http.Error(w, "failed to start miner: "+err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.miner.Status())
+}
+
+// minerStopHandler stops the background miner, abandoning any block in progress.
+func (s *Server) minerStopHandler(w http.ResponseWriter, r *http.Request) {
+	s.miner.Stop()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.miner.Status())
+}
+
+// minerStatusHandler reports whether the miner runs, its hash rate and current template.
+func (s *Server) minerStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.miner.Status())
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+)
+
+func TestSubmitBlockDropsRejectedTxs(t *testing.T) {
+	net := newTestNet(t)
+	n := net.node(t, true)
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	in := n.fund(t, alice.id, 1000)
+	n.mine(t)
+	n.register(t, alice)
+	n.register(t, bob)
+	tx := n.send(t, alice, in, 1000, bob.id, 100, 5, 1)
+	child := n.send(t, bob, tx.OutputUTXOID(0), 100, alice.id, 50, 5, 1)
+
+	// a pooled tx the block validator refuses, as after a bug or bad disk
+	pooled, _ := n.pool.Get(tx.ID)
+	pooled.Signature[0] ^= 0xff
+	tmpl, err := n.NewTemplate("miner", false)
+	if err != nil || tmpl == nil {
+		t.Fatalf("template %v, err %v", tmpl, err)
+	}
+	blockchain.MineBlock(tmpl.Block)
+	if err := n.SubmitBlock(tmpl); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("submitting the bad block: err = %v, want ErrInvalidBlock", err)
+	}
+
+	// it leaves with its descendants, so the miner does not redo the block
+	for _, id := range []string{tx.ID, child.ID} {
+		if _, ok := n.pool.Get(id); ok {
+			t.Errorf("tx %s still pooled", id)
+		}
+	}
+	if got := n.unspent(t, alice.id); got != 1000 {
+		t.Errorf("alice has %d unspent, want her input back", got)
+	}
+	if tmpl, err := n.NewTemplate("miner", false); err != nil || tmpl != nil {
+		t.Errorf("next template %+v, err %v; want nothing to mine", tmpl, err)
+	}
+}
@@ -0,0 +1,321 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/crypto"
+	"github.com/student/decentralized-wallet/internal/db"
+	"github.com/student/decentralized-wallet/internal/utxo"
+	"github.com/student/decentralized-wallet/wallet"
+)
+
+// A multisig wallet spends in three steps: one co-signer proposes the
+// transaction, which is kept as a partial transaction; each co-signer fetches
+// it, checks it and adds their signature of its canonical encoding; once the
+// policy's threshold of distinct keys has signed, the node submits it like
+// any other transaction.
+
+// maxPartialTxsPerWallet caps a multisig wallet's pending proposals, so its
+// co-signers' proposals cannot grow the store without bound.
+const maxPartialTxsPerWallet = 16
+
+type registerMultisigReq struct {
+	Threshold  int      `json:"threshold"`
+	PublicKeys []string `json:"public_keys"` // base64
+}
+
+// registerMultisigHandler registers an M-of-N wallet. Its ID is derived from
+// the policy, so registering the same keys and threshold again is a no-op.
+func (s *Server) registerMultisigHandler(w http.ResponseWriter, r *http.Request) {
+	var req registerMultisigReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := crypto.NewMultisigPolicy(req.Threshold, req.PublicKeys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := p.WalletID()
+	// the wallet belongs to its keys, not to the user who registered it
+	if err := s.store.RegisterWallets([]*db.WalletRecord{{WalletID: id, PublicKey: p.String()}}); err != nil {
+		registerError(w, err)
+		return
+	}
+	utxo.RegisterWallet(id, p.String())
+	addr, _ := wallet.EncodeAddress(s.params.AddressPrefix, id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"wallet_id":   id,
+		"address":     addr,
+		"threshold":   p.Threshold,
+		"public_keys": p.PublicKeys,
+		"policy":      p.String(),
+	})
+}
+
+// coSignature is a co-signer's base64 signature of a partial transaction's
+// canonical encoding.
+type coSignature struct {
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// proposeMultisigReq is a send request from a multisig wallet. The proposer
+// signs it straight away with one of the wallet's keys.
+type proposeMultisigReq struct {
+	sendTxReq
+	Signatures []coSignature `json:"signatures"`
+}
+
+// partialTxView is a partial transaction with what a co-signer needs to sign it.
+type partialTxView struct {
+	*db.PartialTx
+	ID string `json:"id"`
+	// SigningBytes is the canonical encoding, base64. Co-signers should
+	// rebuild it from the transaction rather than sign it blindly.
+	SigningBytes string   `json:"signing_bytes"`
+	Signed       int      `json:"signed"`
+	SignedBy     []string `json:"signed_by"`
+}
+
+func viewPartialTx(p *db.PartialTx) partialTxView {
+	v := partialTxView{PartialTx: p, ID: p.Tx.ID, SigningBytes: base64.StdEncoding.EncodeToString(p.Tx.SigningBytes()), SignedBy: []string{}}
+	v.Signed, _, _ = p.Tx.CountSignatures()
+	for _, sig := range p.Tx.Signatures {
+		v.SignedBy = append(v.SignedBy, sig.PublicKey)
+	}
+	return v
+}
+
+// addCoSignatures verifies and adds signatures to t.
+func addCoSignatures(t *utxo.Transaction, sigs []coSignature) error {
+	for _, cs := range sigs {
+		raw, err := base64.StdEncoding.DecodeString(cs.Signature)
+		if err != nil {
+			return errors.New("signature must be base64")
+		}
+		if err := t.AddSignature(strings.TrimSpace(cs.PublicKey), raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// proposeMultisigHandler creates a partial transaction for a multisig wallet.
+// Only a co-signer may propose one: it must carry a valid signature by one of
+// the wallet's keys.
+func (s *Server) proposeMultisigHandler(w http.ResponseWriter, r *http.Request) {
+	var req proposeMultisigReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ChainID != s.params.ChainID {
+		txError(w, http.StatusBadRequest, errCodeWrongChain, "chain_id mismatch: node is on "+s.params.ChainID)
+		return
+	}
+	pub, err := s.store.GetWalletPublicKey(req.Sender)
+	if err != nil {
+		http.Error(w, "sender wallet not registered", http.StatusBadRequest)
+		return
+	}
+	p, err := crypto.ParseMultisigPolicy(pub)
+	if err != nil {
+		http.Error(w, "sender is not a multisig wallet", http.StatusBadRequest)
+		return
+	}
+	if req.Timestamp == "" {
+		req.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	t := s.buildTx(w, &req.sendTxReq, pub)
+	if t == nil {
+		return
+	}
+	t.ID = t.ComputeID()
+	if err := addCoSignatures(t, req.Signatures); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// addCoSignatures keeps only valid signatures by the wallet's keys
+	if len(t.Signatures) == 0 {
+		http.Error(w, "a proposal must be signed by one of the wallet's keys", http.StatusUnauthorized)
+		return
+	}
+
+	s.partialMu.Lock()
+	defer s.partialMu.Unlock()
+	if _, err := s.store.GetPartialTx(t.ID); err == nil {
+		http.Error(w, "transaction already proposed: "+t.ID, http.StatusConflict)
+		return
+	}
+	pending, err := s.livePartialTxs(t.Sender)
+	if err != nil {
+		http.Error(w, "failed to list partial transactions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if pending >= maxPartialTxsPerWallet {
+		http.Error(w, fmt.Sprintf("wallet already has %d pending proposals; complete one before proposing another", pending), http.StatusTooManyRequests)
+		return
+	}
+	now := time.Now().UTC()
+	pt := &db.PartialTx{Tx: *t, Threshold: p.Threshold, CreatedBy: requestUID(r, ""), CreatedAt: now, UpdatedAt: now}
+	s.finishPartialTx(w, pt)
+}
+
+// livePartialTxs counts a wallet's partial transactions that may still be
+// submitted, deleting those whose nonce has since been used. Callers hold
+// partialMu.
+func (s *Server) livePartialTxs(walletID string) (int, error) {
+	pts, err := s.store.ListPartialTxs(walletID)
+	if err != nil {
+		return 0, err
+	}
+	last, err := s.store.GetWalletNonce(walletID)
+	if err != nil {
+		return 0, err
+	}
+	live := 0
+	for _, pt := range pts {
+		if pt.Tx.Nonce > last {
+			live++
+		} else if err := s.store.DeletePartialTx(pt.Tx.ID); err != nil && !errors.Is(err, db.ErrNotFound) {
+			return 0, err
+		}
+	}
+	return live, nil
+}
+
+// signMultisigHandler adds co-signatures to a partial transaction and
+// submits it once enough distinct keys have signed.
+func (s *Server) signMultisigHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		coSignature
+		Signatures []coSignature `json:"signatures"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	sigs := req.Signatures
+	if req.PublicKey != "" {
+		sigs = append(sigs, req.coSignature)
+	}
+	if len(sigs) == 0 {
+		http.Error(w, "public_key and signature required", http.StatusBadRequest)
+		return
+	}
+
+	s.partialMu.Lock()
+	defer s.partialMu.Unlock()
+	pt, err := s.store.GetPartialTx(mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "partial transaction not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to load partial transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := addCoSignatures(&pt.Tx, sigs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pt.UpdatedAt = time.Now().UTC()
+	s.finishPartialTx(w, pt)
+}
+
+// finishPartialTx saves pt, or submits it once it has enough signatures,
+// and writes the response. Callers hold partialMu.
+func (s *Server) finishPartialTx(w http.ResponseWriter, pt *db.PartialTx) {
+	view := viewPartialTx(pt)
+	if view.Signed < pt.Threshold {
+		if err := s.store.SavePartialTx(pt); err != nil {
+			http.Error(w, "failed to save partial transaction: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "pending", "partial_tx": view})
+		return
+	}
+
+	t := &pt.Tx
+	last, err := s.store.GetWalletNonce(t.Sender)
+	if err != nil {
+		http.Error(w, "failed to read wallet nonce: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	lookup := func(id string) (*utxo.UTXO, bool) {
+		u, err := s.store.GetUTXOByID(id)
+		return u, err == nil && !u.Spent
+	}
+	at, err := s.nextSpendPoint()
+	if err != nil {
+		http.Error(w, "failed to read chain tip: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if problems, _ := blockchain.CheckTx(t, s.txParams(), lookup, last, at); len(problems) > 0 {
+		// a partial transaction whose nonce is used can never be submitted
+		if t.Nonce <= last {
+			s.store.DeletePartialTx(t.ID)
+		}
+		http.Error(w, "transaction can no longer be submitted: "+strings.Join(problems, "; "), http.StatusConflict)
+		return
+	}
+	if err := s.commitPending(t); err != nil {
+		pendingError(w, err)
+		return
+	}
+	s.announceTx(t)
+	if err := s.store.DeletePartialTx(t.ID); err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Printf("multisig: failed to delete submitted partial tx %s: %v", t.ID, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "submitted", "tx_id": t.ID, "partial_tx": view})
+}
+
+// getMultisigTxHandler returns a partial transaction for co-signers to check and sign.
+func (s *Server) getMultisigTxHandler(w http.ResponseWriter, r *http.Request) {
+	pt, err := s.store.GetPartialTx(mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			http.Error(w, "partial transaction not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to load partial transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(viewPartialTx(pt))
+}
+
+// listMultisigTxsHandler lists a multisig wallet's partial transactions
+// (?wallet_id=, oldest first).
+func (s *Server) listMultisigTxsHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("wallet_id")
+	if id == "" {
+		http.Error(w, "wallet_id required", http.StatusBadRequest)
+		return
+	}
+	pts, err := s.store.ListPartialTxs(id)
+	if err != nil {
+		http.Error(w, "failed to list partial transactions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := make([]partialTxView, 0, len(pts))
+	for _, pt := range pts {
+		res = append(res, viewPartialTx(pt))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"wallet_id": id, "partial_txs": res})
+}
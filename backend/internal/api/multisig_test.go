@@ -0,0 +1,93 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/chainparams"
+	"github.com/student/decentralized-wallet/internal/db"
+	"github.com/student/decentralized-wallet/internal/utxo"
+	"github.com/student/decentralized-wallet/wallet"
+)
+
+func TestProposeMultisigLimits(t *testing.T) {
+	params := chainparams.Default()
+	s := NewServer(db.NewMemoryStore(), params)
+	h := s.Router()
+	keys := []testWallet{newTestWallet(t), newTestWallet(t), newTestWallet(t)}
+	outsider, bob := newTestWallet(t), newTestWallet(t)
+	code, body := request(t, h, "POST", "/api/wallets/multisig", map[string]interface{}{
+		"threshold": 2, "public_keys": []string{keys[0].pub, keys[1].pub, keys[2].pub},
+	})
+	var ms map[string]interface{}
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &ms) != nil {
+		t.Fatalf("register multisig: %d %s", code, body)
+	}
+	id, policy := ms["wallet_id"].(string), ms["policy"].(string)
+	code, body = request(t, h, "POST", "/api/admin/fund", map[string]interface{}{"wallet_id": id, "amount": 1000})
+	var funded map[string]string
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &funded) != nil {
+		t.Fatalf("fund: %d %s", code, body)
+	}
+	bobAddr, _ := wallet.EncodeAddress(params.AddressPrefix, bob.id)
+
+	// propose sends amount to bob from in, signed by signers, and returns the
+	// transaction the node builds from the request
+	propose := func(in string, inAmt, amount int64, nonce uint64, signers ...testWallet) (*utxo.Transaction, int, string) {
+		ts := time.Now().UTC().Format(time.RFC3339Nano)
+		tx := &utxo.Transaction{Sender: id, SenderPublicKey: policy, Inputs: []string{in}, ClientTimestamp: ts, ChainID: params.ChainID, Nonce: nonce,
+			Outputs: []utxo.TxOutput{{Recipient: bob.id, Amount: amount}, {Recipient: id, Amount: inAmt - amount - 10}}}
+		sigs := []map[string]string{}
+		for _, k := range signers {
+			sigs = append(sigs, map[string]string{"public_key": k.pub, "signature": base64.StdEncoding.EncodeToString(ed25519.Sign(k.priv, tx.SigningBytes()))})
+		}
+		code, body := request(t, h, "POST", "/api/multisig/txs", map[string]interface{}{
+			"sender": id, "receiver": bobAddr, "amount": amount, "fee": 10, "timestamp": ts,
+			"inputs": []string{in}, "chain_id": params.ChainID, "nonce": nonce, "signatures": sigs,
+		})
+		tx.ID = tx.ComputeID()
+		return tx, code, body
+	}
+
+	in := funded["utxo_id"]
+	if _, code, body := propose(in, 1000, 100, 1); code != http.StatusUnauthorized {
+		t.Errorf("unsigned proposal: %d %s", code, body)
+	}
+	if _, code, body := propose(in, 1000, 100, 1, outsider); code != http.StatusBadRequest {
+		t.Errorf("proposal signed by an outsider: %d %s", code, body)
+	}
+	var first *utxo.Transaction
+	for i := 0; i < maxPartialTxsPerWallet; i++ {
+		tx, code, body := propose(in, 1000, int64(100+i), 1, keys[i%3])
+		if code != http.StatusOK {
+			t.Fatalf("proposal %d: %d %s", i, code, body)
+		}
+		if first == nil {
+			first = tx
+		}
+	}
+	if _, code, body := propose(in, 1000, 200, 1, keys[0]); code != http.StatusTooManyRequests {
+		t.Errorf("proposal past the cap: %d %s", code, body)
+	}
+
+	// once one is submitted, the others can never be and make room
+	sig := ed25519.Sign(keys[1].priv, first.SigningBytes())
+	code, body = request(t, h, "POST", "/api/multisig/txs/"+first.ID+"/signatures", map[string]string{"public_key": keys[1].pub, "signature": base64.StdEncoding.EncodeToString(sig)})
+	if code != http.StatusOK {
+		t.Fatalf("second signature: %d %s", code, body)
+	}
+	if _, code, body := propose(first.OutputUTXOID(1), 890, 50, 2, keys[2]); code != http.StatusOK {
+		t.Errorf("proposal after a submission: %d %s", code, body)
+	}
+	pts, err := s.store.ListPartialTxs(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pts) != 1 {
+		t.Errorf("%d partial transactions left, want the new one", len(pts))
+	}
+}
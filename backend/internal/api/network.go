@@ -0,0 +1,230 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/db"
+	"github.com/student/decentralized-wallet/internal/mempool"
+	"github.com/student/decentralized-wallet/internal/p2p"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+// SetNode attaches the p2p node that locally created transactions and mined
+// blocks are announced to. Call it before serving requests.
+func (s *Server) SetNode(n *p2p.Node) {
+	s.node = n
+}
+
+// announceTx gossips a user transaction accepted through the API. Zakat
+// deductions are created by each node for itself and are not relayed.
+func (s *Server) announceTx(t *utxo.Transaction) {
+	if s.node != nil && t.SenderPublicKey != "" {
+		s.node.BroadcastTx(t)
+	}
+}
+
+// announceBlock gossips a block this node mined.
+func (s *Server) announceBlock(b *blockchain.Block) {
+	if s.node != nil {
+		s.node.BroadcastBlock(b)
+	}
+}
+
+// Tip implements p2p.Backend.
+func (s *Server) Tip() (int64, string) {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+	if s.tip == nil {
+		return -1, ""
+	}
+	return s.tip.Height(), s.tip.Hash()
+}
+
+// ReceiveBlock implements p2p.Backend: the block goes through AcceptBlock
+// like a locally mined one, and is relayed unless it was already known.
+func (s *Server) ReceiveBlock(b *blockchain.Block) (bool, error) {
+	status, err := s.AcceptBlock(b)
+	if errors.Is(err, ErrInvalidBlock) {
+		return false, fmt.Errorf("%w: %v", p2p.ErrInvalid, err)
+	}
+	if err != nil {
+		return false, err
+	}
+	return status != BlockDuplicate, nil
+}
+
+// Locator implements p2p.Backend with the main chain's block locator.
+func (s *Server) Locator() []string {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+	if s.tip == nil {
+		return nil
+	}
+	return s.tip.Locator()
+}
+
+// HeadersAfter implements p2p.Backend. It returns up to max main-chain
+// headers following the first locator hash on the main chain, or following
+// genesis if the locator shares nothing with it.
+func (s *Server) HeadersAfter(locator []string, max int) []blockchain.BlockHeader {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+	if s.tip == nil {
+		return nil
+	}
+	var from int64
+	for _, hash := range locator {
+		if e := s.index.Get(hash); e != nil && s.tip.Ancestor(e.Height()) == e {
+			from = e.Height()
+			break
+		}
+	}
+	return s.mainChainHeaders(from+1, max)
+}
+
+// CheckHeaders implements p2p.Backend. headers must extend a block in the
+// index, each linking to the one before with the target the retarget
+// schedule sets and a hash that meets it. Nothing is stored.
+func (s *Server) CheckHeaders(headers []blockchain.BlockHeader) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+	parent := s.index.Get(headers[0].PreviousHash)
+	if parent == nil {
+		return fmt.Errorf("header %d (%s): %w", headers[0].Index, headers[0].Hash, blockchain.ErrOrphanBlock)
+	}
+	if parent.Invalid {
+		return fmt.Errorf("%w: parent %s was rejected", p2p.ErrInvalid, parent.Hash())
+	}
+	// the retarget schedule may look back past the index into this batch
+	batch := make(map[int64]*blockchain.BlockHeader, len(headers))
+	fromIndex := indexAncestor(parent)
+	ancestor := func(height int64) (*blockchain.BlockHeader, error) {
+		if h, ok := batch[height]; ok {
+			return h, nil
+		}
+		return fromIndex(height)
+	}
+	prev := &parent.Header
+	for i := range headers {
+		h := &headers[i]
+		if e := s.index.Get(h.Hash); e != nil && e.Invalid {
+			return fmt.Errorf("%w: block %s was rejected before", p2p.ErrInvalid, h.Hash)
+		}
+		if err := s.checkHeader(h, prev, ancestor); err != nil {
+			if errors.Is(err, ErrInvalidBlock) {
+				return fmt.Errorf("%w: %v", p2p.ErrInvalid, err)
+			}
+			return err
+		}
+		batch[h.Index] = h
+		prev = h
+	}
+	return nil
+}
+
+// BlockByHash implements p2p.Backend for peers downloading blocks.
+func (s *Server) BlockByHash(hash string) (*blockchain.Block, error) {
+	return s.store.GetBlockByHash(hash)
+}
+
+// ReceiveTx implements p2p.Backend. A relayed transaction gets the checks a
+// block would apply to it, then enters the mempool as if sent through the API.
+// Inputs we do not know yet, stale nonces and timelocks our tip has not
+// reached are not the peer's fault: the transaction is refused without
+// penalty and may be offered again later.
+func (s *Server) ReceiveTx(t *utxo.Transaction) (bool, error) {
+	if _, ok := s.pool.Get(t.ID); ok {
+		return false, nil
+	}
+	if _, err := s.store.GetTransactionByID(t.ID); err == nil {
+		return false, nil
+	}
+	// coinbase, funding and zakat transactions are made by a node for its own
+	// blocks; only signed transfers travel on their own
+	if t.IsCoinbase() || t.SenderPublicKey == "" || !t.HasSignature() {
+		return false, fmt.Errorf("%w: tx %s is not a signed transfer", p2p.ErrInvalid, t.ID)
+	}
+	registered := true
+	last, err := s.store.GetWalletNonce(t.Sender)
+	if errors.Is(err, db.ErrNotFound) {
+		registered = false
+	} else if err != nil {
+		return false, err
+	}
+	if err := db.CheckNonce(last, t.Nonce); err != nil {
+		return false, fmt.Errorf("tx %s: %w", t.ID, err)
+	}
+	for _, id := range t.Inputs {
+		if u, err := s.store.GetUTXOByID(id); err != nil || u.Spent {
+			return false, fmt.Errorf("tx %s: input %s is missing or spent", t.ID, id)
+		}
+	}
+	lookup := func(id string) (*utxo.UTXO, bool) {
+		u, err := s.store.GetUTXOByID(id)
+		return u, err == nil && !u.Spent
+	}
+	at, err := s.nextSpendPoint()
+	if err != nil {
+		return false, err
+	}
+	if problems, locked := blockchain.CheckTx(t, s.txParams(), lookup, last, at); len(problems) > 0 {
+		// timelocks depend on our tip and clock; a peer ahead of us may relay in good faith
+		if locked {
+			return false, fmt.Errorf("tx %s: %s", t.ID, strings.Join(problems, "; "))
+		}
+		return false, fmt.Errorf("%w: tx %s: %s", p2p.ErrInvalid, t.ID, strings.Join(problems, "; "))
+	}
+	// the sender registered on another node; CheckTx tied the key to the wallet ID
+	if !registered {
+		if err := s.learnWallet(t); err != nil {
+			return false, err
+		}
+	}
+	if err := s.commitPending(t); err != nil {
+		if errors.Is(err, mempool.ErrDuplicate) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// learnWallet registers the sender of a validated, signed transaction if
+// this node has not seen the wallet before, so its nonce is tracked here too.
+func (s *Server) learnWallet(t *utxo.Transaction) error {
+	if t.SenderPublicKey == "" {
+		return nil
+	}
+	if _, err := s.store.GetWalletPublicKey(t.Sender); err == nil {
+		return nil
+	}
+	if err := s.store.RegisterWallet(t.Sender, t.SenderPublicKey); err != nil {
+		return fmt.Errorf("failed to register wallet %s: %w", t.Sender, err)
+	}
+	utxo.RegisterWallet(t.Sender, t.SenderPublicKey)
+	return nil
+}
+
+// peersHandler lists the node's connected peers.
+func (s *Server) peersHandler(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{
+		"node_id":     nil,
+		"listen_addr": nil,
+		"peers":       []p2p.PeerInfo{},
+	}
+	if s.node != nil {
+		resp["node_id"] = s.node.ID()
+		resp["listen_addr"] = s.node.Addr()
+		resp["peers"] = s.node.Peers()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
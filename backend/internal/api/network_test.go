@@ -0,0 +1,371 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/chainparams"
+	"github.com/student/decentralized-wallet/internal/crypto"
+	"github.com/student/decentralized-wallet/internal/db"
+	"github.com/student/decentralized-wallet/internal/p2p"
+	"github.com/student/decentralized-wallet/internal/utxo"
+	"github.com/student/decentralized-wallet/wallet"
+)
+
+// testNet is a network of nodes on localhost sharing chain params, with an
+// authority key to fund wallets through blocks.
+type testNet struct {
+	params    *chainparams.Params
+	genesis   *blockchain.Block
+	authority ed25519.PrivateKey
+}
+
+func newTestNet(t *testing.T) *testNet {
+	t.Helper()
+	_, authority, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := chainparams.Default()
+	p.ChainID = "test-net"
+	p.Difficulty.InitialDifficulty = 1
+	p.Difficulty.RetargetInterval = 0
+	p.AuthorityKey = base64.StdEncoding.EncodeToString(authority.Public().(ed25519.PublicKey))
+	return &testNet{params: p, genesis: p.Genesis(), authority: authority}
+}
+
+type testNode struct {
+	*Server
+	p2p     *p2p.Node
+	handler http.Handler
+}
+
+// node starts a server on a fresh store, listening for peers on a random
+// port. An authority node holds the key that signs admin funding.
+func (n *testNet) node(t *testing.T, authority bool) *testNode {
+	t.Helper()
+	store := db.NewMemoryStore()
+	if err := db.EnsureGenesis(store, n.genesis); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(store, n.params)
+	if authority {
+		if err := s.SetAuthority(n.authority); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node := p2p.NewNode(p2p.Config{ListenAddr: "127.0.0.1:0", ChainID: n.params.ChainID, GenesisHash: n.genesis.Hash}, s)
+	if err := node.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(node.Stop)
+	s.SetNode(node)
+	return &testNode{Server: s, p2p: node, handler: s.Router()}
+}
+
+func connect(t *testing.T, from, to *testNode) {
+	t.Helper()
+	if err := from.p2p.Connect(to.p2p.Addr()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// request sends an admin request to h and returns the status and body.
+func request(t *testing.T, h http.Handler, method, path string, body interface{}) (int, string) {
+	t.Helper()
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rd = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, rd)
+	req.Header.Set("Authorization", "Bearer test")
+	req = req.WithContext(context.WithValue(req.Context(), "claims", map[string]interface{}{"admin": true}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr.Code, rr.Body.String()
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func (n *testNode) height() int64 {
+	h, _ := n.Tip()
+	return h
+}
+
+// mine mines and submits the next block, empty or not.
+func (n *testNode) mine(t *testing.T) *blockchain.Block {
+	t.Helper()
+	tmpl, err := n.NewTemplate("miner", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockchain.MineBlock(tmpl.Block)
+	if err := n.SubmitBlock(tmpl); err != nil {
+		t.Fatal(err)
+	}
+	return tmpl.Block
+}
+
+// fund pays amount to walletID through the node's admin funding and returns
+// the funding output.
+func (n *testNode) fund(t *testing.T, walletID string, amount int64) string {
+	t.Helper()
+	code, body := request(t, n.handler, "POST", "/api/admin/fund", map[string]interface{}{"wallet_id": walletID, "amount": amount})
+	if code != http.StatusOK {
+		t.Fatalf("fund: %d %s", code, body)
+	}
+	var resp map[string]string
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp["utxo_id"]
+}
+
+// unspent returns the store's unspent balance of walletID.
+func (n *testNode) unspent(t *testing.T, walletID string) int64 {
+	t.Helper()
+	us, err := n.store.GetUnspentUTXOsByWallet(walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, u := range us {
+		total += u.Amount
+	}
+	return total
+}
+
+type testWallet struct {
+	priv ed25519.PrivateKey
+	pub  string
+	id   string
+}
+
+func newTestWallet(t *testing.T) testWallet {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey))
+	return testWallet{priv: priv, pub: pub, id: crypto.WalletIDFromPublicKey(pub)}
+}
+
+// register answers a registration challenge from n for w.
+func (n *testNode) register(t *testing.T, w testWallet) {
+	t.Helper()
+	code, body := request(t, n.handler, "POST", "/api/wallets/challenge", map[string]string{"user_id": "user"})
+	var ch map[string]string
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &ch) != nil {
+		t.Fatalf("challenge: %d %s", code, body)
+	}
+	sig := ed25519.Sign(w.priv, wallet.RegistrationMessage(n.params.ChainID, "user", ch["nonce"], w.pub))
+	code, body = request(t, n.handler, "POST", "/api/wallets/register", map[string]string{
+		"public_key": w.pub, "nonce": ch["nonce"], "signature": base64.StdEncoding.EncodeToString(sig), "user_id": "user",
+	})
+	if code != http.StatusOK && code != http.StatusCreated {
+		t.Fatalf("register: %d %s", code, body)
+	}
+}
+
+// send spends in, worth inAmt, paying amt to the wallet to and the rest less
+// fee back to from, through n's API.
+func (n *testNode) send(t *testing.T, from testWallet, in string, inAmt int64, to string, amt, fee int64, nonce uint64) *utxo.Transaction {
+	t.Helper()
+	code, body, tx := n.trySend(t, from, in, inAmt, []utxo.TxOutput{{Recipient: to, Amount: amt}}, fee, nonce, n.params.ChainID)
+	if code != http.StatusOK {
+		t.Fatalf("send: %d %s", code, body)
+	}
+	return tx
+}
+
+// trySend signs a send of in, worth inAmt, to the wallets in outs on chainID,
+// with the rest less fee back to from, and returns n's response together with
+// the tx the server should derive from it.
+func (n *testNode) trySend(t *testing.T, from testWallet, in string, inAmt int64, outs []utxo.TxOutput, fee int64, nonce uint64, chainID string) (int, string, *utxo.Transaction) {
+	t.Helper()
+	ts := time.Now().UTC().Format(time.RFC3339Nano)
+	reqOuts := make([]map[string]interface{}, 0, len(outs))
+	change := inAmt - fee
+	for _, o := range outs {
+		addr, err := wallet.EncodeAddress(n.params.AddressPrefix, o.Recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqOuts = append(reqOuts, map[string]interface{}{"recipient": addr, "amount": o.Amount})
+		change -= o.Amount
+	}
+	outs = append([]utxo.TxOutput(nil), outs...)
+	if change > 0 {
+		outs = append(outs, utxo.TxOutput{Recipient: from.id, Amount: change})
+	}
+	tx := &utxo.Transaction{Sender: from.id, SenderPublicKey: from.pub, Inputs: []string{in}, Outputs: outs, ClientTimestamp: ts, ChainID: chainID, Nonce: nonce}
+	code, body := request(t, n.handler, "POST", "/api/tx/send", map[string]interface{}{
+		"sender": from.id, "sender_public_key": from.pub, "inputs": []string{in},
+		"outputs": reqOuts, "fee": fee,
+		"timestamp": ts, "chain_id": chainID, "nonce": nonce,
+		"signature": base64.StdEncoding.EncodeToString(ed25519.Sign(from.priv, tx.SigningBytes())),
+	})
+	tx.ID = tx.ComputeID()
+	return code, body, tx
+}
+
+func (n *testNode) validate(t *testing.T) {
+	t.Helper()
+	code, body := request(t, n.handler, "POST", "/api/admin/validate_chain", nil)
+	var r blockchain.ValidationReport
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &r) != nil || !r.OK {
+		t.Fatalf("validate_chain: %d %s", code, body)
+	}
+}
+
+func TestNetworkRelaysTxsAndBlocks(t *testing.T) {
+	net := newTestNet(t)
+	a, b, c := net.node(t, true), net.node(t, false), net.node(t, false)
+	// c - b - a
+	connect(t, b, a)
+	connect(t, c, b)
+	waitFor(t, "peers", func() bool { return len(b.p2p.Peers()) == 2 })
+
+	// funding is mined, so every node learns of it
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	in := a.fund(t, alice.id, 1000)
+	funded := a.mine(t)
+	waitFor(t, "block relayed to c", func() bool { return c.height() == funded.Index })
+	if got := c.unspent(t, alice.id); got != 1000 {
+		t.Fatalf("alice has %d on c, want 1000", got)
+	}
+
+	// a transaction sent to b reaches both ends
+	b.register(t, alice)
+	tx := b.send(t, alice, in, 1000, bob.id, 100, 5, 1)
+	waitFor(t, "tx relayed", func() bool {
+		_, onA := a.pool.Get(tx.ID)
+		_, onC := c.pool.Get(tx.ID)
+		return onA && onC
+	})
+
+	// a block mined at one end confirms it at the other
+	blk := c.mine(t)
+	waitFor(t, "block relayed to a", func() bool { return a.height() == blk.Index })
+	if a.pool.Len() != 0 {
+		t.Errorf("a's mempool holds %d txs after the block", a.pool.Len())
+	}
+	for name, n := range map[string]*testNode{"a": a, "b": b, "c": c} {
+		if got := n.unspent(t, bob.id); got != 100 {
+			t.Errorf("bob has %d on %s, want 100", got, name)
+		}
+		n.validate(t)
+	}
+}
+
+func TestNetworkRefusesLocalFunding(t *testing.T) {
+	net := newTestNet(t)
+	bob := newTestWallet(t)
+	networked := net.node(t, false)
+	code, body := request(t, networked.handler, "POST", "/api/admin/fund", map[string]interface{}{"wallet_id": bob.id, "amount": 10})
+	if code != http.StatusConflict {
+		t.Errorf("funding without the authority key on a networked node: %d %s", code, body)
+	}
+
+	// a node on its own may still record funding outside blocks
+	store := db.NewMemoryStore()
+	if err := db.EnsureGenesis(store, net.genesis); err != nil {
+		t.Fatal(err)
+	}
+	alone := NewServer(store, net.params)
+	if code, body := request(t, alone.Router(), "POST", "/api/admin/fund", map[string]interface{}{"wallet_id": bob.id, "amount": 10}); code != http.StatusOK {
+		t.Errorf("funding a standalone node: %d %s", code, body)
+	}
+}
+
+func TestNodeSyncsFromPeers(t *testing.T) {
+	net := newTestNet(t)
+	a := net.node(t, true)
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	a.register(t, alice)
+	in, amt := a.fund(t, alice.id, 10000), int64(10000)
+	for i := 1; i <= 40; i++ {
+		if i%10 == 0 {
+			tx := a.send(t, alice, in, amt, bob.id, 10, 1, uint64(i/10))
+			in, amt = tx.OutputUTXOID(1), amt-11
+		}
+		a.mine(t)
+	}
+
+	b := net.node(t, false)
+	connect(t, b, a)
+	waitFor(t, "b synced", func() bool { return b.height() == a.height() })
+	if got, want := b.unspent(t, alice.id), a.unspent(t, alice.id); got != want || want != amt {
+		t.Errorf("alice has %d on b, %d on a, want %d", got, want, amt)
+	}
+	if got := b.unspent(t, bob.id); got != 40 {
+		t.Errorf("bob has %d on b, want 40", got)
+	}
+	b.validate(t)
+
+	// once synced, new blocks are relayed
+	blk := a.mine(t)
+	waitFor(t, "block relayed", func() bool { return b.height() == blk.Index })
+}
+
+func TestNodesReorgToHeavierChain(t *testing.T) {
+	net := newTestNet(t)
+	a, b := net.node(t, true), net.node(t, false)
+	alice, bob := newTestWallet(t), newTestWallet(t)
+
+	// apart, a mines funding and a spend of it in two blocks, b three empty blocks
+	a.register(t, alice)
+	in := a.fund(t, alice.id, 1000)
+	a.mine(t)
+	tx := a.send(t, alice, in, 1000, bob.id, 100, 5, 1)
+	a.mine(t)
+	for i := 0; i < 3; i++ {
+		b.mine(t)
+	}
+	_, bTip := b.Tip()
+
+	connect(t, a, b)
+	waitFor(t, "a reorganized", func() bool { _, h := a.Tip(); return h == bTip })
+	if _, err := a.store.GetTransactionByID(tx.ID); err == nil {
+		t.Error("spend still recorded as mined on a after the reorg")
+	}
+	if _, ok := a.pool.Get(tx.ID); !ok {
+		t.Fatal("spend did not return to a's mempool")
+	}
+
+	// a mines the funding and spend again on the new chain
+	blk := a.mine(t)
+	if len(blk.Transactions) != 3 {
+		t.Fatalf("block has %d txs, want the coinbase, funding and spend", len(blk.Transactions))
+	}
+	waitFor(t, "block relayed to b", func() bool { return b.height() == blk.Index })
+	for name, n := range map[string]*testNode{"a": a, "b": b} {
+		if got := n.unspent(t, bob.id); got != 100 {
+			t.Errorf("bob has %d on %s, want 100", got, name)
+		}
+		n.validate(t)
+	}
+}
@@ -1,20 +1,97 @@
 package api
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/chainparams"
 	"github.com/student/decentralized-wallet/internal/db"
+	"github.com/student/decentralized-wallet/internal/mempool"
+	"github.com/student/decentralized-wallet/internal/miner"
+	"github.com/student/decentralized-wallet/internal/p2p"
 	"github.com/student/decentralized-wallet/internal/utxo"
+	"github.com/student/decentralized-wallet/wallet"
 )
 
-// NewRouter returns an http.Handler with the API routes registered.
-func NewRouter() http.Handler {
+// Server holds the dependencies shared by the API handlers.
+type Server struct {
+	store  db.Store
+	params *chainparams.Params
+	pool   *mempool.Pool
+	miner  *miner.Service
+	// node gossips with peers; nil when the server runs standalone.
+	node *p2p.Node
+	// authority signs zakat deductions; nil unless this node holds the
+	// network authority's key.
+	authority ed25519.PrivateKey
+
+	// chainMu serialises changes to the chain and guards the fields below.
+	chainMu sync.Mutex
+	// index holds every known block header, main chain and side branches.
+	index *blockchain.BlockIndex
+	// tip is the main chain's last block.
+	tip *blockchain.IndexEntry
+	// validator has replayed the main chain up to tip; nil until first needed.
+	validator *blockchain.ChainValidator
+	// utxoReport describes the last rebuild of the in-memory UTXO set.
+	utxoReport *UTXOSetReport
+
+	// challenges holds the nonces issued for wallet registration.
+	challenges *challengeStore
+	// partialMu serialises co-signers updating partial multisig transactions.
+	partialMu sync.Mutex
+}
+
+// NewServer returns a Server backed by the given store, on the network params
+// describe. The mempool is reloaded from the store's pending transactions and
+// the in-memory UTXO set is rebuilt from the stored chain.
+func NewServer(store db.Store, params *chainparams.Params) *Server {
+	pool := mempool.New(mempoolConfig(params))
+	s := &Server{store: store, params: params, pool: pool, challenges: newChallengeStore()}
+	s.miner = miner.New(s)
+	if pending, err := store.ListPendingTxs(); err == nil {
+		txs := make([]*utxo.Transaction, 0, len(pending))
+		acceptedAt := make(map[string]time.Time, len(pending))
+		for _, r := range pending {
+			txs = append(txs, &r.Transaction)
+			acceptedAt[r.ID] = r.AcceptedAt
+		}
+		// limits lowered since the last run drop the worst-paying txs
+		s.dropPending(pool.Reload(txs, func(t *utxo.Transaction) time.Time { return acceptedAt[t.ID] }))
+	} else {
+		log.Printf("mempool: failed to load pending txs: %v", err)
+	}
+	if err := s.loadBlockIndex(); err != nil {
+		log.Printf("chain: failed to load block index: %v", err)
+	}
+	s.loadUTXOSet()
+	return s
+}
+
+// SetAuthority gives the server the network authority's private key, which
+// signs the zakat deductions it makes. Its public half must be the network's
+// authority_key. Call it before serving requests.
+func (s *Server) SetAuthority(key ed25519.PrivateKey) error {
+	pub := base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	if pub != s.params.AuthorityKey {
+		return fmt.Errorf("key %s is not the network's authority_key", pub)
+	}
+	s.authority = key
+	return nil
+}
+
+// Router returns an http.Handler with the API routes registered.
+func (s *Server) Router() http.Handler {
 	r := mux.NewRouter()
 
 	// Simple CORS middleware: allow typical dev origins and handle preflight
@@ -39,71 +116,114 @@ func NewRouter() http.Handler {
 		w.WriteHeader(http.StatusNoContent)
 	}).Methods("OPTIONS")
 
-	r.HandleFunc("/api/status", statusHandler).Methods("GET")
-	r.HandleFunc("/api/debug/state", debugStateHandler).Methods("GET")
-	r.HandleFunc("/api/blocks", blocksHandler).Methods("GET")
-	r.HandleFunc("/api/blocks/{index}", blockDetailHandler).Methods("GET")
-	r.HandleFunc("/api/txs/{id}", txHandler).Methods("GET")
-	r.HandleFunc("/api/wallets/{id}", walletHandler).Methods("GET")
-	r.HandleFunc("/api/wallets/register", RequireAuth(registerWalletHandler)).Methods("POST")
-	r.HandleFunc("/api/tx/send", RequireAuth(sendTxHandler)).Methods("POST")
-	r.HandleFunc("/api/transactions/filter", filterTransactionsHandler).Methods("GET")
+	r.HandleFunc("/api/status", s.statusHandler).Methods("GET")
+	r.HandleFunc("/api/mempool", s.mempoolHandler).Methods("GET")
+	r.HandleFunc("/api/debug/state", s.debugStateHandler).Methods("GET")
+	r.HandleFunc("/api/chain/tips", s.chainTipsHandler).Methods("GET")
+	r.HandleFunc("/api/peers", s.peersHandler).Methods("GET")
+	r.HandleFunc("/api/blocks", s.blocksHandler).Methods("GET")
+	r.HandleFunc("/api/blocks/{index}", s.blockDetailHandler).Methods("GET")
+	r.HandleFunc("/api/blocks/{index}/header", s.blockHeaderHandler).Methods("GET")
+	r.HandleFunc("/api/headers", s.headersHandler).Methods("GET")
+	r.HandleFunc("/api/txs/{id}", s.txHandler).Methods("GET")
+	r.HandleFunc("/api/txs/{id}/proof", s.txProofHandler).Methods("GET")
+	r.HandleFunc("/api/wallets/{id}", s.walletHandler).Methods("GET")
+	r.HandleFunc("/api/wallets/challenge", RequireAuth(s.challengeHandler)).Methods("POST")
+	r.HandleFunc("/api/wallets/register", RequireAuth(s.registerWalletHandler)).Methods("POST")
+	r.HandleFunc("/api/wallets/register/batch", RequireAuth(s.registerBatchHandler)).Methods("POST")
+	r.HandleFunc("/api/wallets/multisig", RequireAuth(s.registerMultisigHandler)).Methods("POST")
+	r.HandleFunc("/api/address/validate", s.validateAddressHandler).Methods("GET")
+	r.HandleFunc("/api/tx/send", RequireAuth(s.sendTxHandler)).Methods("POST")
+	r.HandleFunc("/api/transactions/filter", s.filterTransactionsHandler).Methods("GET")
+	r.HandleFunc("/api/multisig/txs", RequireAuth(s.proposeMultisigHandler)).Methods("POST")
+	r.HandleFunc("/api/multisig/txs", RequireAuth(s.listMultisigTxsHandler)).Methods("GET")
+	r.HandleFunc("/api/multisig/txs/{id}", RequireAuth(s.getMultisigTxHandler)).Methods("GET")
+	r.HandleFunc("/api/multisig/txs/{id}/signatures", RequireAuth(s.signMultisigHandler)).Methods("POST")
 	// User profile endpoints
-	r.HandleFunc("/api/users", RequireAuth(createUserHandler)).Methods("POST")
-	r.HandleFunc("/api/users/{id}", RequireAuth(getUserHandler)).Methods("GET")
-	r.HandleFunc("/api/users/{id}", RequireAuth(updateUserHandler)).Methods("PUT")
-	r.HandleFunc("/api/users/{id}/beneficiaries", RequireAuth(listBeneficiariesHandler)).Methods("GET")
-	r.HandleFunc("/api/users/{id}/beneficiaries", RequireAuth(addBeneficiaryHandler)).Methods("POST")
-	r.HandleFunc("/api/users/{id}/beneficiaries", RequireAuth(removeBeneficiaryHandler)).Methods("DELETE")
+	r.HandleFunc("/api/users", RequireAuth(s.createUserHandler)).Methods("POST")
+	r.HandleFunc("/api/users/{id}", RequireAuth(s.getUserHandler)).Methods("GET")
+	r.HandleFunc("/api/users/{id}", RequireAuth(s.updateUserHandler)).Methods("PUT")
+	r.HandleFunc("/api/users/{id}/beneficiaries", RequireAuth(s.listBeneficiariesHandler)).Methods("GET")
+	r.HandleFunc("/api/users/{id}/beneficiaries", RequireAuth(s.addBeneficiaryHandler)).Methods("POST")
+	r.HandleFunc("/api/users/{id}/beneficiaries", RequireAuth(s.removeBeneficiaryHandler)).Methods("DELETE")
+	r.HandleFunc("/api/users/{id}/wallets", RequireAuth(s.listUserWalletsHandler)).Methods("GET")
 	// Admin endpoints
 	// Admin endpoints require auth first so claims are present, then admin check
-	r.HandleFunc("/api/admin/mine", RequireAuth(RequireAdmin(adminMineHandler))).Methods("POST")
-	r.HandleFunc("/api/admin/zakat", RequireAuth(RequireAdmin(adminZakatHandler))).Methods("POST")
-	r.HandleFunc("/api/admin/validate_chain", RequireAuth(RequireAdmin(validateChainHandler))).Methods("POST")
-	r.HandleFunc("/api/admin/fund", RequireAuth(RequireAdmin(adminFundHandler))).Methods("POST")
+	r.HandleFunc("/api/admin/mine", RequireAuth(RequireAdmin(s.adminMineHandler))).Methods("POST")
+	r.HandleFunc("/api/admin/zakat", RequireAuth(RequireAdmin(s.adminZakatHandler))).Methods("POST")
+	r.HandleFunc("/api/admin/validate_chain", RequireAuth(RequireAdmin(s.validateChainHandler))).Methods("POST")
+	r.HandleFunc("/api/admin/fund", RequireAuth(RequireAdmin(s.adminFundHandler))).Methods("POST")
+	r.HandleFunc("/api/admin/miner/start", RequireAuth(RequireAdmin(s.minerStartHandler))).Methods("POST")
+	r.HandleFunc("/api/admin/miner/stop", RequireAuth(RequireAdmin(s.minerStopHandler))).Methods("POST")
+	r.HandleFunc("/api/admin/miner/status", RequireAuth(RequireAdmin(s.minerStatusHandler))).Methods("GET")
+	r.HandleFunc("/api/admin/utxo/report", RequireAuth(RequireAdmin(s.utxoReportHandler))).Methods("GET")
+	r.HandleFunc("/api/admin/utxo/rebuild", RequireAuth(RequireAdmin(s.utxoRebuildHandler))).Methods("POST")
 	// One-time bootstrap: set admin claim using server-side INITIAL_ADMIN_TOKEN
 	r.HandleFunc("/api/admin/make_admin", makeAdminHandler).Methods("POST")
 
 	// Logs
-	r.HandleFunc("/api/logs", RequireAuth(createLogHandler)).Methods("POST")
-	r.HandleFunc("/api/admin/logs", RequireAuth(listLogsHandler)).Methods("GET")
+	r.HandleFunc("/api/logs", RequireAuth(s.createLogHandler)).Methods("POST")
+	r.HandleFunc("/api/admin/logs", RequireAuth(s.listLogsHandler)).Methods("GET")
 	return r
 }
 
-func statusHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
 	resp := map[string]interface{}{
-		"status":        "ok",
-		"time":          time.Now().UTC(),
-		"pending_txs":   0,
-		"chain_tip":     nil,
-		"difficulty":    "00000",
-		"firestore_enabled": db.FSClient != nil,
+		"status":            "ok",
+		"time":              time.Now().UTC(),
+		"pending_txs":       s.pool.Len(),
+		"chain_tip":         nil,
+		"bits":              nil,
+		"chain_work":        nil,
+		"storage":           s.store.Backend(),
+		"chain_id":          s.params.ChainID,
+		"address_prefix":    s.params.AddressPrefix,
+		"genesis_hash":      s.params.Genesis().Hash,
+		"firestore_enabled": s.store.Backend() == "firestore",
+		"peers":             0,
+		"sync":              nil,
+	}
+	if s.node != nil {
+		resp["peers"] = len(s.node.Peers())
+		resp["sync"] = s.node.SyncStatus()
+	}
+	s.chainMu.Lock()
+	if s.tip != nil {
+		resp["chain_tip"] = map[string]interface{}{"height": s.tip.Header.Index, "hash": s.tip.Header.Hash}
+		resp["chain_work"] = s.tip.ChainWork.String()
+	}
+	s.chainMu.Unlock()
+	// the target the next block must meet
+	if index, _, err := s.store.GetLatestBlock(); err == nil {
+		if bits, err := s.nextBits(index + 1); err == nil {
+			resp["bits"] = fmt.Sprintf("%08x", bits)
+		}
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-// debugStateHandler returns in-memory UTXO and pending tx state when Firestore is not configured.
+// debugStateHandler returns the node's in-memory UTXO and pending tx state when running without Firestore.
 // This is a development helper and should not be enabled in production.
-func debugStateHandler(w http.ResponseWriter, r *http.Request) {
-	if db.FSClient != nil {
+func (s *Server) debugStateHandler(w http.ResponseWriter, r *http.Request) {
+	if s.store.Backend() == "firestore" {
 		http.Error(w, "firestore configured — debug state unavailable", http.StatusServiceUnavailable)
 		return
 	}
 	// return utxo set and pending txs from in-memory packages
-	type debugResp struct{
+	type debugResp struct {
 		UTXOSet interface{} `json:"utxo_set"`
 		Pending interface{} `json:"pending_txs"`
 	}
 	resp := debugResp{
-		UTXOSet: utxo.UTXOSet,
-		Pending: utxo.PendingTxs,
+		UTXOSet: utxo.UTXOSet.Snapshot(),
+		Pending: s.pool.Txs(),
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-func blocksHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) blocksHandler(w http.ResponseWriter, r *http.Request) {
 	// list recent blocks (limit optional via ?limit)
 	limit := 20
 	if v := r.URL.Query().Get("limit"); v != "" {
@@ -112,22 +232,16 @@ func blocksHandler(w http.ResponseWriter, r *http.Request) {
 			limit = n
 		}
 	}
-	if db.FSClient != nil {
-		blks, err := db.ListBlocks(limit)
-		if err != nil {
-			http.Error(w, "failed to list blocks: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(blks)
+	blks, err := s.store.ListBlocks(limit)
+	if err != nil {
+		http.Error(w, "failed to list blocks: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// fallback: no persisted blocks
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode([]interface{}{})
+	json.NewEncoder(w).Encode(blks)
 }
 
-func blockDetailHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) blockDetailHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	idxStr := vars["index"]
 	i64, err := strconv.ParseInt(idxStr, 10, 64)
@@ -135,96 +249,193 @@ func blockDetailHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid index", http.StatusBadRequest)
 		return
 	}
-	if db.FSClient != nil {
-		b, err := db.GetBlockByIndex(i64)
-		if err != nil {
-			http.Error(w, "block not found: "+err.Error(), http.StatusNotFound)
+	b, err := s.store.GetBlockByIndex(i64)
+	if err != nil {
+		http.Error(w, "block not found: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b)
+}
+
+// blockHeaderHandler returns only the header of a block, for light clients.
+func (s *Server) blockHeaderHandler(w http.ResponseWriter, r *http.Request) {
+	i64, err := strconv.ParseInt(mux.Vars(r)["index"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid index", http.StatusBadRequest)
+		return
+	}
+	b, err := s.store.GetBlockByIndex(i64)
+	if err != nil {
+		http.Error(w, "block not found: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b.Header())
+}
+
+// maxHeadersPerRequest caps /api/headers, matching what a p2p peer may ask for.
+const maxHeadersPerRequest = p2p.MaxHeaders
+
+// headersHandler returns a run of main-chain headers (?from=height&count=n)
+// so light clients can follow the chain without downloading blocks.
+func (s *Server) headersHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := strconv.ParseInt(q.Get("from"), 10, 64)
+	if err != nil || from < 0 {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	count := maxHeadersPerRequest
+	if v := q.Get("count"); v != "" {
+		if count, err = strconv.Atoi(v); err != nil || count <= 0 {
+			http.Error(w, "invalid count", http.StatusBadRequest)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(b)
-		return
+		if count > maxHeadersPerRequest {
+			count = maxHeadersPerRequest
+		}
+	}
+	resp := map[string]interface{}{"tip_height": nil, "tip_hash": nil}
+	s.chainMu.Lock()
+	headers := s.mainChainHeaders(from, count)
+	if s.tip != nil {
+		resp["tip_height"] = s.tip.Height()
+		resp["tip_hash"] = s.tip.Hash()
 	}
-	http.Error(w, "firestore not configured", http.StatusServiceUnavailable)
+	s.chainMu.Unlock()
+	if headers == nil {
+		headers = []blockchain.BlockHeader{}
+	}
+	resp["headers"] = headers
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
-func txHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) txHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
-	if db.FSClient != nil {
-		t, err := db.GetTransactionByID(id)
-		if err != nil {
-			http.Error(w, "tx not found: "+err.Error(), http.StatusNotFound)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(t)
+	t, err := s.store.GetTransactionByID(id)
+	if err != nil {
+		http.Error(w, "tx not found: "+err.Error(), http.StatusNotFound)
 		return
 	}
-	http.Error(w, "firestore not configured", http.StatusServiceUnavailable)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
 }
 
-// validateChainHandler runs lightweight validation over stored blocks: merkle root recompute and previous_hash linking.
-func validateChainHandler(w http.ResponseWriter, r *http.Request) {
-	if db.FSClient == nil {
-		http.Error(w, "firestore not configured", http.StatusServiceUnavailable)
+// txProofHandler returns the Merkle audit path proving a mined tx is included
+// in its block, with the block header and the tx itself, so a light client
+// can check the tx against a header chain it verified on its own.
+func (s *Server) txProofHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	t, err := s.store.GetTransactionByID(id)
+	if err != nil {
+		http.Error(w, "tx not found: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	if t.BlockHash == "" {
+		http.Error(w, "tx is not included in a block", http.StatusNotFound)
 		return
 	}
-	// fetch many blocks (limit 1000 for safety)
-	blks, err := db.ListBlocks(1000)
+	b, err := s.store.GetBlockByIndex(t.BlockIndex)
 	if err != nil {
-		http.Error(w, "failed to fetch blocks: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "block not found: "+err.Error(), http.StatusNotFound)
 		return
 	}
-	// blocks returned in desc order; reverse to ascend
-	for i, j := 0, len(blks)-1; i < j; i, j = i+1, j-1 {
-		blks[i], blks[j] = blks[j], blks[i]
-	}
-	// iterate and validate links
-	var prevHash string
-	problems := []string{}
-	for _, b := range blks {
-		// check merkle root vs recomputed
-		txsIface, _ := b["transactions"].([]interface{})
-		txIDs := make([]string, 0, len(txsIface))
-		for _, t := range txsIface {
-			if s, ok := t.(string); ok {
-				txIDs = append(txIDs, s)
-			}
-		}
-		recomputed := blockchain.ComputeMerkleRoot(txIDs)
-		if mr, _ := b["merkle_root"].(string); mr != recomputed {
-			problems = append(problems, "merkle root mismatch for index: "+fmt.Sprint(b["index"]))
-		}
-		if ph, _ := b["previous_hash"].(string); ph != prevHash && prevHash != "" {
-			problems = append(problems, "previous_hash mismatch at index: "+fmt.Sprint(b["index"]))
-		}
-		if h, _ := b["hash"].(string); h == "" {
-			problems = append(problems, "missing hash at index: "+fmt.Sprint(b["index"]))
-		}
-		prevHash, _ = b["hash"].(string)
+	if b.Hash != t.BlockHash {
+		http.Error(w, "tx block is not on the main chain", http.StatusNotFound)
+		return
+	}
+	proof, err := blockchain.BuildMerkleProof(b.TxIDs(), id)
+	if err != nil {
+		http.Error(w, "failed to build proof: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	resp := map[string]interface{}{
+		"tx_id":       proof.TxID,
+		"block_index": b.Index,
+		"block_hash":  b.Hash,
+		"merkle_root": b.MerkleRoot,
+		"index":       proof.Index,
+		"path":        proof.Path,
+		"header":      b.Header(),
+		"tx":          t.Transaction,
 	}
-	resp := map[string]interface{}{"ok": len(problems) == 0, "problems": problems}
+	s.chainMu.Lock()
+	if s.tip != nil {
+		resp["confirmations"] = s.tip.Height() - b.Index + 1
+	}
+	s.chainMu.Unlock()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-func walletHandler(w http.ResponseWriter, r *http.Request) {
+// validateChainHandler streams the whole stored chain through blockchain.ValidateChain:
+// header hashes, PoW, linkage, merkle roots, signatures and a full UTXO replay from genesis.
+func (s *Server) validateChainHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := s.validationParams()
+	if err != nil {
+		http.Error(w, "failed to fetch transactions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report, err := blockchain.ValidateChain(func(check func(*blockchain.Block) error) error {
+		return s.store.ForEachBlock(check)
+	}, params)
+	if err != nil {
+		http.Error(w, "failed to read blocks: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// Wallet UTXO pages: ?limit= defaults to defaultUTXOPage and is capped at maxUTXOPage.
+const (
+	defaultUTXOPage = 500
+	maxUTXOPage     = 1000
+)
+
+// walletHandler returns a wallet's balance and a page of its unspent outputs
+// in ID order; pass next_cursor back as ?after= for the next page.
+func (s *Server) walletHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
-	balance := utxo.WalletBalance(id)
-	// gather UTXOs
-	var list []interface{}
-	// quick scan (in-memory)
-	for _, u := range utxo.UTXOSet {
-		if u.WalletID == id && !u.Spent {
-			list = append(list, u)
+	// the wallet may be named by its address as well as its ID
+	if strings.HasPrefix(strings.ToLower(id), s.params.AddressPrefix+"1") {
+		decoded, err := wallet.DecodeAddress(s.params.AddressPrefix, id)
+		if err != nil {
+			http.Error(w, "invalid address: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		id = decoded
+	}
+	limit := defaultUTXOPage
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
 		}
+		limit = n
 	}
+	if limit > maxUTXOPage {
+		limit = maxUTXOPage
+	}
+	balance := utxo.WalletBalance(id)
+	list, next := utxo.UTXOSet.List(id, r.URL.Query().Get("after"), limit)
+	// unregistered wallets have never signed anything, so their last nonce is 0
+	nonce, _ := s.store.GetWalletNonce(id)
+	addr, _ := wallet.EncodeAddress(s.params.AddressPrefix, id)
 	resp := map[string]interface{}{
-		"wallet_id": id,
-		"balance":   balance,
-		"utxos":     list,
+		"wallet_id":   id,
+		"address":     addr,
+		"balance":     balance,
+		"utxos":       list,
+		"utxo_count":  utxo.UTXOSet.Count(id),
+		"next_cursor": next,
+		"nonce":       nonce,
+		"next_nonce":  nonce + 1,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStatusReportsChainTip(t *testing.T) {
+	n := newTestNet(t).node(t, true)
+	blk := n.mine(t)
+	code, body := request(t, n.handler, "GET", "/api/status", nil)
+	if code != 200 {
+		t.Fatalf("status %d: %s", code, body)
+	}
+	var resp struct {
+		ChainTip *struct {
+			Height int64  `json:"height"`
+			Hash   string `json:"hash"`
+		} `json:"chain_tip"`
+		ChainWork string `json:"chain_work"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ChainTip == nil || resp.ChainTip.Height != blk.Index || resp.ChainTip.Hash != blk.Hash {
+		t.Errorf("chain_tip %+v, want height %d hash %s", resp.ChainTip, blk.Index, blk.Hash)
+	}
+	if want := n.tip.ChainWork.String(); resp.ChainWork != want {
+		t.Errorf("chain_work %s, want %s", resp.ChainWork, want)
+	}
+}
@@ -1,56 +1,127 @@
 package api
 
 import (
-    "crypto/sha256"
-    "encoding/hex"
+    "encoding/base64"
     "encoding/json"
+    "errors"
     "io/ioutil"
     "net/http"
     "strconv"
     "strings"
     "time"
 
+    "github.com/student/decentralized-wallet/internal/blockchain"
     "github.com/student/decentralized-wallet/internal/crypto"
     "github.com/student/decentralized-wallet/internal/db"
     "github.com/student/decentralized-wallet/internal/utxo"
+    "github.com/student/decentralized-wallet/wallet"
 )
 
+// registerReq proves the caller holds the key being registered: Signature is
+// the key's signature of wallet.RegistrationMessage over a challenge Nonce
+// from /api/wallets/challenge. The wallet ID is always derived from the key.
 type registerReq struct {
     PublicKey string `json:"public_key"` // base64
+    Nonce     string `json:"nonce"`
+    Signature string `json:"signature"` // base64
+    // WalletID is optional; when given it must be the ID derived from the key.
     WalletID  string `json:"wallet_id,omitempty"`
+    // UserID names the owner when auth is not configured (local dev only).
+    UserID    string `json:"user_id,omitempty"`
 }
 
-func registerWalletHandler(w http.ResponseWriter, r *http.Request) {
+// registerWalletHandler registers one key to the authenticated user. A
+// wallet another user already owns is refused with 409.
+func (s *Server) registerWalletHandler(w http.ResponseWriter, r *http.Request) {
     var req registerReq
-    body, _ := ioutil.ReadAll(r.Body)
-    json.Unmarshal(body, &req)
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    uid := requestUID(r, req.UserID)
+    if uid == "" {
+        http.Error(w, "uid required", http.StatusUnauthorized)
+        return
+    }
     pub := strings.TrimSpace(req.PublicKey)
-    var walletID string
-    if req.WalletID != "" {
-        walletID = req.WalletID
-    } else {
-        // wallet id = sha256(pubkey)
-        h := sha256.Sum256([]byte(pub))
-        walletID = hex.EncodeToString(h[:])
+    // the wallet ID hashes the key string, so only one spelling may register
+    if _, err := crypto.ParsePublicKey(pub); err != nil {
+        http.Error(w, "public_key must be a canonical base64 Ed25519 public key", http.StatusBadRequest)
+        return
     }
-    // persist in Firestore (and keep in-memory for quick tests)
+    // wallet id = sha256(pubkey), never the caller's choice
+    walletID := crypto.WalletIDFromPublicKey(pub)
+    if req.WalletID != "" && req.WalletID != walletID {
+        http.Error(w, "wallet_id does not match public_key", http.StatusBadRequest)
+        return
+    }
+    if err := s.verifyKeyProofs(uid, req.Nonce, []derivedKey{{PublicKey: pub, Signature: req.Signature}}); err != nil {
+        http.Error(w, "key ownership not proven: "+err.Error(), http.StatusUnauthorized)
+        return
+    }
+    if err := s.store.RegisterWallets([]*db.WalletRecord{{WalletID: walletID, PublicKey: pub, OwnerUID: uid}}); err != nil {
+        registerError(w, err)
+        return
+    }
+    // keep the in-memory registry in sync for the node's live view
     utxo.RegisterWallet(walletID, pub)
-    _ = db.RegisterWallet(walletID, pub)
-    json.NewEncoder(w).Encode(map[string]string{"wallet_id": walletID})
+    addr, _ := wallet.EncodeAddress(s.params.AddressPrefix, walletID)
+    json.NewEncoder(w).Encode(map[string]string{"wallet_id": walletID, "address": addr, "owner_uid": uid})
 }
 
+// sendTxReq pays either a single receiver/amount or, when Outputs is set, every
+// listed recipient (Receiver/Amount are then ignored). Receivers are addresses
+// (see wallet.DecodeAddress); the signature covers the wallet IDs they decode
+// to. Change back to the sender is appended by the server as the last output.
 type sendTxReq struct {
     Sender          string   `json:"sender"`
     Receiver        string   `json:"receiver"`
     Amount          int64    `json:"amount"`
+    Outputs         []utxo.TxOutput `json:"outputs"`
+    // Fee is left for the miner: change = inputs - outputs - fee.
+    Fee             int64    `json:"fee"`
     Note            string   `json:"note"`
     Timestamp       string   `json:"timestamp"`
     SenderPublicKey string   `json:"sender_public_key"`
     Signature       string   `json:"signature"` // base64
     Inputs          []string `json:"inputs"`
+    ChainID         string   `json:"chain_id"`
+    Nonce           uint64   `json:"nonce"`
+    // Preimages (hex) unlock hash-locked inputs; they are not signed.
+    Preimages       []string `json:"preimages"`
 }
 
-func sendTxHandler(w http.ResponseWriter, r *http.Request) {
+// Error codes returned in the JSON body of rejected sends, so clients can tell
+// a replay apart from an ordinary validation failure.
+const (
+    errCodeReplayedTx     = "TX_REPLAYED"
+    errCodeNonceGap       = "TX_NONCE_GAP"
+    errCodeWrongChain     = "TX_WRONG_CHAIN"
+    errCodeBadAddress     = "TX_BAD_ADDRESS"
+    errCodeConditionUnmet = "TX_CONDITION_UNMET"
+)
+
+// txError writes a JSON error body carrying a machine-readable code.
+func txError(w http.ResponseWriter, status int, code, msg string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(map[string]string{"error": msg, "code": code})
+}
+
+// nonceError maps a db nonce error to its response; ok is false for other errors.
+func nonceError(w http.ResponseWriter, err error) bool {
+    switch {
+    case errors.Is(err, db.ErrReplayedTx):
+        txError(w, http.StatusConflict, errCodeReplayedTx, err.Error())
+    case errors.Is(err, db.ErrNonceGap):
+        txError(w, http.StatusConflict, errCodeNonceGap, err.Error())
+    default:
+        return false
+    }
+    return true
+}
+
+func (s *Server) sendTxHandler(w http.ResponseWriter, r *http.Request) {
     var req sendTxReq
     body, _ := ioutil.ReadAll(r.Body)
     if err := json.Unmarshal(body, &req); err != nil {
@@ -58,145 +129,182 @@ func sendTxHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    // signatures are bound to one network
+    if req.ChainID != s.params.ChainID {
+        txError(w, http.StatusBadRequest, errCodeWrongChain, "chain_id mismatch: node is on "+s.params.ChainID)
+        return
+    }
+
     // validate wallet exists
-    // fetch public key from firestore if available, otherwise from in-memory
-    pub, err := db.GetWalletPublicKey(req.Sender)
+    pub, err := s.store.GetWalletPublicKey(req.Sender)
     if err != nil {
-        // fallback to in-memory
-        p, ok := utxo.GetWalletPublicKey(req.Sender)
-        if !ok {
-            http.Error(w, "sender wallet not registered", http.StatusBadRequest)
-            return
-        }
-        pub = p
+        http.Error(w, "sender wallet not registered", http.StatusBadRequest)
+        return
+    }
+    if crypto.IsMultisigPolicy(pub) {
+        http.Error(w, "sender is a multisig wallet: propose the transaction through /api/multisig/txs", http.StatusBadRequest)
+        return
+    }
+    txObj := s.buildTx(w, &req, pub)
+    if txObj == nil {
+        return
     }
 
-    // verify signature over payload sender+receiver+amount+timestamp+note
-    msg := strings.Join([]string{req.Sender, req.Receiver, strconv.FormatInt(req.Amount, 10), req.Timestamp, req.Note}, "|")
-    okSig, err := crypto.VerifyEd25519Signature(pub, []byte(msg), req.Signature)
+    // verify the signature over the canonical encoding (commits to every input and output)
+    okSig, err := crypto.VerifyEd25519Signature(pub, txObj.SigningBytes(), req.Signature)
     if err != nil || !okSig {
         http.Error(w, "invalid signature", http.StatusBadRequest)
         return
     }
+    // keep the raw signature bytes so the tx can be re-verified from the block alone
+    txObj.Signature, _ = base64.StdEncoding.DecodeString(req.Signature)
+    txObj.ID = txObj.ComputeID()
+    txid := txObj.ID
+
+    // reserve a mempool slot (it may evict lower fee-rate txs), then spend inputs,
+    // create outputs and record the pending tx in one atomic store operation
+    if err := s.commitPending(txObj); err != nil {
+        pendingError(w, err)
+        return
+    }
+    s.announceTx(txObj)
+
+    json.NewEncoder(w).Encode(map[string]string{"tx_id": txid})
+}
+
+// buildTx checks a send request against the sender's nonce and inputs and
+// builds the unsigned transaction it describes, with change back to the
+// sender. On failure it writes the response and returns nil.
+func (s *Server) buildTx(w http.ResponseWriter, req *sendTxReq, pub string) *utxo.Transaction {
+    // fail fast on replays; the nonce is consumed atomically with the inputs on commit
+    lastNonce, err := s.store.GetWalletNonce(req.Sender)
+    if err != nil {
+        http.Error(w, "failed to read wallet nonce: "+err.Error(), http.StatusInternalServerError)
+        return nil
+    }
+    if err := db.CheckNonce(lastNonce, req.Nonce); err != nil && nonceError(w, err) {
+        return nil
+    }
 
     // validate inputs exist and unspent
     var totalIn int64
+    seen := map[string]bool{}
+    inputs := make([]*utxo.UTXO, 0, len(req.Inputs))
     for _, id := range req.Inputs {
-        // try firestore first
-        uDoc, err := db.GetUTXOByID(id)
+        if seen[id] {
+            http.Error(w, "duplicate input: "+id, http.StatusBadRequest)
+            return nil
+        }
+        seen[id] = true
+        u, err := s.store.GetUTXOByID(id)
+        // conditioned outputs may name other spenders; the condition is checked below
+        if err != nil || u.Spent || (u.WalletID != req.Sender && u.Condition == nil) {
+            http.Error(w, "invalid or spent input: "+id, http.StatusBadRequest)
+            return nil
+        }
+        inputs = append(inputs, u)
+        totalIn += u.Amount
+    }
+
+    // outputs: the requested recipients in order, then change back to the sender
+    // (omitted when zero) after deducting the fee. The client derives the same list and signs over it.
+    outs := req.Outputs
+    if len(outs) == 0 {
+        outs = []utxo.TxOutput{{Recipient: req.Receiver, Amount: req.Amount}}
+    }
+    // leave room for the change output
+    if len(outs) >= utxo.MaxTxOutputs {
+        http.Error(w, "too many outputs", http.StatusBadRequest)
+        return nil
+    }
+    // receivers are checksummed addresses; the signed outputs carry the wallet IDs they decode to
+    outs = append([]utxo.TxOutput(nil), outs...)
+    for i, o := range outs {
+        id, err := wallet.DecodeAddress(s.params.AddressPrefix, strings.TrimSpace(o.Recipient))
         if err != nil {
-            // fallback to in-memory
-            u, exists := utxo.GetUTXO(id)
-            if !exists || u.Spent || u.WalletID != req.Sender {
-                http.Error(w, "invalid or spent input: "+id, http.StatusBadRequest)
-                return
-            }
-            totalIn += u.Amount
-        } else {
-            if uDoc.Spent || uDoc.WalletID != req.Sender {
-                http.Error(w, "invalid or spent input: "+id, http.StatusBadRequest)
-                return
+            txError(w, http.StatusBadRequest, errCodeBadAddress,
+                "output "+strconv.Itoa(i)+": receiver "+strconv.Quote(o.Recipient)+": "+err.Error()+
+                    " (send to a "+s.params.AddressPrefix+"1... address, not a raw wallet ID)")
+            return nil
+        }
+        outs[i].Recipient = id
+        if o.Condition != nil {
+            c, err := s.resolveCondition(o.Condition)
+            if err != nil {
+                txError(w, http.StatusBadRequest, errCodeBadAddress, "output "+strconv.Itoa(i)+": condition signer: "+err.Error())
+                return nil
             }
-            totalIn += uDoc.Amount
+            outs[i].Condition = c
         }
     }
-    if totalIn < req.Amount {
-        http.Error(w, "insufficient funds", http.StatusBadRequest)
-        return
+    totalOut, err := utxo.SumOutputs(outs)
+    if err != nil {
+        http.Error(w, "invalid outputs: "+err.Error(), http.StatusBadRequest)
+        return nil
     }
-
-    // create tx id
-    h := sha256.New()
-    h.Write([]byte(req.Sender))
-    h.Write([]byte(req.Receiver))
-    h.Write([]byte(strconv.FormatInt(req.Amount, 10)))
-    h.Write([]byte(time.Now().UTC().Format(time.RFC3339Nano)))
-    txid := hex.EncodeToString(h.Sum(nil))
-
-    // mark inputs spent and create outputs (receiver + change)
-    change := totalIn - req.Amount
-
-    // Prepare outputs (in-memory objects)
-    outIndex := 0
-    u1 := utxo.CreateUTXO(txid, outIndex, req.Receiver, req.Amount)
-    outIndex++
-    var outputs []*utxo.UTXO
-    outputs = append(outputs, u1)
-    if change > 0 {
-        u2 := utxo.CreateUTXO(txid, outIndex, req.Sender, change)
-        outputs = append(outputs, u2)
+    if req.Fee < 0 {
+        http.Error(w, "fee must not be negative", http.StatusBadRequest)
+        return nil
+    }
+    if totalIn-totalOut < req.Fee {
+        http.Error(w, "insufficient funds", http.StatusBadRequest)
+        return nil
     }
 
     txObj := &utxo.Transaction{
-        ID:              txid,
         Sender:          req.Sender,
-        Receiver:        req.Receiver,
-        Amount:          req.Amount,
+        Receiver:        outs[0].Recipient,
+        Amount:          totalOut,
         Note:            req.Note,
         Timestamp:       time.Now().UTC(),
-        SenderPublicKey: req.SenderPublicKey,
-        Signature:       []byte(req.Signature),
+        SenderPublicKey: pub,
         Inputs:          req.Inputs,
-        Outputs:         []utxo.TxOutput{{Recipient: req.Receiver, Amount: req.Amount}},
+        Outputs:         append([]utxo.TxOutput(nil), outs...),
+        ClientTimestamp: req.Timestamp,
+        ChainID:         req.ChainID,
+        Nonce:           req.Nonce,
+        Fee:             req.Fee,
+        Preimages:       req.Preimages,
     }
-
-    // If Firestore is configured, perform the create/write inside a Firestore transaction
-    if db.FSClient != nil {
-        if err := db.CreatePendingTxAtomic(txObj, req.Inputs, outputs); err != nil {
-            // rollback in-memory creations we made above
-            for _, o := range outputs {
-                // remove from in-memory UTXO set if present
-                // simple removal by marking spent or deleting
-                // safest: mark as spent so it won't be used
-                _ = utxo.MarkUTXOSpent(o.ID)
-            }
-            http.Error(w, "failed to persist transaction atomically: "+err.Error(), http.StatusInternalServerError)
-            return
-        }
-        // persist succeeded in Firestore; also update in-memory pending pool and ensure inputs marked
-        for _, id := range req.Inputs {
-            _ = utxo.MarkUTXOSpent(id)
-        }
-        utxo.AddPendingTx(txObj)
-        json.NewEncoder(w).Encode(map[string]string{"tx_id": txid})
-        return
+    if change := totalIn - totalOut - req.Fee; change > 0 {
+        txObj.Outputs = append(txObj.Outputs, utxo.TxOutput{Recipient: req.Sender, Amount: change})
     }
 
-    // Firestore not initialized: fallback to best-effort in-memory + non-transactional persistence
-    for _, id := range req.Inputs {
-        success := utxo.MarkUTXOSpent(id)
-        if !success {
-            http.Error(w, "failed to mark input spent: "+id, http.StatusInternalServerError)
-            return
-        }
+    // timelocks and hash locks on the inputs must hold in the next block
+    at, err := s.nextSpendPoint()
+    if err != nil {
+        http.Error(w, "failed to read chain tip: "+err.Error(), http.StatusInternalServerError)
+        return nil
     }
-
-    // create outputs in firestore best-effort
-    for _, o := range outputs {
-        _ = db.CreateUTXO(o)
+    if problems := blockchain.CheckSpends(txObj, inputs, at); len(problems) > 0 {
+        txError(w, http.StatusBadRequest, errCodeConditionUnmet, strings.Join(problems, "; "))
+        return nil
     }
-
-    utxo.AddPendingTx(txObj)
-    _ = db.AddPendingTx(txObj)
-
-    json.NewEncoder(w).Encode(map[string]string{"tx_id": txid})
+    return txObj
 }
 
 // filterTransactionsHandler returns transactions with optional filtering by date range, status, wallet
-func filterTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) filterTransactionsHandler(w http.ResponseWriter, r *http.Request) {
     walletID := r.URL.Query().Get("wallet_id")
     status := r.URL.Query().Get("status")
     startDate := r.URL.Query().Get("start_date")
     endDate := r.URL.Query().Get("end_date")
     
     // Get all pending transactions
-    pendingTxs := utxo.GetPendingTransactions()
+    var pendingTxs []*utxo.Transaction
+    if records, err := s.store.ListPendingTxs(); err == nil {
+        for _, r := range records {
+            pendingTxs = append(pendingTxs, &r.Transaction)
+        }
+    } else {
+        pendingTxs = s.pool.Txs()
+    }
     
-    // Get confirmed transactions from database
-    confirmedTxs, err := db.GetAllTransactions()
+    // Get confirmed transactions from the store
+    confirmedTxs, err := s.store.GetAllTransactions()
     if err != nil {
-        // If DB fails, just return pending ones
-        confirmedTxs = []interface{}{}
+        // If the store fails, just return pending ones
+        confirmedTxs = nil
     }
     
     allTxs := []map[string]interface{}{}
@@ -211,26 +319,42 @@ func filterTransactionsHandler(w http.ResponseWriter, r *http.Request) {
             "timestamp": tx.Timestamp,
             "status":    "pending",
             "tx_id":     tx.ID,
+            "outputs":   tx.Outputs,
         }
         allTxs = append(allTxs, txMap)
     }
     
     // Add confirmed transactions
     for _, tx := range confirmedTxs {
-        if txMap, ok := tx.(map[string]interface{}); ok {
-            txMap["status"] = "confirmed"
-            allTxs = append(allTxs, txMap)
+        txMap := map[string]interface{}{
+            "id":                tx.ID,
+            "sender":            tx.Sender,
+            "receiver":          tx.Receiver,
+            "amount":            tx.Amount,
+            "note":              tx.Note,
+            "timestamp":         tx.Timestamp,
+            "sender_public_key": tx.SenderPublicKey,
+            "inputs":            tx.Inputs,
+            "outputs":           tx.Outputs,
+            "block_hash":        tx.BlockHash,
+            "block_index":       tx.BlockIndex,
+            "status":            "confirmed",
         }
+        allTxs = append(allTxs, txMap)
     }
     
     // Apply filters
     filtered := []map[string]interface{}{}
     for _, tx := range allTxs {
-        // Filter by wallet_id (matches sender or receiver)
+        // Filter by wallet_id (matches sender or any output recipient)
         if walletID != "" {
             sender, _ := tx["sender"].(string)
-            receiver, _ := tx["receiver"].(string)
-            if sender != walletID && receiver != walletID {
+            outs, _ := tx["outputs"].([]utxo.TxOutput)
+            match := sender == walletID
+            for _, o := range outs {
+                match = match || o.Recipient == walletID
+            }
+            if !match {
                 continue
             }
         }
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/student/decentralized-wallet/internal/chainparams"
+	"github.com/student/decentralized-wallet/internal/db"
+	"github.com/student/decentralized-wallet/internal/utxo"
+	"github.com/student/decentralized-wallet/wallet"
+)
+
+func TestRegisterWalletRequiresCanonicalKey(t *testing.T) {
+	s := NewServer(db.NewMemoryStore(), chainparams.Default())
+	h := s.Router()
+	priv, canonical, others := keySpellings(t)
+
+	// every registration is signed properly; only the key's spelling differs
+	register := func(pub string) (int, string) {
+		code, body := request(t, h, "POST", "/api/wallets/challenge", map[string]string{"user_id": "user"})
+		var ch map[string]string
+		if code != http.StatusOK || json.Unmarshal([]byte(body), &ch) != nil {
+			t.Fatalf("challenge: %d %s", code, body)
+		}
+		sig := ed25519.Sign(priv, wallet.RegistrationMessage(s.params.ChainID, "user", ch["nonce"], pub))
+		return request(t, h, "POST", "/api/wallets/register", map[string]string{
+			"public_key": pub, "nonce": ch["nonce"], "signature": base64.StdEncoding.EncodeToString(sig), "user_id": "user",
+		})
+	}
+	for name, pub := range others {
+		if code, body := register(pub); code != http.StatusBadRequest {
+			t.Errorf("%s spelling: %d %s, want 400", name, code, body)
+		}
+	}
+	if code, body := register(canonical); code != http.StatusOK {
+		t.Errorf("canonical key: %d %s", code, body)
+	}
+}
+
+func TestSendRejectsReplays(t *testing.T) {
+	n := newTestNet(t).node(t, true)
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	n.register(t, alice)
+	n.register(t, bob)
+	in := n.fund(t, alice.id, 1000)
+	n.mine(t)
+	first := n.send(t, alice, in, 1000, bob.id, 100, 1, 1)
+	change := first.OutputUTXOID(1)
+
+	tests := []struct {
+		name    string
+		nonce   uint64
+		chainID string
+		status  int
+		code    string
+	}{
+		{"reused nonce", 1, n.params.ChainID, http.StatusConflict, errCodeReplayedTx},
+		{"skipped nonce", 3, n.params.ChainID, http.StatusConflict, errCodeNonceGap},
+		{"foreign chain", 2, "other-net", http.StatusBadRequest, errCodeWrongChain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outs := []utxo.TxOutput{{Recipient: bob.id, Amount: 100}}
+			status, body, _ := n.trySend(t, alice, change, 899, outs, 1, tt.nonce, tt.chainID)
+			var resp map[string]string
+			if err := json.Unmarshal([]byte(body), &resp); err != nil {
+				t.Fatalf("%d %s: %v", status, body, err)
+			}
+			if status != tt.status || resp["code"] != tt.code {
+				t.Errorf("%d %s, want %d %s", status, resp["code"], tt.status, tt.code)
+			}
+		})
+	}
+	if got := n.pool.Len(); got != 1 {
+		t.Errorf("%d txs pending, want only the first", got)
+	}
+	// the next nonce is still free
+	n.send(t, alice, change, 899, bob.id, 100, 1, 2)
+}
+
+func TestSendToSeveralRecipients(t *testing.T) {
+	n := newTestNet(t).node(t, true)
+	alice, bob, carol := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	for _, w := range []testWallet{alice, bob, carol} {
+		n.register(t, w)
+	}
+	in := n.fund(t, alice.id, 1000)
+	n.mine(t)
+
+	outs := []utxo.TxOutput{{Recipient: bob.id, Amount: 300}, {Recipient: carol.id, Amount: 200}}
+	status, body, want := n.trySend(t, alice, in, 1000, outs, 10, 1, n.params.ChainID)
+	if status != http.StatusOK {
+		t.Fatalf("send: %d %s", status, body)
+	}
+	got, ok := n.pool.Get(want.ID)
+	if !ok {
+		t.Fatalf("tx %s not pending", want.ID)
+	}
+	if len(got.Outputs) != 3 {
+		t.Fatalf("%d outputs, want both recipients and the change", len(got.Outputs))
+	}
+	for i, o := range want.Outputs {
+		if got.Outputs[i].Recipient != o.Recipient || got.Outputs[i].Amount != o.Amount {
+			t.Errorf("output %d: %s %d, want %s %d", i, got.Outputs[i].Recipient, got.Outputs[i].Amount, o.Recipient, o.Amount)
+		}
+	}
+	if got.Amount != 500 || got.Fee != 10 {
+		t.Errorf("amount %d fee %d, want 500 10", got.Amount, got.Fee)
+	}
+	u, err := n.store.GetUTXOByID(got.OutputUTXOID(2))
+	if err != nil || u.WalletID != alice.id || u.Amount != 490 || u.Spent {
+		t.Errorf("change utxo %+v, %v: want 490 unspent owned by the sender", u, err)
+	}
+}
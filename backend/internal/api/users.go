@@ -20,7 +20,7 @@ type userProfile struct {
 }
 
 // createUserHandler creates or replaces the authenticated user's profile.
-func createUserHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) createUserHandler(w http.ResponseWriter, r *http.Request) {
     // expect authenticated uid in context
     uid, _ := r.Context().Value("uid").(string)
     // For local dev when auth is not configured, allow client-provided id
@@ -51,7 +51,7 @@ func createUserHandler(w http.ResponseWriter, r *http.Request) {
         CreatedAt: time.Now().UTC(),
         UpdatedAt: time.Now().UTC(),
     }
-    if err := db.CreateUser(&u); err != nil {
+    if err := s.store.CreateUser(&u); err != nil {
         http.Error(w, "failed to create user: "+err.Error(), http.StatusInternalServerError)
         return
     }
@@ -60,7 +60,7 @@ func createUserHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // getUserHandler returns a user's profile by id. Only the user themselves may view their profile in this implementation.
-func getUserHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getUserHandler(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     id := vars["id"]
     uid, _ := r.Context().Value("uid").(string)
@@ -70,7 +70,7 @@ func getUserHandler(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "forbidden", http.StatusForbidden)
         return
     }
-    u, err := db.GetUser(id)
+    u, err := s.store.GetUser(id)
     if err != nil {
         http.Error(w, "user not found: "+err.Error(), http.StatusNotFound)
         return
@@ -80,7 +80,7 @@ func getUserHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // updateUserHandler updates the authenticated user's profile.
-func updateUserHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updateUserHandler(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     id := vars["id"]
     uid, _ := r.Context().Value("uid").(string)
@@ -99,7 +99,7 @@ func updateUserHandler(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
         return
     }
-    u, err := db.GetUser(id)
+    u, err := s.store.GetUser(id)
     if err != nil {
         http.Error(w, "user not found: "+err.Error(), http.StatusNotFound)
         return
@@ -108,7 +108,7 @@ func updateUserHandler(w http.ResponseWriter, r *http.Request) {
     if in.CNIC != "" { u.CNIC = in.CNIC }
     if in.Beneficiaries != nil { u.Beneficiaries = in.Beneficiaries }
     u.UpdatedAt = time.Now().UTC()
-    if err := db.UpdateUser(u); err != nil {
+    if err := s.store.UpdateUser(u); err != nil {
         http.Error(w, "failed to update user: "+err.Error(), http.StatusInternalServerError)
         return
     }
@@ -117,7 +117,7 @@ func updateUserHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // addBeneficiaryHandler adds a beneficiary wallet ID to the user's list
-func addBeneficiaryHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) addBeneficiaryHandler(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     id := vars["id"]
     uid, _ := r.Context().Value("uid").(string)
@@ -139,7 +139,7 @@ func addBeneficiaryHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
     
-    u, err := db.GetUser(id)
+    u, err := s.store.GetUser(id)
     if err != nil {
         http.Error(w, "user not found: "+err.Error(), http.StatusNotFound)
         return
@@ -155,7 +155,7 @@ func addBeneficiaryHandler(w http.ResponseWriter, r *http.Request) {
     
     u.Beneficiaries = append(u.Beneficiaries, in.BeneficiaryWalletID)
     u.UpdatedAt = time.Now().UTC()
-    if err := db.UpdateUser(u); err != nil {
+    if err := s.store.UpdateUser(u); err != nil {
         http.Error(w, "failed to update user: "+err.Error(), http.StatusInternalServerError)
         return
     }
@@ -168,7 +168,7 @@ func addBeneficiaryHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // listBeneficiariesHandler returns the user's beneficiary list
-func listBeneficiariesHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) listBeneficiariesHandler(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     id := vars["id"]
     uid, _ := r.Context().Value("uid").(string)
@@ -179,7 +179,7 @@ func listBeneficiariesHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
     
-    u, err := db.GetUser(id)
+    u, err := s.store.GetUser(id)
     if err != nil {
         http.Error(w, "user not found: "+err.Error(), http.StatusNotFound)
         return
@@ -192,7 +192,7 @@ func listBeneficiariesHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // removeBeneficiaryHandler removes a beneficiary from the user's list
-func removeBeneficiaryHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) removeBeneficiaryHandler(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     id := vars["id"]
     uid, _ := r.Context().Value("uid").(string)
@@ -214,7 +214,7 @@ func removeBeneficiaryHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
     
-    u, err := db.GetUser(id)
+    u, err := s.store.GetUser(id)
     if err != nil {
         http.Error(w, "user not found: "+err.Error(), http.StatusNotFound)
         return
@@ -238,7 +238,7 @@ func removeBeneficiaryHandler(w http.ResponseWriter, r *http.Request) {
     
     u.Beneficiaries = newList
     u.UpdatedAt = time.Now().UTC()
-    if err := db.UpdateUser(u); err != nil {
+    if err := s.store.UpdateUser(u); err != nil {
         http.Error(w, "failed to update user: "+err.Error(), http.StatusInternalServerError)
         return
     }
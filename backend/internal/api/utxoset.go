@@ -0,0 +1,234 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+// maxReportedDivergences caps the divergences a report lists; the count covers all of them.
+const maxReportedDivergences = 100
+
+// Kinds of divergence between the rebuilt UTXO set and the stored utxos collection.
+const (
+	// DivergenceMissingInStore: the chain or mempool created the output but the store has no record of it.
+	DivergenceMissingInStore = "missing_in_store"
+	// DivergenceUnbacked: the store holds an unspent output that no block, funding tx or pending tx accounts for.
+	DivergenceUnbacked = "unbacked_in_store"
+	// DivergenceOwner: the stored output pays a different wallet than the transaction that created it.
+	DivergenceOwner = "owner_mismatch"
+	// DivergenceAmount: the stored output has a different amount than the transaction that created it.
+	DivergenceAmount = "amount_mismatch"
+	// DivergenceSpent: the stored spent flag disagrees with the chain and mempool.
+	DivergenceSpent = "spent_mismatch"
+)
+
+// UTXODivergence is one output on which the rebuilt set and the store disagree.
+type UTXODivergence struct {
+	UTXOID string     `json:"utxo_id"`
+	Kind   string     `json:"kind"`
+	Chain  *utxo.UTXO `json:"chain,omitempty"`
+	Store  *utxo.UTXO `json:"store,omitempty"`
+}
+
+// UTXOSetReport describes the last rebuild of the in-memory UTXO set.
+type UTXOSetReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	Height     int64     `json:"height"`
+	TipHash    string    `json:"tip_hash"`
+	// Source is "chain" when the set was replayed from the blocks, or "store"
+	// when the replay failed and the stored utxos were loaded as they are.
+	Source      string `json:"source"`
+	UTXOCount   int    `json:"utxo_count"`
+	PendingTxs  int    `json:"pending_txs"`
+	StoredUTXOs int    `json:"stored_utxos"`
+	// Consistent is true when the store matches the rebuilt set exactly.
+	Consistent      bool             `json:"consistent"`
+	DivergenceCount int              `json:"divergence_count"`
+	Divergences     []UTXODivergence `json:"divergences"`
+	Problems        []string         `json:"problems"`
+}
+
+func (r *UTXOSetReport) diverge(d UTXODivergence) {
+	r.DivergenceCount++
+	if len(r.Divergences) < maxReportedDivergences {
+		r.Divergences = append(r.Divergences, d)
+	}
+}
+
+// rebuildUTXOSet reconstructs the in-memory UTXO set: the main chain is
+// replayed from genesis over the admin funding allocations, then pending
+// transactions spend and create outputs on top. The result is compared with
+// the stored utxos collection and installed as the mirror the wallet
+// endpoints read. Call it with chainMu held. Transactions sent while it runs
+// may show up as divergences; run it again once the node is quiet.
+func (s *Server) rebuildUTXOSet() *UTXOSetReport {
+	start := time.Now()
+	r := &UTXOSetReport{
+		StartedAt:   start.UTC(),
+		Height:      -1,
+		Source:      "chain",
+		Divergences: []UTXODivergence{},
+		Problems:    []string{},
+	}
+	if s.tip != nil {
+		r.Height, r.TipHash = s.tip.Height(), s.tip.Hash()
+	}
+
+	set := map[string]*utxo.UTXO{}
+	v, err := s.replayMainChain(r.Height)
+	if err != nil {
+		r.Source = "store"
+		r.Problems = append(r.Problems, "replay main chain: "+err.Error())
+	} else {
+		s.validator = v
+		for id, u := range v.UTXOs() {
+			c := *u
+			set[id] = &c
+		}
+		r.PendingTxs = s.applyPendingTxs(set, r)
+	}
+
+	stored := map[string]*utxo.UTXO{}
+	err = s.store.ForEachUTXO(func(u *utxo.UTXO) error {
+		stored[u.ID] = u
+		return nil
+	})
+	if err != nil {
+		r.Problems = append(r.Problems, "read stored utxos: "+err.Error())
+	}
+	r.StoredUTXOs = len(stored)
+
+	if r.Source == "store" {
+		// without a chain to check against, the store is the best we have
+		for id, u := range stored {
+			set[id] = u
+		}
+	} else if err == nil {
+		compareUTXOSets(set, stored, r)
+	}
+	for _, u := range set {
+		if !u.Spent {
+			r.UTXOCount++
+		}
+	}
+	r.Consistent = r.DivergenceCount == 0 && len(r.Problems) == 0
+	utxo.ResetUTXOSet(set)
+	r.DurationMS = time.Since(start).Milliseconds()
+	return r
+}
+
+// applyPendingTxs spends and creates the outputs of pooled transactions on
+// top of set. The pool orders by fee rate, so a child may come before its
+// parent; transactions are retried until no more apply. It returns how many did.
+func (s *Server) applyPendingTxs(set map[string]*utxo.UTXO, r *UTXOSetReport) int {
+	waiting := s.pool.Txs()
+	applied := 0
+	for progress := true; progress && len(waiting) > 0; {
+		progress = false
+		rest := waiting[:0]
+		for _, t := range waiting {
+			ready := true
+			for _, id := range t.Inputs {
+				if u, ok := set[id]; !ok || u.Spent {
+					ready = false
+					break
+				}
+			}
+			if !ready {
+				rest = append(rest, t)
+				continue
+			}
+			for _, id := range t.Inputs {
+				set[id].Spent = true
+			}
+			for _, u := range t.OutputUTXOs() {
+				set[u.ID] = u
+			}
+			applied++
+			progress = true
+		}
+		waiting = rest
+	}
+	for _, t := range waiting {
+		r.Problems = append(r.Problems, fmt.Sprintf("pending tx %s spends outputs that are missing or already spent", t.ID))
+	}
+	return applied
+}
+
+// compareUTXOSets records every way stored differs from rebuilt. Outputs
+// spent on chain drop out of the rebuilt set, so a stored output that is
+// missing from it is only a divergence while the store thinks it unspent.
+// Where the two agree the stored copy, with its creation time, goes into
+// rebuilt.
+func compareUTXOSets(rebuilt, stored map[string]*utxo.UTXO, r *UTXOSetReport) {
+	for id, c := range rebuilt {
+		st, ok := stored[id]
+		switch {
+		case !ok:
+			r.diverge(UTXODivergence{UTXOID: id, Kind: DivergenceMissingInStore, Chain: c})
+		case st.WalletID != c.WalletID:
+			r.diverge(UTXODivergence{UTXOID: id, Kind: DivergenceOwner, Chain: c, Store: st})
+		case st.Amount != c.Amount:
+			r.diverge(UTXODivergence{UTXOID: id, Kind: DivergenceAmount, Chain: c, Store: st})
+		case st.Spent != c.Spent:
+			r.diverge(UTXODivergence{UTXOID: id, Kind: DivergenceSpent, Chain: c, Store: st})
+		default:
+			rebuilt[id] = st
+		}
+	}
+	for id, st := range stored {
+		if _, ok := rebuilt[id]; !ok && !st.Spent {
+			r.diverge(UTXODivergence{UTXOID: id, Kind: DivergenceUnbacked, Store: st})
+		}
+	}
+}
+
+// loadUTXOSet rebuilds the UTXO set at startup and logs the outcome.
+func (s *Server) loadUTXOSet() {
+	s.chainMu.Lock()
+	r := s.rebuildUTXOSet()
+	s.utxoReport = r
+	s.chainMu.Unlock()
+	logUTXOReport(r)
+}
+
+func logUTXOReport(r *UTXOSetReport) {
+	log.Printf("utxo: rebuilt %d unspent outputs from %s at height %d (%d pending txs) in %dms",
+		r.UTXOCount, r.Source, r.Height, r.PendingTxs, r.DurationMS)
+	for _, p := range r.Problems {
+		log.Printf("utxo: %s", p)
+	}
+	if r.DivergenceCount > 0 {
+		log.Printf("utxo: store diverges from the chain on %d outputs; see GET /api/admin/utxo/report", r.DivergenceCount)
+	}
+}
+
+// utxoReportHandler returns the report of the last UTXO set rebuild.
+func (s *Server) utxoReportHandler(w http.ResponseWriter, r *http.Request) {
+	s.chainMu.Lock()
+	report := s.utxoReport
+	s.chainMu.Unlock()
+	if report == nil {
+		http.Error(w, "utxo set has not been rebuilt", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// utxoRebuildHandler rebuilds the UTXO set now and returns the new report.
+func (s *Server) utxoRebuildHandler(w http.ResponseWriter, r *http.Request) {
+	s.chainMu.Lock()
+	report := s.rebuildUTXOSet()
+	s.utxoReport = report
+	s.chainMu.Unlock()
+	logUTXOReport(report)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
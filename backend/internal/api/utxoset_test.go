@@ -0,0 +1,190 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/student/decentralized-wallet/internal/db"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+// corruptStore reports a damaged utxos collection: outputs in drop are
+// missing, outputs in flip have their spent flag inverted and extra outputs
+// appear that nothing created.
+type corruptStore struct {
+	db.Store
+	drop, flip map[string]bool
+	extra      []*utxo.UTXO
+}
+
+func (c *corruptStore) ForEachUTXO(fn func(*utxo.UTXO) error) error {
+	err := c.Store.ForEachUTXO(func(u *utxo.UTXO) error {
+		if c.drop[u.ID] {
+			return nil
+		}
+		if c.flip[u.ID] {
+			f := *u
+			f.Spent = !f.Spent
+			u = &f
+		}
+		return fn(u)
+	})
+	if err != nil {
+		return err
+	}
+	for _, u := range c.extra {
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *testNode) rebuild() *UTXOSetReport {
+	n.chainMu.Lock()
+	defer n.chainMu.Unlock()
+	return n.rebuildUTXOSet()
+}
+
+// pendingChain mines funding for alice, then leaves a payment to bob and
+// bob's spend of it in the mempool. The child pays the higher fee rate, so
+// the pool lists it before its parent.
+func pendingChain(t *testing.T) (n *testNode, parent, child *utxo.Transaction) {
+	t.Helper()
+	n = newTestNet(t).node(t, true)
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	n.register(t, alice)
+	n.register(t, bob)
+	in := n.fund(t, alice.id, 1000)
+	n.mine(t)
+	parent = n.send(t, alice, in, 1000, bob.id, 100, 1, 1)
+	child = n.send(t, bob, parent.OutputUTXOID(0), 100, alice.id, 40, 50, 1)
+	if txs := n.pool.Txs(); len(txs) != 2 || txs[0].ID != child.ID {
+		t.Fatalf("pool does not list the child first")
+	}
+	return n, parent, child
+}
+
+func TestRebuildUTXOSetAppliesChildBeforeParent(t *testing.T) {
+	n, parent, child := pendingChain(t)
+	r := n.rebuild()
+	if !r.Consistent || r.Source != "chain" {
+		t.Fatalf("rebuild not consistent: %+v", r)
+	}
+	if r.PendingTxs != 2 {
+		t.Errorf("applied %d pending txs, want 2", r.PendingTxs)
+	}
+	if u, ok := utxo.GetUTXO(parent.OutputUTXOID(0)); !ok || !u.Spent {
+		t.Errorf("parent's output to bob not spent by the child: %+v", u)
+	}
+	for i := range child.Outputs {
+		if u, ok := utxo.GetUTXO(child.OutputUTXOID(i)); !ok || u.Spent {
+			t.Errorf("child output %d missing or spent: %+v", i, u)
+		}
+	}
+}
+
+func TestRebuildUTXOSetReportsOrphanedPendingTx(t *testing.T) {
+	n, parent, child := pendingChain(t)
+	n.pool.Remove([]string{parent.ID})
+	r := n.rebuild()
+	if r.Consistent || r.PendingTxs != 0 || len(r.Problems) != 1 {
+		t.Fatalf("rebuild without the parent: %+v", r)
+	}
+	if !strings.HasPrefix(r.Problems[0], "pending tx "+child.ID) {
+		t.Errorf("problem %q does not name the child", r.Problems[0])
+	}
+}
+
+func TestRebuildUTXOSetReportsDivergences(t *testing.T) {
+	n, parent, child := pendingChain(t)
+	extra := utxo.NewUTXO("no-such-tx", 0, parent.Sender, 77)
+	missing, flipped := child.OutputUTXOID(0), parent.OutputUTXOID(1)
+	n.store = &corruptStore{
+		Store: n.store,
+		drop:  map[string]bool{missing: true},
+		flip:  map[string]bool{flipped: true},
+		extra: []*utxo.UTXO{extra},
+	}
+
+	r := n.rebuild()
+	if r.Consistent {
+		t.Fatal("report consistent over a corrupted store")
+	}
+	want := map[string]string{
+		missing:  DivergenceMissingInStore,
+		flipped:  DivergenceSpent,
+		extra.ID: DivergenceUnbacked,
+	}
+	if r.DivergenceCount != len(want) || len(r.Divergences) != len(want) {
+		t.Fatalf("%d divergences, want %d: %+v", r.DivergenceCount, len(want), r.Divergences)
+	}
+	for _, d := range r.Divergences {
+		if want[d.UTXOID] != d.Kind {
+			t.Errorf("utxo %s: kind %q, want %q", d.UTXOID, d.Kind, want[d.UTXOID])
+		}
+		switch d.Kind {
+		case DivergenceMissingInStore:
+			if d.Chain == nil || d.Store != nil {
+				t.Errorf("missing output: chain %v store %v", d.Chain, d.Store)
+			}
+		case DivergenceSpent:
+			if d.Chain == nil || d.Store == nil || d.Chain.Spent == d.Store.Spent {
+				t.Errorf("spent mismatch: chain %v store %v", d.Chain, d.Store)
+			}
+		case DivergenceUnbacked:
+			if d.Chain != nil || d.Store == nil {
+				t.Errorf("unbacked output: chain %v store %v", d.Chain, d.Store)
+			}
+		}
+	}
+	// the rebuilt set follows the chain, not the store
+	if u, ok := utxo.GetUTXO(missing); !ok || u.Spent {
+		t.Errorf("output missing from the store dropped from the set: %+v", u)
+	}
+	if _, ok := utxo.GetUTXO(extra.ID); ok {
+		t.Error("unbacked stored output added to the set")
+	}
+}
+
+func TestCompareUTXOSets(t *testing.T) {
+	chain := func(wallet string, amount int64, spent bool) *utxo.UTXO {
+		return &utxo.UTXO{ID: wallet, WalletID: wallet, Amount: amount, Spent: spent}
+	}
+	rebuilt := map[string]*utxo.UTXO{
+		"same":    chain("same", 10, false),
+		"owner":   chain("owner", 10, false),
+		"amount":  chain("amount", 10, false),
+		"spent":   chain("spent", 10, true),
+		"missing": chain("missing", 10, false),
+	}
+	stored := map[string]*utxo.UTXO{
+		"same":   chain("same", 10, false),
+		"owner":  {ID: "owner", WalletID: "someone else", Amount: 10},
+		"amount": chain("amount", 11, false),
+		"spent":  chain("spent", 10, false),
+		// spent on chain and pruned from the rebuilt set: not a divergence
+		"old":      chain("old", 5, true),
+		"unbacked": chain("unbacked", 5, false),
+	}
+	r := &UTXOSetReport{}
+	compareUTXOSets(rebuilt, stored, r)
+	want := map[string]string{
+		"owner":    DivergenceOwner,
+		"amount":   DivergenceAmount,
+		"spent":    DivergenceSpent,
+		"missing":  DivergenceMissingInStore,
+		"unbacked": DivergenceUnbacked,
+	}
+	if r.DivergenceCount != len(want) {
+		t.Errorf("%d divergences, want %d: %+v", r.DivergenceCount, len(want), r.Divergences)
+	}
+	for _, d := range r.Divergences {
+		if want[d.UTXOID] != d.Kind {
+			t.Errorf("utxo %s: kind %q, want %q", d.UTXOID, d.Kind, want[d.UTXOID])
+		}
+	}
+	if rebuilt["same"] != stored["same"] {
+		t.Error("matching output not replaced by the stored copy")
+	}
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/student/decentralized-wallet/internal/crypto"
+	"github.com/student/decentralized-wallet/internal/db"
+	"github.com/student/decentralized-wallet/internal/utxo"
+	"github.com/student/decentralized-wallet/wallet"
+)
+
+// maxRegisterBatch caps the keys one batch registration may carry.
+const maxRegisterBatch = 100
+
+// derivedKey is one HD-derived public key in a batch registration.
+type derivedKey struct {
+	PublicKey string `json:"public_key"` // base64
+	Path      string `json:"path"`
+	// Signature is the key's base64 signature of wallet.RegistrationMessage.
+	Signature string `json:"signature"`
+}
+
+type registerBatchReq struct {
+	// UserID names the owner when auth is not configured (local dev only).
+	UserID string       `json:"user_id,omitempty"`
+	Nonce  string       `json:"nonce"`
+	Keys   []derivedKey `json:"keys"`
+}
+
+// requestUID returns the authenticated user, or the fallback the client sent
+// when auth is not configured (local dev).
+func requestUID(r *http.Request, fallback string) string {
+	if uid, _ := r.Context().Value("uid").(string); uid != "" {
+		return uid
+	}
+	if db.AuthClient == nil {
+		return fallback
+	}
+	return ""
+}
+
+// checkDerivedKeys validates a batch and builds its wallet records. Wallet
+// IDs are derived here from the keys, never taken from the client.
+func checkDerivedKeys(uid string, keys []derivedKey) ([]*db.WalletRecord, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keys required")
+	}
+	if len(keys) > maxRegisterBatch {
+		return nil, fmt.Errorf("at most %d keys per batch", maxRegisterBatch)
+	}
+	recs := make([]*db.WalletRecord, 0, len(keys))
+	seen := map[string]bool{}
+	for i, k := range keys {
+		pub := strings.TrimSpace(k.PublicKey)
+		// the wallet ID hashes the key string, so only one spelling may register
+		if _, err := crypto.ParsePublicKey(pub); err != nil {
+			return nil, fmt.Errorf("key %d: public_key must be a canonical base64 Ed25519 public key", i)
+		}
+		if _, err := wallet.ParsePath(k.Path); err != nil {
+			return nil, fmt.Errorf("key %d: %v", i, err)
+		}
+		id := crypto.WalletIDFromPublicKey(pub)
+		if seen[id] || seen[k.Path] {
+			return nil, fmt.Errorf("key %d: duplicate key or path %s", i, k.Path)
+		}
+		seen[id], seen[k.Path] = true, true
+		recs = append(recs, &db.WalletRecord{WalletID: id, PublicKey: pub, OwnerUID: uid, Path: k.Path})
+	}
+	return recs, nil
+}
+
+// verifyKeyProofs spends the challenge nonce issued to uid and checks that
+// every key signed it, proving the caller holds each private key.
+func (s *Server) verifyKeyProofs(uid, nonce string, keys []derivedKey) error {
+	if err := s.challenges.take(nonce, uid, time.Now()); err != nil {
+		return err
+	}
+	for i, k := range keys {
+		pub := strings.TrimSpace(k.PublicKey)
+		msg := wallet.RegistrationMessage(s.params.ChainID, uid, nonce, pub)
+		if ok, err := crypto.VerifyEd25519Signature(pub, msg, k.Signature); err != nil || !ok {
+			return fmt.Errorf("key %d: invalid signature of the challenge", i)
+		}
+	}
+	return nil
+}
+
+// registerError maps a registration store error to its response.
+func registerError(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.ErrWalletOwned) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	http.Error(w, "failed to register wallets: "+err.Error(), http.StatusInternalServerError)
+}
+
+// registerBatchHandler registers a batch of public keys derived from one HD
+// wallet to the authenticated user, all or none. Every key must sign the
+// challenge named by nonce.
+func (s *Server) registerBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req registerBatchReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	uid := requestUID(r, req.UserID)
+	if uid == "" {
+		http.Error(w, "uid required", http.StatusUnauthorized)
+		return
+	}
+	recs, err := checkDerivedKeys(uid, req.Keys)
+	if err != nil {
+		http.Error(w, "invalid batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.verifyKeyProofs(uid, req.Nonce, req.Keys); err != nil {
+		http.Error(w, "key ownership not proven: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := s.store.RegisterWallets(recs); err != nil {
+		registerError(w, err)
+		return
+	}
+	type registered struct {
+		WalletID  string `json:"wallet_id"`
+		Address   string `json:"address"`
+		PublicKey string `json:"public_key"`
+		Path      string `json:"path"`
+	}
+	res := make([]registered, 0, len(recs))
+	for _, rec := range recs {
+		utxo.RegisterWallet(rec.WalletID, rec.PublicKey)
+		addr, _ := wallet.EncodeAddress(s.params.AddressPrefix, rec.WalletID)
+		res = append(res, registered{rec.WalletID, addr, rec.PublicKey, rec.Path})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"owner_uid": uid, "wallets": res})
+}
+
+// listUserWalletsHandler returns the wallets a user registered, ordered by
+// derivation path, with their balances, so a restored wallet can find its
+// accounts. Only the user themselves may list them.
+func (s *Server) listUserWalletsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if uid := requestUID(r, id); uid != id {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	recs, err := s.store.ListWalletsByOwner(id)
+	if err != nil {
+		http.Error(w, "failed to list wallets: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type ownedWallet struct {
+		*db.WalletRecord
+		Address string `json:"address"`
+		Balance int64  `json:"balance"`
+	}
+	res := make([]ownedWallet, 0, len(recs))
+	for _, rec := range recs {
+		addr, _ := wallet.EncodeAddress(s.params.AddressPrefix, rec.WalletID)
+		res = append(res, ownedWallet{rec, addr, utxo.WalletBalance(rec.WalletID)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user_id": id, "wallets": res})
+}
+
+// addressCheck is the result of validating an address.
+type addressCheck struct {
+	Valid    bool   `json:"valid"`
+	Address  string `json:"address"`
+	WalletID string `json:"wallet_id,omitempty"`
+	Prefix   string `json:"prefix"`
+	Error    string `json:"error,omitempty"`
+}
+
+// validateAddressHandler checks ?address= against this network's prefix and
+// the checksum, so a client can verify a receiver before signing. Given
+// ?wallet_id= instead, it returns the wallet's address.
+func (s *Server) validateAddressHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	res := addressCheck{Address: strings.TrimSpace(q.Get("address")), Prefix: s.params.AddressPrefix}
+	switch id := strings.TrimSpace(q.Get("wallet_id")); {
+	case res.Address != "":
+		walletID, err := wallet.DecodeAddress(s.params.AddressPrefix, res.Address)
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Valid, res.WalletID = true, walletID
+		}
+	case id != "":
+		addr, err := wallet.EncodeAddress(s.params.AddressPrefix, strings.ToLower(id))
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Valid, res.Address, res.WalletID = true, addr, strings.ToLower(id)
+		}
+	default:
+		http.Error(w, "address or wallet_id required", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// keySpellings returns a key, its canonical base64 public key and other
+// strings that decode to the same 32 bytes.
+func keySpellings(t *testing.T) (ed25519.PrivateKey, string, map[string]string) {
+	t.Helper()
+	// a key whose encoding uses + or /, so the URL alphabet spells it differently
+	var priv ed25519.PrivateKey
+	var canonical string
+	for !strings.ContainsAny(canonical, "+/") {
+		pub, key, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		priv, canonical = key, base64.StdEncoding.EncodeToString(pub)
+	}
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	// the last character before the padding carries two unused bits
+	last := strings.IndexByte(alphabet, canonical[42])
+	return priv, canonical, map[string]string{
+		"unpadded":      strings.TrimSuffix(canonical, "="),
+		"url alphabet":  strings.NewReplacer("+", "-", "/", "_").Replace(canonical),
+		"trailing bits": canonical[:42] + string(alphabet[last|1]) + "=",
+	}
+}
+
+func TestCheckDerivedKeysRequiresCanonicalKeys(t *testing.T) {
+	_, canonical, others := keySpellings(t)
+	recs, err := checkDerivedKeys("user", []derivedKey{{PublicKey: canonical, Path: "m/44'/1'/0'/0'/0'"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, pub := range others {
+		if _, err := checkDerivedKeys("user", []derivedKey{{PublicKey: pub, Path: "m/44'/1'/0'/0'/0'"}}); err == nil {
+			t.Errorf("%s spelling %q accepted; it would register a second wallet for %s", name, pub, recs[0].WalletID)
+		}
+	}
+}
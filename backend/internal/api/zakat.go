@@ -1,122 +1,134 @@
 package api
 
 import (
-    "crypto/sha256"
-    "encoding/hex"
+    "crypto/ed25519"
     "encoding/json"
+    "errors"
     "net/http"
-    "os"
-    "strconv"
     "time"
 
-    "github.com/student/decentralized-wallet/internal/db"
     "github.com/student/decentralized-wallet/internal/utxo"
 )
 
-// computeZakatForWallet computes 2.5% of balance (integer minor units) and creates a pending txn to zakat pool if >0.
-func computeZakatForWallet(walletID string, zakatPoolID string) (string, error) {
-    // fetch unspent utxos from firestore or in-memory
-    utxos, err := db.GetUnspentUTXOsByWallet(walletID)
-    var total int64
-    if err != nil {
-        // fallback to in-memory
-        total = utxo.WalletBalance(walletID)
-    } else {
-        for _, u := range utxos {
-            total += u.Amount
-        }
+// errNoAuthority is returned when zakat is due but this node cannot sign it.
+var errNoAuthority = errors.New("zakat deductions need the network authority's key (AUTHORITY_PRIVATE_KEY)")
+
+// maxZakatInputs caps the inputs of one zakat deduction, so a wallet with
+// many outputs is charged in several transactions that each fit in a block.
+const maxZakatInputs = 250
+
+// computeZakatForWallet deducts 2.5% of the wallet's unconditioned balance
+// (integer minor units) into pending txns to the zakat pool, if that is >0.
+// Every unconditioned utxo is spent, at most maxZakatInputs per txn, since
+// validators check each deduction against its own inputs, and the authority
+// key signs them in place of the wallet. It returns the txids it created.
+func (s *Server) computeZakatForWallet(walletID string, zakatPoolID string) ([]string, error) {
+    // the wallet index keeps the balance, so wallets owing nothing cost nothing
+    if utxo.ZakatDue(utxo.WalletBalance(walletID)) <= 0 {
+        return nil, nil
     }
-    zakat := (total * 25) / 1000 // 2.5% = 25/1000
-    if zakat <= 0 {
-        return "", nil
+
+    // gather the wallet's utxos, a page at a time
+    var inputs []utxo.UTXO
+    for after := ""; ; {
+        page, next := utxo.UTXOSet.List(walletID, after, 100)
+        for _, u := range page {
+            // locked outputs are spent on their own terms, not by the node
+            if u.Condition != nil { continue }
+            inputs = append(inputs, u)
+        }
+        if next == "" { break }
+        after = next
     }
 
-    // pick inputs greedily (from firestore or in-memory)
-    var inputs []string
-    var collected int64
-    if err == nil {
-        for _, u := range utxos {
-            inputs = append(inputs, u.ID)
-            collected += u.Amount
-            if collected >= zakat { break }
+    var txids []string
+    for len(inputs) > 0 {
+        n := len(inputs)
+        if n > maxZakatInputs { n = maxZakatInputs }
+        txid, err := s.deductZakat(walletID, zakatPoolID, inputs[:n])
+        if err != nil {
+            return txids, err
         }
-    } else {
-        // in-memory utxos
-        for _, u := range utxo.UTXOSet {
-            if u.WalletID == walletID && !u.Spent {
-                inputs = append(inputs, u.ID)
-                collected += u.Amount
-                if collected >= zakat { break }
-            }
+        if txid != "" {
+            txids = append(txids, txid)
         }
+        inputs = inputs[n:]
     }
-    if collected < zakat {
-        return "", nil // insufficient even after all utxos (shouldn't happen)
-    }
+    return txids, nil
+}
 
-    // create tx id
-    h := sha256.New()
-    h.Write([]byte(walletID))
-    h.Write([]byte(zakatPoolID))
-    h.Write([]byte(strconv.FormatInt(zakat, 10)))
-    h.Write([]byte(time.Now().UTC().Format(time.RFC3339Nano)))
-    txid := hex.EncodeToString(h.Sum(nil))
+// deductZakat commits one zakat deduction spending inputs, unless they owe
+// nothing. It returns the txid, or "" when there was nothing to deduct.
+func (s *Server) deductZakat(walletID, zakatPoolID string, inputs []utxo.UTXO) (string, error) {
+    ids := make([]string, 0, len(inputs))
+    var total int64
+    for _, u := range inputs {
+        ids = append(ids, u.ID)
+        total += u.Amount
+    }
+    zakat := utxo.ZakatDue(total)
+    if zakat <= 0 {
+        return "", nil
+    }
+    if s.authority == nil {
+        return "", errNoAuthority
+    }
 
+    now := time.Now().UTC()
     tx := &utxo.Transaction{
-        ID: txid,
         Sender: walletID,
         Receiver: zakatPoolID,
         Amount: zakat,
-        Note: "zakat_deduction",
-        Timestamp: time.Now().UTC(),
+        Note: utxo.ZakatNote,
+        Timestamp: now,
         SenderPublicKey: "", // system tx
-        Signature: []byte{},
-        Inputs: inputs,
+        Inputs: ids,
         Outputs: []utxo.TxOutput{{Recipient: zakatPoolID, Amount: zakat}},
+        ClientTimestamp: now.Format(time.RFC3339Nano),
+        ChainID: s.params.ChainID,
     }
-
-    // mark inputs spent (try firestore then in-memory)
-    for _, id := range inputs {
-        if err := db.MarkUTXOSpent(id); err != nil {
-            utxo.MarkUTXOSpent(id)
-        }
+    if change := total - zakat; change > 0 {
+        tx.Outputs = append(tx.Outputs, utxo.TxOutput{Recipient: walletID, Amount: change})
     }
-    // create output UTXO record for zakat pool
-    out := utxo.CreateUTXO(txid, 0, zakatPoolID, zakat)
-    _ = db.CreateUTXO(out)
+    tx.ID = tx.ComputeID()
+    tx.Signature = ed25519.Sign(s.authority, tx.SigningBytes())
+    txid := tx.ID
 
-    // persist pending tx (in-memory)
-    utxo.AddPendingTx(tx)
-    _ = db.AddPendingTx(tx)
-    _ = db.AddZakatRecord(walletID, zakat, txid)
+    // spend inputs, create the zakat pool output (and change) and record the pending tx atomically
+    if err := s.commitPending(tx); err != nil {
+        return "", err
+    }
+    _ = s.store.AddZakatRecord(walletID, zakat, txid)
 
     return txid, nil
 }
 
 // admin trigger for zakat (manual)
-func adminZakatHandler(w http.ResponseWriter, r *http.Request) {
-    zakatPool := os.Getenv("ZAKAT_POOL_WALLET_ID")
+func (s *Server) adminZakatHandler(w http.ResponseWriter, r *http.Request) {
+    zakatPool := s.params.ZakatPoolWallet
     if zakatPool == "" {
-        http.Error(w, "ZAKAT_POOL_WALLET_ID not configured", http.StatusInternalServerError)
+        http.Error(w, "zakat pool wallet not configured (zakat_pool_wallet / ZAKAT_POOL_WALLET_ID)", http.StatusInternalServerError)
         return
     }
-    wallets, err := db.ListAllWalletIDs()
+    if s.authority == nil {
+        http.Error(w, errNoAuthority.Error(), http.StatusInternalServerError)
+        return
+    }
+    wallets, err := s.store.ListAllWalletIDs()
     if err != nil {
         http.Error(w, "failed to list wallets: "+err.Error(), http.StatusInternalServerError)
         return
     }
     var created []string
     for _, wID := range wallets {
-        txid, err := computeZakatForWallet(wID, zakatPool)
-        if err == nil && txid != "" {
-            created = append(created, txid)
-        }
+        // a failure part way through still reports the deductions made
+        txids, _ := s.computeZakatForWallet(wID, zakatPool)
+        created = append(created, txids...)
     }
     json.NewEncoder(w).Encode(map[string]interface{}{"created_tx_ids": created})
 }
 
-// exported wrapper so main.go can call zakat logic without HTTP
 // ComputeZakatForWallet is an exported wrapper so main.go can call zakat logic directly.
-func ComputeZakatForWallet(walletID, zakatPool string) (string, error) {
-    return computeZakatForWallet(walletID, zakatPool)
+func (s *Server) ComputeZakatForWallet(walletID, zakatPool string) ([]string, error) {
+    return s.computeZakatForWallet(walletID, zakatPool)
 }
@@ -0,0 +1,51 @@
+package api
+
+import "testing"
+
+func TestZakatSplitsWalletWithManyOutputs(t *testing.T) {
+	net := newTestNet(t)
+	net.params.ZakatPoolWallet = newTestWallet(t).id
+	n := net.node(t, true)
+	w := newTestWallet(t)
+	const outputs = 2*maxZakatInputs + 100
+	for i := 0; i < outputs; i++ {
+		n.fund(t, w.id, 100)
+	}
+	for n.pool.Len() > 0 {
+		n.mine(t)
+	}
+
+	txids, err := n.ComputeZakatForWallet(w.id, net.params.ZakatPoolWallet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txids) != 3 {
+		t.Fatalf("%d deductions, want 3", len(txids))
+	}
+	spent := 0
+	for _, id := range txids {
+		tx, ok := n.pool.Get(id)
+		if !ok {
+			t.Fatalf("deduction %s is not pending", id)
+		}
+		if len(tx.Inputs) > maxZakatInputs {
+			t.Errorf("deduction %s spends %d outputs, more than %d", id, len(tx.Inputs), maxZakatInputs)
+		}
+		spent += len(tx.Inputs)
+	}
+	if spent != outputs {
+		t.Errorf("deductions spend %d outputs, want all %d", spent, outputs)
+	}
+
+	// every deduction is mined and valid, and together they take 2.5%
+	for n.pool.Len() > 0 {
+		n.mine(t)
+	}
+	n.validate(t)
+	if got := n.unspent(t, net.params.ZakatPoolWallet); got != outputs*100/40 {
+		t.Errorf("zakat pool has %d, want %d", got, outputs*100/40)
+	}
+	if got := n.unspent(t, w.id); got != outputs*100*39/40 {
+		t.Errorf("wallet has %d left, want %d", got, outputs*100*39/40)
+	}
+}
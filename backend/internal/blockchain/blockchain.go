@@ -2,31 +2,144 @@ package blockchain
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
-	"strconv"
 	"time"
+
+	"github.com/student/decentralized-wallet/internal/utxo"
 )
 
-// Block represents a simple block in the chain.
+// BlockVersion is the only header version this node writes or accepts. A
+// header commits to Bits, a compact 256-bit target (see target.go), and to
+// WitnessRoot, the Merkle root of the transactions' witness hashes, so a
+// block's signatures, preimages and fees cannot change without its hash
+// changing too. It is hashed in a fixed-width binary encoding (see
+// appendHeaderPrefix).
+const BlockVersion = 4
+
+// headerMagic starts the encoding of a header.
+var headerMagic = []byte("DWBH")
+
+// Block represents a simple block in the chain. It embeds the full transactions
+// so a block can be verified or shipped to another node without a database lookup.
 type Block struct {
-	Index        int64         `json:"index"`
-	Timestamp    time.Time     `json:"timestamp"`
-	Transactions []interface{} `json:"transactions"`
-	PreviousHash string        `json:"previous_hash"`
-	Nonce        int64         `json:"nonce"`
-	Hash         string        `json:"hash"`
-	MerkleRoot   string        `json:"merkle_root"`
+	Version      int                `json:"version"`
+	Index        int64              `json:"index"`
+	Timestamp    time.Time          `json:"timestamp"`
+	Transactions []utxo.Transaction `json:"transactions"`
+	PreviousHash string             `json:"previous_hash"`
+	Nonce        int64              `json:"nonce"`
+	Bits         uint32             `json:"bits"`
+	Hash         string             `json:"hash"`
+	MerkleRoot   string             `json:"merkle_root"`
+	// WitnessRoot commits to the transactions' witnesses.
+	WitnessRoot string `json:"witness_root"`
+}
+
+// BlockHeader is everything needed to recompute a block's hash, without the
+// transaction bodies. Light clients work with headers only.
+type BlockHeader struct {
+	Version      int       `json:"version"`
+	Index        int64     `json:"index"`
+	Timestamp    time.Time `json:"timestamp"`
+	PreviousHash string    `json:"previous_hash"`
+	MerkleRoot   string    `json:"merkle_root"`
+	Nonce        int64     `json:"nonce"`
+	Bits         uint32    `json:"bits"`
+	Hash         string    `json:"hash"`
+	TxCount      int       `json:"tx_count"`
+	WitnessRoot  string    `json:"witness_root"`
+}
+
+// Header returns the block's header.
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{
+		Version:      b.Version,
+		Index:        b.Index,
+		Timestamp:    b.Timestamp,
+		PreviousHash: b.PreviousHash,
+		MerkleRoot:   b.MerkleRoot,
+		Nonce:        b.Nonce,
+		Bits:         b.Bits,
+		Hash:         b.Hash,
+		TxCount:      len(b.Transactions),
+		WitnessRoot:  b.WitnessRoot,
+	}
+}
+
+// TxIDs returns the IDs of the block's transactions in block order.
+func (b *Block) TxIDs() []string {
+	ids := make([]string, 0, len(b.Transactions))
+	for _, t := range b.Transactions {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+// VerifyMerkleRoot reports whether MerkleRoot matches the embedded transactions.
+func (b *Block) VerifyMerkleRoot() bool {
+	return b.MerkleRoot == ComputeMerkleRoot(b.TxIDs())
+}
+
+// VerifyTxIDs reports whether every transaction's ID is the hash of its
+// canonical encoding. The Merkle root is built from the IDs, so only then
+// does it commit to the transactions' contents.
+func (b *Block) VerifyTxIDs() bool {
+	for i := range b.Transactions {
+		if b.Transactions[i].ComputeID() != b.Transactions[i].ID {
+			return false
+		}
+	}
+	return true
+}
+
+// ComputeWitnessRoot returns the Merkle root of the witness hashes of the
+// block's transactions, in block order.
+func (b *Block) ComputeWitnessRoot() string {
+	hashes := make([]string, 0, len(b.Transactions))
+	for i := range b.Transactions {
+		hashes = append(hashes, b.Transactions[i].WitnessHash())
+	}
+	return ComputeMerkleRoot(hashes)
+}
+
+// VerifyWitnessRoot reports whether WitnessRoot matches the embedded
+// transactions' witnesses.
+func (b *Block) VerifyWitnessRoot() bool {
+	return b.WitnessRoot == b.ComputeWitnessRoot()
 }
 
 // ComputeHash computes SHA-256 of the block header fields.
 func (b *Block) ComputeHash() string {
-	data := []byte("")
-	data = append(data, []byte(b.PreviousHash)...)
-	data = append(data, []byte(b.Timestamp.UTC().Format(time.RFC3339Nano))...)
-	data = append(data, []byte(b.MerkleRoot)...)
-	data = append(data, []byte(strconv.FormatInt(b.Index, 10))...)
-	data = append(data, []byte(strconv.FormatInt(b.Nonce, 10))...)
-	
-	h := sha256.Sum256(data)
-	return hex.EncodeToString(h[:])
+	h := b.Header()
+	return h.ComputeHash()
+}
+
+// ComputeHash computes SHA-256 of the header fields.
+func (h *BlockHeader) ComputeHash() string {
+	data := appendHeaderPrefix(nil, h.Version, h.Index, h.PreviousHash, h.Timestamp, h.MerkleRoot, h.WitnessRoot, h.Bits)
+	data = binary.BigEndian.AppendUint64(data, uint64(h.Nonce))
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// appendHeaderPrefix appends the encoding of a header up to its nonce, which
+// follows as a big-endian uint64: "DWBH", then the version (uint32), index
+// (int64), previous hash (uint32 length + bytes), timestamp (int64 Unix
+// nanoseconds), Merkle root (uint32 length + bytes), witness root (uint32
+// length + bytes) and bits (uint32).
+// Integers are big-endian. Every field is fixed width or length prefixed, so
+// no two headers share an encoding.
+func appendHeaderPrefix(dst []byte, version int, index int64, prevHash string, ts time.Time, merkleRoot, witnessRoot string, bits uint32) []byte {
+	dst = append(dst, headerMagic...)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(version))
+	dst = binary.BigEndian.AppendUint64(dst, uint64(index))
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(prevHash)))
+	dst = append(dst, prevHash...)
+	dst = binary.BigEndian.AppendUint64(dst, uint64(ts.UnixNano()))
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(merkleRoot)))
+	dst = append(dst, merkleRoot...)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(witnessRoot)))
+	dst = append(dst, witnessRoot...)
+	return binary.BigEndian.AppendUint32(dst, bits)
 }
@@ -0,0 +1,115 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+func testHeader() BlockHeader {
+	return BlockHeader{
+		Version:      BlockVersion,
+		Index:        7,
+		Timestamp:    time.Date(2025, 1, 2, 3, 4, 5, 678000000, time.UTC),
+		PreviousHash: "0000abcd0000abcd0000abcd0000abcd0000abcd0000abcd0000abcd0000abcd",
+		MerkleRoot:   "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		WitnessRoot:  "5df6e0e2761359d30a8275058e299fcc0381534545f55cf43e41983f5d4c9456",
+		Nonce:        42,
+		Bits:         0x1f00ffff,
+	}
+}
+
+// Known answers, computed independently from the layout documented on
+// appendHeaderPrefix.
+func TestHeaderHashKnownAnswers(t *testing.T) {
+	h := testHeader()
+	if got, want := h.ComputeHash(), "385314e3d0d66aa295a338e00ac515bf63611ab4c335e334915cb30d34771b9a"; got != want {
+		t.Errorf("hash %s, want %s", got, want)
+	}
+	h.WitnessRoot = ""
+	if got, want := h.ComputeHash(), "aef3e00c0247cd3bcd56b19d8587369dc9cc3224577e616421d47fa94bd299e7"; got != want {
+		t.Errorf("hash without a witness root %s, want %s", got, want)
+	}
+	h = testHeader()
+	h.Index, h.PreviousHash, h.Nonce = 0, "", 0
+	if got, want := h.ComputeHash(), "ac40fd5064be38cf5d1516db0d7bde28e77cd210d3eca8d722f74907786da8e7"; got != want {
+		t.Errorf("genesis-like hash %s, want %s", got, want)
+	}
+}
+
+func TestHeaderHashUnambiguous(t *testing.T) {
+	// a decimal encoding would let the index/nonce boundary move
+	a, b := testHeader(), testHeader()
+	a.Index, a.Nonce = 12, 3
+	b.Index, b.Nonce = 1, 23
+	if a.ComputeHash() == b.ComputeHash() {
+		t.Error("headers with different index and nonce share a hash")
+	}
+
+	// every field changes the hash
+	base := testHeader()
+	for name, change := range map[string]func(*BlockHeader){
+		"version":       func(h *BlockHeader) { h.Version++ },
+		"index":         func(h *BlockHeader) { h.Index++ },
+		"previous hash": func(h *BlockHeader) { h.PreviousHash = h.PreviousHash[1:] + "0" },
+		"timestamp":     func(h *BlockHeader) { h.Timestamp = h.Timestamp.Add(time.Millisecond) },
+		"merkle root":   func(h *BlockHeader) { h.MerkleRoot = h.MerkleRoot[:63] },
+		"witness root":  func(h *BlockHeader) { h.WitnessRoot = "00" },
+		"bits":          func(h *BlockHeader) { h.Bits++ },
+		"nonce":         func(h *BlockHeader) { h.Nonce++ },
+	} {
+		h := base
+		change(&h)
+		if h.ComputeHash() == base.ComputeHash() {
+			t.Errorf("changing the %s leaves the hash unchanged", name)
+		}
+	}
+}
+
+func TestMineBlockParallelMatchesComputeHash(t *testing.T) {
+	b := NewBlockTemplate(5, "prev", DifficultyToBits(2), nil)
+	floor := time.Now().Add(time.Hour)
+	b.Timestamp = floor
+	mined, err := MineBlockParallel(context.Background(), b, MineOptions{Workers: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mined.ComputeHash() != mined.Hash {
+		t.Errorf("mined hash %s, header hashes to %s", mined.Hash, mined.ComputeHash())
+	}
+	if !HashMeetsTarget(mined.Hash, mined.Bits) {
+		t.Error("mined hash does not meet its target")
+	}
+	if !mined.Timestamp.After(floor) {
+		t.Errorf("timestamp %s not after the template's %s", mined.Timestamp, floor)
+	}
+}
+
+// A block's signatures are not in its txids or Merkle root; only the witness
+// root ties them to the block hash.
+func TestVerifyWitnessRoot(t *testing.T) {
+	f := testFunding()
+	tx := transfer(alice, 1, []string{f.OutputUTXOID(0)}, 1000, utxo.TxOutput{Recipient: bob.id, Amount: 990})
+	b := NewBlockTemplate(1, "prev", DifficultyToBits(1), []utxo.Transaction{*NewCoinbase(testChainID, 1, bob.id, 10, testStart), tx})
+	if !b.VerifyMerkleRoot() || !b.VerifyWitnessRoot() {
+		t.Fatal("template does not verify")
+	}
+	for name, change := range map[string]func(*utxo.Transaction){
+		"signature": func(t *utxo.Transaction) { t.Signature = ed25519.Sign(mallory.priv, t.SigningBytes()) },
+		"preimages": func(t *utxo.Transaction) { t.Preimages = []string{"00"} },
+		"fee":       func(t *utxo.Transaction) { t.Fee++ },
+	} {
+		m := *b
+		m.Transactions = append([]utxo.Transaction(nil), b.Transactions...)
+		change(&m.Transactions[1])
+		if !m.VerifyMerkleRoot() {
+			t.Fatalf("%s: changed the Merkle root; the test proves nothing", name)
+		}
+		if m.VerifyWitnessRoot() {
+			t.Errorf("changing the %s leaves the witness root valid", name)
+		}
+	}
+}
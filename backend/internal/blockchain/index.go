@@ -0,0 +1,250 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// A node keeps every block it has seen, not only those on its main chain, so
+// it can switch to a competing branch once that branch has more cumulative
+// proof of work. The BlockIndex holds their headers as a tree keyed by hash.
+
+var (
+	// ErrOrphanBlock is returned when a block's parent is not in the index.
+	ErrOrphanBlock = errors.New("parent block unknown")
+	// ErrKnownBlock is returned when a block is already in the index.
+	ErrKnownBlock = errors.New("block already known")
+)
+
+// IndexEntry is one block in a BlockIndex.
+type IndexEntry struct {
+	Header BlockHeader
+	// Parent is nil for the genesis block.
+	Parent *IndexEntry
+	// ChainWork is the total work of the chain from genesis up to and including this block.
+	ChainWork *big.Int
+	// Invalid marks a block that failed full validation, or descends from one.
+	Invalid bool
+
+	seq      int64 // arrival order: ties in work go to the block seen first
+	children []*IndexEntry
+}
+
+// Hash returns the block hash.
+func (e *IndexEntry) Hash() string { return e.Header.Hash }
+
+// Height returns the block index.
+func (e *IndexEntry) Height() int64 { return e.Header.Index }
+
+// Ancestor returns the block at height on e's branch (e itself at its own
+// height), or nil if height is out of range.
+func (e *IndexEntry) Ancestor(height int64) *IndexEntry {
+	if height < 0 || height > e.Height() {
+		return nil
+	}
+	for e != nil && e.Height() > height {
+		e = e.Parent
+	}
+	return e
+}
+
+// Locator lists hashes on e's branch walking back from e: the ten most recent
+// blocks, then exponentially sparser ones, ending with genesis. A peer finds
+// the last block the two chains share from the first hash it recognises.
+func (e *IndexEntry) Locator() []string {
+	var hashes []string
+	step := int64(1)
+	for cur := e; cur != nil; {
+		hashes = append(hashes, cur.Hash())
+		if cur.Height() == 0 {
+			break
+		}
+		if len(hashes) >= 10 {
+			step *= 2
+		}
+		h := cur.Height() - step
+		if h < 0 {
+			h = 0
+		}
+		cur = cur.Ancestor(h)
+	}
+	return hashes
+}
+
+// heavier reports whether a should be preferred over b as the chain tip.
+func heavier(a, b *IndexEntry) bool {
+	if c := a.ChainWork.Cmp(b.ChainWork); c != 0 {
+		return c > 0
+	}
+	return a.seq < b.seq
+}
+
+// BlockIndex is a tree of block headers keyed by hash, tracking the
+// cumulative work of every branch. It is safe for concurrent use.
+type BlockIndex struct {
+	mu      sync.RWMutex
+	entries map[string]*IndexEntry
+	best    *IndexEntry
+	seq     int64
+}
+
+// NewBlockIndex returns an empty index.
+func NewBlockIndex() *BlockIndex {
+	return &BlockIndex{entries: map[string]*IndexEntry{}}
+}
+
+// Add indexes h under its parent. A header with an empty PreviousHash is a
+// genesis block and starts a new tree. It returns ErrOrphanBlock if the parent
+// is unknown and ErrKnownBlock if h is already indexed. Children of invalid
+// blocks are indexed as invalid.
+func (x *BlockIndex) Add(h BlockHeader) (*IndexEntry, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, ok := x.entries[h.Hash]; ok {
+		return nil, ErrKnownBlock
+	}
+	work := Work(h.Bits)
+	e := &IndexEntry{Header: h, ChainWork: work, seq: x.seq}
+	if h.PreviousHash != "" {
+		parent, ok := x.entries[h.PreviousHash]
+		if !ok {
+			return nil, fmt.Errorf("block %d (%s): %w", h.Index, h.Hash, ErrOrphanBlock)
+		}
+		if h.Index != parent.Height()+1 {
+			return nil, fmt.Errorf("block index %d does not follow parent %d", h.Index, parent.Height())
+		}
+		e.Parent = parent
+		e.ChainWork = work.Add(work, parent.ChainWork)
+		e.Invalid = parent.Invalid
+		parent.children = append(parent.children, e)
+	}
+	x.seq++
+	x.entries[h.Hash] = e
+	if !e.Invalid && (x.best == nil || heavier(e, x.best)) {
+		x.best = e
+	}
+	return e, nil
+}
+
+// Get returns the entry for hash, or nil.
+func (x *BlockIndex) Get(hash string) *IndexEntry {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.entries[hash]
+}
+
+// Len returns the number of indexed blocks.
+func (x *BlockIndex) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.entries)
+}
+
+// Best returns the tip of the heaviest valid branch, the one seen first on a
+// tie, or nil if the index is empty.
+func (x *BlockIndex) Best() *IndexEntry {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.best
+}
+
+// Invalidate marks the block and every descendant invalid, so Best never
+// selects a branch through it again.
+func (x *BlockIndex) Invalidate(hash string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	e, ok := x.entries[hash]
+	if !ok {
+		return
+	}
+	stack := []*IndexEntry{e}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		e.Invalid = true
+		stack = append(stack, e.children...)
+	}
+	x.resetBestLocked()
+}
+
+// resetBestLocked picks the best entry again after one was removed or
+// invalidated.
+func (x *BlockIndex) resetBestLocked() {
+	x.best = nil
+	for _, e := range x.entries {
+		if !e.Invalid && (x.best == nil || heavier(e, x.best)) {
+			x.best = e
+		}
+	}
+}
+
+// Remove drops a block without children from the index, e.g. one whose
+// connection failed for a reason other than its validity, so a later copy of
+// it is not taken for a duplicate. Blocks with children are kept.
+func (x *BlockIndex) Remove(hash string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	e, ok := x.entries[hash]
+	if !ok || len(e.children) > 0 {
+		return
+	}
+	delete(x.entries, hash)
+	if p := e.Parent; p != nil {
+		for i, c := range p.children {
+			if c == e {
+				p.children = append(p.children[:i], p.children[i+1:]...)
+				break
+			}
+		}
+	}
+	if x.best == e {
+		x.resetBestLocked()
+	}
+}
+
+// Tips returns every block without children: the main chain tip and the end
+// of each side branch.
+func (x *BlockIndex) Tips() []*IndexEntry {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var tips []*IndexEntry
+	for _, e := range x.entries {
+		if len(e.children) == 0 {
+			tips = append(tips, e)
+		}
+	}
+	return tips
+}
+
+// FindFork returns the last block a and b have in common, or nil if they are
+// in different trees.
+func FindFork(a, b *IndexEntry) *IndexEntry {
+	for a != nil && b != nil && a.Height() > b.Height() {
+		a = a.Parent
+	}
+	for a != nil && b != nil && b.Height() > a.Height() {
+		b = b.Parent
+	}
+	for a != nil && b != nil && a != b {
+		a, b = a.Parent, b.Parent
+	}
+	if a == nil || b == nil {
+		return nil
+	}
+	return a
+}
+
+// Branch returns the blocks after fork up to and including tip, in ascending
+// height. fork must be an ancestor of tip.
+func Branch(fork, tip *IndexEntry) []*IndexEntry {
+	var path []*IndexEntry
+	for e := tip; e != nil && e != fork; e = e.Parent {
+		path = append(path, e)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
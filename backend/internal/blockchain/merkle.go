@@ -0,0 +1,143 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// Merkle tree over transaction IDs.
+//
+// Leaves are H(0x00 || txid) and inner nodes are H(0x01 || left || right), so a
+// leaf can never be passed off as an inner node (second-preimage resistance).
+// When a level has an odd number of nodes the last one is promoted unchanged
+// to the next level rather than duplicated, which keeps two different tx lists
+// from producing the same root. The root of an empty list is SHA-256 of nothing.
+
+const (
+	leafPrefix  = 0x00
+	innerPrefix = 0x01
+)
+
+// ErrTxNotInBlock is returned when a proof is requested for a tx the block does not contain.
+var ErrTxNotInBlock = errors.New("transaction not in block")
+
+// ProofStep is one sibling on the audit path from a leaf to the root.
+// Left is true when the sibling sits to the left of the running hash.
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// MerkleProof proves that TxID is included under MerkleRoot.
+type MerkleProof struct {
+	TxID       string      `json:"tx_id"`
+	Index      int         `json:"index"`
+	MerkleRoot string      `json:"merkle_root"`
+	Path       []ProofStep `json:"path"`
+}
+
+func leafHash(txID string) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write([]byte(txID))
+	return h.Sum(nil)
+}
+
+func innerHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{innerPrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// merkleLevels returns every level of the tree, leaves first and root last.
+func merkleLevels(txIDs []string) [][][]byte {
+	level := make([][]byte, 0, len(txIDs))
+	for _, id := range txIDs {
+		level = append(level, leafHash(id))
+	}
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				// odd node out: promote unchanged
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, innerHash(level[i], level[i+1]))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// ComputeMerkleRoot returns the hex-encoded Merkle root of the tx IDs in block order.
+func ComputeMerkleRoot(txIDs []string) string {
+	if len(txIDs) == 0 {
+		h := sha256.Sum256(nil)
+		return hex.EncodeToString(h[:])
+	}
+	levels := merkleLevels(txIDs)
+	return hex.EncodeToString(levels[len(levels)-1][0])
+}
+
+// BuildMerkleProof returns the audit path for txID within txIDs.
+func BuildMerkleProof(txIDs []string, txID string) (*MerkleProof, error) {
+	index := -1
+	for i, id := range txIDs {
+		if id == txID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, ErrTxNotInBlock
+	}
+	levels := merkleLevels(txIDs)
+	proof := &MerkleProof{
+		TxID:       txID,
+		Index:      index,
+		MerkleRoot: hex.EncodeToString(levels[len(levels)-1][0]),
+		Path:       []ProofStep{},
+	}
+	pos := index
+	for _, level := range levels[:len(levels)-1] {
+		sibling := pos ^ 1
+		if sibling < len(level) {
+			proof.Path = append(proof.Path, ProofStep{
+				Hash: hex.EncodeToString(level[sibling]),
+				Left: sibling < pos,
+			})
+		}
+		// a promoted node has no sibling at this level and contributes no step
+		pos /= 2
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof checks that txID hashes up to merkleRoot along path.
+func VerifyMerkleProof(txID string, path []ProofStep, merkleRoot string) bool {
+	cur := leafHash(txID)
+	for _, step := range path {
+		sib, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sib) != sha256.Size {
+			return false
+		}
+		if step.Left {
+			cur = innerHash(sib, cur)
+		} else {
+			cur = innerHash(cur, sib)
+		}
+	}
+	return hex.EncodeToString(cur) == merkleRoot
+}
+
+// Verify checks the proof against its own MerkleRoot. Callers should also
+// compare MerkleRoot with the header they trust.
+func (p *MerkleProof) Verify() bool {
+	return VerifyMerkleProof(p.TxID, p.Path, p.MerkleRoot)
+}
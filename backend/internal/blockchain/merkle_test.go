@@ -0,0 +1,119 @@
+package blockchain
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func merkleIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = "tx" + strconv.Itoa(i)
+	}
+	return ids
+}
+
+// Known answers, computed independently from the rules in merkle.go: leaves
+// H(0x00 || txid), inner nodes H(0x01 || left || right), odd node promoted.
+func TestComputeMerkleRootKnownAnswers(t *testing.T) {
+	tests := []struct {
+		n    int
+		root string
+	}{
+		{0, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{1, "a91327b97f480f98a384156722f60b982456b1cc3ed6f0c31de3f18824907837"},
+		{2, "e7f5de3cc43cc9ff4cbef41b922f3c341e2509204dfe188027df39185797ba6c"},
+		{3, "e52026eebb267b65f2d684eb8bea5aefc48d0224008bae3108ff4d29ccdd189e"},
+		{5, "410e561afb28190ea4a953dc541c5ee3965b9abc09724adfe1467fd55dfc1949"},
+		{7, "025331e8d58758d6d655b817aa923ba264937e79a7a364c19ced83819e9cf91d"},
+	}
+	for _, tt := range tests {
+		if got := ComputeMerkleRoot(merkleIDs(tt.n)); got != tt.root {
+			t.Errorf("%d leaves: root %s, want %s", tt.n, got, tt.root)
+		}
+	}
+}
+
+func TestBuildMerkleProofKnownAnswers(t *testing.T) {
+	tests := []struct {
+		n, index int
+		path     []ProofStep
+	}{
+		// the promoted last leaf of an odd level skips that level
+		{3, 2, []ProofStep{
+			{"e7f5de3cc43cc9ff4cbef41b922f3c341e2509204dfe188027df39185797ba6c", true},
+		}},
+		{5, 4, []ProofStep{
+			{"f7ef01f2494a1ec991f6314065b11deb28b3b95b21db6d076ddd71201aadfc98", true},
+		}},
+		{7, 6, []ProofStep{
+			{"c2fdc5c06a35747fe523f39d8576cf9df5eda2c7926864302b3c07f65fb3cf95", true},
+			{"f7ef01f2494a1ec991f6314065b11deb28b3b95b21db6d076ddd71201aadfc98", true},
+		}},
+		{7, 3, []ProofStep{
+			{"8f968b0e4dc16ea8cfd5a00660c8d7fe37949172a64cec693d98fe5e640b0fa6", true},
+			{"e7f5de3cc43cc9ff4cbef41b922f3c341e2509204dfe188027df39185797ba6c", true},
+			{"9f1cc0ca28064b2c913d64abbc94aaa2d87a38ea7bfcaffe06841bd10490587e", false},
+		}},
+	}
+	for _, tt := range tests {
+		ids := merkleIDs(tt.n)
+		p, err := BuildMerkleProof(ids, ids[tt.index])
+		if err != nil {
+			t.Fatalf("%d leaves, index %d: %v", tt.n, tt.index, err)
+		}
+		if p.Index != tt.index || p.MerkleRoot != ComputeMerkleRoot(ids) {
+			t.Errorf("%d leaves, index %d: proof index %d root %s", tt.n, tt.index, p.Index, p.MerkleRoot)
+		}
+		if !reflect.DeepEqual(p.Path, tt.path) {
+			t.Errorf("%d leaves, index %d: path %+v, want %+v", tt.n, tt.index, p.Path, tt.path)
+		}
+	}
+}
+
+func TestMerkleProofsVerify(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		ids := merkleIDs(n)
+		root := ComputeMerkleRoot(ids)
+		for i, id := range ids {
+			p, err := BuildMerkleProof(ids, id)
+			if err != nil {
+				t.Fatalf("%d leaves, index %d: %v", n, i, err)
+			}
+			if !p.Verify() || !VerifyMerkleProof(id, p.Path, root) {
+				t.Errorf("%d leaves, index %d: valid proof rejected", n, i)
+			}
+			if VerifyMerkleProof("other", p.Path, root) {
+				t.Errorf("%d leaves, index %d: proof accepted for another tx", n, i)
+			}
+			if len(p.Path) > 0 {
+				flipped := append([]ProofStep(nil), p.Path...)
+				flipped[0].Left = !flipped[0].Left
+				if VerifyMerkleProof(id, flipped, root) {
+					t.Errorf("%d leaves, index %d: proof accepted with a sibling on the wrong side", n, i)
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleRootDistinguishesDuplicatedLeaf(t *testing.T) {
+	// Bitcoin's duplicate-the-last-node rule gives these two lists one root
+	ids := merkleIDs(3)
+	if ComputeMerkleRoot(ids) == ComputeMerkleRoot(append(ids, ids[2])) {
+		t.Error("a list and the list with its last leaf repeated share a root")
+	}
+	// an inner node is not accepted as a leaf
+	levels := merkleLevels(merkleIDs(4))
+	inner := string(levels[1][0])
+	if ComputeMerkleRoot([]string{inner, string(levels[1][1])}) == ComputeMerkleRoot(merkleIDs(4)) {
+		t.Error("inner nodes passed off as leaves reproduce the root")
+	}
+}
+
+func TestBuildMerkleProofMissingTx(t *testing.T) {
+	if _, err := BuildMerkleProof(merkleIDs(3), "tx9"); err != ErrTxNotInBlock {
+		t.Errorf("err = %v, want ErrTxNotInBlock", err)
+	}
+}
@@ -1,54 +1,69 @@
 package blockchain
 
 import (
-    "crypto/sha256"
-    "encoding/hex"
-    "strings"
+    "context"
+    "sync/atomic"
     "time"
+
+    "github.com/student/decentralized-wallet/internal/utxo"
 )
 
-// DifficultyPrefix returns the target prefix for the hash based on difficulty (number of leading zeros)
-func DifficultyPrefix(d int) string {
-    return strings.Repeat("0", d)
+// MineBlock searches for a nonce on every core until the hash meets b.Bits.
+// It returns the mined block with Hash, Nonce and Timestamp set.
+func MineBlock(b *Block) *Block {
+    mined, _ := MineBlockParallel(context.Background(), b, MineOptions{}, nil)
+    return mined
 }
 
-// MineBlock runs a simple PoW loop trying nonces until hash has the required prefix.
-// It returns the mined block with Hash and Nonce set.
-func MineBlock(b *Block, difficulty int) *Block {
-    prefix := DifficultyPrefix(difficulty)
+// ctxCheckInterval is how many attempts MineBlockContext makes between context checks.
+const ctxCheckInterval = 1024
+
+// MineBlockContext is the original single-core PoW loop: it tries nonces in
+// order, restamping the block on every attempt, until the hash meets b.Bits,
+// and gives up with ctx.Err() once ctx is done. If hashes is non-nil it is
+// incremented (atomically) with the number of attempts made. Kept as the
+// baseline for MineBlockParallel.
+func MineBlockContext(ctx context.Context, b *Block, hashes *int64) (*Block, error) {
     var nonce int64 = 0
     for {
+        if nonce%ctxCheckInterval == 0 {
+            if hashes != nil && nonce > 0 {
+                atomic.AddInt64(hashes, ctxCheckInterval)
+            }
+            if err := ctx.Err(); err != nil {
+                return nil, err
+            }
+        }
         b.Nonce = nonce
-        b.Timestamp = time.Now().UTC()
+        // millisecond precision survives every store (Firestore keeps microseconds) and JSON round-trips
+        b.Timestamp = time.Now().UTC().Truncate(time.Millisecond)
         h := b.ComputeHash()
-        if strings.HasPrefix(h, prefix) {
+        if HashMeetsTarget(h, b.Bits) {
+            if hashes != nil {
+                atomic.AddInt64(hashes, nonce%ctxCheckInterval+1)
+            }
             b.Hash = h
-            return b
+            return b, nil
         }
         nonce++
     }
 }
 
-// ComputeMerkleRoot placeholder: for now compute hash over concatenated tx IDs
-func ComputeMerkleRoot(txIDs []string) string {
-    h := sha256.New()
-    for _, id := range txIDs {
-        h.Write([]byte(id))
-    }
-    return hex.EncodeToString(h.Sum(nil))
-}
-
-// CreateBlock builds a new Block from previous hash and transactions
-func CreateBlock(index int64, prevHash string, txIDs []string, difficulty int) *Block {
+// NewBlockTemplate builds an unsolved block (Merkle and witness roots and target set, no nonce or hash).
+func NewBlockTemplate(index int64, prevHash string, bits uint32, txs []utxo.Transaction) *Block {
     b := &Block{
+        Version:      BlockVersion,
         Index:        index,
         PreviousHash: prevHash,
-        Transactions: make([]interface{}, 0),
-        MerkleRoot:   ComputeMerkleRoot(txIDs),
-    }
-    // set transactions as txIDs for now
-    for _, t := range txIDs {
-        b.Transactions = append(b.Transactions, t)
+        Bits:         bits,
+        Transactions: txs,
     }
-    return MineBlock(b, difficulty)
+    b.MerkleRoot = ComputeMerkleRoot(b.TxIDs())
+    b.WitnessRoot = b.ComputeWitnessRoot()
+    return b
+}
+
+// CreateBlock builds a new Block from previous hash and transactions
+func CreateBlock(index int64, prevHash string, txs []utxo.Transaction, bits uint32) *Block {
+    return MineBlock(NewBlockTemplate(index, prevHash, bits, txs))
 }
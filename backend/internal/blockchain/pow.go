@@ -0,0 +1,134 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MineOptions tunes MineBlockParallel. The zero value uses every core and the
+// full nonce range.
+type MineOptions struct {
+	// Workers is the number of goroutines searching; <= 0 means runtime.NumCPU().
+	Workers int
+	// MaxNonce bounds the nonces tried per timestamp to [0, MaxNonce]; 0 means
+	// math.MaxInt64. Once a worker has tried all of its nonces it moves the
+	// timestamp forward and starts over.
+	MaxNonce int64
+}
+
+func (o MineOptions) workers() int {
+	if o.Workers > 0 {
+		return o.Workers
+	}
+	return runtime.NumCPU()
+}
+
+func (o MineOptions) maxNonce() int64 {
+	if o.MaxNonce > 0 {
+		return o.MaxNonce
+	}
+	return math.MaxInt64
+}
+
+// powResult is a solution found by one worker.
+type powResult struct {
+	timestamp time.Time
+	nonce     int64
+	hash      string
+}
+
+// MineBlockParallel searches for a nonce meeting b.Bits on opts.Workers goroutines. Worker i
+// tries nonces i, i+W, i+2W, ... so no two workers ever hash the same header;
+// each keeps its timestamp fixed until its share of the nonce range is used
+// up, then bumps it by at least a millisecond. Every timestamp tried is after
+// b.Timestamp, so a template can set the earliest time consensus allows. The
+// first solution stops every worker. It returns ctx.Err() if ctx is done first. If hashes is non-nil it is
+// incremented (atomically) with the number of attempts made.
+func MineBlockParallel(ctx context.Context, b *Block, opts MineOptions, hashes *int64) (*Block, error) {
+	workers := opts.workers()
+	maxNonce := opts.maxNonce()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	found := make(chan powResult, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(start int64) {
+			defer wg.Done()
+			if r, ok := powWorker(ctx, b, start, int64(workers), maxNonce, hashes); ok {
+				select {
+				case found <- r:
+					cancel()
+				default:
+					// another worker won the race
+				}
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+
+	select {
+	case r := <-found:
+		b.Timestamp = r.timestamp
+		b.Nonce = r.nonce
+		b.Hash = r.hash
+		return b, nil
+	default:
+		return nil, ctx.Err()
+	}
+}
+
+// powWorker tries nonces start, start+stride, ... up to maxNonce, moving the
+// timestamp forward whenever it runs out. It reports false if ctx ends first.
+func powWorker(ctx context.Context, b *Block, start, stride, maxNonce int64, hashes *int64) (powResult, bool) {
+	ts := b.Timestamp.UTC().Truncate(time.Millisecond)
+	var head, buf []byte
+	target := targetBytes(b.Bits)
+	// counted is how many of the tried nonces were added to hashes
+	var tried, counted int64
+	defer func() {
+		if hashes != nil {
+			atomic.AddInt64(hashes, tried-counted)
+		}
+	}()
+	for {
+		// millisecond precision survives every store (Firestore keeps microseconds) and JSON round-trips
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		if !now.After(ts) {
+			now = ts.Add(time.Millisecond)
+		}
+		ts = now
+		// the nonce ends the header, so everything before it is hashed as is
+		head = appendHeaderPrefix(head[:0], b.Version, b.Index, b.PreviousHash, ts, b.MerkleRoot, b.WitnessRoot, b.Bits)
+
+		for nonce := start; nonce >= 0 && nonce <= maxNonce; nonce += stride {
+			if tried-counted == ctxCheckInterval {
+				if hashes != nil {
+					atomic.AddInt64(hashes, ctxCheckInterval)
+				}
+				counted = tried
+				if ctx.Err() != nil {
+					return powResult{}, false
+				}
+			}
+			tried++
+			buf = binary.BigEndian.AppendUint64(append(buf[:0], head...), uint64(nonce))
+			sum := sha256.Sum256(buf)
+			if bytes.Compare(sum[:], target[:]) <= 0 {
+				return powResult{timestamp: ts, nonce: nonce, hash: hex.EncodeToString(sum[:])}, true
+			}
+		}
+		if ctx.Err() != nil {
+			return powResult{}, false
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// benchBits needs ~65k attempts per block: long enough for the workers to
+// matter, short enough for a quick benchmark run.
+var benchBits = DifficultyToBits(4)
+
+func benchTemplate(i int) *Block {
+	// a different parent per iteration so every search starts fresh
+	return NewBlockTemplate(1, strconv.Itoa(i), benchBits, nil)
+}
+
+func reportHashRate(b *testing.B, hashes int64) {
+	b.ReportMetric(float64(hashes)/b.Elapsed().Seconds(), "hashes/s")
+}
+
+// BenchmarkMineSerial is the original single-core loop.
+func BenchmarkMineSerial(b *testing.B) {
+	var hashes int64
+	for i := 0; i < b.N; i++ {
+		if _, err := MineBlockContext(context.Background(), benchTemplate(i), &hashes); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportHashRate(b, atomic.LoadInt64(&hashes))
+}
+
+func BenchmarkMineParallel(b *testing.B) {
+	counts := []int{1, 2, 4}
+	if n := runtime.NumCPU(); n > 4 {
+		counts = append(counts, n)
+	}
+	for _, workers := range counts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			var hashes int64
+			opts := MineOptions{Workers: workers}
+			for i := 0; i < b.N; i++ {
+				blk, err := MineBlockParallel(context.Background(), benchTemplate(i), opts, &hashes)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if blk.ComputeHash() != blk.Hash {
+					b.Fatalf("block %d: hash does not match header", i)
+				}
+			}
+			reportHashRate(b, atomic.LoadInt64(&hashes))
+		})
+	}
+}
+
+// BenchmarkMineParallelExhaustion forces every worker to run out of nonces
+// and bump the timestamp many times per block.
+func BenchmarkMineParallelExhaustion(b *testing.B) {
+	var hashes int64
+	opts := MineOptions{MaxNonce: 1000}
+	for i := 0; i < b.N; i++ {
+		blk, err := MineBlockParallel(context.Background(), benchTemplate(i), opts, &hashes)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if blk.Nonce > opts.MaxNonce || blk.ComputeHash() != blk.Hash {
+			b.Fatalf("block %d: bad solution nonce=%d", i, blk.Nonce)
+		}
+	}
+	reportHashRate(b, atomic.LoadInt64(&hashes))
+}
@@ -0,0 +1,97 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// futureFloor is a template timestamp the clock never reaches, so every
+// timestamp a worker moves to is exactly a millisecond after its last.
+var futureFloor = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestMineBlockParallelSolves(t *testing.T) {
+	for _, opts := range []MineOptions{{Workers: 1}, {Workers: 4}, {Workers: 3, MaxNonce: 5}} {
+		var hashes int64
+		b := NewBlockTemplate(1, "parent", DifficultyToBits(2), nil)
+		mined, err := MineBlockParallel(context.Background(), b, opts, &hashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mined.Hash != mined.ComputeHash() || !HashMeetsTarget(mined.Hash, mined.Bits) {
+			t.Errorf("%+v: %s is not a solution", opts, mined.Hash)
+		}
+		if opts.MaxNonce > 0 && mined.Nonce > opts.MaxNonce {
+			t.Errorf("%+v: nonce %d past the limit", opts, mined.Nonce)
+		}
+		if hashes == 0 {
+			t.Errorf("%+v: no hashes counted", opts)
+		}
+	}
+}
+
+func TestMineBlockParallelExhaustsNonces(t *testing.T) {
+	// parent-4542 first meets a 2^-10 target at nonce 1023 of the second
+	// timestamp: the search runs out of nonces once, and the solution lands
+	// exactly on an interval at which hashes are counted
+	b := NewBlockTemplate(1, "parent-4542", BigToCompact(new(big.Int).Lsh(big.NewInt(1), 246)), nil)
+	b.Timestamp = futureFloor
+	var hashes int64
+	mined, err := MineBlockParallel(context.Background(), b, MineOptions{Workers: 1, MaxNonce: ctxCheckInterval - 1}, &hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := futureFloor.Add(2 * time.Millisecond); !mined.Timestamp.Equal(want) || mined.Nonce != ctxCheckInterval-1 {
+		t.Fatalf("solved at nonce %d on %s, want %d on %s", mined.Nonce, mined.Timestamp, ctxCheckInterval-1, want)
+	}
+	if mined.Hash != mined.ComputeHash() || !HashMeetsTarget(mined.Hash, mined.Bits) {
+		t.Fatalf("%s is not a solution", mined.Hash)
+	}
+	if hashes != 2*ctxCheckInterval {
+		t.Errorf("%d hashes counted, want %d", hashes, 2*ctxCheckInterval)
+	}
+}
+
+func TestMineBlockParallelCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	var hashes int64
+	done := make(chan error, 1)
+	go func() {
+		// never solved while the test runs
+		_, err := MineBlockParallel(ctx, NewBlockTemplate(1, "parent", DifficultyToBits(20), nil), MineOptions{Workers: 4}, &hashes)
+		done <- err
+	}()
+	for deadline := time.Now().Add(10 * time.Second); atomic.LoadInt64(&hashes) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("no hashes counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still mining after the cancel")
+	}
+
+	// every worker is gone: nothing more is counted and no goroutine is left
+	n := atomic.LoadInt64(&hashes)
+	time.Sleep(20 * time.Millisecond)
+	if got := atomic.LoadInt64(&hashes); got != n {
+		t.Errorf("%d hashes counted after returning", got-n)
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines, %d before mining", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,69 @@
+package blockchain
+
+import (
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+// maxHalvings is where the subsidy has shifted to zero for any int64 amount.
+const maxHalvings = 63
+
+// RewardSchedule is the emission schedule: the block subsidy starts at
+// InitialSubsidy and halves every HalvingInterval blocks.
+type RewardSchedule struct {
+	InitialSubsidy  int64 `json:"initial_subsidy"`
+	HalvingInterval int64 `json:"halving_interval"`
+}
+
+// DefaultRewardSchedule is used when nothing else is configured.
+var DefaultRewardSchedule = RewardSchedule{InitialSubsidy: 5000, HalvingInterval: 100000}
+
+// Subsidy returns the newly minted amount allowed at height (the first block is height 1).
+func (r RewardSchedule) Subsidy(height int64) int64 {
+	if height < 1 || r.InitialSubsidy <= 0 {
+		return 0
+	}
+	if r.HalvingInterval <= 0 {
+		return r.InitialSubsidy
+	}
+	halvings := (height - 1) / r.HalvingInterval
+	if halvings >= maxHalvings {
+		return 0
+	}
+	return r.InitialSubsidy >> uint(halvings)
+}
+
+// NewCoinbase builds the reward transaction for the block at height, paying
+// value (subsidy plus fees) to miner. The height is carried in Nonce so every
+// coinbase has a distinct txid. A zero value produces a coinbase with no outputs.
+func NewCoinbase(chainID string, height int64, miner string, value int64, ts time.Time) *utxo.Transaction {
+	t := &utxo.Transaction{
+		Sender:          utxo.CoinbaseSender,
+		Receiver:        miner,
+		Amount:          value,
+		Note:            "coinbase",
+		Timestamp:       ts,
+		Inputs:          []string{},
+		Outputs:         []utxo.TxOutput{},
+		ClientTimestamp: ts.UTC().Format(time.RFC3339Nano),
+		ChainID:         chainID,
+		Nonce:           uint64(height),
+	}
+	if value > 0 {
+		t.Outputs = append(t.Outputs, utxo.TxOutput{Recipient: miner, Amount: value})
+	}
+	t.ID = t.ComputeID()
+	return t
+}
+
+// BlockFees sums the fees of every non-coinbase transaction in txs.
+func BlockFees(txs []utxo.Transaction) int64 {
+	var fees int64
+	for i := range txs {
+		if !txs[i].IsCoinbase() {
+			fees += txs[i].Fee
+		}
+	}
+	return fees
+}
@@ -0,0 +1,110 @@
+package blockchain
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+func TestSubsidyHalvings(t *testing.T) {
+	r := RewardSchedule{InitialSubsidy: 5000, HalvingInterval: 10}
+	tests := []struct {
+		height, want int64
+	}{
+		{0, 0},
+		{-1, 0},
+		{1, 5000},
+		{10, 5000}, // interval: the last block before the first halving
+		{11, 2500}, // interval+1
+		{20, 2500}, // 2·interval
+		{21, 1250}, // 2·interval+1
+		{121, 1},   // 12 halvings: 5000>>12
+		{131, 0},   // 13 halvings shift 5000 to zero
+		{631, 0},   // 63 halvings
+		{641, 0},   // beyond 63 halvings
+		{math.MaxInt64, 0},
+	}
+	for _, tt := range tests {
+		if got := r.Subsidy(tt.height); got != tt.want {
+			t.Errorf("Subsidy(%d) = %d, want %d", tt.height, got, tt.want)
+		}
+	}
+}
+
+// With the largest possible subsidy the shift must run all the way to zero
+// without wrapping around to a non-zero amount.
+func TestSubsidyShiftReachesZero(t *testing.T) {
+	r := RewardSchedule{InitialSubsidy: math.MaxInt64, HalvingInterval: 1}
+	tests := []struct {
+		height, want int64
+	}{
+		{1, math.MaxInt64},
+		{2, math.MaxInt64 >> 1},
+		{63, 1}, // 62 halvings
+		{64, 0}, // 63 halvings
+		{65, 0},
+		{200, 0},
+	}
+	for _, tt := range tests {
+		if got := r.Subsidy(tt.height); got != tt.want {
+			t.Errorf("Subsidy(%d) = %d, want %d", tt.height, got, tt.want)
+		}
+	}
+	for h := int64(2); h <= 200; h++ {
+		if s := r.Subsidy(h); s < 0 || s > r.Subsidy(h-1) {
+			t.Fatalf("Subsidy(%d) = %d after %d", h, s, r.Subsidy(h-1))
+		}
+	}
+}
+
+func TestSubsidyWithoutHalving(t *testing.T) {
+	for _, r := range []RewardSchedule{
+		{InitialSubsidy: 50, HalvingInterval: 0},
+		{InitialSubsidy: 50, HalvingInterval: -5},
+	} {
+		if got := r.Subsidy(1 << 40); got != 50 {
+			t.Errorf("%+v: Subsidy = %d, want 50", r, got)
+		}
+	}
+	if got := (RewardSchedule{InitialSubsidy: 0, HalvingInterval: 10}).Subsidy(1); got != 0 {
+		t.Errorf("zero initial subsidy: Subsidy = %d", got)
+	}
+}
+
+func TestNewCoinbase(t *testing.T) {
+	ts := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cb := NewCoinbase("chain", 7, "miner", 120, ts)
+	if !cb.IsCoinbase() {
+		t.Fatal("NewCoinbase did not build a coinbase")
+	}
+	if len(cb.Outputs) != 1 || cb.Outputs[0].Recipient != "miner" || cb.Outputs[0].Amount != 120 {
+		t.Errorf("outputs = %+v, want one of 120 to miner", cb.Outputs)
+	}
+	if cb.Nonce != 7 || cb.ChainID != "chain" {
+		t.Errorf("nonce %d chain %q, want 7 and chain", cb.Nonce, cb.ChainID)
+	}
+	if cb.ID != cb.ComputeID() {
+		t.Error("coinbase id does not match its encoding")
+	}
+	if other := NewCoinbase("chain", 8, "miner", 120, ts); other.ID == cb.ID {
+		t.Error("coinbases at different heights share a txid")
+	}
+	if empty := NewCoinbase("chain", 7, "miner", 0, ts); len(empty.Outputs) != 0 {
+		t.Errorf("zero-value coinbase has outputs %+v", empty.Outputs)
+	}
+}
+
+func TestBlockFees(t *testing.T) {
+	ts := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cb := NewCoinbase("chain", 1, "miner", 100, ts)
+	cb.Fee = 1000 // a coinbase never contributes fees
+	txs := []utxo.Transaction{*cb, {Fee: 3}, {Fee: 0}, {Fee: 7}}
+	if got := BlockFees(txs); got != 10 {
+		t.Errorf("BlockFees = %d, want 10", got)
+	}
+	if got := BlockFees(nil); got != 0 {
+		t.Errorf("BlockFees(nil) = %d, want 0", got)
+	}
+}
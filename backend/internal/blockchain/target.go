@@ -0,0 +1,244 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// Proof of work is a numeric target: a block is valid when its header hash,
+// read as a 256-bit big-endian integer, is at most the target. The target is
+// stored in the header in compact "bits" form: the high byte is a base-256
+// exponent and the low three bytes the mantissa, so
+// target = mantissa * 256^(exponent-3). Unlike a count of leading zero hex
+// digits, any target can be approximated to ~1 part in 65536.
+
+// DefaultPowLimit is the easiest target allowed: one leading zero hex digit.
+var DefaultPowLimit = DifficultyToBits(1)
+
+// CompactToBig expands compact bits into the target they encode.
+// Negative encodings (sign bit set) yield zero, which no hash can meet.
+func CompactToBig(bits uint32) *big.Int {
+	mantissa := int64(bits & 0x007fffff)
+	exponent := uint(bits >> 24)
+	if bits&0x00800000 != 0 {
+		return new(big.Int)
+	}
+	if exponent <= 3 {
+		return big.NewInt(mantissa >> (8 * (3 - exponent)))
+	}
+	t := big.NewInt(mantissa)
+	return t.Lsh(t, 8*(exponent-3))
+}
+
+// BigToCompact encodes target in compact form, rounding it down to the
+// 3 most significant bytes.
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+	exponent := uint((target.BitLen() + 7) / 8)
+	var mantissa uint32
+	if exponent <= 3 {
+		mantissa = uint32(target.Uint64() << (8 * (3 - exponent)))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, 8*(exponent-3)).Uint64())
+	}
+	// the mantissa's top bit is a sign bit: move it into the exponent instead
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	return uint32(exponent)<<24 | mantissa
+}
+
+// DifficultyToBits returns the target met by any hash with d leading zero
+// hex digits, i.e. 2^(256-4d).
+func DifficultyToBits(d int) uint32 {
+	if d < 0 {
+		d = 0
+	}
+	if d > 63 {
+		d = 63
+	}
+	return BigToCompact(new(big.Int).Lsh(big.NewInt(1), uint(256-4*d)))
+}
+
+// HashMeetsTarget reports whether the hex hash, as a 256-bit integer, is at most the target in bits.
+func HashMeetsTarget(hash string, bits uint32) bool {
+	h, ok := new(big.Int).SetString(hash, 16)
+	if !ok || len(hash) != 64 {
+		return false
+	}
+	return h.Cmp(CompactToBig(bits)) <= 0
+}
+
+// targetBytes returns the target as 32 big-endian bytes, so a miner can
+// compare raw digests against it without allocating.
+func targetBytes(bits uint32) [32]byte {
+	var out [32]byte
+	t := CompactToBig(bits)
+	if t.BitLen() > 256 {
+		for i := range out {
+			out[i] = 0xff
+		}
+		return out
+	}
+	t.FillBytes(out[:])
+	return out
+}
+
+// Work is the expected number of hashes needed to meet bits: 2^256 / (target+1).
+func Work(bits uint32) *big.Int {
+	t := CompactToBig(bits)
+	if t.Sign() <= 0 {
+		return new(big.Int)
+	}
+	n := new(big.Int).Lsh(big.NewInt(1), 256)
+	return n.Div(n, t.Add(t, big.NewInt(1)))
+}
+
+// TargetRules is the difficulty retargeting schedule.
+type TargetRules struct {
+	// InitialBits is the target of the first block.
+	InitialBits uint32
+	// PowLimit is the easiest target retargeting may reach.
+	PowLimit uint32
+	// RetargetInterval is how many blocks share a target; < 2 never retargets.
+	RetargetInterval int64
+	// TargetSpacing is the block time retargeting steers toward.
+	TargetSpacing time.Duration
+}
+
+// maxRetargetFactor bounds how far one retarget may move the target either way.
+const maxRetargetFactor = 4
+
+// NextBits returns the target required of the block at height. prev is the
+// block at height-1, nil for the first block. At the start of each retarget
+// window (heights 1+k*RetargetInterval) the target is scaled by how long the
+// previous window's blocks took versus TargetSpacing, at most 4x either way;
+// ancestor is then called for the first block of that window.
+func (r TargetRules) NextBits(height int64, prev *BlockHeader, ancestor func(height int64) (*BlockHeader, error)) (uint32, error) {
+	if prev == nil {
+		return r.InitialBits, nil
+	}
+	// windows start at height 1, after the genesis block, so the first retarget is at 1+RetargetInterval
+	if r.RetargetInterval < 2 || r.TargetSpacing <= 0 || height <= r.RetargetInterval || (height-1)%r.RetargetInterval != 0 {
+		return prev.Bits, nil
+	}
+	first, err := ancestor(height - r.RetargetInterval)
+	if err != nil {
+		return 0, fmt.Errorf("retarget at height %d: %w", height, err)
+	}
+	if first == nil {
+		return 0, errors.New("retarget: missing window start block")
+	}
+
+	expected := r.TargetSpacing * time.Duration(r.RetargetInterval-1)
+	if expected < maxRetargetFactor*time.Millisecond {
+		return prev.Bits, nil
+	}
+	actual := prev.Timestamp.Sub(first.Timestamp)
+	if actual < expected/maxRetargetFactor {
+		actual = expected / maxRetargetFactor
+	}
+	if actual > expected*maxRetargetFactor {
+		actual = expected * maxRetargetFactor
+	}
+	target := CompactToBig(prev.Bits)
+	target.Mul(target, big.NewInt(int64(actual/time.Millisecond)))
+	target.Div(target, big.NewInt(int64(expected/time.Millisecond)))
+	limit := r.PowLimit
+	if limit == 0 {
+		limit = DefaultPowLimit
+	}
+	if target.Cmp(CompactToBig(limit)) > 0 {
+		return limit, nil
+	}
+	return BigToCompact(target), nil
+}
+
+// ErrInvalidHeader wraps the rule a block header breaks.
+var ErrInvalidHeader = errors.New("invalid header")
+
+const (
+	// MedianTimeSpan is how many blocks, ending with the parent, the
+	// median-time-past of a block is taken over.
+	MedianTimeSpan = 11
+	// MaxFutureBlockTime is how far past the local clock a block's timestamp may be.
+	MaxFutureBlockTime = 2 * time.Hour
+)
+
+// MedianTimePast returns the median timestamp of prev and the blocks before
+// it, MedianTimeSpan in all (fewer at the start of the chain). ancestor
+// resolves earlier headers on prev's branch; a nil header ends the walk, as
+// at the start of the chain. A block's timestamp must be after its parent's
+// median-time-past, so no miner can move the chain's clock backwards by more
+// than a few blocks.
+func MedianTimePast(prev *BlockHeader, ancestor func(height int64) (*BlockHeader, error)) (time.Time, error) {
+	times := []time.Time{prev.Timestamp}
+	for height := prev.Index - 1; height >= 0 && height > prev.Index-MedianTimeSpan; height-- {
+		h, err := ancestor(height)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("median time past at height %d: %w", prev.Index, err)
+		}
+		if h == nil {
+			break
+		}
+		times = append(times, h.Timestamp)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times[len(times)/2], nil
+}
+
+// checkTimestamp checks that ts is after mtp, the parent's median-time-past,
+// and at most MaxFutureBlockTime ahead of now.
+func checkTimestamp(ts, mtp, now time.Time) error {
+	if !ts.After(mtp) {
+		return fmt.Errorf("timestamp %s not after median time past %s", ts.UTC().Format(time.RFC3339Nano), mtp.UTC().Format(time.RFC3339Nano))
+	}
+	if ts.After(now.Add(MaxFutureBlockTime)) {
+		return fmt.Errorf("timestamp %s more than %s in the future", ts.UTC().Format(time.RFC3339Nano), MaxFutureBlockTime)
+	}
+	return nil
+}
+
+// CheckHeader validates h as the child of prev: its version, its hash, linkage and height, a timestamp after prev's
+// median-time-past and at most MaxFutureBlockTime ahead of the local clock,
+// the target rules set at its height and a hash meeting it. ancestor resolves
+// earlier headers on the same branch for the median time and retargeting.
+// Broken rules wrap ErrInvalidHeader; any other error means ancestor failed.
+func CheckHeader(h, prev *BlockHeader, rules TargetRules, ancestor func(height int64) (*BlockHeader, error)) error {
+	if h.Version != BlockVersion {
+		return fmt.Errorf("%w: version %d, want %d", ErrInvalidHeader, h.Version, BlockVersion)
+	}
+	if h.ComputeHash() != h.Hash {
+		return fmt.Errorf("%w: hash does not match header", ErrInvalidHeader)
+	}
+	if h.PreviousHash != prev.Hash {
+		return fmt.Errorf("%w: block %d does not link to %s", ErrInvalidHeader, h.Index, prev.Hash)
+	}
+	if h.Index != prev.Index+1 {
+		return fmt.Errorf("%w: index %d does not follow parent %d", ErrInvalidHeader, h.Index, prev.Index)
+	}
+	mtp, err := MedianTimePast(prev, ancestor)
+	if err != nil {
+		return err
+	}
+	if err := checkTimestamp(h.Timestamp, mtp, time.Now()); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	want, err := rules.NextBits(h.Index, prev, ancestor)
+	if err != nil {
+		return err
+	}
+	if h.Bits != want {
+		return fmt.Errorf("%w: bits %08x, want %08x", ErrInvalidHeader, h.Bits, want)
+	}
+	if !HashMeetsTarget(h.Hash, h.Bits) {
+		return fmt.Errorf("%w: hash does not meet target %08x", ErrInvalidHeader, h.Bits)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRules retarget every 5 blocks toward one a minute. The targets are easy
+// enough to mine in a test, with room below PowLimit to ease by 4x.
+var testRules = TargetRules{
+	InitialBits:      0x2007ffff,
+	PowLimit:         0x207fffff,
+	RetargetInterval: 5,
+	TargetSpacing:    time.Minute,
+}
+
+// headerChain is a branch of headers from genesis, indexed by height.
+type headerChain []BlockHeader
+
+func (c headerChain) ancestor(height int64) (*BlockHeader, error) {
+	if height < 0 || height >= int64(len(c)) {
+		return nil, fmt.Errorf("header %d unknown", height)
+	}
+	return &c[height], nil
+}
+
+func (c headerChain) tip() *BlockHeader {
+	return &c[len(c)-1]
+}
+
+// child returns a mined header on the tip, timestamped ts, with the target
+// the rules set at its height.
+func (c headerChain) child(t *testing.T, ts time.Time) BlockHeader {
+	t.Helper()
+	prev := c.tip()
+	bits, err := testRules.NextBits(prev.Index+1, prev, c.ancestor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := BlockHeader{Version: BlockVersion, Index: prev.Index + 1, Timestamp: ts, PreviousHash: prev.Hash, MerkleRoot: "root", Bits: bits}
+	for ; ; h.Nonce++ {
+		if h.Hash = h.ComputeHash(); HashMeetsTarget(h.Hash, h.Bits) {
+			return h
+		}
+	}
+}
+
+// extend returns a copy of c with a child timestamped ts, unchecked.
+func (c headerChain) extend(t *testing.T, ts time.Time) headerChain {
+	t.Helper()
+	return append(c[:len(c):len(c)], c.child(t, ts))
+}
+
+// newHeaderChain returns genesis and n honest blocks a minute apart, the last
+// one timestamped end.
+func newHeaderChain(t *testing.T, n int, end time.Time) headerChain {
+	t.Helper()
+	start := end.Add(-time.Duration(n) * time.Minute)
+	g := BlockHeader{Version: BlockVersion, Timestamp: start, MerkleRoot: "root", Bits: testRules.InitialBits}
+	g.Hash = g.ComputeHash()
+	c := headerChain{g}
+	for i := 1; i <= n; i++ {
+		h := c.child(t, start.Add(time.Duration(i)*time.Minute))
+		if err := CheckHeader(&h, c.tip(), testRules, c.ancestor); err != nil {
+			t.Fatalf("honest header %d: %v", i, err)
+		}
+		c = append(c, h)
+	}
+	return c
+}
+
+func wantHeaderError(t *testing.T, err error, want string) {
+	t.Helper()
+	if !errors.Is(err, ErrInvalidHeader) || !strings.Contains(err.Error(), want) {
+		t.Errorf("err = %v, want an invalid header error containing %q", err, want)
+	}
+}
+
+func TestMedianTimePast(t *testing.T) {
+	c := newHeaderChain(t, 12, time.Now().Add(-time.Hour))
+	mtp, err := MedianTimePast(c.tip(), c.ancestor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// blocks 2..12, median is block 7
+	if !mtp.Equal(c[7].Timestamp) {
+		t.Errorf("median time past %s, want block 7's %s", mtp, c[7].Timestamp)
+	}
+	// the median, not the latest: one block far in the future does not move it
+	c[12].Timestamp = c[12].Timestamp.Add(24 * time.Hour)
+	if mtp2, _ := MedianTimePast(c.tip(), c.ancestor); !mtp2.Equal(mtp) {
+		t.Errorf("median time past %s with a future tip, want %s", mtp2, mtp)
+	}
+	// near genesis, fewer blocks
+	if mtp, _ := MedianTimePast(&c[2], c.ancestor); !mtp.Equal(c[1].Timestamp) {
+		t.Errorf("median of blocks 0..2 is %s, want block 1's %s", mtp, c[1].Timestamp)
+	}
+}
+
+func TestCheckHeaderTimestamps(t *testing.T) {
+	now := time.Now()
+	c := newHeaderChain(t, 11, now.Add(-30*time.Minute))
+	mtp, _ := MedianTimePast(c.tip(), c.ancestor)
+
+	tests := []struct {
+		name string
+		ts   time.Time
+		want string // empty: accepted
+	}{
+		{"at the median time past", mtp, "not after median time past"},
+		{"before the median time past", mtp.Add(-time.Hour), "not after median time past"},
+		{"just after the median time past, before the parent", mtp.Add(time.Millisecond), ""},
+		{"an hour and a half ahead", now.Add(90 * time.Minute), ""},
+		{"three hours ahead", now.Add(3 * time.Hour), "in the future"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := c.child(t, tt.ts)
+			err := CheckHeader(&h, c.tip(), testRules, c.ancestor)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("rejected: %v", err)
+				}
+				return
+			}
+			wantHeaderError(t, err, tt.want)
+		})
+	}
+}
+
+func TestCheckHeaderVersion(t *testing.T) {
+	c := newHeaderChain(t, 4, time.Now().Add(-time.Hour))
+	for _, version := range []int{0, 1, 2, 3, BlockVersion + 1} {
+		h := c.child(t, time.Now().Add(-time.Minute))
+		h.Version = version
+		for h.Nonce = 0; ; h.Nonce++ {
+			if h.Hash = h.ComputeHash(); HashMeetsTarget(h.Hash, h.Bits) {
+				break
+			}
+		}
+		wantHeaderError(t, CheckHeader(&h, c.tip(), testRules, c.ancestor), fmt.Sprintf("version %d, want %d", version, BlockVersion))
+	}
+}
+
+// A miner who sets the timestamps at both ends of a retarget window can make
+// it look as long as they like and ease the target. The median-time-past
+// rule pins the window's start, the future limit its end.
+func TestRetargetTimeWarp(t *testing.T) {
+	now := time.Now()
+	// genesis..10; the retarget at 16 measures the window 11..15
+	honest := newHeaderChain(t, 10, now.Add(-time.Hour))
+	base := honest.tip().Timestamp
+	window := func(c headerChain, first time.Time) headerChain {
+		c = c.extend(t, first)
+		for i := 1; i < 5; i++ {
+			c = c.extend(t, base.Add(time.Duration(1+i)*time.Minute))
+		}
+		return c
+	}
+	fair := window(honest, base.Add(time.Minute))
+	honestBits, err := testRules.NextBits(16, fair.tip(), fair.ancestor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("backdated window start", func(t *testing.T) {
+		first := honest[1].Timestamp.Add(-time.Hour)
+		warped := window(honest, first)
+		bits, err := testRules.NextBits(16, warped.tip(), warped.ancestor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if CompactToBig(bits).Cmp(CompactToBig(honestBits)) <= 0 {
+			t.Fatalf("backdating did not ease the target (%08x vs %08x); the test proves nothing", bits, honestBits)
+		}
+		// ...but the backdated block is not accepted
+		h := warped[11]
+		wantHeaderError(t, CheckHeader(&h, &honest[10], testRules, honest.ancestor), "not after median time past")
+	})
+
+	t.Run("future-dated window end", func(t *testing.T) {
+		prev := fair[:15:15]
+		h := prev.child(t, now.Add(3*time.Hour))
+		wantHeaderError(t, CheckHeader(&h, prev.tip(), testRules, prev.ancestor), "in the future")
+
+		// the furthest a window end may go eases the target at most 4x
+		h = prev.child(t, now.Add(MaxFutureBlockTime-time.Minute))
+		if err := CheckHeader(&h, prev.tip(), testRules, prev.ancestor); err != nil {
+			t.Fatal(err)
+		}
+		c := append(prev, h)
+		bits, err := testRules.NextBits(16, c.tip(), c.ancestor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		limit := new(big.Int).Mul(CompactToBig(c.tip().Bits), big.NewInt(maxRetargetFactor))
+		if CompactToBig(bits).Cmp(limit) > 0 {
+			t.Errorf("target %08x eased more than %dx from %08x", bits, maxRetargetFactor, c.tip().Bits)
+		}
+	})
+
+	t.Run("honest window keeps the target", func(t *testing.T) {
+		if ratio := new(big.Int).Div(CompactToBig(honestBits), CompactToBig(testRules.InitialBits)); ratio.Int64() != 1 {
+			t.Errorf("honest retarget moved the target %08x -> %08x", testRules.InitialBits, honestBits)
+		}
+	})
+}
@@ -0,0 +1,638 @@
+package blockchain
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/crypto"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+// errStopValidation is returned from ChainValidator.Check once a block has
+// failed, so callers streaming blocks can stop early.
+var errStopValidation = errors.New("chain validation stopped at invalid block")
+
+// ValidationParams configures a full-chain validation run.
+type ValidationParams struct {
+	// Target is the retargeting schedule every block's Bits must follow.
+	Target TargetRules
+	// ChainID is the network every transaction must be bound to.
+	ChainID string
+	// AuthorityKey is the public key that must sign admin funding and zakat
+	// deductions, which pay ZakatPool. Without it neither is valid in a
+	// block, and without ZakatPool zakat deductions are not.
+	AuthorityKey string
+	ZakatPool    string
+	// Rewards bounds the value each block's coinbase may claim.
+	Rewards RewardSchedule
+	// MaxBlockTxs and MaxBlockBytes limit block contents (coinbase included); 0 disables.
+	MaxBlockTxs   int
+	MaxBlockBytes int
+	// Genesis, if set, is the block the chain must start with at index 0. It
+	// is compared by hash only, and its outputs seed the UTXO set.
+	Genesis *Block
+	// Allocations are out-of-block funding transactions (admin funding) whose
+	// outputs seed the UTXO set before the first block is replayed.
+	Allocations []utxo.Transaction
+}
+
+// ValidationProblem describes one consensus rule violation.
+type ValidationProblem struct {
+	Height    int64  `json:"height"`
+	BlockHash string `json:"block_hash,omitempty"`
+	TxID      string `json:"tx_id,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+// ValidationReport is the result of ValidateChain.
+type ValidationReport struct {
+	OK                 bool                `json:"ok"`
+	BlocksChecked      int64               `json:"blocks_checked"`
+	TxsChecked         int                 `json:"txs_checked"`
+	FirstInvalidHeight *int64              `json:"first_invalid_height"`
+	TipHash            string              `json:"tip_hash"`
+	UTXOCount          int                 `json:"utxo_count"`
+	Problems           []ValidationProblem `json:"problems"`
+}
+
+// ChainValidator replays blocks from genesis, checking header linkage, proof
+// of work, Merkle roots, signatures and UTXO spends. Feed it blocks in
+// ascending height with Check; it stops accepting blocks after the first
+// invalid one since the UTXO state past that point is undefined.
+type ChainValidator struct {
+	params  ValidationParams
+	utxos   map[string]*utxo.UTXO
+	seenTxs map[string]bool
+	nonces  map[string]uint64 // last nonce per sender wallet
+	// confirmed is the height of each transaction with conditioned outputs,
+	// for relative timelocks
+	confirmed map[string]int64
+	prev      *Block
+	// recent holds the headers of the last retarget window, and at least the
+	// last MedianTimeSpan, by height
+	recent map[int64]BlockHeader
+	report ValidationReport
+	// locked counts the spends refused only for a timelock not reached yet
+	locked int
+}
+
+// NewChainValidator returns a validator with the UTXO set seeded from params.Allocations.
+func NewChainValidator(params ValidationParams) *ChainValidator {
+	v := &ChainValidator{
+		params:    params,
+		utxos:     map[string]*utxo.UTXO{},
+		seenTxs:   map[string]bool{},
+		nonces:    map[string]uint64{},
+		confirmed: map[string]int64{},
+		recent:    map[int64]BlockHeader{},
+		report:    ValidationReport{Problems: []ValidationProblem{}},
+	}
+	for i := range params.Allocations {
+		v.applyOutputs(&params.Allocations[i])
+	}
+	return v
+}
+
+// applyOutputs adds the outputs of an allocation or genesis transaction,
+// which count as confirmed at height 0.
+func (v *ChainValidator) applyOutputs(t *utxo.Transaction) {
+	for i, o := range t.Outputs {
+		id := t.OutputUTXOID(i)
+		v.utxos[id] = &utxo.UTXO{ID: id, TxID: t.ID, Index: i, WalletID: o.Recipient, Amount: o.Amount, Condition: o.Condition}
+	}
+	v.seenTxs[t.ID] = true
+	if t.HasConditions() {
+		v.confirmed[t.ID] = 0
+	}
+}
+
+// Check validates the next block. It returns a non-nil error once the chain is invalid.
+func (v *ChainValidator) Check(b *Block) error {
+	if v.report.FirstInvalidHeight != nil {
+		return errStopValidation
+	}
+	var problems []ValidationProblem
+	fail := func(txID, reason string) {
+		problems = append(problems, ValidationProblem{Height: b.Index, BlockHash: b.Hash, TxID: txID, Reason: reason})
+	}
+
+	if v.prev == nil && v.params.Genesis != nil {
+		return v.checkGenesis(b)
+	}
+
+	// header linkage
+	if v.prev == nil {
+		if b.Index != 1 {
+			fail("", fmt.Sprintf("first block has index %d, want 1", b.Index))
+		}
+		if b.PreviousHash != "" {
+			fail("", "first block has non-empty previous_hash")
+		}
+	} else {
+		if b.Index != v.prev.Index+1 {
+			fail("", fmt.Sprintf("index %d does not follow %d", b.Index, v.prev.Index))
+		}
+		if b.PreviousHash != v.prev.Hash {
+			fail("", "previous_hash does not match hash of previous block")
+		}
+	}
+
+	if b.Version != BlockVersion {
+		fail("", fmt.Sprintf("version %d, want %d", b.Version, BlockVersion))
+	}
+
+	// spending conditions' after_time is checked against this, not b's own timestamp
+	var mtp time.Time
+	if v.prev != nil {
+		prev := v.prev.Header()
+		var err error
+		mtp, err = MedianTimePast(&prev, func(height int64) (*BlockHeader, error) {
+			if h, ok := v.recent[height]; ok {
+				return &h, nil
+			}
+			// before the first block checked
+			return nil, nil
+		})
+		if err != nil {
+			fail("", err.Error())
+		} else if err := checkTimestamp(b.Timestamp, mtp, time.Now()); err != nil {
+			fail("", err.Error())
+		}
+	}
+
+	// header hash and proof of work
+	if h := b.ComputeHash(); h != b.Hash {
+		fail("", "stored hash does not match recomputed header hash")
+	}
+	for _, p := range v.checkTarget(b) {
+		fail("", p)
+	}
+	if !b.VerifyMerkleRoot() {
+		fail("", "merkle root does not match transactions")
+	}
+	if !b.VerifyWitnessRoot() {
+		fail("", "witness root does not match transactions")
+	}
+	if limit := v.params.MaxBlockTxs; limit > 0 && len(b.Transactions) > limit {
+		fail("", fmt.Sprintf("block has %d transactions, limit %d", len(b.Transactions), limit))
+	}
+	if limit := v.params.MaxBlockBytes; limit > 0 {
+		size := 0
+		for i := range b.Transactions {
+			size += b.Transactions[i].Size()
+		}
+		if size > limit {
+			fail("", fmt.Sprintf("block transactions total %d bytes, limit %d", size, limit))
+		}
+	}
+
+	// replay transactions against a scratch copy so a bad block leaves the set untouched
+	spentInBlock := map[string]bool{}
+	created := map[string]*utxo.UTXO{}
+	nonces := map[string]uint64{}
+	lookup := func(id string) (*utxo.UTXO, bool) {
+		if u, ok := created[id]; ok {
+			return u, true
+		}
+		u, ok := v.utxos[id]
+		return u, ok
+	}
+	// transactions with conditioned outputs in this block are confirmed at its height
+	conditioned := map[string]bool{}
+	at := SpendPoint{Height: b.Index, Time: mtp, Confirmed: func(txID string) (int64, bool) {
+		if conditioned[txID] {
+			return b.Index, true
+		}
+		h, ok := v.confirmed[txID]
+		return h, ok
+	}}
+	var fees int64
+	for i := range b.Transactions {
+		t := &b.Transactions[i]
+		if v.seenTxs[t.ID] {
+			fail(t.ID, "duplicate transaction id")
+			continue
+		}
+		if t.IsCoinbase() {
+			// checked below once the block's fees are known
+			if i != 0 {
+				fail(t.ID, "coinbase not at index 0")
+			}
+		} else {
+			for _, p := range v.checkTx(t, lookup, spentInBlock, nonces, at) {
+				fail(t.ID, p)
+			}
+			if t.Fee > math.MaxInt64-fees {
+				fail(t.ID, "block fees overflow")
+			} else {
+				fees += t.Fee
+			}
+		}
+		for j, o := range t.Outputs {
+			id := t.OutputUTXOID(j)
+			created[id] = &utxo.UTXO{ID: id, TxID: t.ID, Index: j, WalletID: o.Recipient, Amount: o.Amount, Condition: o.Condition}
+		}
+		if t.HasConditions() {
+			conditioned[t.ID] = true
+		}
+	}
+
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		fail("", "block has no coinbase at index 0")
+	} else {
+		cb := &b.Transactions[0]
+		for _, p := range v.checkCoinbase(cb, b.Index, fees) {
+			fail(cb.ID, p)
+		}
+	}
+
+	v.report.TxsChecked += len(b.Transactions)
+	if len(problems) > 0 {
+		h := b.Index
+		v.report.FirstInvalidHeight = &h
+		v.report.Problems = append(v.report.Problems, problems...)
+		return errStopValidation
+	}
+
+	for id := range spentInBlock {
+		delete(v.utxos, id)
+	}
+	for sender, n := range nonces {
+		v.nonces[sender] = n
+	}
+	for id := range conditioned {
+		v.confirmed[id] = b.Index
+	}
+	for id, u := range created {
+		if !spentInBlock[id] {
+			v.utxos[id] = u
+		}
+	}
+	for i := range b.Transactions {
+		v.seenTxs[b.Transactions[i].ID] = true
+	}
+	v.report.BlocksChecked++
+	v.report.TipHash = b.Hash
+	v.prev = b
+	v.recent[b.Index] = b.Header()
+	keep := v.params.Target.RetargetInterval
+	if keep < MedianTimeSpan-1 {
+		keep = MedianTimeSpan - 1
+	}
+	delete(v.recent, b.Index-keep-1)
+	return nil
+}
+
+// checkGenesis accepts b only if it is exactly the expected genesis block.
+func (v *ChainValidator) checkGenesis(b *Block) error {
+	want := v.params.Genesis
+	reason := ""
+	switch {
+	case b.Index != 0:
+		reason = fmt.Sprintf("chain starts at index %d, want genesis at 0", b.Index)
+	case b.Hash != want.Hash || b.ComputeHash() != want.Hash:
+		reason = fmt.Sprintf("genesis hash %s, want %s", b.Hash, want.Hash)
+	case !b.VerifyMerkleRoot():
+		reason = "merkle root does not match transactions"
+	case !b.VerifyWitnessRoot():
+		reason = "witness root does not match transactions"
+	}
+	for i := range b.Transactions {
+		if reason == "" && b.Transactions[i].ComputeID() != b.Transactions[i].ID {
+			reason = "genesis txid does not match canonical encoding"
+		}
+	}
+	if reason != "" {
+		h := b.Index
+		v.report.FirstInvalidHeight = &h
+		v.report.Problems = append(v.report.Problems, ValidationProblem{Height: b.Index, BlockHash: b.Hash, Reason: reason})
+		return errStopValidation
+	}
+	for i := range b.Transactions {
+		v.applyOutputs(&b.Transactions[i])
+	}
+	v.report.TxsChecked += len(b.Transactions)
+	v.report.BlocksChecked++
+	v.report.TipHash = b.Hash
+	v.prev = b
+	v.recent[b.Index] = b.Header()
+	return nil
+}
+
+// checkTarget checks proof of work: a block must carry exactly the target
+// the retarget schedule gives for its height, and meet it.
+func (v *ChainValidator) checkTarget(b *Block) []string {
+	var problems []string
+	var prev *BlockHeader
+	if v.prev != nil {
+		h := v.prev.Header()
+		prev = &h
+	}
+	want, err := v.params.Target.NextBits(b.Index, prev, func(height int64) (*BlockHeader, error) {
+		h, ok := v.recent[height]
+		if !ok {
+			return nil, fmt.Errorf("block %d not seen", height)
+		}
+		return &h, nil
+	})
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	if b.Bits != want {
+		problems = append(problems, fmt.Sprintf("bits %08x, want %08x at height %d", b.Bits, want, b.Index))
+	}
+	if !HashMeetsTarget(b.Hash, b.Bits) {
+		problems = append(problems, fmt.Sprintf("hash does not meet target %08x", b.Bits))
+	}
+	return problems
+}
+
+// checkTx validates a single transaction's inputs, value, replay protection and
+// signature, with spending conditions checked at the point at. Spent inputs
+// and consumed nonces are recorded in spent and nonces so double-spends and
+// replays within a block are caught.
+func (v *ChainValidator) checkTx(t *utxo.Transaction, lookup func(string) (*utxo.UTXO, bool), spent map[string]bool, nonces map[string]uint64, at SpendPoint) []string {
+	var problems []string
+	if len(t.Inputs) == 0 {
+		if t.Sender == utxo.SystemSender && t.SenderPublicKey == "" {
+			return v.checkFunding(t)
+		}
+		return append(problems, "transaction has no inputs")
+	}
+	if t.ComputeID() != t.ID {
+		problems = append(problems, "txid does not match canonical encoding")
+	}
+	if t.ChainID != v.params.ChainID {
+		problems = append(problems, fmt.Sprintf("chain id %q, want %q", t.ChainID, v.params.ChainID))
+	}
+
+	var totalIn int64
+	inputs := make([]*utxo.UTXO, 0, len(t.Inputs))
+	for _, id := range t.Inputs {
+		if spent[id] {
+			problems = append(problems, "input spent twice: "+id)
+			continue
+		}
+		u, ok := lookup(id)
+		if !ok {
+			problems = append(problems, "input does not exist or is already spent: "+id)
+			continue
+		}
+		inputs = append(inputs, u)
+		spent[id] = true
+		totalIn += u.Amount
+	}
+	spends, locked := checkSpends(t, inputs, at)
+	problems = append(problems, spends...)
+	v.locked += locked
+	totalOut, err := utxo.SumOutputs(t.Outputs)
+	switch {
+	case err != nil:
+		problems = append(problems, err.Error())
+	case totalOut > totalIn:
+		problems = append(problems, fmt.Sprintf("outputs (%d) exceed inputs (%d)", totalOut, totalIn))
+	// receiver/amount are unsigned summary fields: pin them to the outputs
+	case t.Receiver != t.Outputs[0].Recipient || t.Amount <= 0 || t.Amount > totalOut:
+		problems = append(problems, "receiver/amount do not match outputs")
+	}
+	if err == nil && totalOut <= totalIn && t.Fee != totalIn-totalOut {
+		problems = append(problems, fmt.Sprintf("fee %d does not equal inputs minus outputs (%d)", t.Fee, totalIn-totalOut))
+	}
+
+	// zakat deductions are authorized by the network authority, not the sender
+	if t.Note == utxo.ZakatNote && t.SenderPublicKey == "" {
+		return append(problems, v.checkZakat(t, inputs, totalIn)...)
+	}
+	if crypto.WalletIDFromPublicKey(t.SenderPublicKey) != t.Sender {
+		return append(problems, "sender public key does not match sender wallet")
+	}
+	last, ok := nonces[t.Sender]
+	if !ok {
+		last = v.nonces[t.Sender]
+	}
+	// strictly increasing rather than contiguous: txs evicted from the mempool leave gaps
+	if t.Nonce <= last {
+		problems = append(problems, fmt.Sprintf("nonce %d not above previous %d (replay)", t.Nonce, last))
+	}
+	nonces[t.Sender] = t.Nonce
+	if t.IsMultisig() {
+		return append(problems, checkMultisig(t)...)
+	}
+	if len(t.Signatures) > 0 {
+		problems = append(problems, "co-signatures on a single-key transaction")
+	}
+	ok, err = crypto.VerifyEd25519Signature(t.SenderPublicKey, t.SigningBytes(), base64.StdEncoding.EncodeToString(t.Signature))
+	if err != nil || !ok {
+		problems = append(problems, "invalid signature")
+	}
+	return problems
+}
+
+// checkZakat checks a zakat deduction. No wallet signs one, so the network
+// authority must, and its shape is fixed: it spends only the sender's own
+// unconditioned outputs, its first output pays the zakat pool 2.5% of them,
+// rounded down, and its only other output returns the rest to the sender.
+func (v *ChainValidator) checkZakat(t *utxo.Transaction, inputs []*utxo.UTXO, totalIn int64) []string {
+	var problems []string
+	if v.params.AuthorityKey == "" || v.params.ZakatPool == "" {
+		return append(problems, "zakat deductions are not enabled on this network")
+	}
+	ok, err := crypto.VerifyEd25519Signature(v.params.AuthorityKey, t.SigningBytes(), base64.StdEncoding.EncodeToString(t.Signature))
+	if err != nil || !ok {
+		problems = append(problems, "zakat deduction is not signed by the network authority")
+	}
+	if len(t.Signatures) > 0 {
+		problems = append(problems, "co-signatures on a zakat deduction")
+	}
+	for _, u := range inputs {
+		if u.WalletID != t.Sender || u.Condition != nil {
+			problems = append(problems, "zakat deduction spends "+u.ID+", not an unconditioned output of the sender")
+		}
+	}
+	due := utxo.ZakatDue(totalIn)
+	outs := t.Outputs
+	switch {
+	case due <= 0:
+		problems = append(problems, fmt.Sprintf("inputs of %d owe no zakat", totalIn))
+	case len(outs) == 0 || len(outs) > 2:
+		problems = append(problems, "zakat deduction must have the zakat output and at most one change output")
+	case outs[0].Recipient != v.params.ZakatPool || outs[0].Amount != due || outs[0].Condition != nil:
+		problems = append(problems, fmt.Sprintf("zakat output must pay %d to the zakat pool", due))
+	case len(outs) == 1 && totalIn != due,
+		len(outs) == 2 && (outs[1].Recipient != t.Sender || outs[1].Amount != totalIn-due || outs[1].Condition != nil):
+		problems = append(problems, fmt.Sprintf("zakat change must return %d to the sender", totalIn-due))
+	}
+	return problems
+}
+
+// checkFunding checks an admin funding transaction: new coins with no
+// inputs, so only the network authority may sign one, and it pays no fee.
+func (v *ChainValidator) checkFunding(t *utxo.Transaction) []string {
+	var problems []string
+	if v.params.AuthorityKey == "" {
+		return append(problems, "funding transactions are not enabled on this network")
+	}
+	if t.ComputeID() != t.ID {
+		problems = append(problems, "txid does not match canonical encoding")
+	}
+	if t.ChainID != v.params.ChainID {
+		problems = append(problems, fmt.Sprintf("chain id %q, want %q", t.ChainID, v.params.ChainID))
+	}
+	ok, err := crypto.VerifyEd25519Signature(v.params.AuthorityKey, t.SigningBytes(), base64.StdEncoding.EncodeToString(t.Signature))
+	if err != nil || !ok {
+		problems = append(problems, "funding transaction is not signed by the network authority")
+	}
+	if len(t.Signatures) > 0 {
+		problems = append(problems, "co-signatures on a funding transaction")
+	}
+	total, err := utxo.SumOutputs(t.Outputs)
+	switch {
+	case err != nil:
+		problems = append(problems, err.Error())
+	case t.Receiver != t.Outputs[0].Recipient || t.Amount <= 0 || t.Amount > total:
+		problems = append(problems, "receiver/amount do not match outputs")
+	}
+	if t.Fee != 0 {
+		problems = append(problems, fmt.Sprintf("funding transaction pays fee %d", t.Fee))
+	}
+	return problems
+}
+
+// checkMultisig checks that a multisig wallet's transaction carries valid
+// signatures by at least its policy's threshold of distinct keys.
+func checkMultisig(t *utxo.Transaction) []string {
+	var problems []string
+	if len(t.Signature) > 0 {
+		problems = append(problems, "single signature on a multisig transaction")
+	}
+	valid, p, err := t.CountSignatures()
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	// bounds the work (and block space) a transaction can ask for
+	if len(t.Signatures) > len(p.PublicKeys) {
+		problems = append(problems, fmt.Sprintf("%d co-signatures for %d keys", len(t.Signatures), len(p.PublicKeys)))
+	}
+	if valid < p.Threshold {
+		problems = append(problems, fmt.Sprintf("multisig needs %d valid signatures from distinct keys, has %d", p.Threshold, valid))
+	}
+	return problems
+}
+
+// CheckTx validates a transaction that is not yet in a block, such as one
+// relayed by a peer, under the transaction rules of params (ChainID,
+// AuthorityKey and ZakatPool). lookup resolves unspent outputs, lastNonce is
+// the sender's last accepted nonce and at is where the transaction would be
+// mined. It returns the rules t breaks, if any; locked reports that every one
+// of them is a timelock not reached yet, so t may be valid in a later block.
+func CheckTx(t *utxo.Transaction, params ValidationParams, lookup func(string) (*utxo.UTXO, bool), lastNonce uint64, at SpendPoint) (problems []string, locked bool) {
+	v := &ChainValidator{params: params}
+	problems = v.checkTx(t, lookup, map[string]bool{}, map[string]uint64{t.Sender: lastNonce}, at)
+	return problems, len(problems) > 0 && v.locked == len(problems)
+}
+
+// SpendPoint is where spending conditions are checked: the height and
+// timestamp of the block a spend is in (for one not yet mined, the next
+// height and the current time), and the heights of the blocks holding the
+// transactions it spends from.
+type SpendPoint struct {
+	Height int64
+	// Time is the median time past of the blocks before Height.
+	Time time.Time
+	// Confirmed returns the height of the block holding a transaction, and
+	// false if it is not in the chain.
+	Confirmed func(txID string) (int64, bool)
+}
+
+// CheckSpends checks that t may spend inputs, its resolved inputs: each must
+// be the sender's own output, or have a spending condition that t meets at
+// the point at. It returns the rules t breaks, if any.
+func CheckSpends(t *utxo.Transaction, inputs []*utxo.UTXO, at SpendPoint) []string {
+	problems, _ := checkSpends(t, inputs, at)
+	return problems
+}
+
+// checkSpends is CheckSpends, also counting the inputs refused only for a
+// timelock not reached yet (utxo.ErrLocked).
+func checkSpends(t *utxo.Transaction, inputs []*utxo.UTXO, at SpendPoint) (problems []string, locked int) {
+	hashes, err := t.PreimageHashes()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, u := range inputs {
+		ctx := &utxo.SpendContext{Sender: t.Sender, Height: at.Height, Time: at.Time, Hashes: hashes}
+		if u.Condition != nil && at.Confirmed != nil {
+			ctx.ConfirmedAt, ctx.Confirmed = at.Confirmed(u.TxID)
+		}
+		if err := utxo.CheckSpend(u, ctx); err != nil {
+			problems = append(problems, fmt.Sprintf("input %s: %v", u.ID, err))
+			if errors.Is(err, utxo.ErrLocked) {
+				locked++
+			}
+		}
+	}
+	return problems, locked
+}
+
+// checkCoinbase validates the block reward: no inputs, bound to this chain and
+// height, and paying at most the subsidy for height plus the block's fees.
+func (v *ChainValidator) checkCoinbase(t *utxo.Transaction, height, fees int64) []string {
+	var problems []string
+	if len(t.Inputs) != 0 {
+		problems = append(problems, "coinbase has inputs")
+	}
+	if t.ComputeID() != t.ID {
+		problems = append(problems, "txid does not match canonical encoding")
+	}
+	if t.ChainID != v.params.ChainID {
+		problems = append(problems, fmt.Sprintf("chain id %q, want %q", t.ChainID, v.params.ChainID))
+	}
+	if t.Nonce != uint64(height) {
+		problems = append(problems, fmt.Sprintf("coinbase height %d, want %d", t.Nonce, height))
+	}
+	var total int64
+	if len(t.Outputs) > 0 {
+		var err error
+		if total, err = utxo.SumOutputs(t.Outputs); err != nil {
+			return append(problems, err.Error())
+		}
+	}
+	allowed := v.params.Rewards.Subsidy(height)
+	if fees > math.MaxInt64-allowed {
+		allowed = math.MaxInt64
+	} else {
+		allowed += fees
+	}
+	if total > allowed {
+		problems = append(problems, fmt.Sprintf("coinbase pays %d, allowed %d (subsidy + fees)", total, allowed))
+	}
+	return problems
+}
+
+// Report returns the validation result so far.
+func (v *ChainValidator) Report() *ValidationReport {
+	r := v.report
+	r.OK = r.FirstInvalidHeight == nil
+	r.UTXOCount = len(v.utxos)
+	return &r
+}
+
+// UTXOs returns the UTXO set rebuilt from the blocks checked so far.
+func (v *ChainValidator) UTXOs() map[string]*utxo.UTXO {
+	return v.utxos
+}
+
+// ValidateChain streams every block through a ChainValidator. each must call
+// its callback once per block in ascending height and stop when it returns an
+// error. Errors from the block source itself are returned alongside the report.
+func ValidateChain(each func(func(*Block) error) error, params ValidationParams) (*ValidationReport, error) {
+	v := NewChainValidator(params)
+	err := each(v.Check)
+	if errors.Is(err, errStopValidation) {
+		err = nil
+	}
+	return v.Report(), err
+}
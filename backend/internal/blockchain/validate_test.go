@@ -0,0 +1,540 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/crypto"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+const testChainID = "dwallet-test"
+
+var testStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testKey is a deterministic wallet key, named for readable failures.
+type testKey struct {
+	priv ed25519.PrivateKey
+	pub  string
+	id   string
+}
+
+func newTestKey(name string) testKey {
+	seed := sha256.Sum256([]byte(name))
+	priv := ed25519.NewKeyFromSeed(seed[:])
+	pub := base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey))
+	return testKey{priv: priv, pub: pub, id: crypto.WalletIDFromPublicKey(pub)}
+}
+
+var (
+	alice     = newTestKey("alice")
+	bob       = newTestKey("bob")
+	mallory   = newTestKey("mallory")
+	authority = newTestKey("authority")
+	zakatPool = newTestKey("zakat pool")
+)
+
+// testFunding gives alice two outputs of 1000 before the first block.
+func testFunding() utxo.Transaction {
+	t := utxo.Transaction{
+		Sender:    utxo.SystemSender,
+		Receiver:  alice.id,
+		Amount:    2000,
+		Timestamp: testStart,
+		Outputs:   []utxo.TxOutput{{Recipient: alice.id, Amount: 1000}, {Recipient: alice.id, Amount: 1000}},
+		ChainID:   testChainID,
+	}
+	t.ID = t.ComputeID()
+	return t
+}
+
+// anyHash is a target of 2^256, which every hash meets, so test blocks need no mining.
+var anyHash = DifficultyToBits(0)
+
+func testParams() ValidationParams {
+	return ValidationParams{
+		ChainID:      testChainID,
+		AuthorityKey: authority.pub,
+		ZakatPool:    zakatPool.id,
+		Rewards:      DefaultRewardSchedule,
+		Target:       TargetRules{InitialBits: anyHash},
+		Allocations:  []utxo.Transaction{testFunding()},
+	}
+}
+
+// transfer builds a transaction spending inputs, paying outs and leaving the
+// rest as fee, signed by from.
+func transfer(from testKey, nonce uint64, inputs []string, in int64, outs ...utxo.TxOutput) utxo.Transaction {
+	t := utxo.Transaction{
+		Sender:          from.id,
+		Receiver:        outs[0].Recipient,
+		Amount:          outs[0].Amount,
+		Timestamp:       testStart,
+		Inputs:          inputs,
+		Outputs:         outs,
+		ClientTimestamp: testStart.Format(time.RFC3339Nano),
+		ChainID:         testChainID,
+		Nonce:           nonce,
+	}
+	var out int64
+	for _, o := range outs {
+		out += o.Amount
+	}
+	t.Fee = in - out
+	t.Sign(from.priv)
+	return t
+}
+
+// testBlock builds a block on prev, with a target any hash meets, holding a
+// coinbase of reward and txs.
+func testBlock(prev *Block, reward int64, txs ...utxo.Transaction) *Block {
+	index, prevHash := int64(1), ""
+	if prev != nil {
+		index, prevHash = prev.Index+1, prev.Hash
+	}
+	ts := testStart.Add(time.Duration(index) * time.Minute)
+	cb := NewCoinbase(testChainID, index, bob.id, reward, ts)
+	b := &Block{
+		Version:      BlockVersion,
+		Index:        index,
+		Timestamp:    ts,
+		Bits:         anyHash,
+		Transactions: append([]utxo.Transaction{*cb}, txs...),
+		PreviousHash: prevHash,
+	}
+	b.MerkleRoot = ComputeMerkleRoot(b.TxIDs())
+	b.WitnessRoot = b.ComputeWitnessRoot()
+	b.Hash = b.ComputeHash()
+	return b
+}
+
+// checkProblems runs b through v and returns the reasons it was rejected.
+func checkProblems(v *ChainValidator, b *Block) []string {
+	before := len(v.report.Problems)
+	if err := v.Check(b); err == nil {
+		return nil
+	}
+	var reasons []string
+	for _, p := range v.report.Problems[before:] {
+		reasons = append(reasons, p.Reason)
+	}
+	return reasons
+}
+
+func wantRejected(t *testing.T, reasons []string, want string) {
+	t.Helper()
+	for _, r := range reasons {
+		if strings.Contains(r, want) {
+			return
+		}
+	}
+	t.Errorf("want a problem containing %q, got %q", want, reasons)
+}
+
+func TestValidatorAcceptsSignedSpend(t *testing.T) {
+	v := NewChainValidator(testParams())
+	f := testFunding()
+	tx := transfer(alice, 1, []string{f.OutputUTXOID(0)}, 1000,
+		utxo.TxOutput{Recipient: bob.id, Amount: 600}, utxo.TxOutput{Recipient: alice.id, Amount: 390})
+	b := testBlock(nil, DefaultRewardSchedule.Subsidy(1)+10, tx)
+	if reasons := checkProblems(v, b); reasons != nil {
+		t.Fatalf("valid block rejected: %q", reasons)
+	}
+	if _, ok := v.UTXOs()[tx.OutputUTXOID(0)]; !ok {
+		t.Error("payment output missing from the UTXO set")
+	}
+	if _, ok := v.UTXOs()[f.OutputUTXOID(0)]; ok {
+		t.Error("spent funding output still in the UTXO set")
+	}
+}
+
+func TestValidatorRejects(t *testing.T) {
+	f := testFunding()
+	in0, in1 := f.OutputUTXOID(0), f.OutputUTXOID(1)
+	pay := func(nonce uint64, input string) utxo.Transaction {
+		return transfer(alice, nonce, []string{input}, 1000, utxo.TxOutput{Recipient: bob.id, Amount: 1000})
+	}
+	subsidy := DefaultRewardSchedule.Subsidy(1)
+
+	tests := []struct {
+		name  string
+		block func() *Block
+		want  string
+	}{
+		{"bad signature", func() *Block {
+			tx := pay(1, in0)
+			tx.Signature[0] ^= 0xff
+			return testBlock(nil, subsidy, tx)
+		}, "invalid signature"},
+		{"signed by another key", func() *Block {
+			tx := pay(1, in0)
+			tx.Sign(mallory.priv)
+			return testBlock(nil, subsidy, tx)
+		}, "sender public key does not match sender wallet"},
+		{"spend of another wallet's output", func() *Block {
+			tx := transfer(mallory, 1, []string{in0}, 1000, utxo.TxOutput{Recipient: mallory.id, Amount: 1000})
+			return testBlock(nil, subsidy, tx)
+		}, "does not belong to sender"},
+		{"double spend in one block", func() *Block {
+			return testBlock(nil, subsidy, pay(1, in0), pay(2, in0))
+		}, "input spent twice"},
+		{"wrong chain", func() *Block {
+			tx := pay(1, in0)
+			tx.ChainID = "other-chain"
+			tx.Sign(alice.priv)
+			return testBlock(nil, subsidy, tx)
+		}, `chain id "other-chain"`},
+		{"repeated nonce", func() *Block {
+			return testBlock(nil, subsidy, pay(1, in0), pay(1, in1))
+		}, "nonce 1 not above previous 1"},
+		{"decreasing nonce", func() *Block {
+			return testBlock(nil, subsidy, pay(2, in0), pay(1, in1))
+		}, "nonce 1 not above previous 2"},
+		{"coinbase above subsidy and fees", func() *Block {
+			return testBlock(nil, subsidy+1, pay(1, in0))
+		}, "coinbase pays"},
+		{"coinbase above subsidy and fees by one", func() *Block {
+			tx := transfer(alice, 1, []string{in0}, 1000, utxo.TxOutput{Recipient: bob.id, Amount: 990})
+			return testBlock(nil, subsidy+10+1, tx)
+		}, "coinbase pays"},
+		{"fee not claimed by inputs minus outputs", func() *Block {
+			tx := pay(1, in0)
+			tx.Fee = 5
+			tx.Sign(alice.priv)
+			return testBlock(nil, subsidy, tx)
+		}, "fee 5 does not equal"},
+		{"outputs above inputs", func() *Block {
+			tx := transfer(alice, 1, []string{in0}, 1000, utxo.TxOutput{Recipient: bob.id, Amount: 1001})
+			return testBlock(nil, subsidy, tx)
+		}, "outputs (1001) exceed inputs (1000)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewChainValidator(testParams())
+			wantRejected(t, checkProblems(v, tt.block()), tt.want)
+			if v.Report().OK {
+				t.Error("report OK after a rejected block")
+			}
+		})
+	}
+}
+
+func TestValidatorRejectsSpendAcrossBlocks(t *testing.T) {
+	v := NewChainValidator(testParams())
+	f := testFunding()
+	subsidy := DefaultRewardSchedule.Subsidy(1)
+	first := transfer(alice, 1, []string{f.OutputUTXOID(0)}, 1000, utxo.TxOutput{Recipient: bob.id, Amount: 1000})
+	b1 := testBlock(nil, subsidy, first)
+	if reasons := checkProblems(v, b1); reasons != nil {
+		t.Fatalf("block 1 rejected: %q", reasons)
+	}
+
+	// the same output again, and a replay of the first transaction's nonce
+	again := transfer(alice, 2, []string{f.OutputUTXOID(0)}, 1000, utxo.TxOutput{Recipient: mallory.id, Amount: 1000})
+	wantRejected(t, checkProblems(validatorAt(t, b1), testBlock(b1, subsidy, again)), "does not exist or is already spent")
+	replay := transfer(alice, 1, []string{f.OutputUTXOID(1)}, 1000, utxo.TxOutput{Recipient: mallory.id, Amount: 1000})
+	wantRejected(t, checkProblems(validatorAt(t, b1), testBlock(b1, subsidy, replay)), "nonce 1 not above previous 1")
+	wantRejected(t, checkProblems(v, testBlock(b1, subsidy, first)), "duplicate transaction id")
+}
+
+// validatorAt returns a validator that has accepted blocks.
+func validatorAt(t *testing.T, blocks ...*Block) *ChainValidator {
+	t.Helper()
+	v := NewChainValidator(testParams())
+	for _, b := range blocks {
+		if reasons := checkProblems(v, b); reasons != nil {
+			t.Fatalf("block %d rejected: %q", b.Index, reasons)
+		}
+	}
+	return v
+}
+
+// zakatTx builds a zakat deduction of alice's funding outputs, as the node
+// does, and signs it with signer unless signer is nil.
+func zakatTx(signer ed25519.PrivateKey, outs ...utxo.TxOutput) utxo.Transaction {
+	f := testFunding()
+	t := utxo.Transaction{
+		Sender:          alice.id,
+		Receiver:        outs[0].Recipient,
+		Amount:          outs[0].Amount,
+		Note:            utxo.ZakatNote,
+		Timestamp:       testStart,
+		Inputs:          []string{f.OutputUTXOID(0), f.OutputUTXOID(1)},
+		Outputs:         outs,
+		ClientTimestamp: testStart.Format(time.RFC3339Nano),
+		ChainID:         testChainID,
+	}
+	t.ID = t.ComputeID()
+	if signer != nil {
+		t.Signature = ed25519.Sign(signer, t.SigningBytes())
+	}
+	return t
+}
+
+func TestValidatorZakat(t *testing.T) {
+	subsidy := DefaultRewardSchedule.Subsidy(1)
+	due := utxo.ZakatDue(2000)
+	if due != 50 {
+		t.Fatalf("ZakatDue(2000) = %d, want 50", due)
+	}
+	pool := utxo.TxOutput{Recipient: zakatPool.id, Amount: due}
+	change := utxo.TxOutput{Recipient: alice.id, Amount: 2000 - due}
+
+	tests := []struct {
+		name string
+		tx   utxo.Transaction
+		want string // empty: accepted
+	}{
+		{"authority-signed deduction", zakatTx(authority.priv, pool, change), ""},
+		// anyone could once move any wallet's outputs by calling them zakat
+		{"unsigned theft", zakatTx(nil, utxo.TxOutput{Recipient: mallory.id, Amount: 2000}), "not signed by the network authority"},
+		{"unsigned deduction", zakatTx(nil, pool, change), "not signed by the network authority"},
+		{"signed by the sender's key", zakatTx(alice.priv, pool, change), "not signed by the network authority"},
+		{"pays someone else", zakatTx(authority.priv, utxo.TxOutput{Recipient: mallory.id, Amount: due}, change), "must pay 50 to the zakat pool"},
+		{"takes too much", zakatTx(authority.priv, utxo.TxOutput{Recipient: zakatPool.id, Amount: 2000}), "must pay 50 to the zakat pool"},
+		{"change to someone else", zakatTx(authority.priv, pool, utxo.TxOutput{Recipient: mallory.id, Amount: 2000 - due}), "must return 1950 to the sender"},
+		{"no change", zakatTx(authority.priv, pool), "must return 1950 to the sender"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewChainValidator(testParams())
+			reasons := checkProblems(v, testBlock(nil, subsidy, tt.tx))
+			if tt.want == "" {
+				if reasons != nil {
+					t.Fatalf("rejected: %q", reasons)
+				}
+				return
+			}
+			wantRejected(t, reasons, tt.want)
+		})
+	}
+
+	t.Run("disabled without an authority key", func(t *testing.T) {
+		params := testParams()
+		params.AuthorityKey = ""
+		reasons := checkProblems(NewChainValidator(params), testBlock(nil, subsidy, zakatTx(authority.priv, pool, change)))
+		wantRejected(t, reasons, "not enabled")
+	})
+	t.Run("relayed through CheckTx", func(t *testing.T) {
+		f := testFunding()
+		utxos := map[string]*utxo.UTXO{}
+		for _, u := range f.OutputUTXOs() {
+			utxos[u.ID] = u
+		}
+		lookup := func(id string) (*utxo.UTXO, bool) {
+			u, ok := utxos[id]
+			return u, ok
+		}
+		theft := zakatTx(nil, utxo.TxOutput{Recipient: mallory.id, Amount: 2000})
+		if problems, _ := CheckTx(&theft, testParams(), lookup, 0, SpendPoint{Height: 1}); len(problems) == 0 {
+			t.Error("CheckTx accepted an unsigned zakat theft")
+		}
+		ok := zakatTx(authority.priv, pool, change)
+		if problems, _ := CheckTx(&ok, testParams(), lookup, 0, SpendPoint{Height: 1}); len(problems) > 0 {
+			t.Errorf("CheckTx rejected a valid deduction: %q", problems)
+		}
+	})
+}
+
+// fundingTx builds an admin funding transaction paying outs, signed by
+// signer unless signer is nil.
+func fundingTx(signer ed25519.PrivateKey, outs ...utxo.TxOutput) utxo.Transaction {
+	t := utxo.Transaction{
+		Sender:          utxo.SystemSender,
+		Receiver:        outs[0].Recipient,
+		Amount:          outs[0].Amount,
+		Note:            utxo.FundingNote,
+		Timestamp:       testStart,
+		Outputs:         outs,
+		ClientTimestamp: testStart.Format(time.RFC3339Nano),
+		ChainID:         testChainID,
+	}
+	t.ID = t.ComputeID()
+	if signer != nil {
+		t.Signature = ed25519.Sign(signer, t.SigningBytes())
+	}
+	return t
+}
+
+func TestValidatorFunding(t *testing.T) {
+	subsidy := DefaultRewardSchedule.Subsidy(1)
+	pay := utxo.TxOutput{Recipient: bob.id, Amount: 5000}
+	feePaying := fundingTx(nil, pay)
+	feePaying.Fee = 10
+	feePaying.ID = feePaying.ComputeID()
+	feePaying.Signature = ed25519.Sign(authority.priv, feePaying.SigningBytes())
+
+	tests := []struct {
+		name string
+		tx   utxo.Transaction
+		want string // empty: accepted
+	}{
+		{"authority-signed", fundingTx(authority.priv, pay), ""},
+		{"unsigned", fundingTx(nil, pay), "not signed by the network authority"},
+		{"signed by another key", fundingTx(mallory.priv, pay), "not signed by the network authority"},
+		{"claims a fee", feePaying, "pays fee 10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reasons := checkProblems(NewChainValidator(testParams()), testBlock(nil, subsidy, tt.tx))
+			if tt.want == "" {
+				if reasons != nil {
+					t.Fatalf("rejected: %q", reasons)
+				}
+				return
+			}
+			wantRejected(t, reasons, tt.want)
+		})
+	}
+
+	t.Run("disabled without an authority key", func(t *testing.T) {
+		params := testParams()
+		params.AuthorityKey = ""
+		reasons := checkProblems(NewChainValidator(params), testBlock(nil, subsidy, fundingTx(authority.priv, pay)))
+		wantRejected(t, reasons, "not enabled")
+	})
+	t.Run("spendable in the next block", func(t *testing.T) {
+		f := fundingTx(authority.priv, pay)
+		b1 := testBlock(nil, subsidy, f)
+		v := validatorAt(t, b1)
+		spend := transfer(bob, 1, []string{f.OutputUTXOID(0)}, 5000, utxo.TxOutput{Recipient: alice.id, Amount: 5000})
+		if reasons := checkProblems(v, testBlock(b1, subsidy, spend)); reasons != nil {
+			t.Fatalf("spend of mined funding rejected: %q", reasons)
+		}
+	})
+	t.Run("not mined twice", func(t *testing.T) {
+		f := fundingTx(authority.priv, pay)
+		b1 := testBlock(nil, subsidy, f)
+		wantRejected(t, checkProblems(validatorAt(t, b1), testBlock(b1, subsidy, f)), "duplicate transaction id")
+	})
+}
+
+func TestZakatDue(t *testing.T) {
+	for _, tt := range []struct{ amount, due int64 }{
+		{0, 0}, {39, 0}, {40, 1}, {1000, 25}, {1999, 49}, {9223372036854775807, 230584300921369395},
+	} {
+		if got := utxo.ZakatDue(tt.amount); got != tt.due {
+			t.Errorf("ZakatDue(%d) = %d, want %d", tt.amount, got, tt.due)
+		}
+	}
+}
+
+func TestValidatorTimestamps(t *testing.T) {
+	subsidy := DefaultRewardSchedule.Subsidy(1)
+	restamp := func(b *Block, ts time.Time) *Block {
+		b.Timestamp = ts
+		b.Hash = b.ComputeHash()
+		return b
+	}
+	var chain []*Block
+	var prev *Block
+	for i := 0; i < 11; i++ {
+		prev = testBlock(prev, subsidy)
+		chain = append(chain, prev)
+	}
+	// blocks 1..11 are a minute apart, so the median time past is block 6's
+	mtp := chain[5].Timestamp
+
+	tests := []struct {
+		name string
+		ts   time.Time
+		want string
+	}{
+		{"at the median time past", mtp, "not after median time past"},
+		{"before the median time past", mtp.Add(-time.Hour), "not after median time past"},
+		{"three hours ahead", time.Now().Add(3 * time.Hour), "in the future"},
+		{"just after the median time past", mtp.Add(time.Millisecond), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validatorAt(t, chain...)
+			reasons := checkProblems(v, restamp(testBlock(prev, subsidy), tt.ts))
+			if tt.want == "" {
+				if reasons != nil {
+					t.Errorf("rejected: %q", reasons)
+				}
+				return
+			}
+			wantRejected(t, reasons, tt.want)
+		})
+	}
+
+	for _, version := range []int{1, 3, BlockVersion + 1} {
+		t.Run(fmt.Sprintf("version %d", version), func(t *testing.T) {
+			b := testBlock(prev, subsidy)
+			b.Version = version
+			b.Hash = b.ComputeHash()
+			wantRejected(t, checkProblems(validatorAt(t, chain...), b), fmt.Sprintf("version %d, want %d", version, BlockVersion))
+		})
+	}
+}
+
+// An after_time lock opens with the chain's median time past, not with the
+// timestamp the miner of the spending block chose.
+func TestValidatorAfterTimeUsesMedianTimePast(t *testing.T) {
+	subsidy := DefaultRewardSchedule.Subsidy(1)
+	f := testFunding()
+	unlock := testStart.Add(8 * time.Minute)
+	lock := transfer(alice, 1, []string{f.OutputUTXOID(0)}, 1000,
+		utxo.TxOutput{Recipient: bob.id, Amount: 990, Condition: &utxo.Condition{AfterTime: unlock.Unix()}})
+	chain := []*Block{testBlock(nil, subsidy+10, lock)}
+	for len(chain) < 11 {
+		chain = append(chain, testBlock(chain[len(chain)-1], subsidy))
+	}
+	spend := transfer(bob, 1, []string{lock.OutputUTXOID(0)}, 990, utxo.TxOutput{Recipient: bob.id, Amount: 980})
+
+	// block 12 is stamped past the unlock time, but the median is block 6's
+	early := testBlock(chain[10], subsidy+10, spend)
+	early.Timestamp = unlock.Add(time.Hour)
+	early.Hash = early.ComputeHash()
+	wantRejected(t, checkProblems(validatorAt(t, chain...), early), "locked until")
+
+	// three blocks on, the median is block 9's
+	for len(chain) < 14 {
+		chain = append(chain, testBlock(chain[len(chain)-1], subsidy))
+	}
+	if reasons := checkProblems(validatorAt(t, chain...), testBlock(chain[13], subsidy+10, spend)); reasons != nil {
+		t.Errorf("spend after the median time past reached the unlock time rejected: %q", reasons)
+	}
+}
+
+// A relayed spend that only waits on a timelock is reported as locked, so the
+// peer that sent it is not blamed; any other problem is.
+func TestCheckTxReportsTimelocks(t *testing.T) {
+	utxos := map[string]*utxo.UTXO{
+		"lock:0": {ID: "lock:0", TxID: "lock", WalletID: bob.id, Amount: 990, Condition: &utxo.Condition{After: 5}},
+		"lock:1": {ID: "lock:1", TxID: "lock", WalletID: bob.id, Amount: 10},
+	}
+	lookup := func(id string) (*utxo.UTXO, bool) {
+		u, ok := utxos[id]
+		return u, ok
+	}
+	spend := transfer(bob, 1, []string{"lock:0", "lock:1"}, 1000, utxo.TxOutput{Recipient: alice.id, Amount: 990})
+	tests := []struct {
+		name   string
+		tx     utxo.Transaction
+		height int64
+		locked bool
+	}{
+		{"before the lock", spend, 4, true},
+		{"at the lock", spend, 5, false},
+		{"before the lock, overspending", transfer(bob, 1, []string{"lock:0", "lock:1"}, 1000, utxo.TxOutput{Recipient: alice.id, Amount: 1001}), 4, false},
+		{"before the lock, not the owner", transfer(alice, 1, []string{"lock:0"}, 990, utxo.TxOutput{Recipient: alice.id, Amount: 980}), 4, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, locked := CheckTx(&tt.tx, testParams(), lookup, 0, SpendPoint{Height: tt.height})
+			if locked != tt.locked {
+				t.Errorf("locked = %v, want %v (problems %q)", locked, tt.locked, problems)
+			}
+			if tt.height == 5 && len(problems) > 0 {
+				t.Errorf("spend at the lock refused: %q", problems)
+			}
+		})
+	}
+}
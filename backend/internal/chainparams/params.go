@@ -0,0 +1,308 @@
+// Package chainparams describes a network: everything nodes must agree on to
+// accept each other's blocks, from the chain ID and genesis allocations to the
+// difficulty and reward rules. Params are loaded from a JSON or YAML file, or
+// built from environment variables for local development.
+package chainparams
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/crypto"
+	"github.com/student/decentralized-wallet/internal/utxo"
+	"github.com/student/decentralized-wallet/wallet"
+	"gopkg.in/yaml.v3"
+)
+
+// GenesisNote marks the genesis allocation transaction.
+const GenesisNote = "genesis"
+
+// Allocation credits a wallet in the genesis block.
+type Allocation struct {
+	Wallet string `json:"wallet" yaml:"wallet"`
+	Amount int64  `json:"amount" yaml:"amount"`
+}
+
+// Difficulty configures proof of work and retargeting.
+type Difficulty struct {
+	// InitialDifficulty is the starting target as leading zero hex digits.
+	InitialDifficulty int `json:"initial_difficulty" yaml:"initial_difficulty"`
+	// InitialBits is the starting target in compact hex form; it overrides InitialDifficulty.
+	InitialBits string `json:"initial_bits,omitempty" yaml:"initial_bits,omitempty"`
+	// PowLimitBits is the easiest target allowed (compact hex); default one leading zero.
+	PowLimitBits string `json:"pow_limit_bits,omitempty" yaml:"pow_limit_bits,omitempty"`
+	// RetargetInterval is how many blocks share a target; 0 never retargets.
+	RetargetInterval int64 `json:"retarget_interval" yaml:"retarget_interval"`
+	// TargetBlockTime is the block time retargeting steers toward, e.g. "1m".
+	TargetBlockTime string `json:"target_block_time" yaml:"target_block_time"`
+}
+
+// Rewards is the block subsidy schedule.
+type Rewards struct {
+	BlockSubsidy    int64 `json:"block_subsidy" yaml:"block_subsidy"`
+	HalvingInterval int64 `json:"halving_interval" yaml:"halving_interval"`
+}
+
+// BlockLimits bound block contents, coinbase included; 0 disables a limit.
+type BlockLimits struct {
+	MaxTxs   int `json:"max_txs" yaml:"max_txs"`
+	MaxBytes int `json:"max_bytes" yaml:"max_bytes"`
+}
+
+// Params are the consensus parameters of one network.
+type Params struct {
+	ChainID          string    `json:"chain_id" yaml:"chain_id"`
+	GenesisTimestamp time.Time `json:"genesis_timestamp" yaml:"genesis_timestamp"`
+	// GenesisHash, if set, pins the genesis block: loading fails when the
+	// other parameters produce a different one.
+	GenesisHash     string       `json:"genesis_hash,omitempty" yaml:"genesis_hash,omitempty"`
+	Allocations     []Allocation `json:"allocations" yaml:"allocations"`
+	Difficulty      Difficulty   `json:"difficulty" yaml:"difficulty"`
+	Rewards         Rewards      `json:"rewards" yaml:"rewards"`
+	BlockLimits     BlockLimits  `json:"block_limits" yaml:"block_limits"`
+	ZakatPoolWallet string       `json:"zakat_pool_wallet" yaml:"zakat_pool_wallet"`
+	// AuthorityKey is the base64 Ed25519 public key that signs the
+	// transactions no wallet signs: zakat deductions. Empty disables them.
+	AuthorityKey string `json:"authority_key,omitempty" yaml:"authority_key,omitempty"`
+	// AddressPrefix starts every address on the network ("dwt1..."), so an
+	// address of one network is refused on another. It is not part of consensus.
+	AddressPrefix string `json:"address_prefix" yaml:"address_prefix"`
+}
+
+// Default returns the development network's parameters.
+func Default() *Params {
+	return &Params{
+		ChainID:          "dwallet-dev",
+		GenesisTimestamp: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Difficulty: Difficulty{
+			InitialDifficulty: 5,
+			RetargetInterval:  10,
+			TargetBlockTime:   "1m",
+		},
+		Rewards: Rewards{
+			BlockSubsidy:    blockchain.DefaultRewardSchedule.InitialSubsidy,
+			HalvingInterval: blockchain.DefaultRewardSchedule.HalvingInterval,
+		},
+		BlockLimits:   BlockLimits{MaxTxs: 500, MaxBytes: 1 << 20},
+		AddressPrefix: "dwt",
+	}
+}
+
+// FromEnv returns Default overridden by the legacy environment variables
+// (CHAIN_ID, POW_DIFFICULTY, POW_BITS, RETARGET_INTERVAL, TARGET_BLOCK_TIME,
+// BLOCK_SUBSIDY, HALVING_INTERVAL, BLOCK_MAX_TXS, BLOCK_MAX_BYTES,
+// ZAKAT_POOL_WALLET_ID, AUTHORITY_KEY, ADDRESS_PREFIX).
+func FromEnv() (*Params, error) {
+	p := Default()
+	if v := os.Getenv("CHAIN_ID"); v != "" {
+		p.ChainID = v
+	}
+	envInt := func(name string, dst *int) {
+		if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v >= 0 {
+			*dst = v
+		}
+	}
+	envInt64 := func(name string, dst *int64) {
+		if v, err := strconv.ParseInt(os.Getenv(name), 10, 64); err == nil && v >= 0 {
+			*dst = v
+		}
+	}
+	envInt("POW_DIFFICULTY", &p.Difficulty.InitialDifficulty)
+	p.Difficulty.InitialBits = os.Getenv("POW_BITS")
+	envInt64("RETARGET_INTERVAL", &p.Difficulty.RetargetInterval)
+	if v := os.Getenv("TARGET_BLOCK_TIME"); v != "" {
+		p.Difficulty.TargetBlockTime = v
+	}
+	envInt64("BLOCK_SUBSIDY", &p.Rewards.BlockSubsidy)
+	if v, err := strconv.ParseInt(os.Getenv("HALVING_INTERVAL"), 10, 64); err == nil && v > 0 {
+		p.Rewards.HalvingInterval = v
+	}
+	envInt("BLOCK_MAX_TXS", &p.BlockLimits.MaxTxs)
+	envInt("BLOCK_MAX_BYTES", &p.BlockLimits.MaxBytes)
+	p.ZakatPoolWallet = os.Getenv("ZAKAT_POOL_WALLET_ID")
+	p.AuthorityKey = os.Getenv("AUTHORITY_KEY")
+	if v := os.Getenv("ADDRESS_PREFIX"); v != "" {
+		p.AddressPrefix = v
+	}
+	return p, p.Validate()
+}
+
+// Load reads params from a .json, .yaml or .yml file. Fields the file leaves
+// out keep their Default values; unknown fields are an error.
+func Load(path string) (*Params, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := Default()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(p)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(p)
+	default:
+		return nil, fmt.Errorf("chain params %s: want a .json, .yaml or .yml file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("chain params %s: %w", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("chain params %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Validate checks the params are internally consistent, including the
+// genesis_hash pin if one is set.
+func (p *Params) Validate() error {
+	if p.ChainID == "" {
+		return errors.New("chain_id is required")
+	}
+	if p.GenesisTimestamp.IsZero() {
+		return errors.New("genesis_timestamp is required")
+	}
+	if err := wallet.CheckPrefix(p.AddressPrefix); err != nil {
+		return fmt.Errorf("address_prefix: %w", err)
+	}
+	if p.AuthorityKey != "" {
+		if _, err := crypto.ParsePublicKey(p.AuthorityKey); err != nil {
+			return fmt.Errorf("authority_key: %w", err)
+		}
+	}
+	if len(p.Allocations) > 0 {
+		// same rules as any transaction's outputs: recipients, positive amounts, no overflow
+		if _, err := utxo.SumOutputs(p.allocationOutputs()); err != nil {
+			return fmt.Errorf("allocations: %w", err)
+		}
+	}
+
+	d := p.Difficulty
+	if d.InitialDifficulty < 0 || d.InitialDifficulty > 63 {
+		return fmt.Errorf("initial_difficulty %d out of range", d.InitialDifficulty)
+	}
+	for name, v := range map[string]string{"initial_bits": d.InitialBits, "pow_limit_bits": d.PowLimitBits} {
+		if _, err := parseBits(v); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if d.RetargetInterval < 0 {
+		return errors.New("retarget_interval must not be negative")
+	}
+	if dur, err := time.ParseDuration(d.TargetBlockTime); err != nil || dur <= 0 {
+		return fmt.Errorf("target_block_time %q is not a positive duration", d.TargetBlockTime)
+	}
+
+	if p.Rewards.BlockSubsidy < 0 || p.Rewards.HalvingInterval < 0 {
+		return errors.New("rewards must not be negative")
+	}
+	if p.BlockLimits.MaxTxs < 0 || p.BlockLimits.MaxBytes < 0 {
+		return errors.New("block limits must not be negative")
+	}
+	if p.BlockLimits.MaxTxs == 1 {
+		return errors.New("block_limits.max_txs must leave room for the coinbase")
+	}
+
+	if p.GenesisHash != "" {
+		if h := p.Genesis().Hash; h != p.GenesisHash {
+			return fmt.Errorf("genesis_hash pins %s but the params produce %s", p.GenesisHash, h)
+		}
+	}
+	return nil
+}
+
+// parseBits parses a compact target written as hex, with or without "0x". Empty means unset.
+func parseBits(s string) (uint32, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 32)
+	if err != nil || v == 0 {
+		return 0, fmt.Errorf("%q is not a compact target in hex", s)
+	}
+	return uint32(v), nil
+}
+
+// InitialBits is the compact target of the genesis block and those after it.
+func (p *Params) InitialBits() uint32 {
+	if bits, _ := parseBits(p.Difficulty.InitialBits); bits != 0 {
+		return bits
+	}
+	return blockchain.DifficultyToBits(p.Difficulty.InitialDifficulty)
+}
+
+// TargetRules returns the retargeting schedule.
+func (p *Params) TargetRules() blockchain.TargetRules {
+	spacing, _ := time.ParseDuration(p.Difficulty.TargetBlockTime)
+	rules := blockchain.TargetRules{
+		InitialBits:      p.InitialBits(),
+		PowLimit:         blockchain.DefaultPowLimit,
+		RetargetInterval: p.Difficulty.RetargetInterval,
+		TargetSpacing:    spacing,
+	}
+	if bits, _ := parseBits(p.Difficulty.PowLimitBits); bits != 0 {
+		rules.PowLimit = bits
+	}
+	// never let the limit be stricter than where the chain starts
+	if blockchain.CompactToBig(rules.InitialBits).Cmp(blockchain.CompactToBig(rules.PowLimit)) > 0 {
+		rules.PowLimit = rules.InitialBits
+	}
+	return rules
+}
+
+// RewardSchedule returns the block subsidy schedule.
+func (p *Params) RewardSchedule() blockchain.RewardSchedule {
+	return blockchain.RewardSchedule{
+		InitialSubsidy:  p.Rewards.BlockSubsidy,
+		HalvingInterval: p.Rewards.HalvingInterval,
+	}
+}
+
+func (p *Params) allocationOutputs() []utxo.TxOutput {
+	outs := make([]utxo.TxOutput, 0, len(p.Allocations))
+	for _, a := range p.Allocations {
+		outs = append(outs, utxo.TxOutput{Recipient: a.Wallet, Amount: a.Amount})
+	}
+	return outs
+}
+
+// Genesis builds the genesis block. It is a pure function of the params, so
+// every node on the network derives the same block and hash. The block sits
+// at index 0, needs no proof of work and carries a single coinbase-style
+// transaction paying the allocations (none if there are no allocations).
+func (p *Params) Genesis() *blockchain.Block {
+	ts := p.GenesisTimestamp.UTC()
+	var txs []utxo.Transaction
+	if len(p.Allocations) > 0 {
+		outs := p.allocationOutputs()
+		total, _ := utxo.SumOutputs(outs)
+		t := utxo.Transaction{
+			ChainID:         p.ChainID,
+			Sender:          utxo.CoinbaseSender,
+			Receiver:        outs[0].Recipient,
+			Amount:          total,
+			Note:            GenesisNote,
+			Timestamp:       ts,
+			Inputs:          []string{},
+			Outputs:         outs,
+			ClientTimestamp: ts.Format(time.RFC3339Nano),
+		}
+		t.ID = t.ComputeID()
+		txs = append(txs, t)
+	}
+	b := blockchain.NewBlockTemplate(0, "", p.InitialBits(), txs)
+	b.Timestamp = ts
+	b.Hash = b.ComputeHash()
+	return b
+}
@@ -0,0 +1,139 @@
+package chainparams
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+)
+
+func TestGenesisVersion(t *testing.T) {
+	g := Default().Genesis()
+	if g.Version != blockchain.BlockVersion {
+		t.Errorf("genesis version %d, want %d", g.Version, blockchain.BlockVersion)
+	}
+	if !g.VerifyWitnessRoot() || g.ComputeHash() != g.Hash {
+		t.Error("genesis header does not verify")
+	}
+}
+
+// writeFile writes data to name in a fresh directory and returns its path.
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const paramsYAML = `
+chain_id: test-net
+genesis_timestamp: 2026-03-01T12:00:00Z
+allocations:
+  - wallet: alice
+    amount: 1000
+  - wallet: bob
+    amount: 250
+difficulty:
+  initial_bits: "1f0fffff"
+  retarget_interval: 20
+  target_block_time: 30s
+rewards:
+  block_subsidy: 100
+  halving_interval: 1000
+zakat_pool_wallet: pool
+address_prefix: tst
+`
+
+const paramsJSON = `{
+  "chain_id": "test-net",
+  "genesis_timestamp": "2026-03-01T12:00:00Z",
+  "allocations": [{"wallet": "alice", "amount": 1000}, {"wallet": "bob", "amount": 250}],
+  "difficulty": {"initial_bits": "1f0fffff", "retarget_interval": 20, "target_block_time": "30s"},
+  "rewards": {"block_subsidy": 100, "halving_interval": 1000},
+  "zakat_pool_wallet": "pool",
+  "address_prefix": "tst"
+}`
+
+func TestLoadJSONAndYAMLAgree(t *testing.T) {
+	fromYAML, err := Load(writeFile(t, "net.yaml", paramsYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := Load(writeFile(t, "net.JSON", paramsJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Fatalf("YAML gives %+v, JSON %+v", fromYAML, fromJSON)
+	}
+	// fields the files leave out keep their defaults
+	if fromYAML.BlockLimits != Default().BlockLimits {
+		t.Errorf("block limits %+v, want the defaults", fromYAML.BlockLimits)
+	}
+
+	g := fromYAML.Genesis()
+	if g.Hash != fromJSON.Genesis().Hash || g.Hash != g.ComputeHash() {
+		t.Fatalf("genesis hashes %s and %s differ", g.Hash, fromJSON.Genesis().Hash)
+	}
+	if g.Index != 0 || g.PreviousHash != "" || len(g.Transactions) != 1 || g.Transactions[0].Amount != 1250 {
+		t.Errorf("genesis %+v, want one allocation tx of 1250 at index 0", g)
+	}
+	// anything else in the params changes the genesis block
+	other := *fromYAML
+	other.ChainID = "other-net"
+	if other.Genesis().Hash == g.Hash {
+		t.Error("another chain ID gives the same genesis")
+	}
+
+	// a pin on the right hash loads, one on another hash does not
+	pinned := paramsYAML + "genesis_hash: " + g.Hash + "\n"
+	if _, err := Load(writeFile(t, "pinned.yml", pinned)); err != nil {
+		t.Errorf("correct pin: %v", err)
+	}
+	pinned = paramsYAML + "genesis_hash: " + other.Genesis().Hash + "\n"
+	if _, err := Load(writeFile(t, "pinned.yml", pinned)); err == nil || !strings.Contains(err.Error(), "genesis_hash") {
+		t.Errorf("wrong pin: err = %v, want a genesis_hash error", err)
+	}
+}
+
+func TestLoadRejectsBadParams(t *testing.T) {
+	tests := []struct {
+		name, file, data, want string
+	}{
+		{"unknown YAML field", "net.yaml", paramsYAML + "block_time: 1m\n", "block_time"},
+		{"unknown JSON field", "net.json", `{"chain_id": "x", "difficulty": {"target": 3}}`, "target"},
+		{"malformed JSON", "net.json", `{"chain_id": `, "chain params"},
+		{"other extension", "net.toml", `chain_id = "x"`, "want a .json"},
+		{"no chain ID", "net.yaml", "chain_id: \"\"\n", "chain_id"},
+		{"no genesis timestamp", "net.yaml", "genesis_timestamp: 0001-01-01T00:00:00Z\n", "genesis_timestamp"},
+		{"bad address prefix", "net.yaml", "address_prefix: \"Not Valid\"\n", "address_prefix"},
+		{"bad authority key", "net.yaml", "authority_key: nope\n", "authority_key"},
+		{"zero allocation", "net.yaml", "allocations: [{wallet: alice, amount: 0}]\n", "allocations"},
+		{"allocation without a wallet", "net.yaml", "allocations: [{wallet: \"\", amount: 5}]\n", "allocations"},
+		{"difficulty out of range", "net.yaml", "difficulty: {initial_difficulty: 64}\n", "initial_difficulty"},
+		{"bad initial bits", "net.yaml", "difficulty: {initial_bits: zz}\n", "initial_bits"},
+		{"bad pow limit", "net.yaml", "difficulty: {pow_limit_bits: \"0\"}\n", "pow_limit_bits"},
+		{"negative retarget interval", "net.yaml", "difficulty: {retarget_interval: -1}\n", "retarget_interval"},
+		{"block time not positive", "net.yaml", "difficulty: {target_block_time: 0s}\n", "target_block_time"},
+		{"negative subsidy", "net.yaml", "rewards: {block_subsidy: -5}\n", "rewards"},
+		{"negative limit", "net.yaml", "block_limits: {max_bytes: -1}\n", "block limits"},
+		{"no room for the coinbase", "net.yaml", "block_limits: {max_txs: 1}\n", "max_txs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeFile(t, tt.file, tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
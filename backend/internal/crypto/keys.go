@@ -2,9 +2,26 @@ package crypto
 
 import (
     "crypto/ed25519"
+    "crypto/sha256"
     "encoding/base64"
+    "encoding/hex"
+    "errors"
 )
 
+// ErrInvalidPublicKey is returned for a public key that is not a canonical
+// base64 Ed25519 key.
+var ErrInvalidPublicKey = errors.New("not a base64 Ed25519 public key")
+
+// ErrInvalidPrivateKey is returned for a private key that is not a base64
+// Ed25519 seed or key.
+var ErrInvalidPrivateKey = errors.New("not a base64 Ed25519 private key")
+
+// WalletIDFromPublicKey derives a wallet ID as the hex SHA-256 of the base64 public key string.
+func WalletIDFromPublicKey(pubKeyB64 string) string {
+    h := sha256.Sum256([]byte(pubKeyB64))
+    return hex.EncodeToString(h[:])
+}
+
 // VerifyEd25519Signature verifies an Ed25519 signature where the public key and signature
 // are provided as base64-encoded strings. Message is raw bytes.
 func VerifyEd25519Signature(pubKeyB64 string, message []byte, sigB64 string) (bool, error) {
@@ -22,3 +39,35 @@ func VerifyEd25519Signature(pubKeyB64 string, message []byte, sigB64 string) (bo
     ok := ed25519.Verify(ed25519.PublicKey(pub), message, sig)
     return ok, nil
 }
+
+// ParsePublicKey decodes a base64 Ed25519 public key. Wallet IDs hash the key
+// string, so only the canonical (padded, standard alphabet) encoding is
+// accepted: any other spelling of the same key would be another wallet.
+func ParsePublicKey(pubKeyB64 string) (ed25519.PublicKey, error) {
+    raw, err := base64.StdEncoding.DecodeString(pubKeyB64)
+    if err != nil || len(raw) != ed25519.PublicKeySize || base64.StdEncoding.EncodeToString(raw) != pubKeyB64 {
+        return nil, ErrInvalidPublicKey
+    }
+    return ed25519.PublicKey(raw), nil
+}
+
+// ParsePrivateKey decodes a base64 Ed25519 private key, given as its 32-byte
+// seed or as the 64-byte seed and public key.
+func ParsePrivateKey(privKeyB64 string) (ed25519.PrivateKey, error) {
+    raw, err := base64.StdEncoding.DecodeString(privKeyB64)
+    if err != nil {
+        return nil, ErrInvalidPrivateKey
+    }
+    switch len(raw) {
+    case ed25519.SeedSize:
+        return ed25519.NewKeyFromSeed(raw), nil
+    case ed25519.PrivateKeySize:
+        key := ed25519.NewKeyFromSeed(raw[:ed25519.SeedSize])
+        // the public half is derived, so a mismatched one means a corrupt key
+        if string(key[ed25519.SeedSize:]) != string(raw[ed25519.SeedSize:]) {
+            return nil, ErrInvalidPrivateKey
+        }
+        return key, nil
+    }
+    return nil, ErrInvalidPrivateKey
+}
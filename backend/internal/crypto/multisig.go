@@ -0,0 +1,107 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A multisig wallet is spent by M of its N keys. Its policy is written as
+//
+//	multisig:<M>:<key 1>,<key 2>,...
+//
+// with the base64 keys sorted, and stands in for the public key everywhere a
+// single-key wallet has one: the wallet ID is WalletIDFromPublicKey of the
+// policy, and a transaction from the wallet carries the policy as its sender
+// public key, so the signed bytes commit to it.
+
+const (
+	// MultisigPrefix starts every multisig policy.
+	MultisigPrefix = "multisig:"
+	// MaxMultisigKeys caps the keys of one policy.
+	MaxMultisigKeys = 15
+)
+
+// ErrInvalidPolicy is returned for a multisig policy that does not parse or is not canonical.
+var ErrInvalidPolicy = errors.New("invalid multisig policy")
+
+// MultisigPolicy is an M-of-N spending policy.
+type MultisigPolicy struct {
+	Threshold  int      `json:"threshold"`
+	PublicKeys []string `json:"public_keys"` // base64, sorted
+}
+
+// NewMultisigPolicy checks the keys and threshold and returns the policy with
+// its keys in canonical order. It needs 2 to MaxMultisigKeys distinct keys and
+// a threshold between 1 and their number.
+func NewMultisigPolicy(threshold int, keys []string) (*MultisigPolicy, error) {
+	if len(keys) < 2 || len(keys) > MaxMultisigKeys {
+		return nil, fmt.Errorf("%w: needs 2 to %d keys, not %d", ErrInvalidPolicy, MaxMultisigKeys, len(keys))
+	}
+	if threshold < 1 || threshold > len(keys) {
+		return nil, fmt.Errorf("%w: threshold must be 1 to %d, not %d", ErrInvalidPolicy, len(keys), threshold)
+	}
+	sorted := make([]string, 0, len(keys))
+	seen := map[string]bool{}
+	for i, k := range keys {
+		k = strings.TrimSpace(k)
+		raw, err := base64.StdEncoding.DecodeString(k)
+		// keys are hashed as strings, so only the canonical encoding is accepted
+		if err != nil || len(raw) != ed25519.PublicKeySize || base64.StdEncoding.EncodeToString(raw) != k {
+			return nil, fmt.Errorf("%w: key %d is not a base64 Ed25519 public key", ErrInvalidPolicy, i)
+		}
+		if seen[k] {
+			return nil, fmt.Errorf("%w: key %d is listed twice", ErrInvalidPolicy, i)
+		}
+		seen[k] = true
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	return &MultisigPolicy{Threshold: threshold, PublicKeys: sorted}, nil
+}
+
+// IsMultisigPolicy reports whether a sender public key is a multisig policy.
+func IsMultisigPolicy(s string) bool {
+	return strings.HasPrefix(s, MultisigPrefix)
+}
+
+// ParseMultisigPolicy parses a policy, which must be in canonical form so
+// that every wallet has exactly one policy string.
+func ParseMultisigPolicy(s string) (*MultisigPolicy, error) {
+	parts := strings.SplitN(strings.TrimPrefix(s, MultisigPrefix), ":", 2)
+	if !IsMultisigPolicy(s) || len(parts) != 2 {
+		return nil, fmt.Errorf("%w: want %s<threshold>:<keys>", ErrInvalidPolicy, MultisigPrefix)
+	}
+	m, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad threshold %q", ErrInvalidPolicy, parts[0])
+	}
+	p, err := NewMultisigPolicy(m, strings.Split(parts[1], ","))
+	if err != nil {
+		return nil, err
+	}
+	if p.String() != s {
+		return nil, fmt.Errorf("%w: not in canonical form", ErrInvalidPolicy)
+	}
+	return p, nil
+}
+
+// String returns the canonical policy.
+func (p *MultisigPolicy) String() string {
+	return MultisigPrefix + strconv.Itoa(p.Threshold) + ":" + strings.Join(p.PublicKeys, ",")
+}
+
+// WalletID returns the ID of the wallet the policy guards.
+func (p *MultisigPolicy) WalletID() string {
+	return WalletIDFromPublicKey(p.String())
+}
+
+// HasKey reports whether pub is one of the policy's keys.
+func (p *MultisigPolicy) HasKey(pub string) bool {
+	i := sort.SearchStrings(p.PublicKeys, pub)
+	return i < len(p.PublicKeys) && p.PublicKeys[i] == pub
+}
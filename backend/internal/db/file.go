@@ -0,0 +1,353 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+// FileStore is an embedded single-file store for running a node fully offline.
+// It keeps the working set in a MemoryStore and rewrites the whole file after
+// every mutation (write to a temp file, fsync, rename) so a crash never leaves
+// a half-written database behind. Logs, written far more often than anything
+// else, are appended one JSON line each to a file of their own (path + ".logs").
+type FileStore struct {
+	*MemoryStore
+	path    string
+	writeMu sync.Mutex // held across a mutation and its save; see persist
+	saveMu  sync.Mutex
+	logs    *os.File
+}
+
+// OpenFileStore opens (or creates) the store at path.
+func OpenFileStore(path string) (*FileStore, error) {
+	fs := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("create store directory: %w", err)
+		}
+		if err := fs.openLogs(); err != nil {
+			return nil, err
+		}
+		return fs, fs.save()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read store file: %w", err)
+	}
+	st := newMemState()
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("decode store file %s: %w", path, err)
+	}
+	st.fill()
+	// a store file from before the log file holds its logs itself
+	legacy := st.Logs
+	st.Logs = nil
+	fs.MemoryStore.state = st
+	if err := fs.openLogs(); err != nil {
+		return nil, err
+	}
+	if len(legacy) > 0 {
+		if err := fs.migrateLogs(legacy); err != nil {
+			return nil, err
+		}
+		if err := fs.save(); err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// migrateLogs moves the inline logs of an old store file to the front of the
+// log file. The log file is replaced as a whole, and records it already holds
+// are kept once, so a crash before the store file drops its copy only means
+// migrating the same records again on the next open.
+func (f *FileStore) migrateLogs(legacy []*LogRecord) error {
+	merged := append([]*LogRecord{}, legacy...)
+	seen := map[string]bool{}
+	for _, rec := range legacy {
+		seen[rec.ID] = true
+	}
+	for _, rec := range f.state.Logs {
+		if !seen[rec.ID] {
+			merged = append(merged, rec)
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, rec := range merged {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("encode log: %w", err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".logs.tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp log file: %w", err)
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("write log file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("sync log file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	f.logs.Close()
+	if err := os.Rename(tmpName, f.path+".logs"); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("replace log file: %w", err)
+	}
+	file, err := os.OpenFile(f.path+".logs", os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	f.logs = file
+	f.state.Logs = merged
+	return nil
+}
+
+// openLogs loads the log file into the working set and opens it for appending.
+func (f *FileStore) openLogs() error {
+	file, err := os.OpenFile(f.path+".logs", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	logs := []*LogRecord{}
+	var size int64
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// a line cut short by a crash has no newline; drop it so the
+			// next record starts on a line of its own
+			if len(line) > 0 {
+				if err := file.Truncate(size); err != nil {
+					file.Close()
+					return fmt.Errorf("truncate log file: %w", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("read log file: %w", err)
+		}
+		var rec LogRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			file.Close()
+			return fmt.Errorf("decode log file %s.logs: %w", f.path, err)
+		}
+		logs = append(logs, &rec)
+		size += int64(len(line))
+	}
+	f.state.Logs = logs
+	f.logs = file
+	return nil
+}
+
+// appendLog writes one record to the end of the log file.
+func (f *FileStore) appendLog(rec *LogRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encode log: %w", err)
+	}
+	if _, err := f.logs.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write log: %w", err)
+	}
+	return nil
+}
+
+func (f *FileStore) Backend() string { return "file" }
+
+func (f *FileStore) Close() error {
+	err := f.save()
+	if cerr := f.logs.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// save writes the current state, logs aside, to disk atomically.
+func (f *FileStore) save() error {
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+
+	f.mu.RLock()
+	st := f.state
+	st.Logs = nil
+	data, err := json.Marshal(&st)
+	f.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("encode store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("write store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("sync store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, f.path); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("replace store file: %w", err)
+	}
+	return nil
+}
+
+// persist runs a MemoryStore mutation and saves the file if it succeeded. If
+// the save fails, the working set is put back to how it was before the
+// mutation, so memory never holds a change the file does not.
+func (f *FileStore) persist(mutate func() error) error {
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+
+	f.mu.RLock()
+	before := f.state
+	before.Logs = nil
+	snapshot, err := json.Marshal(&before)
+	f.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("encode store: %w", err)
+	}
+	if err := mutate(); err != nil {
+		return err
+	}
+	if err := f.save(); err != nil {
+		if rerr := f.restore(snapshot); rerr != nil {
+			return fmt.Errorf("%w (and restoring the working set failed: %v)", err, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// restore replaces the working set, logs aside, with an encoded snapshot.
+func (f *FileStore) restore(snapshot []byte) error {
+	st := newMemState()
+	if err := json.Unmarshal(snapshot, &st); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	st.fill()
+	f.mu.Lock()
+	st.Logs = f.state.Logs
+	f.state = st
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *FileStore) RegisterWallet(walletID, publicKeyB64 string) error {
+	return f.persist(func() error { return f.MemoryStore.RegisterWallet(walletID, publicKeyB64) })
+}
+
+func (f *FileStore) RegisterWallets(recs []*WalletRecord) error {
+	return f.persist(func() error { return f.MemoryStore.RegisterWallets(recs) })
+}
+
+func (f *FileStore) CreateUTXO(u *utxo.UTXO) error {
+	return f.persist(func() error { return f.MemoryStore.CreateUTXO(u) })
+}
+
+func (f *FileStore) MarkUTXOSpent(id string) error {
+	return f.persist(func() error { return f.MemoryStore.MarkUTXOSpent(id) })
+}
+
+func (f *FileStore) AddPendingTx(t *utxo.Transaction) error {
+	return f.persist(func() error { return f.MemoryStore.AddPendingTx(t) })
+}
+
+func (f *FileStore) CreatePendingTxAtomic(t *utxo.Transaction, inputIDs []string, outputs []*utxo.UTXO) error {
+	return f.persist(func() error { return f.MemoryStore.CreatePendingTxAtomic(t, inputIDs, outputs) })
+}
+
+func (f *FileStore) DropPendingTx(txID string) error {
+	return f.persist(func() error { return f.MemoryStore.DropPendingTx(txID) })
+}
+
+func (f *FileStore) MovePendingToMined(txIDs []string, blockHash string, blockIndex int64) error {
+	return f.persist(func() error { return f.MemoryStore.MovePendingToMined(txIDs, blockHash, blockIndex) })
+}
+
+func (f *FileStore) MoveMinedToPending(txIDs []string) error {
+	return f.persist(func() error { return f.MemoryStore.MoveMinedToPending(txIDs) })
+}
+
+func (f *FileStore) ConfirmTx(t *utxo.Transaction, blockHash string, blockIndex int64) error {
+	return f.persist(func() error { return f.MemoryStore.ConfirmTx(t, blockHash, blockIndex) })
+}
+
+func (f *FileStore) RevertTx(txID string) error {
+	return f.persist(func() error { return f.MemoryStore.RevertTx(txID) })
+}
+
+func (f *FileStore) AddTransactionRecord(t *utxo.Transaction, blockHash string, blockIndex int64) error {
+	return f.persist(func() error { return f.MemoryStore.AddTransactionRecord(t, blockHash, blockIndex) })
+}
+
+func (f *FileStore) AddBlock(b *blockchain.Block) error {
+	return f.persist(func() error { return f.MemoryStore.AddBlock(b) })
+}
+
+func (f *FileStore) StoreSideBlock(b *blockchain.Block) error {
+	return f.persist(func() error { return f.MemoryStore.StoreSideBlock(b) })
+}
+
+func (f *FileStore) DisconnectBlock(index int64) error {
+	return f.persist(func() error { return f.MemoryStore.DisconnectBlock(index) })
+}
+
+func (f *FileStore) CreateUser(u *User) error {
+	return f.persist(func() error { return f.MemoryStore.CreateUser(u) })
+}
+
+func (f *FileStore) UpdateUser(u *User) error {
+	return f.persist(func() error { return f.MemoryStore.UpdateUser(u) })
+}
+
+// AddLog appends the record to the log file instead of rewriting the store.
+// The record only joins the working set once it is on disk.
+func (f *FileStore) AddLog(level, message string, meta map[string]interface{}) error {
+	return f.MemoryStore.addLog(level, message, meta, f.appendLog)
+}
+
+func (f *FileStore) AddZakatRecord(walletID string, amount int64, txID string) error {
+	return f.persist(func() error { return f.MemoryStore.AddZakatRecord(walletID, amount, txID) })
+}
+
+func (f *FileStore) SavePartialTx(p *PartialTx) error {
+	return f.persist(func() error { return f.MemoryStore.SavePartialTx(p) })
+}
+
+func (f *FileStore) DeletePartialTx(id string) error {
+	return f.persist(func() error { return f.MemoryStore.DeletePartialTx(id) })
+}
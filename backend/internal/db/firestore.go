@@ -2,18 +2,23 @@ package db
 
 import (
     "context"
+    "encoding/json"
     "errors"
     "fmt"
     "os"
+    "sort"
     "strconv"
-    "sync"
     "time"
 
     firebase "firebase.google.com/go/v4"
     "firebase.google.com/go/v4/auth"
     "cloud.google.com/go/firestore"
+    "google.golang.org/api/iterator"
     "google.golang.org/api/option"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
 
+    "github.com/student/decentralized-wallet/internal/blockchain"
     "github.com/student/decentralized-wallet/internal/utxo"
 )
 
@@ -21,27 +26,12 @@ var (
     App        *firebase.App
     AuthClient *auth.Client
     FSClient   *firestore.Client
-    ctx        context.Context
-)
-
-// Logs: in-memory fallback and helpers for server-side logging
-type LogRecord struct {
-    ID        string                 `json:"id"`
-    Level     string                 `json:"level"`
-    Message   string                 `json:"message"`
-    Meta      map[string]interface{} `json:"meta"`
-    CreatedAt time.Time              `json:"created_at"`
-}
-
-var (
-    logsMu sync.RWMutex
-    Logs   = []*LogRecord{}
 )
 
 // InitFirebase initializes Firebase app, Auth client and Firestore client.
 // It reads service account from env `GOOGLE_APPLICATION_CREDENTIALS` or uses default credentials.
 func InitFirebase() error {
-    ctx = context.Background()
+    ctx := context.Background()
     var opt option.ClientOption
     // Support either a path via GOOGLE_APPLICATION_CREDENTIALS or raw JSON via SERVICE_ACCOUNT_JSON.
     credPath := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
@@ -71,131 +61,393 @@ func InitFirebase() error {
         return fmt.Errorf("failed to init firestore client: %w", err)
     }
     FSClient = fs
+    return nil
+}
 
+// FirestoreStore implements Store on top of a Firestore client.
+type FirestoreStore struct {
+    client *firestore.Client
     // use a long-lived background context for Firestore operations
-    ctx = context.Background()
-    return nil
+    ctx context.Context
 }
 
-// RegisterWallet persists a wallet public key to Firestore.
-func RegisterWallet(walletID, publicKeyB64 string) error {
-    if FSClient == nil {
-        return errors.New("firestore not initialized")
+// NewFirestoreStore wraps an initialized Firestore client.
+func NewFirestoreStore(client *firestore.Client) *FirestoreStore {
+    return &FirestoreStore{client: client, ctx: context.Background()}
+}
+
+func (s *FirestoreStore) Backend() string { return "firestore" }
+
+func (s *FirestoreStore) Close() error { return s.client.Close() }
+
+// notFound maps Firestore's NotFound status onto ErrNotFound.
+func notFound(err error, what string) error {
+    if status.Code(err) == codes.NotFound {
+        return fmt.Errorf("%s: %w", what, ErrNotFound)
     }
-    _, err := FSClient.Collection("wallets").Doc(walletID).Set(ctx, map[string]interface{}{
-        "wallet_id": walletID,
-        "public_key": publicKeyB64,
-        "created_at": time.Now().UTC(),
-    })
     return err
 }
 
-// CreateUTXO stores a UTXO document in Firestore.
-func CreateUTXO(u *utxo.UTXO) error {
-    if FSClient == nil {
-        return errors.New("firestore not initialized")
+// toInt64 reads a numeric field; Firestore may return int64 or float64 depending on how it was written.
+func toInt64(v interface{}) int64 {
+    switch n := v.(type) {
+    case int64:
+        return n
+    case int:
+        return int64(n)
+    case float64:
+        return int64(n)
+    }
+    return 0
+}
+
+func toStrings(v interface{}) []string {
+    arr, _ := v.([]interface{})
+    res := make([]string, 0, len(arr))
+    for _, x := range arr {
+        if s, ok := x.(string); ok { res = append(res, s) }
     }
-    _, err := FSClient.Collection("utxos").Doc(u.ID).Set(ctx, map[string]interface{}{
+    return res
+}
+
+func utxoData(u *utxo.UTXO) map[string]interface{} {
+    m := map[string]interface{}{
         "tx_id": u.TxID,
         "index": u.Index,
         "wallet_id": u.WalletID,
         "amount": u.Amount,
         "spent": u.Spent,
         "created_at": u.CreatedAt,
-    })
+    }
+    if u.Condition != nil {
+        m["condition"] = conditionData(u.Condition)
+    }
+    return m
+}
+
+// conditionData stores a spending condition as its JSON, so the nested tree
+// round-trips exactly.
+func conditionData(c *utxo.Condition) string {
+    b, _ := json.Marshal(c)
+    return string(b)
+}
+
+func conditionFromData(v interface{}) *utxo.Condition {
+    s, ok := v.(string)
+    if !ok {
+        return nil
+    }
+    var c utxo.Condition
+    if err := json.Unmarshal([]byte(s), &c); err != nil {
+        return nil
+    }
+    return &c
+}
+
+func utxoFromData(id string, m map[string]interface{}) *utxo.UTXO {
+    // best-effort mapping with type assertions
+    u := &utxo.UTXO{ID: id}
+    if v, ok := m["tx_id"].(string); ok { u.TxID = v }
+    u.Index = int(toInt64(m["index"]))
+    if v, ok := m["wallet_id"].(string); ok { u.WalletID = v }
+    u.Amount = toInt64(m["amount"])
+    if v, ok := m["spent"].(bool); ok { u.Spent = v }
+    if v, ok := m["created_at"].(time.Time); ok { u.CreatedAt = v }
+    u.Condition = conditionFromData(m["condition"])
+    return u
+}
+
+func txData(t *utxo.Transaction) map[string]interface{} {
+    outMaps := make([]map[string]interface{}, 0, len(t.Outputs))
+    for _, o := range t.Outputs {
+        om := map[string]interface{}{"recipient": o.Recipient, "amount": o.Amount}
+        if o.Condition != nil {
+            om["condition"] = conditionData(o.Condition)
+        }
+        outMaps = append(outMaps, om)
+    }
+    sigMaps := make([]map[string]interface{}, 0, len(t.Signatures))
+    for _, sg := range t.Signatures {
+        sigMaps = append(sigMaps, map[string]interface{}{"public_key": sg.PublicKey, "signature": sg.Signature})
+    }
+    return map[string]interface{}{
+        "id": t.ID,
+        "sender": t.Sender,
+        "receiver": t.Receiver,
+        "amount": t.Amount,
+        "note": t.Note,
+        "timestamp": t.Timestamp,
+        "sender_public_key": t.SenderPublicKey,
+        "signature": t.Signature,
+        "signatures": sigMaps,
+        "preimages": t.Preimages,
+        "inputs": t.Inputs,
+        "outputs": outMaps,
+        "client_timestamp": t.ClientTimestamp,
+        "chain_id": t.ChainID,
+        "nonce": int64(t.Nonce),
+        "fee": t.Fee,
+    }
+}
+
+func txFromData(id string, m map[string]interface{}) *utxo.Transaction {
+    t := &utxo.Transaction{ID: id}
+    if v, ok := m["sender"].(string); ok { t.Sender = v }
+    if v, ok := m["receiver"].(string); ok { t.Receiver = v }
+    t.Amount = toInt64(m["amount"])
+    if v, ok := m["note"].(string); ok { t.Note = v }
+    if v, ok := m["timestamp"].(time.Time); ok { t.Timestamp = v }
+    if v, ok := m["sender_public_key"].(string); ok { t.SenderPublicKey = v }
+    if v, ok := m["signature"].([]byte); ok { t.Signature = v }
+    if v, ok := m["client_timestamp"].(string); ok { t.ClientTimestamp = v }
+    if v, ok := m["chain_id"].(string); ok { t.ChainID = v }
+    t.Nonce = uint64(toInt64(m["nonce"]))
+    t.Fee = toInt64(m["fee"])
+    t.Inputs = toStrings(m["inputs"])
+    t.Preimages = toStrings(m["preimages"])
+    if outs, ok := m["outputs"].([]interface{}); ok {
+        for _, o := range outs {
+            om, _ := o.(map[string]interface{})
+            r, _ := om["recipient"].(string)
+            t.Outputs = append(t.Outputs, utxo.TxOutput{Recipient: r, Amount: toInt64(om["amount"]), Condition: conditionFromData(om["condition"])})
+        }
+    }
+    if sigs, ok := m["signatures"].([]interface{}); ok {
+        for _, sg := range sigs {
+            sm, _ := sg.(map[string]interface{})
+            pub, _ := sm["public_key"].(string)
+            sig, _ := sm["signature"].([]byte)
+            t.Signatures = append(t.Signatures, utxo.TxSignature{PublicKey: pub, Signature: sig})
+        }
+    }
+    return t
+}
+
+func txRecordFromData(id string, m map[string]interface{}) *TxRecord {
+    rec := &TxRecord{Transaction: *txFromData(id, m)}
+    if v, ok := m["block_hash"].(string); ok { rec.BlockHash = v }
+    rec.BlockIndex = toInt64(m["block_index"])
+    return rec
+}
+
+func blockFromData(m map[string]interface{}) *blockchain.Block {
+    b := &blockchain.Block{
+        Version:    int(toInt64(m["version"])),
+        Index:      toInt64(m["index"]),
+        Nonce:      toInt64(m["nonce"]),
+        Bits:       uint32(toInt64(m["bits"])),
+    }
+    if v, ok := m["timestamp"].(time.Time); ok { b.Timestamp = v }
+    if v, ok := m["previous_hash"].(string); ok { b.PreviousHash = v }
+    if v, ok := m["hash"].(string); ok { b.Hash = v }
+    if v, ok := m["merkle_root"].(string); ok { b.MerkleRoot = v }
+    if v, ok := m["witness_root"].(string); ok { b.WitnessRoot = v }
+    if txs, ok := m["transactions"].([]interface{}); ok {
+        for _, x := range txs {
+            tm, _ := x.(map[string]interface{})
+            id, _ := tm["id"].(string)
+            b.Transactions = append(b.Transactions, *txFromData(id, tm))
+        }
+    }
+    return b
+}
+
+// RegisterWallet persists a wallet public key to Firestore.
+func (s *FirestoreStore) RegisterWallet(walletID, publicKeyB64 string) error {
+    // merge so re-registering keeps the wallet's nonce (otherwise old txs could be replayed)
+    _, err := s.client.Collection("wallets").Doc(walletID).Set(s.ctx, map[string]interface{}{
+        "wallet_id": walletID,
+        "public_key": publicKeyB64,
+        "created_at": time.Now().UTC(),
+    }, firestore.MergeAll)
     return err
 }
 
-// GetUnspentUTXOsByWallet returns unspent UTXOs for a wallet.
-func GetUnspentUTXOsByWallet(walletID string) ([]utxo.UTXO, error) {
-    if FSClient == nil {
-        return nil, errors.New("firestore not initialized")
+// GetWalletPublicKey retrieves a wallet's public key from Firestore.
+func (s *FirestoreStore) GetWalletPublicKey(walletID string) (string, error) {
+    doc, err := s.client.Collection("wallets").Doc(walletID).Get(s.ctx)
+    if err != nil {
+        return "", notFound(err, "wallet "+walletID)
+    }
+    m := doc.Data()
+    pk, ok := m["public_key"].(string)
+    if !ok {
+        return "", errors.New("public_key missing or invalid")
+    }
+    return pk, nil
+}
+
+// GetWalletNonce returns the last nonce accepted from a wallet.
+func (s *FirestoreStore) GetWalletNonce(walletID string) (uint64, error) {
+    doc, err := s.client.Collection("wallets").Doc(walletID).Get(s.ctx)
+    if err != nil {
+        return 0, notFound(err, "wallet "+walletID)
     }
-    q := FSClient.Collection("utxos").Where("wallet_id", "==", walletID).Where("spent", "==", false)
-    docs, err := q.Documents(ctx).GetAll()
+    return uint64(toInt64(doc.Data()["nonce"])), nil
+}
+
+// ListAllWalletIDs returns all wallet document IDs in the wallets collection.
+func (s *FirestoreStore) ListAllWalletIDs() ([]string, error) {
+    docs, err := s.client.Collection("wallets").Documents(s.ctx).GetAll()
     if err != nil {
         return nil, err
     }
-    var res []utxo.UTXO
+    ids := make([]string, 0, len(docs))
+    for _, d := range docs {
+        ids = append(ids, d.Ref.ID)
+    }
+    sort.Strings(ids)
+    return ids, nil
+}
+
+// RegisterWallets writes a batch of owned wallets in one Firestore transaction.
+func (s *FirestoreStore) RegisterWallets(recs []*WalletRecord) error {
+    return s.client.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+        refs := make([]*firestore.DocumentRef, 0, len(recs))
+        for _, r := range recs {
+            refs = append(refs, s.client.Collection("wallets").Doc(r.WalletID))
+        }
+        snaps, err := tx.GetAll(refs)
+        if err != nil {
+            return err
+        }
+        now := time.Now().UTC()
+        for i, r := range recs {
+            data := map[string]interface{}{
+                "wallet_id":  r.WalletID,
+                "public_key": r.PublicKey,
+                "owner_uid":  r.OwnerUID,
+                "path":       r.Path,
+            }
+            if snaps[i].Exists() {
+                if owner, _ := snaps[i].Data()["owner_uid"].(string); owner != "" && owner != r.OwnerUID {
+                    return fmt.Errorf("wallet %s: %w", r.WalletID, ErrWalletOwned)
+                }
+            } else {
+                data["created_at"] = now
+            }
+            // merge so re-registering keeps the wallet's nonce
+            if err := tx.Set(refs[i], data, firestore.MergeAll); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// ListWalletsByOwner returns the wallets registered to a user.
+func (s *FirestoreStore) ListWalletsByOwner(uid string) ([]*WalletRecord, error) {
+    docs, err := s.client.Collection("wallets").Where("owner_uid", "==", uid).Documents(s.ctx).GetAll()
+    if err != nil {
+        return nil, err
+    }
+    res := make([]*WalletRecord, 0, len(docs))
     for _, d := range docs {
         m := d.Data()
-        u := utxo.UTXO{}
-        // best-effort mapping with type assertions
-        if v, ok := m["tx_id"].(string); ok { u.TxID = v }
-        if v, ok := m["index"].(int64); ok { u.Index = int(v) }
-        if v, ok := m["wallet_id"].(string); ok { u.WalletID = v }
-        if v, ok := m["amount"].(int64); ok { u.Amount = v }
-        if v, ok := m["spent"].(bool); ok { u.Spent = v }
-        u.ID = d.Ref.ID
-        res = append(res, u)
+        rec := &WalletRecord{WalletID: d.Ref.ID, Nonce: uint64(toInt64(m["nonce"]))}
+        rec.PublicKey, _ = m["public_key"].(string)
+        rec.OwnerUID, _ = m["owner_uid"].(string)
+        rec.Path, _ = m["path"].(string)
+        rec.CreatedAt, _ = m["created_at"].(time.Time)
+        res = append(res, rec)
     }
+    sortWalletRecords(res)
     return res, nil
 }
 
-// MarkUTXOSpent updates the spent flag for a utxo doc.
-func MarkUTXOSpent(id string) error {
-    if FSClient == nil {
-        return errors.New("firestore not initialized")
-    }
-    _, err := FSClient.Collection("utxos").Doc(id).Update(ctx, []firestore.Update{{Path: "spent", Value: true}})
+// CreateUTXO stores a UTXO document in Firestore.
+func (s *FirestoreStore) CreateUTXO(u *utxo.UTXO) error {
+    _, err := s.client.Collection("utxos").Doc(u.ID).Set(s.ctx, utxoData(u))
     return err
 }
 
 // GetUTXOByID fetches a utxo document by ID.
-func GetUTXOByID(id string) (*utxo.UTXO, error) {
-    if FSClient == nil {
-        return nil, errors.New("firestore not initialized")
+func (s *FirestoreStore) GetUTXOByID(id string) (*utxo.UTXO, error) {
+    doc, err := s.client.Collection("utxos").Doc(id).Get(s.ctx)
+    if err != nil {
+        return nil, notFound(err, "utxo "+id)
     }
-    doc, err := FSClient.Collection("utxos").Doc(id).Get(ctx)
+    return utxoFromData(doc.Ref.ID, doc.Data()), nil
+}
+
+// GetUnspentUTXOsByWallet returns unspent UTXOs for a wallet, oldest first.
+func (s *FirestoreStore) GetUnspentUTXOsByWallet(walletID string) ([]utxo.UTXO, error) {
+    q := s.client.Collection("utxos").Where("wallet_id", "==", walletID).Where("spent", "==", false)
+    docs, err := q.Documents(s.ctx).GetAll()
     if err != nil {
         return nil, err
     }
-    m := doc.Data()
-    u := &utxo.UTXO{
-        ID: doc.Ref.ID,
-        TxID: m["tx_id"].(string),
-        Index: int(m["index"].(int64)),
-        WalletID: m["wallet_id"].(string),
-        Amount: m["amount"].(int64),
-        Spent: m["spent"].(bool),
+    var res []utxo.UTXO
+    for _, d := range docs {
+        res = append(res, *utxoFromData(d.Ref.ID, d.Data()))
     }
-    return u, nil
+    // sorted here rather than by the query, which would need a composite index
+    sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+    return res, nil
 }
 
-// AddPendingTx stores a pending transaction.
-func AddPendingTx(t *utxo.Transaction) error {
-    if FSClient == nil {
-        return errors.New("firestore not initialized")
+// ForEachUTXO streams every utxo doc, spent or not.
+func (s *FirestoreStore) ForEachUTXO(fn func(*utxo.UTXO) error) error {
+    iter := s.client.Collection("utxos").Documents(s.ctx)
+    defer iter.Stop()
+    for {
+        doc, err := iter.Next()
+        if err == iterator.Done {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        if err := fn(utxoFromData(doc.Ref.ID, doc.Data())); err != nil {
+            return err
+        }
     }
-    _, err := FSClient.Collection("pending_txs").Doc(t.ID).Set(ctx, map[string]interface{}{
-        "sender": t.Sender,
-        "receiver": t.Receiver,
-        "amount": t.Amount,
-        "note": t.Note,
-        "timestamp": t.Timestamp,
-        "sender_public_key": t.SenderPublicKey,
-        "inputs": t.Inputs,
-        "outputs": t.Outputs,
-    })
+}
+
+// MarkUTXOSpent updates the spent flag for a utxo doc.
+func (s *FirestoreStore) MarkUTXOSpent(id string) error {
+    _, err := s.client.Collection("utxos").Doc(id).Update(s.ctx, []firestore.Update{{Path: "spent", Value: true}})
+    return notFound(err, "utxo "+id)
+}
+
+// pendingData is the pending_txs doc of t, accepted now.
+func pendingData(t *utxo.Transaction) map[string]interface{} {
+    data := txData(t)
+    data["accepted_at"] = time.Now().UTC()
+    return data
+}
+
+// AddPendingTx stores a pending transaction.
+func (s *FirestoreStore) AddPendingTx(t *utxo.Transaction) error {
+    _, err := s.client.Collection("pending_txs").Doc(t.ID).Set(s.ctx, pendingData(t))
     return err
 }
 
 // CreatePendingTxAtomic performs an atomic transaction that:
 // - verifies that each input UTXO exists, is unspent, and belongs to the expected wallet
+// - for signed txs, verifies and consumes the sender wallet's next nonce
 // - marks each input as spent
 // - creates output UTXO documents
 // - writes the pending transaction document
 // This prevents double-spend races when multiple senders try to spend the same inputs.
-func CreatePendingTxAtomic(t *utxo.Transaction, inputIDs []string, outputs []*utxo.UTXO) error {
-    if FSClient == nil {
-        return errors.New("firestore not initialized")
-    }
-
+func (s *FirestoreStore) CreatePendingTxAtomic(t *utxo.Transaction, inputIDs []string, outputs []*utxo.UTXO) error {
     // use a background context for the transaction (caller may pass short-lived ctx)
-    return FSClient.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
-        // 1) Verify and mark inputs spent
+    return s.client.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+        // 1) Verify nonce and inputs. Firestore requires all reads before any writes.
+        var walletRef *firestore.DocumentRef
+        if t.SenderPublicKey != "" {
+            walletRef = s.client.Collection("wallets").Doc(t.Sender)
+            snap, err := tx.Get(walletRef)
+            if err != nil {
+                return notFound(err, "wallet "+t.Sender)
+            }
+            if err := CheckNonce(uint64(toInt64(snap.Data()["nonce"])), t.Nonce); err != nil {
+                return fmt.Errorf("wallet %s nonce %d: %w", t.Sender, t.Nonce, err)
+            }
+        }
+        refs := make([]*firestore.DocumentRef, 0, len(inputIDs))
         for _, id := range inputIDs {
-            docRef := FSClient.Collection("utxos").Doc(id)
+            docRef := s.client.Collection("utxos").Doc(id)
             docSnap, err := tx.Get(docRef)
             if err != nil {
                 return fmt.Errorf("input utxo not found: %s: %w", id, err)
@@ -204,51 +456,40 @@ func CreatePendingTxAtomic(t *utxo.Transaction, inputIDs []string, outputs []*ut
             // validate wallet ownership and spent flag
             wid, _ := m["wallet_id"].(string)
             spent, _ := m["spent"].(bool)
+            _, conditioned := m["condition"].(string)
             if spent {
                 return fmt.Errorf("input utxo already spent: %s", id)
             }
-            if wid != t.Sender {
+            // a conditioned output's spender was checked against its condition
+            if wid != t.Sender && !conditioned {
                 return fmt.Errorf("input utxo does not belong to sender: %s", id)
             }
-            // mark spent
+            refs = append(refs, docRef)
+        }
+
+        // 2) Consume the nonce and mark inputs spent
+        if walletRef != nil {
+            if err := tx.Update(walletRef, []firestore.Update{{Path: "nonce", Value: int64(t.Nonce)}}); err != nil {
+                return fmt.Errorf("failed to update wallet nonce: %w", err)
+            }
+        }
+        for i, docRef := range refs {
             if err := tx.Update(docRef, []firestore.Update{{Path: "spent", Value: true}}); err != nil {
-                return fmt.Errorf("failed to mark utxo spent %s: %w", id, err)
+                return fmt.Errorf("failed to mark utxo spent %s: %w", inputIDs[i], err)
             }
         }
 
-        // 2) Create output UTXOs
+        // 3) Create output UTXOs
         for _, out := range outputs {
-            docRef := FSClient.Collection("utxos").Doc(out.ID)
-            data := map[string]interface{}{
-                "tx_id": out.TxID,
-                "index": out.Index,
-                "wallet_id": out.WalletID,
-                "amount": out.Amount,
-                "spent": out.Spent,
-                "created_at": out.CreatedAt,
-            }
-            if err := tx.Set(docRef, data); err != nil {
+            docRef := s.client.Collection("utxos").Doc(out.ID)
+            if err := tx.Set(docRef, utxoData(out)); err != nil {
                 return fmt.Errorf("failed to create output utxo %s: %w", out.ID, err)
             }
         }
 
-        // 3) Write pending transaction
-        pendingRef := FSClient.Collection("pending_txs").Doc(t.ID)
-        outMaps := make([]map[string]interface{}, 0, len(t.Outputs))
-        for _, o := range t.Outputs {
-            outMaps = append(outMaps, map[string]interface{}{"recipient": o.Recipient, "amount": o.Amount})
-        }
-        pendingData := map[string]interface{}{
-            "sender": t.Sender,
-            "receiver": t.Receiver,
-            "amount": t.Amount,
-            "note": t.Note,
-            "timestamp": t.Timestamp,
-            "sender_public_key": t.SenderPublicKey,
-            "inputs": t.Inputs,
-            "outputs": outMaps,
-        }
-        if err := tx.Set(pendingRef, pendingData); err != nil {
+        // 4) Write pending transaction
+        pendingRef := s.client.Collection("pending_txs").Doc(t.ID)
+        if err := tx.Set(pendingRef, pendingData(t)); err != nil {
             return fmt.Errorf("failed to write pending tx: %w", err)
         }
 
@@ -256,29 +497,62 @@ func CreatePendingTxAtomic(t *utxo.Transaction, inputIDs []string, outputs []*ut
     })
 }
 
-// GetWalletPublicKey retrieves a wallet's public key from Firestore.
-func GetWalletPublicKey(walletID string) (string, error) {
-    if FSClient == nil {
-        return "", errors.New("firestore not initialized")
-    }
-    doc, err := FSClient.Collection("wallets").Doc(walletID).Get(ctx)
-    if err != nil {
-        return "", err
-    }
-    m := doc.Data()
-    pk, ok := m["public_key"].(string)
-    if !ok {
-        return "", errors.New("public_key missing or invalid")
-    }
-    return pk, nil
+// DropPendingTx atomically deletes a pending tx's outputs, marks its inputs
+// unspent again and removes the pending document.
+func (s *FirestoreStore) DropPendingTx(txID string) error {
+    return s.client.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+        pendingRef := s.client.Collection("pending_txs").Doc(txID)
+        snap, err := tx.Get(pendingRef)
+        if err != nil {
+            return notFound(err, "pending tx "+txID)
+        }
+        t := txFromData(txID, snap.Data())
+
+        // reads first: outputs must still be unspent, inputs must exist
+        outRefs := make([]*firestore.DocumentRef, 0, len(t.Outputs))
+        for i := range t.Outputs {
+            ref := s.client.Collection("utxos").Doc(t.OutputUTXOID(i))
+            osnap, err := tx.Get(ref)
+            if err != nil {
+                if status.Code(err) == codes.NotFound {
+                    continue
+                }
+                return err
+            }
+            if spent, _ := osnap.Data()["spent"].(bool); spent {
+                return fmt.Errorf("output %s of pending tx %s already spent", ref.ID, txID)
+            }
+            outRefs = append(outRefs, ref)
+        }
+        inRefs := make([]*firestore.DocumentRef, 0, len(t.Inputs))
+        for _, id := range t.Inputs {
+            ref := s.client.Collection("utxos").Doc(id)
+            if _, err := tx.Get(ref); err != nil {
+                if status.Code(err) == codes.NotFound {
+                    continue
+                }
+                return err
+            }
+            inRefs = append(inRefs, ref)
+        }
+
+        for _, ref := range outRefs {
+            if err := tx.Delete(ref); err != nil {
+                return err
+            }
+        }
+        for _, ref := range inRefs {
+            if err := tx.Update(ref, []firestore.Update{{Path: "spent", Value: false}}); err != nil {
+                return err
+            }
+        }
+        return tx.Delete(pendingRef)
+    })
 }
 
 // GetAllPendingTxIDs returns the IDs of all pending transactions.
-func GetAllPendingTxIDs() ([]string, error) {
-    if FSClient == nil {
-        return nil, errors.New("firestore not initialized")
-    }
-    docs, err := FSClient.Collection("pending_txs").Documents(ctx).GetAll()
+func (s *FirestoreStore) GetAllPendingTxIDs() ([]string, error) {
+    docs, err := s.client.Collection("pending_txs").Documents(s.ctx).GetAll()
     if err != nil {
         return nil, err
     }
@@ -289,265 +563,441 @@ func GetAllPendingTxIDs() ([]string, error) {
     return ids, nil
 }
 
-// ListAllWalletIDs returns all wallet document IDs in the wallets collection.
-func ListAllWalletIDs() ([]string, error) {
-    if FSClient == nil {
-        return nil, errors.New("firestore not initialized")
-    }
-    docs, err := FSClient.Collection("wallets").Documents(ctx).GetAll()
+// ListPendingTxs returns all pending transactions, oldest first.
+func (s *FirestoreStore) ListPendingTxs() ([]*PendingRecord, error) {
+    docs, err := s.client.Collection("pending_txs").Documents(s.ctx).GetAll()
     if err != nil {
         return nil, err
     }
-    ids := make([]string, 0, len(docs))
+    res := make([]*PendingRecord, 0, len(docs))
     for _, d := range docs {
-        ids = append(ids, d.Ref.ID)
+        r := &PendingRecord{Transaction: *txFromData(d.Ref.ID, d.Data())}
+        r.AcceptedAt, _ = d.Data()["accepted_at"].(time.Time)
+        res = append(res, r)
     }
-    return ids, nil
-}
-
-// User profile storage (supports Firestore when configured, otherwise in-memory map for dev).
-type User struct {
-    ID string `json:"id"`
-    Name string `json:"name"`
-    CNIC string `json:"cnic"`
-    Beneficiaries []string `json:"beneficiaries"`
-    CreatedAt time.Time `json:"created_at"`
-    UpdatedAt time.Time `json:"updated_at"`
+    sort.Slice(res, func(i, j int) bool { return res[i].Timestamp.Before(res[j].Timestamp) })
+    return res, nil
 }
 
-var (
-    usersMu sync.RWMutex
-    Users = map[string]*User{}
-)
+// movesPerTxn keeps each transaction of a move under Firestore's 500 writes
+// (two per moved tx).
+const movesPerTxn = 200
 
-// CreateUser stores a user profile. Uses Firestore when available.
-func CreateUser(u *User) error {
-    if FSClient != nil {
-        _, err := FSClient.Collection("users").Doc(u.ID).Set(ctx, map[string]interface{}{
-            "id": u.ID,
-            "name": u.Name,
-            "cnic": u.CNIC,
-            "beneficiaries": u.Beneficiaries,
-            "created_at": u.CreatedAt,
-            "updated_at": u.UpdatedAt,
+// moveTxs moves the docs of txIDs from one collection to another, passing
+// each through edit. Every doc is checked first, so one in neither collection
+// moves nothing. A block can hold more txs than one transaction can move, so
+// the moves run in transactions of movesPerTxn docs: a doc is never in both
+// collections or in neither, but a failure can leave the move part done. A doc
+// already in the target collection is skipped, so repeating the move finishes
+// it.
+func (s *FirestoreStore) moveTxs(txIDs []string, from, to, what string, edit func(map[string]interface{})) error {
+    for _, id := range txIDs {
+        _, err := s.client.Collection(from).Doc(id).Get(s.ctx)
+        if status.Code(err) == codes.NotFound {
+            _, err = s.client.Collection(to).Doc(id).Get(s.ctx)
+        }
+        if err != nil {
+            return notFound(err, what+" "+id)
+        }
+    }
+    for start := 0; start < len(txIDs); start += movesPerTxn {
+        end := start + movesPerTxn
+        if end > len(txIDs) {
+            end = len(txIDs)
+        }
+        chunk := txIDs[start:end]
+        err := s.client.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+            ids := make([]string, 0, len(chunk))
+            docs := make([]map[string]interface{}, 0, len(chunk))
+            for _, id := range chunk {
+                snap, err := tx.Get(s.client.Collection(from).Doc(id))
+                if status.Code(err) == codes.NotFound {
+                    // moved by an earlier, interrupted call
+                    if _, err := tx.Get(s.client.Collection(to).Doc(id)); err != nil {
+                        return notFound(err, what+" "+id)
+                    }
+                    continue
+                }
+                if err != nil {
+                    return err
+                }
+                data := snap.Data()
+                edit(data)
+                ids = append(ids, id)
+                docs = append(docs, data)
+            }
+            for i, id := range ids {
+                if err := tx.Set(s.client.Collection(to).Doc(id), docs[i]); err != nil {
+                    return err
+                }
+                if err := tx.Delete(s.client.Collection(from).Doc(id)); err != nil {
+                    return err
+                }
+            }
+            return nil
         })
-        return err
+        if err != nil {
+            return err
+        }
     }
-    usersMu.Lock()
-    defer usersMu.Unlock()
-    Users[u.ID] = u
     return nil
 }
 
-// GetUser retrieves a user profile by id.
-func GetUser(id string) (*User, error) {
-    if FSClient != nil {
-        doc, err := FSClient.Collection("users").Doc(id).Get(ctx)
+// MovePendingToMined moves pending transaction docs into `transactions` collection and deletes pending docs.
+func (s *FirestoreStore) MovePendingToMined(txIDs []string, blockHash string, blockIndex int64) error {
+    return s.moveTxs(txIDs, "pending_txs", "transactions", "pending tx", func(data map[string]interface{}) {
+        delete(data, "accepted_at")
+        data["block_hash"] = blockHash
+        data["block_index"] = blockIndex
+    })
+}
+
+// MoveMinedToPending moves transaction docs of a disconnected block back into `pending_txs`.
+func (s *FirestoreStore) MoveMinedToPending(txIDs []string) error {
+    return s.moveTxs(txIDs, "transactions", "pending_txs", "transaction", func(data map[string]interface{}) {
+        delete(data, "block_hash")
+        delete(data, "block_index")
+        data["accepted_at"] = time.Now().UTC()
+    })
+}
+
+// ConfirmTx atomically spends a block transaction's inputs, creates its
+// outputs and writes its confirmed record.
+func (s *FirestoreStore) ConfirmTx(t *utxo.Transaction, blockHash string, blockIndex int64) error {
+    return s.client.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+        // reads first: the sender's nonce and every input
+        var walletRef *firestore.DocumentRef
+        if t.SenderPublicKey != "" {
+            ref := s.client.Collection("wallets").Doc(t.Sender)
+            snap, err := tx.Get(ref)
+            if err != nil && status.Code(err) != codes.NotFound {
+                return err
+            }
+            if err == nil && uint64(toInt64(snap.Data()["nonce"])) < t.Nonce {
+                walletRef = ref
+            }
+        }
+        refs := make([]*firestore.DocumentRef, 0, len(t.Inputs))
+        for _, id := range t.Inputs {
+            ref := s.client.Collection("utxos").Doc(id)
+            snap, err := tx.Get(ref)
+            if err != nil {
+                return fmt.Errorf("input utxo not found: %s: %w", id, err)
+            }
+            if spent, _ := snap.Data()["spent"].(bool); spent {
+                return fmt.Errorf("input utxo already spent: %s", id)
+            }
+            refs = append(refs, ref)
+        }
+
+        if walletRef != nil {
+            if err := tx.Update(walletRef, []firestore.Update{{Path: "nonce", Value: int64(t.Nonce)}}); err != nil {
+                return err
+            }
+        }
+        for _, ref := range refs {
+            if err := tx.Update(ref, []firestore.Update{{Path: "spent", Value: true}}); err != nil {
+                return err
+            }
+        }
+        for _, out := range t.OutputUTXOs() {
+            if err := tx.Set(s.client.Collection("utxos").Doc(out.ID), utxoData(out)); err != nil {
+                return err
+            }
+        }
+        data := txData(t)
+        data["block_hash"] = blockHash
+        data["block_index"] = blockIndex
+        return tx.Set(s.client.Collection("transactions").Doc(t.ID), data)
+    })
+}
+
+// RevertTx atomically undoes ConfirmTx: deletes the outputs, marks the inputs
+// unspent again and removes the transaction record.
+func (s *FirestoreStore) RevertTx(txID string) error {
+    return s.client.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+        recRef := s.client.Collection("transactions").Doc(txID)
+        snap, err := tx.Get(recRef)
         if err != nil {
-            return nil, err
+            return notFound(err, "transaction "+txID)
         }
-        m := doc.Data()
-        u := &User{ID: id}
-        if v, ok := m["name"].(string); ok { u.Name = v }
-        if v, ok := m["cnic"].(string); ok { u.CNIC = v }
-        if v, ok := m["beneficiaries"].([]interface{}); ok {
-            b := make([]string, 0, len(v))
-            for _, x := range v {
-                if s, ok := x.(string); ok { b = append(b, s) }
+        t := txFromData(txID, snap.Data())
+
+        outRefs := make([]*firestore.DocumentRef, 0, len(t.Outputs))
+        for i := range t.Outputs {
+            ref := s.client.Collection("utxos").Doc(t.OutputUTXOID(i))
+            osnap, err := tx.Get(ref)
+            if err != nil {
+                if status.Code(err) == codes.NotFound {
+                    continue
+                }
+                return err
+            }
+            if spent, _ := osnap.Data()["spent"].(bool); spent {
+                return fmt.Errorf("output %s of transaction %s already spent", ref.ID, txID)
             }
-            u.Beneficiaries = b
+            outRefs = append(outRefs, ref)
         }
-        return u, nil
-    }
-    usersMu.RLock()
-    defer usersMu.RUnlock()
-    if u, ok := Users[id]; ok {
-        return u, nil
+        inRefs := make([]*firestore.DocumentRef, 0, len(t.Inputs))
+        for _, id := range t.Inputs {
+            ref := s.client.Collection("utxos").Doc(id)
+            if _, err := tx.Get(ref); err != nil {
+                if status.Code(err) == codes.NotFound {
+                    continue
+                }
+                return err
+            }
+            inRefs = append(inRefs, ref)
+        }
+
+        for _, ref := range outRefs {
+            if err := tx.Delete(ref); err != nil {
+                return err
+            }
+        }
+        for _, ref := range inRefs {
+            if err := tx.Update(ref, []firestore.Update{{Path: "spent", Value: false}}); err != nil {
+                return err
+            }
+        }
+        return tx.Delete(recRef)
+    })
+}
+
+// AddTransactionRecord persists a transaction document into `transactions` collection.
+func (s *FirestoreStore) AddTransactionRecord(t *utxo.Transaction, blockHash string, blockIndex int64) error {
+    data := txData(t)
+    data["block_hash"] = blockHash
+    data["block_index"] = blockIndex
+    _, err := s.client.Collection("transactions").Doc(t.ID).Set(s.ctx, data)
+    return err
+}
+
+// GetTransactionByID fetches a mined transaction by ID from `transactions` collection.
+func (s *FirestoreStore) GetTransactionByID(id string) (*TxRecord, error) {
+    doc, err := s.client.Collection("transactions").Doc(id).Get(s.ctx)
+    if err != nil {
+        return nil, notFound(err, "transaction "+id)
     }
-    return nil, errors.New("user not found")
+    return txRecordFromData(doc.Ref.ID, doc.Data()), nil
 }
 
-// UpdateUser updates an existing user profile.
-func UpdateUser(u *User) error {
-    if FSClient != nil {
-        _, err := FSClient.Collection("users").Doc(u.ID).Set(ctx, map[string]interface{}{
-            "id": u.ID,
-            "name": u.Name,
-            "cnic": u.CNIC,
-            "beneficiaries": u.Beneficiaries,
-            "updated_at": u.UpdatedAt,
-        }, firestore.MergeAll)
-        return err
+// GetAllTransactions fetches all transactions from the transactions collection, oldest first.
+func (s *FirestoreStore) GetAllTransactions() ([]*TxRecord, error) {
+    docs, err := s.client.Collection("transactions").Documents(s.ctx).GetAll()
+    if err != nil {
+        return nil, err
     }
-    usersMu.Lock()
-    defer usersMu.Unlock()
-    Users[u.ID] = u
-    return nil
+    result := make([]*TxRecord, 0, len(docs))
+    for _, doc := range docs {
+        result = append(result, txRecordFromData(doc.Ref.ID, doc.Data()))
+    }
+    sort.Slice(result, func(i, j int) bool { return result[i].Timestamp.Before(result[j].Timestamp) })
+    return result, nil
 }
 
-// AddZakatRecord stores a zakat deduction record.
-func AddZakatRecord(walletID string, amount int64, txID string) error {
-    if FSClient == nil {
-        return errors.New("firestore not initialized")
+// blockData is a block's header document. Its transactions are stored apart,
+// in `block_txs` (see writeBlockTxs): a block can be as large as Firestore's
+// 1 MiB document limit on its own, before field overhead.
+func blockData(b *blockchain.Block) map[string]interface{} {
+    return map[string]interface{}{
+        "version": b.Version,
+        "index": b.Index,
+        "timestamp": b.Timestamp,
+        "previous_hash": b.PreviousHash,
+        "hash": b.Hash,
+        "merkle_root": b.MerkleRoot,
+        "witness_root": b.WitnessRoot,
+        "nonce": b.Nonce,
+        "bits": int64(b.Bits),
+        "tx_count": len(b.Transactions),
     }
-    _, err := FSClient.Collection("zakat_deductions").NewDoc().Set(ctx, map[string]interface{}{
-        "wallet_id": walletID,
-        "amount": amount,
-        "tx_id": txID,
-        "created_at": time.Now().UTC(),
-    })
-    return err
 }
 
-// AddLog creates a log record in Firestore when available, otherwise stores in-memory.
-func AddLog(level, message string, meta map[string]interface{}) error {
-    rec := &LogRecord{
-        Level:     level,
-        Message:   message,
-        Meta:      meta,
-        CreatedAt: time.Now().UTC(),
-    }
-    if FSClient != nil {
-        _, _, err := FSClient.Collection("logs").Add(ctx, map[string]interface{}{
-            "level": rec.Level,
-            "message": rec.Message,
-            "meta": rec.Meta,
-            "created_at": rec.CreatedAt,
-        })
-        return err
+// blockTxsPerBatch keeps block writes under Firestore's 500 writes per batch.
+const blockTxsPerBatch = 400
+
+// blockTxID keys a transaction in a block's `txs` subcollection by position,
+// zero-padded so document ID order is block order.
+func blockTxID(i int) string {
+    return fmt.Sprintf("%06d", i)
+}
+
+// blockTxs is where the transactions of the block with hash live:
+// `block_txs/{hash}/txs`. A block's hash commits to its transactions, so the
+// documents under a hash never change, and moving a block between `blocks`
+// and `side_blocks` only rewrites its header.
+func (s *FirestoreStore) blockTxs(hash string) *firestore.CollectionRef {
+    return s.client.Collection("block_txs").Doc(hash).Collection("txs")
+}
+
+// writeBlockTxs stores b's transactions under its hash. Writing them again is
+// harmless, so they go first and a header is only written once they are all
+// in place: a reader never finds a header whose transactions are missing.
+func (s *FirestoreStore) writeBlockTxs(b *blockchain.Block) error {
+    col := s.blockTxs(b.Hash)
+    for start := 0; start < len(b.Transactions); start += blockTxsPerBatch {
+        batch := s.client.Batch()
+        for i := start; i < len(b.Transactions) && i < start+blockTxsPerBatch; i++ {
+            batch.Set(col.Doc(blockTxID(i)), txData(&b.Transactions[i]))
+        }
+        if _, err := batch.Commit(s.ctx); err != nil {
+            return err
+        }
     }
-    logsMu.Lock()
-    defer logsMu.Unlock()
-    Logs = append(Logs, rec)
     return nil
 }
 
-// ListLogs returns recent logs (desc by created_at). Uses Firestore when configured.
-func ListLogs(limit int) ([]*LogRecord, error) {
-    if limit <= 0 {
-        limit = 100
+// loadBlock reads the block whose header is doc, with its transactions.
+// Blocks written before transactions moved to `block_txs` keep them inline
+// and are read as they are.
+func (s *FirestoreStore) loadBlock(doc *firestore.DocumentSnapshot) (*blockchain.Block, error) {
+    m := doc.Data()
+    b := blockFromData(m)
+    if _, ok := m["tx_count"]; !ok {
+        return b, nil
     }
-    if FSClient != nil {
-        q := FSClient.Collection("logs").OrderBy("created_at", firestore.Desc).Limit(limit)
-        docs, err := q.Documents(ctx).GetAll()
+    n := int(toInt64(m["tx_count"]))
+    docs, err := s.blockTxs(b.Hash).OrderBy(firestore.DocumentID, firestore.Asc).Limit(n).Documents(s.ctx).GetAll()
+    if err != nil {
+        return nil, err
+    }
+    if len(docs) != n {
+        return nil, fmt.Errorf("block %d: %d of %d transactions stored", b.Index, len(docs), n)
+    }
+    b.Transactions = make([]utxo.Transaction, 0, n)
+    for _, d := range docs {
+        tm := d.Data()
+        id, _ := tm["id"].(string)
+        b.Transactions = append(b.Transactions, *txFromData(id, tm))
+    }
+    return b, nil
+}
+
+// loadBlocks reads the blocks whose headers are docs.
+func (s *FirestoreStore) loadBlocks(docs []*firestore.DocumentSnapshot) ([]*blockchain.Block, error) {
+    res := make([]*blockchain.Block, 0, len(docs))
+    for _, d := range docs {
+        b, err := s.loadBlock(d)
         if err != nil {
             return nil, err
         }
-        res := make([]*LogRecord, 0, len(docs))
-        for _, d := range docs {
-            m := d.Data()
-            rec := &LogRecord{ID: d.Ref.ID}
-            if v, ok := m["level"].(string); ok { rec.Level = v }
-            if v, ok := m["message"].(string); ok { rec.Message = v }
-            if v, ok := m["meta"].(map[string]interface{}); ok { rec.Meta = v }
-            if v, ok := m["created_at"].(time.Time); ok { rec.CreatedAt = v }
-            res = append(res, rec)
-        }
-        return res, nil
-    }
-    logsMu.RLock()
-    defer logsMu.RUnlock()
-    n := len(Logs)
-    if limit > n { limit = n }
-    res := make([]*LogRecord, 0, limit)
-    for i := n-1; i >= 0 && len(res) < limit; i-- {
-        res = append(res, Logs[i])
+        res = append(res, b)
     }
     return res, nil
 }
 
-// MovePendingToMined moves pending transaction docs into `transactions` collection and deletes pending docs.
-func MovePendingToMined(txIDs []string, blockHash string, blockIndex int64) error {
-    if FSClient == nil {
-        return errors.New("firestore not initialized")
+// AddBlock persists a mined block in Firestore, keyed by index. A different
+// block already at that index is moved to `side_blocks`. Once b's
+// transactions are stored, the headers are swapped in one transaction, so
+// the index is never without a block.
+func (s *FirestoreStore) AddBlock(b *blockchain.Block) error {
+    if err := s.writeBlockTxs(b); err != nil {
+        return err
     }
-    for _, id := range txIDs {
-        doc, err := FSClient.Collection("pending_txs").Doc(id).Get(ctx)
-        if err != nil {
+    ref := s.client.Collection("blocks").Doc(strconv.FormatInt(b.Index, 10))
+    side := s.client.Collection("side_blocks")
+    return s.client.RunTransaction(s.ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+        doc, err := tx.Get(ref)
+        if err != nil && status.Code(err) != codes.NotFound {
             return err
         }
-        data := doc.Data()
-        data["block_hash"] = blockHash
-        data["block_index"] = blockIndex
-        _, err = FSClient.Collection("transactions").Doc(id).Set(ctx, data)
-        if err != nil {
-            return err
+        if err == nil {
+            // the old block's transactions stay under its hash
+            if oldHash, _ := doc.Data()["hash"].(string); oldHash != b.Hash {
+                if err := tx.Set(side.Doc(oldHash), doc.Data()); err != nil {
+                    return err
+                }
+            }
         }
-        // delete pending
-        _, err = FSClient.Collection("pending_txs").Doc(id).Delete(ctx)
-        if err != nil {
+        if err := tx.Set(ref, blockData(b)); err != nil {
             return err
         }
+        return tx.Delete(side.Doc(b.Hash))
+    })
+}
+
+// StoreSideBlock persists a block off the main chain in `side_blocks`, keyed by hash.
+func (s *FirestoreStore) StoreSideBlock(b *blockchain.Block) error {
+    if err := s.writeBlockTxs(b); err != nil {
+        return err
     }
-    return nil
+    _, err := s.client.Collection("side_blocks").Doc(b.Hash).Set(s.ctx, blockData(b))
+    return err
 }
 
-// AddBlock persists a mined block in Firestore.
-func AddBlock(index int64, timestamp time.Time, prevHash, hash, merkle string, txIDs []string) error {
-    if FSClient == nil {
-        return errors.New("firestore not initialized")
-    }
-    _, err := FSClient.Collection("blocks").Doc(strconv.FormatInt(index, 10)).Set(ctx, map[string]interface{}{
-        "index": index,
-        "timestamp": timestamp,
-        "previous_hash": prevHash,
-        "hash": hash,
-        "merkle_root": merkle,
-        // nonce will be added by caller if available
-        // store as int64 when provided
-        // leaving unset is acceptable
-        "transactions": txIDs,
+// ListSideBlocks returns every side block ordered by index.
+func (s *FirestoreStore) ListSideBlocks() ([]*blockchain.Block, error) {
+    docs, err := s.client.Collection("side_blocks").OrderBy("index", firestore.Asc).Documents(s.ctx).GetAll()
+    if err != nil {
+        return nil, err
+    }
+    return s.loadBlocks(docs)
+}
+
+// DisconnectBlock moves the tip block at index from `blocks` to `side_blocks`
+// in one transaction; its transactions stay where they are.
+func (s *FirestoreStore) DisconnectBlock(index int64) error {
+    blocks := s.client.Collection("blocks")
+    return s.client.RunTransaction(s.ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+        tip, err := tx.Documents(blocks.OrderBy("index", firestore.Desc).Limit(1)).GetAll()
+        if err != nil {
+            return err
+        }
+        if len(tip) == 0 || toInt64(tip[0].Data()["index"]) != index {
+            return fmt.Errorf("block %d is not the tip", index)
+        }
+        hash, _ := tip[0].Data()["hash"].(string)
+        if err := tx.Set(s.client.Collection("side_blocks").Doc(hash), tip[0].Data()); err != nil {
+            return err
+        }
+        return tx.Delete(tip[0].Ref)
     })
-    return err
+}
+
+// GetBlockByHash looks a block up on the main chain, then among the side blocks.
+func (s *FirestoreStore) GetBlockByHash(hash string) (*blockchain.Block, error) {
+    docs, err := s.client.Collection("blocks").Where("hash", "==", hash).Limit(1).Documents(s.ctx).GetAll()
+    if err != nil {
+        return nil, err
+    }
+    if len(docs) > 0 {
+        return s.loadBlock(docs[0])
+    }
+    doc, err := s.client.Collection("side_blocks").Doc(hash).Get(s.ctx)
+    if err != nil {
+        return nil, notFound(err, "block "+hash)
+    }
+    return s.loadBlock(doc)
 }
 
 // GetLatestBlock returns the highest-index block stored in Firestore (index and hash).
 // If no blocks exist, returns (0, "", nil).
-func GetLatestBlock() (int64, string, error) {
-    if FSClient == nil {
-        return 0, "", errors.New("firestore not initialized")
-    }
+func (s *FirestoreStore) GetLatestBlock() (int64, string, error) {
     // query blocks ordered by index descending, limit 1
-    q := FSClient.Collection("blocks").OrderBy("index", firestore.Desc).Limit(1)
-    docs, err := q.Documents(ctx).GetAll()
+    q := s.client.Collection("blocks").OrderBy("index", firestore.Desc).Limit(1)
+    docs, err := q.Documents(s.ctx).GetAll()
     if err != nil {
         return 0, "", err
     }
     if len(docs) == 0 {
         return 0, "", nil
     }
-    m := docs[0].Data()
-    // Firestore may store numeric fields as int64 or float64 depending on client library
-    var idx int64
-    switch v := m["index"].(type) {
-    case int64:
-        idx = v
-    case int:
-        idx = int64(v)
-    case float64:
-        idx = int64(v)
-    }
-    h, _ := m["hash"].(string)
-    return idx, h, nil
+    b := blockFromData(docs[0].Data())
+    return b.Index, b.Hash, nil
 }
 
 // GetBlockByIndex retrieves a block document by its index.
-func GetBlockByIndex(index int64) (map[string]interface{}, error) {
-    if FSClient == nil {
-        return nil, errors.New("firestore not initialized")
-    }
-    doc, err := FSClient.Collection("blocks").Doc(strconv.FormatInt(index, 10)).Get(ctx)
+func (s *FirestoreStore) GetBlockByIndex(index int64) (*blockchain.Block, error) {
+    doc, err := s.client.Collection("blocks").Doc(strconv.FormatInt(index, 10)).Get(s.ctx)
     if err != nil {
-        return nil, err
+        return nil, notFound(err, "block "+strconv.FormatInt(index, 10))
     }
-    return doc.Data(), nil
+    return s.loadBlock(doc)
 }
 
 // ListBlocks returns recent blocks ordered by index descending limited by `limit`.
-func ListBlocks(limit int) ([]map[string]interface{}, error) {
-    if FSClient == nil {
-        return nil, errors.New("firestore not initialized")
-    }
+func (s *FirestoreStore) ListBlocks(limit int) ([]*blockchain.Block, error) {
     if limit <= 0 {
         limit = 20
     }
@@ -555,64 +1005,170 @@ func ListBlocks(limit int) ([]map[string]interface{}, error) {
     ctxLocal, cancel := context.WithTimeout(context.Background(), 10*time.Second)
     defer cancel()
 
-    q := FSClient.Collection("blocks").OrderBy("index", firestore.Desc).Limit(limit)
+    q := s.client.Collection("blocks").OrderBy("index", firestore.Desc).Limit(limit)
     docs, err := q.Documents(ctxLocal).GetAll()
     if err != nil {
         return nil, err
     }
-    res := make([]map[string]interface{}, 0, len(docs))
-    for _, d := range docs {
-        res = append(res, d.Data())
-    }
-    return res, nil
+    return s.loadBlocks(docs)
 }
 
-// GetTransactionByID fetches a mined transaction by ID from `transactions` collection.
-func GetTransactionByID(id string) (map[string]interface{}, error) {
-    if FSClient == nil {
-        return nil, errors.New("firestore not initialized")
+// ForEachBlock streams blocks in ascending index order without loading the whole chain.
+func (s *FirestoreStore) ForEachBlock(fn func(*blockchain.Block) error) error {
+    iter := s.client.Collection("blocks").OrderBy("index", firestore.Asc).Documents(s.ctx)
+    defer iter.Stop()
+    for {
+        doc, err := iter.Next()
+        if err == iterator.Done {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        b, err := s.loadBlock(doc)
+        if err != nil {
+            return err
+        }
+        if err := fn(b); err != nil {
+            return err
+        }
     }
-    doc, err := FSClient.Collection("transactions").Doc(id).Get(ctx)
+}
+
+// CreateUser stores a user profile.
+func (s *FirestoreStore) CreateUser(u *User) error {
+    _, err := s.client.Collection("users").Doc(u.ID).Set(s.ctx, map[string]interface{}{
+        "id": u.ID,
+        "name": u.Name,
+        "cnic": u.CNIC,
+        "beneficiaries": u.Beneficiaries,
+        "created_at": u.CreatedAt,
+        "updated_at": u.UpdatedAt,
+    })
+    return err
+}
+
+// GetUser retrieves a user profile by id.
+func (s *FirestoreStore) GetUser(id string) (*User, error) {
+    doc, err := s.client.Collection("users").Doc(id).Get(s.ctx)
     if err != nil {
-        return nil, err
+        return nil, notFound(err, "user "+id)
     }
-    return doc.Data(), nil
+    m := doc.Data()
+    u := &User{ID: id}
+    if v, ok := m["name"].(string); ok { u.Name = v }
+    if v, ok := m["cnic"].(string); ok { u.CNIC = v }
+    u.Beneficiaries = toStrings(m["beneficiaries"])
+    if v, ok := m["created_at"].(time.Time); ok { u.CreatedAt = v }
+    if v, ok := m["updated_at"].(time.Time); ok { u.UpdatedAt = v }
+    return u, nil
 }
 
-// AddTransactionRecord persists a transaction document into `transactions` collection.
-func AddTransactionRecord(t *utxo.Transaction, blockHash string, blockIndex int64) error {
-    if FSClient == nil {
-        return errors.New("firestore not initialized")
+// UpdateUser updates an existing user profile.
+func (s *FirestoreStore) UpdateUser(u *User) error {
+    _, err := s.client.Collection("users").Doc(u.ID).Set(s.ctx, map[string]interface{}{
+        "id": u.ID,
+        "name": u.Name,
+        "cnic": u.CNIC,
+        "beneficiaries": u.Beneficiaries,
+        "updated_at": u.UpdatedAt,
+    }, firestore.MergeAll)
+    return err
+}
+
+// AddLog creates a log record in Firestore.
+func (s *FirestoreStore) AddLog(level, message string, meta map[string]interface{}) error {
+    _, _, err := s.client.Collection("logs").Add(s.ctx, map[string]interface{}{
+        "level": level,
+        "message": message,
+        "meta": meta,
+        "created_at": time.Now().UTC(),
+    })
+    return err
+}
+
+// ListLogs returns recent logs (desc by created_at).
+func (s *FirestoreStore) ListLogs(limit int) ([]*LogRecord, error) {
+    if limit <= 0 {
+        limit = 100
     }
-    data := map[string]interface{}{
-        "id": t.ID,
-        "sender": t.Sender,
-        "receiver": t.Receiver,
-        "amount": t.Amount,
-        "note": t.Note,
-        "timestamp": t.Timestamp,
-        "sender_public_key": t.SenderPublicKey,
-        "inputs": t.Inputs,
-        "outputs": t.Outputs,
-        "block_hash": blockHash,
-        "block_index": blockIndex,
+    q := s.client.Collection("logs").OrderBy("created_at", firestore.Desc).Limit(limit)
+    docs, err := q.Documents(s.ctx).GetAll()
+    if err != nil {
+        return nil, err
+    }
+    res := make([]*LogRecord, 0, len(docs))
+    for _, d := range docs {
+        m := d.Data()
+        rec := &LogRecord{ID: d.Ref.ID}
+        if v, ok := m["level"].(string); ok { rec.Level = v }
+        if v, ok := m["message"].(string); ok { rec.Message = v }
+        if v, ok := m["meta"].(map[string]interface{}); ok { rec.Meta = v }
+        if v, ok := m["created_at"].(time.Time); ok { rec.CreatedAt = v }
+        res = append(res, rec)
     }
-    _, err := FSClient.Collection("transactions").Doc(t.ID).Set(ctx, data)
+    return res, nil
+}
+
+// AddZakatRecord stores a zakat deduction record.
+func (s *FirestoreStore) AddZakatRecord(walletID string, amount int64, txID string) error {
+    _, err := s.client.Collection("zakat_deductions").NewDoc().Set(s.ctx, map[string]interface{}{
+        "wallet_id": walletID,
+        "amount": amount,
+        "tx_id": txID,
+        "created_at": time.Now().UTC(),
+    })
+    return err
+}
+
+// SavePartialTx stores a partially-signed multisig transaction under its txid.
+func (s *FirestoreStore) SavePartialTx(p *PartialTx) error {
+    data := txData(&p.Tx)
+    data["threshold"] = p.Threshold
+    data["created_by"] = p.CreatedBy
+    data["created_at"] = p.CreatedAt
+    data["updated_at"] = p.UpdatedAt
+    _, err := s.client.Collection("partial_txs").Doc(p.Tx.ID).Set(s.ctx, data)
     return err
 }
 
-// GetAllTransactions fetches all transactions from the transactions collection.
-func GetAllTransactions() ([]interface{}, error) {
-    if FSClient == nil {
-        return []interface{}{}, nil
+func partialTxFromData(id string, m map[string]interface{}) *PartialTx {
+    p := &PartialTx{Tx: *txFromData(id, m), Threshold: int(toInt64(m["threshold"]))}
+    if v, ok := m["created_by"].(string); ok { p.CreatedBy = v }
+    if v, ok := m["created_at"].(time.Time); ok { p.CreatedAt = v }
+    if v, ok := m["updated_at"].(time.Time); ok { p.UpdatedAt = v }
+    return p
+}
+
+// GetPartialTx fetches a partially-signed transaction by txid.
+func (s *FirestoreStore) GetPartialTx(id string) (*PartialTx, error) {
+    doc, err := s.client.Collection("partial_txs").Doc(id).Get(s.ctx)
+    if err != nil {
+        return nil, notFound(err, "partial tx "+id)
     }
-    docs, err := FSClient.Collection("transactions").Documents(ctx).GetAll()
+    return partialTxFromData(id, doc.Data()), nil
+}
+
+// ListPartialTxs returns a wallet's partially-signed transactions, oldest first.
+func (s *FirestoreStore) ListPartialTxs(walletID string) ([]*PartialTx, error) {
+    docs, err := s.client.Collection("partial_txs").Where("sender", "==", walletID).Documents(s.ctx).GetAll()
     if err != nil {
         return nil, err
     }
-    result := make([]interface{}, 0, len(docs))
-    for _, doc := range docs {
-        result = append(result, doc.Data())
+    res := make([]*PartialTx, 0, len(docs))
+    for _, d := range docs {
+        res = append(res, partialTxFromData(d.Ref.ID, d.Data()))
     }
-    return result, nil
+    sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+    return res, nil
+}
+
+// DeletePartialTx removes a partially-signed transaction.
+func (s *FirestoreStore) DeletePartialTx(id string) error {
+    ref := s.client.Collection("partial_txs").Doc(id)
+    if _, err := ref.Get(s.ctx); err != nil {
+        return notFound(err, "partial tx "+id)
+    }
+    _, err := ref.Delete(s.ctx)
+    return err
 }
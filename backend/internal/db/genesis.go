@@ -0,0 +1,48 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+)
+
+// ErrGenesisMismatch is returned by EnsureGenesis when the store holds a
+// different chain than the configured params describe.
+var ErrGenesisMismatch = errors.New("stored genesis block does not match chain params")
+
+// EnsureGenesis checks the store's chain starts with genesis. An empty store
+// is initialised with it: the block, its allocation transaction and the
+// allocations' UTXOs. A store whose block 0 has another hash, or that has
+// blocks but no genesis at all, yields ErrGenesisMismatch.
+func EnsureGenesis(s Store, genesis *blockchain.Block) error {
+	stored, err := s.GetBlockByIndex(0)
+	switch {
+	case err == nil:
+		if stored.Hash != genesis.Hash {
+			return fmt.Errorf("%w: store has %s, params give %s", ErrGenesisMismatch, stored.Hash, genesis.Hash)
+		}
+		return nil
+	case !errors.Is(err, ErrNotFound):
+		return err
+	}
+
+	if index, hash, err := s.GetLatestBlock(); err != nil {
+		return err
+	} else if hash != "" {
+		return fmt.Errorf("%w: store has blocks up to %d but no genesis block", ErrGenesisMismatch, index)
+	}
+	// the block goes in last: once it exists, initialisation is complete
+	for i := range genesis.Transactions {
+		t := &genesis.Transactions[i]
+		if err := s.AddTransactionRecord(t, genesis.Hash, 0); err != nil {
+			return err
+		}
+		for _, u := range t.OutputUTXOs() {
+			if err := s.CreateUTXO(u); err != nil {
+				return err
+			}
+		}
+	}
+	return s.AddBlock(genesis)
+}
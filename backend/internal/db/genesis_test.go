@@ -0,0 +1,60 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/chainparams"
+)
+
+func TestEnsureGenesis(t *testing.T) {
+	p := chainparams.Default()
+	p.Allocations = []chainparams.Allocation{{Wallet: "alice", Amount: 1000}, {Wallet: "bob", Amount: 50}}
+	genesis := p.Genesis()
+	p.ChainID = "other-net"
+	other := p.Genesis()
+
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			st := s.open(t)
+			defer st.Close()
+			if err := EnsureGenesis(st, genesis); err != nil {
+				t.Fatal(err)
+			}
+			if _, hash, _ := st.GetLatestBlock(); hash != genesis.Hash {
+				t.Fatalf("tip %s, want the genesis block", hash)
+			}
+			if _, err := st.GetTransactionByID(genesis.Transactions[0].ID); err != nil {
+				t.Errorf("allocation tx: %v", err)
+			}
+			for wallet, want := range map[string]int64{"alice": 1000, "bob": 50} {
+				if us, _ := st.GetUnspentUTXOsByWallet(wallet); len(us) != 1 || us[0].Amount != want {
+					t.Errorf("%s holds %+v, want one output of %d", wallet, us, want)
+				}
+			}
+
+			// the same genesis again is a no-op
+			if err := EnsureGenesis(st, genesis); err != nil {
+				t.Fatal(err)
+			}
+			if us, _ := st.GetUnspentUTXOsByWallet("alice"); len(us) != 1 {
+				t.Errorf("alice holds %d outputs after a second call", len(us))
+			}
+			if err := EnsureGenesis(st, other); !errors.Is(err, ErrGenesisMismatch) {
+				t.Errorf("other genesis: err = %v, want ErrGenesisMismatch", err)
+			}
+		})
+	}
+
+	// blocks without a genesis block are some other chain too
+	st := NewMemoryStore()
+	b := blockchain.NewBlockTemplate(1, genesis.Hash, genesis.Bits, nil)
+	b.Hash = b.ComputeHash()
+	if err := st.AddBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnsureGenesis(st, genesis); !errors.Is(err, ErrGenesisMismatch) {
+		t.Errorf("store without genesis: err = %v, want ErrGenesisMismatch", err)
+	}
+}
@@ -0,0 +1,754 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+// memState is the complete dataset held by a MemoryStore. It is also the
+// on-disk format of the FileStore, so every field must round-trip through JSON.
+type memState struct {
+	Wallets      map[string]*WalletRecord     `json:"wallets"`
+	UTXOs        map[string]*utxo.UTXO        `json:"utxos"`
+	Pending      map[string]*PendingRecord    `json:"pending_txs"`
+	Transactions map[string]*TxRecord         `json:"transactions"`
+	Blocks       map[int64]*blockchain.Block  `json:"blocks"`
+	SideBlocks   map[string]*blockchain.Block `json:"side_blocks"`
+	Users        map[string]*User             `json:"users"`
+	Logs         []*LogRecord                 `json:"logs,omitempty"` // the FileStore keeps them in a file of their own
+	Zakat        []*ZakatRecord               `json:"zakat_deductions"`
+	PartialTxs   map[string]*PartialTx        `json:"partial_txs"`
+
+	// blockIndexes maps main-chain block hashes to their index; rebuilt by fill.
+	blockIndexes map[string]int64
+}
+
+func newMemState() memState {
+	return memState{
+		Wallets:      map[string]*WalletRecord{},
+		UTXOs:        map[string]*utxo.UTXO{},
+		Pending:      map[string]*PendingRecord{},
+		Transactions: map[string]*TxRecord{},
+		Blocks:       map[int64]*blockchain.Block{},
+		SideBlocks:   map[string]*blockchain.Block{},
+		Users:        map[string]*User{},
+		Logs:         []*LogRecord{},
+		Zakat:        []*ZakatRecord{},
+		PartialTxs:   map[string]*PartialTx{},
+		blockIndexes: map[string]int64{},
+	}
+}
+
+// fill replaces nil collections (e.g. from an older or partial file) with empty ones.
+func (s *memState) fill() {
+	empty := newMemState()
+	if s.Wallets == nil {
+		s.Wallets = empty.Wallets
+	}
+	if s.UTXOs == nil {
+		s.UTXOs = empty.UTXOs
+	}
+	if s.Pending == nil {
+		s.Pending = empty.Pending
+	}
+	if s.Transactions == nil {
+		s.Transactions = empty.Transactions
+	}
+	if s.Blocks == nil {
+		s.Blocks = empty.Blocks
+	}
+	if s.SideBlocks == nil {
+		s.SideBlocks = empty.SideBlocks
+	}
+	if s.Users == nil {
+		s.Users = empty.Users
+	}
+	if s.Logs == nil {
+		s.Logs = empty.Logs
+	}
+	if s.Zakat == nil {
+		s.Zakat = empty.Zakat
+	}
+	if s.PartialTxs == nil {
+		s.PartialTxs = empty.PartialTxs
+	}
+	s.blockIndexes = make(map[string]int64, len(s.Blocks))
+	for i, b := range s.Blocks {
+		s.blockIndexes[b.Hash] = i
+	}
+}
+
+// MemoryStore keeps all state in process memory. It is used for local
+// development and tests, and as the working set of the FileStore.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	state memState
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: newMemState()}
+}
+
+func (m *MemoryStore) Backend() string { return "memory" }
+
+func (m *MemoryStore) Close() error { return nil }
+
+// copies handed out to callers so they can't mutate store state without the lock
+
+func copyUTXO(u *utxo.UTXO) *utxo.UTXO {
+	c := *u
+	return &c
+}
+
+func copyTx(t *utxo.Transaction) *utxo.Transaction {
+	c := *t
+	c.Inputs = append([]string(nil), t.Inputs...)
+	c.Outputs = append([]utxo.TxOutput(nil), t.Outputs...)
+	c.Signature = append([]byte(nil), t.Signature...)
+	c.Signatures = append([]utxo.TxSignature(nil), t.Signatures...)
+	c.Preimages = append([]string(nil), t.Preimages...)
+	return &c
+}
+
+func copyUser(u *User) *User {
+	c := *u
+	c.Beneficiaries = append([]string(nil), u.Beneficiaries...)
+	return &c
+}
+
+func (m *MemoryStore) RegisterWallet(walletID, publicKeyB64 string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec := &WalletRecord{
+		WalletID:  walletID,
+		PublicKey: publicKeyB64,
+		CreatedAt: time.Now().UTC(),
+	}
+	// re-registering must not reset the nonce, or old transactions could be replayed
+	if old, ok := m.state.Wallets[walletID]; ok {
+		rec.Nonce = old.Nonce
+	}
+	m.state.Wallets[walletID] = rec
+	return nil
+}
+
+func (m *MemoryStore) RegisterWallets(recs []*WalletRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range recs {
+		if old, ok := m.state.Wallets[r.WalletID]; ok && old.OwnerUID != "" && old.OwnerUID != r.OwnerUID {
+			return fmt.Errorf("wallet %s: %w", r.WalletID, ErrWalletOwned)
+		}
+	}
+	now := time.Now().UTC()
+	for _, r := range recs {
+		rec := *r
+		rec.CreatedAt = now
+		rec.Nonce = 0
+		if old, ok := m.state.Wallets[r.WalletID]; ok {
+			rec.CreatedAt, rec.Nonce = old.CreatedAt, old.Nonce
+		}
+		m.state.Wallets[r.WalletID] = &rec
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListWalletsByOwner(uid string) ([]*WalletRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []*WalletRecord{}
+	for _, w := range m.state.Wallets {
+		if w.OwnerUID == uid {
+			c := *w
+			res = append(res, &c)
+		}
+	}
+	sortWalletRecords(res)
+	return res, nil
+}
+
+func (m *MemoryStore) GetWalletPublicKey(walletID string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	w, ok := m.state.Wallets[walletID]
+	if !ok {
+		return "", fmt.Errorf("wallet %s: %w", walletID, ErrNotFound)
+	}
+	return w.PublicKey, nil
+}
+
+func (m *MemoryStore) GetWalletNonce(walletID string) (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	w, ok := m.state.Wallets[walletID]
+	if !ok {
+		return 0, fmt.Errorf("wallet %s: %w", walletID, ErrNotFound)
+	}
+	return w.Nonce, nil
+}
+
+func (m *MemoryStore) ListAllWalletIDs() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := make([]string, 0, len(m.state.Wallets))
+	for id := range m.state.Wallets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (m *MemoryStore) CreateUTXO(u *utxo.UTXO) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.UTXOs[u.ID] = copyUTXO(u)
+	return nil
+}
+
+func (m *MemoryStore) GetUTXOByID(id string) (*utxo.UTXO, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.state.UTXOs[id]
+	if !ok {
+		return nil, fmt.Errorf("utxo %s: %w", id, ErrNotFound)
+	}
+	return copyUTXO(u), nil
+}
+
+func (m *MemoryStore) GetUnspentUTXOsByWallet(walletID string) ([]utxo.UTXO, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var res []utxo.UTXO
+	for _, u := range m.state.UTXOs {
+		if u.WalletID == walletID && !u.Spent {
+			res = append(res, *u)
+		}
+	}
+	// deterministic order so greedy input selection is stable
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+	return res, nil
+}
+
+func (m *MemoryStore) ForEachUTXO(fn func(*utxo.UTXO) error) error {
+	m.mu.RLock()
+	list := make([]utxo.UTXO, 0, len(m.state.UTXOs))
+	for _, u := range m.state.UTXOs {
+		list = append(list, *u)
+	}
+	m.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	for i := range list {
+		if err := fn(&list[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) MarkUTXOSpent(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.state.UTXOs[id]
+	if !ok {
+		return fmt.Errorf("utxo %s: %w", id, ErrNotFound)
+	}
+	u.Spent = true
+	return nil
+}
+
+func (m *MemoryStore) AddPendingTx(t *utxo.Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Pending[t.ID] = newPending(t)
+	return nil
+}
+
+// newPending returns the pending record of t, accepted now.
+func newPending(t *utxo.Transaction) *PendingRecord {
+	return &PendingRecord{Transaction: *copyTx(t), AcceptedAt: time.Now().UTC()}
+}
+
+// CreatePendingTxAtomic verifies and spends the inputs, creates the outputs and
+// records the pending transaction under a single lock, so concurrent senders
+// cannot double-spend the same inputs or reuse a nonce.
+func (m *MemoryStore) CreatePendingTxAtomic(t *utxo.Transaction, inputIDs []string, outputs []*utxo.UTXO) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var wallet *WalletRecord
+	if t.SenderPublicKey != "" {
+		var ok bool
+		if wallet, ok = m.state.Wallets[t.Sender]; !ok {
+			return fmt.Errorf("wallet %s: %w", t.Sender, ErrNotFound)
+		}
+		if err := CheckNonce(wallet.Nonce, t.Nonce); err != nil {
+			return fmt.Errorf("wallet %s nonce %d: %w", t.Sender, t.Nonce, err)
+		}
+	}
+	for _, id := range inputIDs {
+		u, ok := m.state.UTXOs[id]
+		if !ok {
+			return fmt.Errorf("input utxo not found: %s: %w", id, ErrNotFound)
+		}
+		if u.Spent {
+			return fmt.Errorf("input utxo already spent: %s", id)
+		}
+		// a conditioned output's spender was checked against its condition
+		if u.WalletID != t.Sender && u.Condition == nil {
+			return fmt.Errorf("input utxo does not belong to sender: %s", id)
+		}
+	}
+	if wallet != nil {
+		wallet.Nonce = t.Nonce
+	}
+	for _, id := range inputIDs {
+		m.state.UTXOs[id].Spent = true
+	}
+	for _, out := range outputs {
+		m.state.UTXOs[out.ID] = copyUTXO(out)
+	}
+	m.state.Pending[t.ID] = newPending(t)
+	return nil
+}
+
+func (m *MemoryStore) DropPendingTx(txID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.state.Pending[txID]
+	if !ok {
+		return fmt.Errorf("pending tx %s: %w", txID, ErrNotFound)
+	}
+	for i := range t.Outputs {
+		if u, ok := m.state.UTXOs[t.OutputUTXOID(i)]; ok && u.Spent {
+			return fmt.Errorf("output %s of pending tx %s already spent", u.ID, txID)
+		}
+	}
+	for i := range t.Outputs {
+		delete(m.state.UTXOs, t.OutputUTXOID(i))
+	}
+	for _, id := range t.Inputs {
+		if u, ok := m.state.UTXOs[id]; ok {
+			u.Spent = false
+		}
+	}
+	delete(m.state.Pending, txID)
+	return nil
+}
+
+func (m *MemoryStore) GetAllPendingTxIDs() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := make([]string, 0, len(m.state.Pending))
+	for id := range m.state.Pending {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (m *MemoryStore) ListPendingTxs() ([]*PendingRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*PendingRecord, 0, len(m.state.Pending))
+	for _, r := range m.state.Pending {
+		res = append(res, &PendingRecord{Transaction: *copyTx(&r.Transaction), AcceptedAt: r.AcceptedAt})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Timestamp.Before(res[j].Timestamp) })
+	return res, nil
+}
+
+func (m *MemoryStore) MovePendingToMined(txIDs []string, blockHash string, blockIndex int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range txIDs {
+		_, pending := m.state.Pending[id]
+		if _, mined := m.state.Transactions[id]; !pending && !mined {
+			return fmt.Errorf("pending tx %s: %w", id, ErrNotFound)
+		}
+	}
+	for _, id := range txIDs {
+		r, ok := m.state.Pending[id]
+		if !ok {
+			continue
+		}
+		m.state.Transactions[id] = &TxRecord{Transaction: r.Transaction, BlockHash: blockHash, BlockIndex: blockIndex}
+		delete(m.state.Pending, id)
+	}
+	return nil
+}
+
+func (m *MemoryStore) MoveMinedToPending(txIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range txIDs {
+		_, mined := m.state.Transactions[id]
+		if _, pending := m.state.Pending[id]; !mined && !pending {
+			return fmt.Errorf("transaction %s: %w", id, ErrNotFound)
+		}
+	}
+	for _, id := range txIDs {
+		r, ok := m.state.Transactions[id]
+		if !ok {
+			continue
+		}
+		m.state.Pending[id] = newPending(&r.Transaction)
+		delete(m.state.Transactions, id)
+	}
+	return nil
+}
+
+// ConfirmTx applies a transaction this node first sees in a block: its inputs
+// are spent, its outputs created and the confirmed record written together.
+func (m *MemoryStore) ConfirmTx(t *utxo.Transaction, blockHash string, blockIndex int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range t.Inputs {
+		u, ok := m.state.UTXOs[id]
+		if !ok {
+			return fmt.Errorf("input utxo not found: %s: %w", id, ErrNotFound)
+		}
+		if u.Spent {
+			return fmt.Errorf("input utxo already spent: %s", id)
+		}
+	}
+	for _, id := range t.Inputs {
+		m.state.UTXOs[id].Spent = true
+	}
+	for _, out := range t.OutputUTXOs() {
+		m.state.UTXOs[out.ID] = out
+	}
+	if w, ok := m.state.Wallets[t.Sender]; ok && t.SenderPublicKey != "" && t.Nonce > w.Nonce {
+		w.Nonce = t.Nonce
+	}
+	m.state.Transactions[t.ID] = &TxRecord{Transaction: *copyTx(t), BlockHash: blockHash, BlockIndex: blockIndex}
+	return nil
+}
+
+func (m *MemoryStore) RevertTx(txID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.state.Transactions[txID]
+	if !ok {
+		return fmt.Errorf("transaction %s: %w", txID, ErrNotFound)
+	}
+	t := &r.Transaction
+	for i := range t.Outputs {
+		if u, ok := m.state.UTXOs[t.OutputUTXOID(i)]; ok && u.Spent {
+			return fmt.Errorf("output %s of transaction %s already spent", u.ID, txID)
+		}
+	}
+	for i := range t.Outputs {
+		delete(m.state.UTXOs, t.OutputUTXOID(i))
+	}
+	for _, id := range t.Inputs {
+		if u, ok := m.state.UTXOs[id]; ok {
+			u.Spent = false
+		}
+	}
+	delete(m.state.Transactions, txID)
+	return nil
+}
+
+func (m *MemoryStore) AddTransactionRecord(t *utxo.Transaction, blockHash string, blockIndex int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Transactions[t.ID] = &TxRecord{Transaction: *copyTx(t), BlockHash: blockHash, BlockIndex: blockIndex}
+	return nil
+}
+
+func (m *MemoryStore) GetTransactionByID(id string) (*TxRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.state.Transactions[id]
+	if !ok {
+		return nil, fmt.Errorf("transaction %s: %w", id, ErrNotFound)
+	}
+	return &TxRecord{Transaction: *copyTx(&r.Transaction), BlockHash: r.BlockHash, BlockIndex: r.BlockIndex}, nil
+}
+
+func (m *MemoryStore) GetAllTransactions() ([]*TxRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*TxRecord, 0, len(m.state.Transactions))
+	for _, r := range m.state.Transactions {
+		res = append(res, &TxRecord{Transaction: *copyTx(&r.Transaction), BlockHash: r.BlockHash, BlockIndex: r.BlockIndex})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Timestamp.Before(res[j].Timestamp) })
+	return res, nil
+}
+
+func copyBlock(b *blockchain.Block) *blockchain.Block {
+	c := *b
+	c.Transactions = make([]utxo.Transaction, 0, len(b.Transactions))
+	for i := range b.Transactions {
+		c.Transactions = append(c.Transactions, *copyTx(&b.Transactions[i]))
+	}
+	return &c
+}
+
+// AddBlock puts b on the main chain at b.Index. A different block already at
+// that index becomes a side block.
+func (m *MemoryStore) AddBlock(b *blockchain.Block) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.state.Blocks[b.Index]; ok && old.Hash != b.Hash {
+		m.state.SideBlocks[old.Hash] = old
+		delete(m.state.blockIndexes, old.Hash)
+	}
+	delete(m.state.SideBlocks, b.Hash)
+	m.state.Blocks[b.Index] = copyBlock(b)
+	m.state.blockIndexes[b.Hash] = b.Index
+	return nil
+}
+
+func (m *MemoryStore) StoreSideBlock(b *blockchain.Block) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.state.blockIndexes[b.Hash]; ok {
+		return nil
+	}
+	m.state.SideBlocks[b.Hash] = copyBlock(b)
+	return nil
+}
+
+func (m *MemoryStore) ListSideBlocks() ([]*blockchain.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*blockchain.Block, 0, len(m.state.SideBlocks))
+	for _, b := range m.state.SideBlocks {
+		res = append(res, copyBlock(b))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Index < res[j].Index })
+	return res, nil
+}
+
+func (m *MemoryStore) DisconnectBlock(index int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.state.Blocks[index]
+	if !ok {
+		return fmt.Errorf("block %d: %w", index, ErrNotFound)
+	}
+	for i := range m.state.Blocks {
+		if i > index {
+			return fmt.Errorf("block %d is not the tip", index)
+		}
+	}
+	m.state.SideBlocks[b.Hash] = b
+	delete(m.state.blockIndexes, b.Hash)
+	delete(m.state.Blocks, index)
+	return nil
+}
+
+func (m *MemoryStore) GetBlockByHash(hash string) (*blockchain.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if i, ok := m.state.blockIndexes[hash]; ok {
+		return copyBlock(m.state.Blocks[i]), nil
+	}
+	if b, ok := m.state.SideBlocks[hash]; ok {
+		return copyBlock(b), nil
+	}
+	return nil, fmt.Errorf("block %s: %w", hash, ErrNotFound)
+}
+
+func (m *MemoryStore) GetLatestBlock() (int64, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var best *blockchain.Block
+	for _, b := range m.state.Blocks {
+		if best == nil || b.Index > best.Index {
+			best = b
+		}
+	}
+	if best == nil {
+		return 0, "", nil
+	}
+	return best.Index, best.Hash, nil
+}
+
+func (m *MemoryStore) GetBlockByIndex(index int64) (*blockchain.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.state.Blocks[index]
+	if !ok {
+		return nil, fmt.Errorf("block %d: %w", index, ErrNotFound)
+	}
+	return copyBlock(b), nil
+}
+
+func (m *MemoryStore) ListBlocks(limit int) ([]*blockchain.Block, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*blockchain.Block, 0, len(m.state.Blocks))
+	for _, b := range m.state.Blocks {
+		res = append(res, copyBlock(b))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Index > res[j].Index })
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (m *MemoryStore) ForEachBlock(fn func(*blockchain.Block) error) error {
+	m.mu.RLock()
+	indexes := make([]int64, 0, len(m.state.Blocks))
+	for i := range m.state.Blocks {
+		indexes = append(indexes, i)
+	}
+	m.mu.RUnlock()
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	// fetch one block at a time so fn runs without holding the lock
+	for _, i := range indexes {
+		b, err := m.GetBlockByIndex(i)
+		if err != nil {
+			return err
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateUser(u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Users[u.ID] = copyUser(u)
+	return nil
+}
+
+func (m *MemoryStore) GetUser(id string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.state.Users[id]
+	if !ok {
+		return nil, fmt.Errorf("user %s: %w", id, ErrNotFound)
+	}
+	return copyUser(u), nil
+}
+
+func (m *MemoryStore) UpdateUser(u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.state.Users[u.ID]
+	c := copyUser(u)
+	if ok && c.CreatedAt.IsZero() {
+		c.CreatedAt = existing.CreatedAt
+	}
+	m.state.Users[u.ID] = c
+	return nil
+}
+
+func (m *MemoryStore) AddLog(level, message string, meta map[string]interface{}) error {
+	return m.addLog(level, message, meta, nil)
+}
+
+// addLog appends a log record. If write is set, it is called with the new
+// record first, under the lock so records are written in ID order, and the
+// record is only kept if it succeeds.
+func (m *MemoryStore) addLog(level, message string, meta map[string]interface{}, write func(*LogRecord) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec := &LogRecord{
+		ID:        strconv.Itoa(len(m.state.Logs) + 1),
+		Level:     level,
+		Message:   message,
+		Meta:      meta,
+		CreatedAt: time.Now().UTC(),
+	}
+	if write != nil {
+		if err := write(rec); err != nil {
+			return err
+		}
+	}
+	m.state.Logs = append(m.state.Logs, rec)
+	return nil
+}
+
+// ListLogs returns the most recent logs first.
+func (m *MemoryStore) ListLogs(limit int) ([]*LogRecord, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n := len(m.state.Logs)
+	if limit > n {
+		limit = n
+	}
+	res := make([]*LogRecord, 0, limit)
+	for i := n - 1; i >= 0 && len(res) < limit; i-- {
+		c := *m.state.Logs[i]
+		res = append(res, &c)
+	}
+	return res, nil
+}
+
+func (m *MemoryStore) AddZakatRecord(walletID string, amount int64, txID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Zakat = append(m.state.Zakat, &ZakatRecord{
+		WalletID:  walletID,
+		Amount:    amount,
+		TxID:      txID,
+		CreatedAt: time.Now().UTC(),
+	})
+	return nil
+}
+
+func copyPartialTx(p *PartialTx) *PartialTx {
+	c := *p
+	c.Tx = *copyTx(&p.Tx)
+	return &c
+}
+
+func (m *MemoryStore) SavePartialTx(p *PartialTx) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.PartialTxs[p.Tx.ID] = copyPartialTx(p)
+	return nil
+}
+
+func (m *MemoryStore) GetPartialTx(id string) (*PartialTx, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.state.PartialTxs[id]
+	if !ok {
+		return nil, fmt.Errorf("partial tx %s: %w", id, ErrNotFound)
+	}
+	return copyPartialTx(p), nil
+}
+
+func (m *MemoryStore) ListPartialTxs(walletID string) ([]*PartialTx, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []*PartialTx{}
+	for _, p := range m.state.PartialTxs {
+		if p.Tx.Sender == walletID {
+			res = append(res, copyPartialTx(p))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+	return res, nil
+}
+
+func (m *MemoryStore) DeletePartialTx(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.state.PartialTxs[id]; !ok {
+		return fmt.Errorf("partial tx %s: %w", id, ErrNotFound)
+	}
+	delete(m.state.PartialTxs, id)
+	return nil
+}
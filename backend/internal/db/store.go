@@ -0,0 +1,255 @@
+package db
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+// ErrNotFound is returned by Store lookups when the requested record does not exist.
+var ErrNotFound = errors.New("not found")
+
+var (
+	// ErrReplayedTx is returned by CreatePendingTxAtomic when a signed
+	// transaction reuses a nonce the sender wallet has already consumed.
+	ErrReplayedTx = errors.New("transaction replayed: nonce already used")
+	// ErrNonceGap is returned when a signed transaction skips ahead of the
+	// sender wallet's next nonce.
+	ErrNonceGap = errors.New("nonce out of sequence")
+	// ErrWalletOwned is returned by RegisterWallets for a wallet another user already registered.
+	ErrWalletOwned = errors.New("wallet is registered to another user")
+)
+
+// CheckNonce reports whether next is the nonce that follows last.
+func CheckNonce(last, next uint64) error {
+	switch {
+	case next <= last:
+		return ErrReplayedTx
+	case next != last+1:
+		return ErrNonceGap
+	}
+	return nil
+}
+
+// Store is the persistence layer used by the API handlers. Every backend
+// (Firestore, in-memory, single-file on disk) implements the full interface so
+// the node behaves the same regardless of where its state lives.
+type Store interface {
+	// Backend returns a short name for the storage backend ("firestore", "memory", "file").
+	Backend() string
+	// Close releases any resources held by the store.
+	Close() error
+
+	// Wallets
+	RegisterWallet(walletID, publicKeyB64 string) error
+	GetWalletPublicKey(walletID string) (string, error)
+	// GetWalletNonce returns the last nonce accepted from the wallet (0 if none).
+	GetWalletNonce(walletID string) (uint64, error)
+	ListAllWalletIDs() ([]string, error)
+	// RegisterWallets registers a batch of wallets to their owners, all or
+	// none. Re-registering keeps a wallet's nonce; a wallet owned by another
+	// user fails the batch with ErrWalletOwned.
+	RegisterWallets(recs []*WalletRecord) error
+	// ListWalletsByOwner returns the wallets registered to a user, ordered by path.
+	ListWalletsByOwner(uid string) ([]*WalletRecord, error)
+
+	// UTXOs
+	CreateUTXO(u *utxo.UTXO) error
+	GetUTXOByID(id string) (*utxo.UTXO, error)
+	// GetUnspentUTXOsByWallet returns a wallet's unspent outputs, oldest first.
+	GetUnspentUTXOsByWallet(walletID string) ([]utxo.UTXO, error)
+	MarkUTXOSpent(id string) error
+	// ForEachUTXO streams every stored output, spent or not, stopping at the first error fn returns.
+	ForEachUTXO(fn func(*utxo.UTXO) error) error
+
+	// Pending transactions
+	AddPendingTx(t *utxo.Transaction) error
+	// CreatePendingTxAtomic spends inputIDs, creates outputs and records t as
+	// pending in one atomic step. Signed transactions (non-empty
+	// SenderPublicKey) must also carry the sender's next nonce, which is
+	// consumed in the same step; see CheckNonce.
+	CreatePendingTxAtomic(t *utxo.Transaction, inputIDs []string, outputs []*utxo.UTXO) error
+	// DropPendingTx undoes CreatePendingTxAtomic for a tx leaving the mempool
+	// unmined (evicted or expired): its outputs are deleted, its inputs become
+	// unspent again and the pending record is removed. It fails if an output
+	// has already been spent, so descendants must be dropped first.
+	DropPendingTx(txID string) error
+	// GetAllPendingTxIDs returns the pending txids in ascending order.
+	GetAllPendingTxIDs() ([]string, error)
+	// ListPendingTxs returns the pending transactions, oldest first. Each was
+	// accepted when AddPendingTx, CreatePendingTxAtomic or MoveMinedToPending
+	// recorded it.
+	ListPendingTxs() ([]*PendingRecord, error)
+	// MovePendingToMined records pending transactions as mined in a block.
+	// If one is neither pending nor mined, none are moved. Ones already mined
+	// are skipped: a backend that moves a large batch in several steps can
+	// fail part way, and calling it again with the same txids finishes the
+	// move.
+	MovePendingToMined(txIDs []string, blockHash string, blockIndex int64) error
+	// MoveMinedToPending undoes MovePendingToMined for the transactions of a
+	// block taken off the main chain; their inputs stay spent and their
+	// outputs stay in place, as for any pending tx. Like MovePendingToMined,
+	// it skips ones already pending and can be repeated after a failure.
+	MoveMinedToPending(txIDs []string) error
+
+	// Confirmed transactions
+	AddTransactionRecord(t *utxo.Transaction, blockHash string, blockIndex int64) error
+	GetTransactionByID(id string) (*TxRecord, error)
+	// GetAllTransactions returns the confirmed transactions, oldest first.
+	GetAllTransactions() ([]*TxRecord, error)
+	// ConfirmTx records a transaction first seen in a block (a coinbase, or
+	// one that never reached this node's mempool): it spends the inputs,
+	// creates the outputs and writes the confirmed record in one step. A
+	// signed tx raises its sender's nonce to t.Nonce if that is higher.
+	ConfirmTx(t *utxo.Transaction, blockHash string, blockIndex int64) error
+	// RevertTx undoes ConfirmTx. It fails if an output has already been spent.
+	RevertTx(txID string) error
+
+	// Blocks
+	// AddBlock persists the full block: header (including nonce, difficulty and version) and transactions.
+	// The block goes on the main chain at b.Index; whatever block was there becomes a side block.
+	AddBlock(b *blockchain.Block) error
+	// StoreSideBlock keeps a block that is not on the main chain, e.g. one
+	// extending a competing branch. Main-chain blocks are left where they are.
+	StoreSideBlock(b *blockchain.Block) error
+	// ListSideBlocks returns every block off the main chain in ascending index order.
+	ListSideBlocks() ([]*blockchain.Block, error)
+	// DisconnectBlock moves the main chain's tip, which must be at index, to the side blocks.
+	DisconnectBlock(index int64) error
+	// GetBlockByHash finds a block on the main chain or among the side blocks.
+	GetBlockByHash(hash string) (*blockchain.Block, error)
+	GetLatestBlock() (int64, string, error)
+	GetBlockByIndex(index int64) (*blockchain.Block, error)
+	ListBlocks(limit int) ([]*blockchain.Block, error)
+	// ForEachBlock streams every block in ascending index order, stopping at the first error fn returns.
+	ForEachBlock(fn func(*blockchain.Block) error) error
+
+	// Users
+	CreateUser(u *User) error
+	GetUser(id string) (*User, error)
+	UpdateUser(u *User) error
+
+	// Logs
+	AddLog(level, message string, meta map[string]interface{}) error
+	ListLogs(limit int) ([]*LogRecord, error)
+
+	// Zakat
+	AddZakatRecord(walletID string, amount int64, txID string) error
+
+	// Partially-signed multisig transactions
+	// SavePartialTx inserts or replaces a partial transaction, keyed by its txid.
+	SavePartialTx(p *PartialTx) error
+	GetPartialTx(id string) (*PartialTx, error)
+	// ListPartialTxs returns a wallet's partial transactions, oldest first.
+	ListPartialTxs(walletID string) ([]*PartialTx, error)
+	DeletePartialTx(id string) error
+}
+
+// User is a user profile keyed by the Firebase UID.
+type User struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	CNIC          string    `json:"cnic"`
+	Beneficiaries []string  `json:"beneficiaries"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// LogRecord is a structured server-side log entry.
+type LogRecord struct {
+	ID        string                 `json:"id"`
+	Level     string                 `json:"level"`
+	Message   string                 `json:"message"`
+	Meta      map[string]interface{} `json:"meta"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// WalletRecord is a registered wallet public key.
+type WalletRecord struct {
+	WalletID  string    `json:"wallet_id"`
+	PublicKey string    `json:"public_key"`
+	CreatedAt time.Time `json:"created_at"`
+	// Nonce is the last nonce accepted from this wallet.
+	Nonce uint64 `json:"nonce"`
+	// OwnerUID is the user who registered the wallet; empty for wallets learned
+	// from the chain or registered before registration proved key ownership.
+	OwnerUID string `json:"owner_uid,omitempty"`
+	// Path is the HD derivation path of the wallet's key, if it was derived from a mnemonic.
+	Path string `json:"path,omitempty"`
+}
+
+// sortWalletRecords orders wallets by derivation path, comparing levels as
+// numbers so m/.../2' comes before m/.../10'; wallets without a path go last.
+func sortWalletRecords(recs []*WalletRecord) {
+	level := func(p string) int64 {
+		n, err := strconv.ParseInt(strings.TrimRight(p, "'h"), 10, 64)
+		if err != nil {
+			return -1
+		}
+		return n
+	}
+	less := func(a, b string) bool {
+		if (a == "") != (b == "") {
+			return b == ""
+		}
+		pa, pb := strings.Split(a, "/"), strings.Split(b, "/")
+		for i := 0; i < len(pa) && i < len(pb); i++ {
+			if x, y := level(pa[i]), level(pb[i]); x != y {
+				return x < y
+			}
+		}
+		return len(pa) < len(pb)
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		if recs[i].Path != recs[j].Path {
+			return less(recs[i].Path, recs[j].Path)
+		}
+		return recs[i].WalletID < recs[j].WalletID
+	})
+}
+
+// TxRecord is a confirmed (or admin-issued) transaction together with the block it was mined in.
+// Admin funding records are blockless and carry an empty BlockHash and BlockIndex 0.
+type TxRecord struct {
+	utxo.Transaction
+	BlockHash  string `json:"block_hash"`
+	BlockIndex int64  `json:"block_index"`
+}
+
+// PendingRecord is a pending transaction and the time this node accepted it,
+// which its mempool expiry counts from. Unlike the transaction's Timestamp,
+// which a relaying peer sets, AcceptedAt is always this node's clock.
+type PendingRecord struct {
+	utxo.Transaction
+	AcceptedAt time.Time `json:"accepted_at"`
+}
+
+// ZakatRecord is a zakat deduction applied to a wallet.
+type ZakatRecord struct {
+	WalletID  string    `json:"wallet_id"`
+	Amount    int64     `json:"amount"`
+	TxID      string    `json:"tx_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PartialTx is a multisig wallet's transaction collecting its co-signers'
+// signatures (in Tx.Signatures) until it has enough to be submitted.
+type PartialTx struct {
+	Tx        utxo.Transaction `json:"tx"`
+	Threshold int              `json:"threshold"`
+	// CreatedBy is the user who proposed the transaction.
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var (
+	_ Store = (*FirestoreStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*FileStore)(nil)
+)
@@ -0,0 +1,535 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// stores opens a fresh store of every backend that runs without a network.
+var stores = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store { return NewMemoryStore() }},
+	{"file", func(t *testing.T) Store { return openFile(t, filepath.Join(t.TempDir(), "node.json")) }},
+}
+
+func openFile(t *testing.T, path string) *FileStore {
+	t.Helper()
+	fs, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+// testTx returns a tx created at epoch plus minute minutes, spending inputs.
+func testTx(minute int, sender string, inputs ...string) *utxo.Transaction {
+	tx := &utxo.Transaction{
+		Sender:    sender,
+		Receiver:  "bob",
+		Amount:    10,
+		Inputs:    inputs,
+		Outputs:   []utxo.TxOutput{{Recipient: "bob", Amount: 10}},
+		Timestamp: epoch.Add(time.Duration(minute) * time.Minute),
+	}
+	tx.ID = tx.ComputeID()
+	return tx
+}
+
+func txIDs(txs []*utxo.Transaction) []string {
+	res := make([]string, 0, len(txs))
+	for _, t := range txs {
+		res = append(res, t.ID)
+	}
+	return res
+}
+
+func TestStoreContract(t *testing.T) {
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			t.Run("wallets", func(t *testing.T) { testWallets(t, s.open(t)) })
+			t.Run("utxos", func(t *testing.T) { testUTXOs(t, s.open(t)) })
+			t.Run("pending", func(t *testing.T) { testPending(t, s.open(t)) })
+			t.Run("blocks", func(t *testing.T) { testBlocks(t, s.open(t)) })
+			t.Run("logs", func(t *testing.T) { testLogs(t, s.open(t)) })
+		})
+	}
+}
+
+func testWallets(t *testing.T, st Store) {
+	defer st.Close()
+	for _, id := range []string{"carol", "alice", "bob"} {
+		if err := st.RegisterWallet(id, id+"-key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ids, _ := st.ListAllWalletIDs(); !reflect.DeepEqual(ids, []string{"alice", "bob", "carol"}) {
+		t.Errorf("wallets %v, want them sorted", ids)
+	}
+	if key, err := st.GetWalletPublicKey("bob"); err != nil || key != "bob-key" {
+		t.Errorf("bob's key %q, err %v", key, err)
+	}
+	if _, err := st.GetWalletPublicKey("dave"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown wallet: err = %v, want ErrNotFound", err)
+	}
+
+	// a signed tx must carry the next nonce, which it consumes
+	u := utxo.NewUTXO("funding", 0, "alice", 100)
+	if err := st.CreateUTXO(u); err != nil {
+		t.Fatal(err)
+	}
+	tx := testTx(0, "alice", u.ID)
+	tx.SenderPublicKey, tx.Nonce = "alice-key", 2
+	if err := st.CreatePendingTxAtomic(tx, tx.Inputs, nil); !errors.Is(err, ErrNonceGap) {
+		t.Errorf("nonce 2 first: err = %v, want ErrNonceGap", err)
+	}
+	tx.Nonce = 1
+	if err := st.CreatePendingTxAtomic(tx, tx.Inputs, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreatePendingTxAtomic(tx, nil, nil); !errors.Is(err, ErrReplayedTx) {
+		t.Errorf("nonce 1 again: err = %v, want ErrReplayedTx", err)
+	}
+	if n, err := st.GetWalletNonce("alice"); err != nil || n != 1 {
+		t.Errorf("nonce %d, err %v, want 1", n, err)
+	}
+}
+
+func testUTXOs(t *testing.T, st Store) {
+	defer st.Close()
+	var want []string
+	for i, minute := range []int{3, 1, 2} {
+		u := utxo.NewUTXO(fmt.Sprint("tx-", i), 0, "alice", int64(i+1))
+		u.CreatedAt = epoch.Add(time.Duration(minute) * time.Minute)
+		if err := st.CreateUTXO(u); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, u.ID)
+	}
+	if err := st.CreateUTXO(utxo.NewUTXO("tx-bob", 0, "bob", 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.MarkUTXOSpent(want[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.MarkUTXOSpent("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown output: err = %v, want ErrNotFound", err)
+	}
+
+	// oldest first, spent and other wallets' outputs left out
+	unspent, err := st.GetUnspentUTXOsByWallet("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, u := range unspent {
+		got = append(got, u.ID)
+	}
+	if !reflect.DeepEqual(got, []string{want[1], want[0]}) {
+		t.Errorf("unspent %v, want %v", got, []string{want[1], want[0]})
+	}
+	if u, err := st.GetUTXOByID(want[2]); err != nil || !u.Spent || u.Amount != 3 {
+		t.Errorf("spent output %+v, err %v", u, err)
+	}
+	n := 0
+	st.ForEachUTXO(func(*utxo.UTXO) error { n++; return nil })
+	if n != 4 {
+		t.Errorf("ForEachUTXO saw %d outputs, want 4", n)
+	}
+}
+
+func testPending(t *testing.T, st Store) {
+	defer st.Close()
+	funding := utxo.NewUTXO("funding", 0, "alice", 100)
+	if err := st.CreateUTXO(funding); err != nil {
+		t.Fatal(err)
+	}
+	spend := testTx(5, "alice", funding.ID)
+	if err := st.CreatePendingTxAtomic(spend, spend.Inputs, spend.OutputUTXOs()); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreatePendingTxAtomic(testTx(6, "alice", funding.ID), []string{funding.ID}, nil); err == nil {
+		t.Error("spent the same output twice")
+	}
+	if err := st.CreatePendingTxAtomic(testTx(6, "alice", "unknown"), []string{"unknown"}, nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown input: err = %v, want ErrNotFound", err)
+	}
+	txs := []*utxo.Transaction{testTx(1, "carol"), spend, testTx(3, "dave")}
+	for _, tx := range []*utxo.Transaction{txs[2], txs[0]} {
+		if err := st.AddPendingTx(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sorted := txIDs(txs)
+	sort.Strings(sorted)
+	if ids, _ := st.GetAllPendingTxIDs(); !reflect.DeepEqual(ids, sorted) {
+		t.Errorf("pending IDs %v, want %v", ids, sorted)
+	}
+	oldest := []string{txs[0].ID, txs[2].ID, spend.ID}
+	got, err := st.ListPendingTxs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotIDs []string
+	for _, r := range got {
+		gotIDs = append(gotIDs, r.ID)
+		// acceptance is this node's clock, not the tx's own timestamp
+		if r.AcceptedAt.IsZero() || r.AcceptedAt.Equal(r.Timestamp) {
+			t.Errorf("pending %s accepted at %v", r.ID, r.AcceptedAt)
+		}
+	}
+	if !reflect.DeepEqual(gotIDs, oldest) {
+		t.Errorf("pending %v, want oldest first %v", gotIDs, oldest)
+	}
+
+	// one tx not pending moves none of them
+	if err := st.MovePendingToMined([]string{txs[0].ID, "unknown"}, "block", 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("moving an unknown tx: err = %v, want ErrNotFound", err)
+	}
+	if ids, _ := st.GetAllPendingTxIDs(); len(ids) != 3 {
+		t.Errorf("%d pending after a failed move, want 3", len(ids))
+	}
+
+	// a move interrupted after its first tx is finished by repeating it
+	if err := st.MovePendingToMined(txIDs(txs[:1]), "block", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.MovePendingToMined(txIDs(txs), "block", 1); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := st.GetAllPendingTxIDs(); len(ids) != 0 {
+		t.Errorf("still pending: %v", ids)
+	}
+	all, err := st.GetAllTransactions()
+	if err != nil || len(all) != 3 {
+		t.Fatalf("%d transactions, err %v", len(all), err)
+	}
+	for i, r := range all {
+		if r.ID != oldest[i] || r.BlockHash != "block" || r.BlockIndex != 1 {
+			t.Errorf("transaction %d is %s in %s at %d, want %s in block at 1", i, r.ID, r.BlockHash, r.BlockIndex, oldest[i])
+		}
+	}
+
+	if err := st.MoveMinedToPending([]string{spend.ID, "unknown"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("moving back an unknown tx: err = %v, want ErrNotFound", err)
+	}
+	// stores may keep only microseconds
+	moved := time.Now().Add(-time.Millisecond)
+	if err := st.MoveMinedToPending([]string{spend.ID}); err != nil {
+		t.Fatal(err)
+	}
+	// a tx returned by a disconnected block is accepted again
+	if got, _ := st.ListPendingTxs(); len(got) != 1 || got[0].AcceptedAt.Before(moved) {
+		t.Errorf("pending after the move back: %+v", got)
+	}
+	if err := st.MoveMinedToPending([]string{spend.ID}); err != nil {
+		t.Errorf("repeating the move back: %v", err)
+	}
+	if ids, _ := st.GetAllPendingTxIDs(); len(ids) != 1 {
+		t.Errorf("pending after repeating the move back: %v", ids)
+	}
+	if _, err := st.GetTransactionByID(spend.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unmined tx still confirmed: err = %v", err)
+	}
+	// the inputs stay spent and the outputs in place, so it can be dropped
+	if u, _ := st.GetUTXOByID(funding.ID); u == nil || !u.Spent {
+		t.Errorf("input after the move back: %+v", u)
+	}
+	if err := st.DropPendingTx(spend.ID); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := st.GetUTXOByID(funding.ID); u == nil || u.Spent {
+		t.Errorf("input after the drop: %+v", u)
+	}
+	if _, err := st.GetUTXOByID(spend.OutputUTXOID(0)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("dropped tx's output: err = %v, want ErrNotFound", err)
+	}
+}
+
+func testBlocks(t *testing.T, st Store) {
+	defer st.Close()
+	bits := blockchain.DifficultyToBits(1)
+	var main []*blockchain.Block
+	prev := "genesis"
+	for i := int64(0); i < 3; i++ {
+		b := blockchain.NewBlockTemplate(i, prev, bits, []utxo.Transaction{*testTx(int(i), "miner")})
+		blockchain.MineBlock(b)
+		if err := st.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		main, prev = append(main, b), b.Hash
+	}
+	if idx, hash, err := st.GetLatestBlock(); err != nil || idx != 2 || hash != main[2].Hash {
+		t.Fatalf("latest %d %s, err %v", idx, hash, err)
+	}
+	if b, err := st.GetBlockByIndex(1); err != nil || b.Hash != main[1].Hash || len(b.Transactions) != 1 {
+		t.Errorf("block 1: %+v, err %v", b, err)
+	}
+
+	// the tip goes to the side blocks and can still be found by hash
+	if err := st.DisconnectBlock(2); err != nil {
+		t.Fatal(err)
+	}
+	if idx, _, _ := st.GetLatestBlock(); idx != 1 {
+		t.Errorf("tip at %d after the disconnect, want 1", idx)
+	}
+	if side, _ := st.ListSideBlocks(); len(side) != 1 || side[0].Hash != main[2].Hash {
+		t.Errorf("side blocks %v, want the old tip", side)
+	}
+	if b, err := st.GetBlockByHash(main[2].Hash); err != nil || b.Index != 2 {
+		t.Errorf("old tip by hash: %+v, err %v", b, err)
+	}
+	var seen []int64
+	st.ForEachBlock(func(b *blockchain.Block) error { seen = append(seen, b.Index); return nil })
+	if !reflect.DeepEqual(seen, []int64{0, 1}) {
+		t.Errorf("ForEachBlock saw %v, want [0 1]", seen)
+	}
+}
+
+func testLogs(t *testing.T, st Store) {
+	defer st.Close()
+	for i := 0; i < 5; i++ {
+		if err := st.AddLog("info", fmt.Sprint("line ", i), map[string]interface{}{"i": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logs, err := st.ListLogs(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, l := range logs {
+		got = append(got, l.Message)
+	}
+	if want := []string{"line 4", "line 3", "line 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("logs %v, want newest first %v", got, want)
+	}
+}
+
+func TestFileStoreReopens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.json")
+	fs := openFile(t, path)
+	if err := fs.RegisterWallet("alice", "alice-key"); err != nil {
+		t.Fatal(err)
+	}
+	tx := testTx(1, "carol")
+	if err := fs.AddPendingTx(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.AddLog("info", "first", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a log line is appended without rewriting the store file
+	before, _ := os.ReadFile(path)
+	if err := fs.AddLog("warn", "second", map[string]interface{}{"k": "v"}); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.ReadFile(path)
+	if !bytes.Equal(before, after) || bytes.Contains(after, []byte("first")) {
+		t.Error("logging rewrote the store file")
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = openFile(t, path)
+	defer fs.Close()
+	if key, _ := fs.GetWalletPublicKey("alice"); key != "alice-key" {
+		t.Errorf("alice's key %q after reopening", key)
+	}
+	if ids, _ := fs.GetAllPendingTxIDs(); !reflect.DeepEqual(ids, []string{tx.ID}) {
+		t.Errorf("pending %v after reopening", ids)
+	}
+	logs, _ := fs.ListLogs(0)
+	if len(logs) != 2 || logs[0].Message != "second" || logs[0].Meta["k"] != "v" || logs[1].ID != "1" {
+		t.Fatalf("logs after reopening: %+v", logs)
+	}
+	// IDs carry on from the reloaded logs
+	fs.AddLog("info", "third", nil)
+	if logs, _ := fs.ListLogs(1); logs[0].ID != "3" {
+		t.Errorf("new log has ID %s, want 3", logs[0].ID)
+	}
+}
+
+func TestFileStoreLogFileRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.json")
+	// a store file from before the log file, with its logs inline
+	old := `{"logs":[{"id":"1","level":"info","message":"inline"}]}`
+	if err := os.WriteFile(path, []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs := openFile(t, path)
+	fs.AddLog("info", "appended", nil)
+	fs.Close()
+	if data, _ := os.ReadFile(path); bytes.Contains(data, []byte("inline")) {
+		t.Error("the store file keeps its inline logs")
+	}
+
+	// a crash mid-write leaves a line with no newline, which is dropped
+	f, err := os.OpenFile(path+".logs", os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"3","level":"in`)
+	f.Close()
+
+	fs = openFile(t, path)
+	fs.AddLog("info", "after the crash", nil)
+	fs.Close()
+	fs = openFile(t, path)
+	defer fs.Close()
+	logs, _ := fs.ListLogs(0)
+	var got []string
+	for _, l := range logs {
+		got = append(got, l.Message)
+	}
+	if want := []string{"after the crash", "appended", "inline"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("logs %v, want %v", got, want)
+	}
+}
+
+// An old store file's inline logs go to the front of the log file once, even
+// if a crash left some or all of them in the log file already.
+func TestFileStoreMigratesInlineLogsOnce(t *testing.T) {
+	old := `{"logs":[{"id":"1","level":"info","message":"one"},{"id":"2","level":"info","message":"two"}]}`
+	for name, logFile := range map[string]string{
+		"empty log file": "",
+		"first migrated": `{"id":"1","level":"info","message":"one"}` + "\n",
+		"all migrated":   `{"id":"1","level":"info","message":"one"}` + "\n" + `{"id":"2","level":"info","message":"two"}` + "\n",
+		"cut mid-record": `{"id":"1","level":"info","message":"one"}` + "\n" + `{"id":"2","lev`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "node.json")
+			if err := os.WriteFile(path, []byte(old), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path+".logs", []byte(logFile), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			messages := func(fs *FileStore) []string {
+				logs, _ := fs.ListLogs(0)
+				var got []string
+				for _, l := range logs {
+					got = append(got, l.Message)
+				}
+				return got
+			}
+			want := []string{"three", "two", "one"}
+			fs := openFile(t, path)
+			if err := fs.AddLog("info", "three", nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := messages(fs); !reflect.DeepEqual(got, want) {
+				t.Errorf("logs %v, want %v", got, want)
+			}
+			fs.Close()
+			fs = openFile(t, path)
+			defer fs.Close()
+			if got := messages(fs); !reflect.DeepEqual(got, want) {
+				t.Errorf("logs after reopening %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFileStoreAddLogWriteFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.json")
+	fs := openFile(t, path)
+	if err := fs.AddLog("info", "kept", nil); err != nil {
+		t.Fatal(err)
+	}
+	fs.logs.Close()
+	if err := fs.AddLog("info", "lost", nil); err == nil {
+		t.Fatal("AddLog succeeded with the log file closed")
+	}
+	if logs, _ := fs.ListLogs(0); len(logs) != 1 || logs[0].Message != "kept" {
+		t.Errorf("logs after a failed write: %+v", logs)
+	}
+}
+
+func TestFileStoreSaveFailureRestoresState(t *testing.T) {
+	dir := t.TempDir()
+	fs := openFile(t, filepath.Join(dir, "node.json"))
+	u := &utxo.UTXO{ID: "tx1:0", TxID: "tx1", WalletID: "alice", Amount: 5}
+	if err := fs.CreateUTXO(u); err != nil {
+		t.Fatal(err)
+	}
+	fs.path = filepath.Join(dir, "missing", "node.json")
+	if err := fs.MarkUTXOSpent(u.ID); err == nil {
+		t.Fatal("MarkUTXOSpent succeeded with the store file unwritable")
+	}
+	if got, err := fs.GetUTXOByID(u.ID); err != nil || got.Spent {
+		t.Errorf("UTXO after a failed save: %+v, %v", got, err)
+	}
+	if err := fs.RegisterWallet("bob", "key"); err == nil {
+		t.Fatal("RegisterWallet succeeded with the store file unwritable")
+	}
+	if _, err := fs.GetWalletPublicKey("bob"); err == nil {
+		t.Error("wallet registered by a failed save is still in memory")
+	}
+}
+
+func TestBlocksCarryFullTransactions(t *testing.T) {
+	tx := utxo.Transaction{
+		Sender:          "alice",
+		Receiver:        "bob",
+		Amount:          60,
+		Timestamp:       epoch,
+		SenderPublicKey: "YWxpY2UncyBrZXk=",
+		Signature:       []byte("signature"),
+		Inputs:          []string{"fund:0", "fund:1"},
+		Outputs: []utxo.TxOutput{
+			{Recipient: "bob", Amount: 60, Condition: &utxo.Condition{After: 10}},
+			{Recipient: "alice", Amount: 35},
+		},
+		ClientTimestamp: epoch.Format(time.RFC3339Nano),
+		ChainID:         "test-net",
+		Nonce:           3,
+		Fee:             5,
+		Preimages:       []string{"00ff"},
+	}
+	tx.ID = tx.ComputeID()
+	b := blockchain.NewBlockTemplate(0, "", blockchain.DifficultyToBits(1), []utxo.Transaction{tx})
+	blockchain.MineBlock(b)
+
+	mem := NewMemoryStore()
+	if err := mem.AddBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	// the file store is reopened, so the bodies must be on disk, not only their IDs
+	path := filepath.Join(t.TempDir(), "node.json")
+	fs := openFile(t, path)
+	if err := fs.AddBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+	for name, st := range map[string]Store{"memory": mem, "file": openFile(t, path)} {
+		got, err := st.GetBlockByHash(b.Hash)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got.Transactions, b.Transactions) {
+			t.Errorf("%s: transactions\n%+v\nwant\n%+v", name, got.Transactions, b.Transactions)
+		}
+		// and the block checks out on its own
+		if !got.VerifyTxIDs() || !got.VerifyMerkleRoot() || !got.VerifyWitnessRoot() || got.ComputeHash() != b.Hash {
+			t.Errorf("%s: stored block does not verify", name)
+		}
+		st.Close()
+	}
+}
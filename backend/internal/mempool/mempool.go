@@ -0,0 +1,553 @@
+// Package mempool holds transactions that have been accepted by the node but
+// not yet mined, ordered by fee rate.
+//
+// The pool only tracks admission, ordering and eviction. Spending inputs and
+// creating outputs for a pending transaction is done by the store when the
+// transaction is accepted; callers must undo that (db.Store.DropPendingTx) for
+// every transaction the pool evicts or expires.
+package mempool
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+var (
+	// ErrPoolFull is returned by Add when the pool is at capacity and the
+	// transaction pays no better fee rate than what would have to be evicted.
+	ErrPoolFull = errors.New("mempool full: fee rate too low")
+	// ErrDuplicate is returned by Add for a transaction already in the pool.
+	ErrDuplicate = errors.New("transaction already in mempool")
+	// ErrTooLarge is returned by Add for a transaction that could never fit in a block.
+	ErrTooLarge = errors.New("transaction larger than block byte limit")
+)
+
+// Config bounds the pool and the block templates built from it. Zero values disable a limit.
+type Config struct {
+	MaxTxs        int           `json:"max_txs"`
+	MaxBytes      int           `json:"max_bytes"`
+	MaxBlockTxs   int           `json:"max_block_txs"`
+	MaxBlockBytes int           `json:"max_block_bytes"`
+	Expiry        time.Duration `json:"expiry"`
+}
+
+// DefaultConfig is used when nothing else is configured.
+var DefaultConfig = Config{
+	MaxTxs:        5000,
+	MaxBytes:      5 << 20,
+	MaxBlockTxs:   500,
+	MaxBlockBytes: 1 << 20,
+	Expiry:        72 * time.Hour,
+}
+
+// Entry is a pooled transaction with its admission metadata.
+type Entry struct {
+	Tx      *utxo.Transaction `json:"-"`
+	TxID    string            `json:"tx_id"`
+	Size    int               `json:"size"`
+	Fee     int64             `json:"fee"`
+	FeeRate float64           `json:"fee_rate"` // fee per byte
+	AddedAt time.Time         `json:"added_at"`
+}
+
+// Stats summarizes the pool for GET /api/mempool.
+type Stats struct {
+	Count      int        `json:"count"`
+	Bytes      int        `json:"bytes"`
+	TotalFees  int64      `json:"total_fees"`
+	MinFeeRate float64    `json:"min_fee_rate"`
+	MaxFeeRate float64    `json:"max_fee_rate"`
+	Oldest     *time.Time `json:"oldest,omitempty"`
+	Config     Config     `json:"config"`
+}
+
+// Pool is a fee-rate ordered mempool. It is safe for concurrent use.
+type Pool struct {
+	mu      sync.Mutex
+	cfg     Config
+	entries map[string]*Entry
+	bytes   int
+	// spentBy maps an input UTXO ID to the pooled tx spending it, so the
+	// descendants of a tx (which spend its outputs) can be found.
+	spentBy map[string]string
+	now     func() time.Time
+}
+
+// New returns an empty pool.
+func New(cfg Config) *Pool {
+	return &Pool{
+		cfg:     cfg,
+		entries: map[string]*Entry{},
+		spentBy: map[string]string{},
+		now:     time.Now,
+	}
+}
+
+// Config returns the pool's limits.
+func (p *Pool) Config() Config { return p.cfg }
+
+func newEntry(t *utxo.Transaction, at time.Time) *Entry {
+	size := t.Size()
+	return &Entry{
+		Tx:      t,
+		TxID:    t.ID,
+		Size:    size,
+		Fee:     t.Fee,
+		FeeRate: float64(t.Fee) / float64(size),
+		AddedAt: at,
+	}
+}
+
+// better orders entries by fee rate, oldest first on ties.
+func better(a, b *Entry) bool {
+	if a.FeeRate != b.FeeRate {
+		return a.FeeRate > b.FeeRate
+	}
+	if !a.AddedAt.Equal(b.AddedAt) {
+		return a.AddedAt.Before(b.AddedAt)
+	}
+	return a.TxID < b.TxID
+}
+
+// Add admits t. When the pool is full, the lowest fee-rate transactions (and
+// their descendants) are evicted to make room, provided t pays a higher fee
+// rate than each of them; the evicted transactions are returned, descendants
+// before ancestors.
+func (p *Pool) Add(t *utxo.Transaction) ([]*utxo.Transaction, error) {
+	return p.AddWithCommit(t, nil)
+}
+
+// AddWithCommit is Add that runs commit, when not nil, once t is known to fit
+// and before anything changes. If commit fails its error is returned and the
+// pool is left as it was: t is not admitted and nothing is evicted. The pool
+// stays locked while commit runs, so commit must not call back into it.
+func (p *Pool) AddWithCommit(t *utxo.Transaction, commit func() error) ([]*utxo.Transaction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.entries[t.ID]; ok {
+		return nil, ErrDuplicate
+	}
+	e := newEntry(t, p.now())
+	if p.cfg.MaxBlockBytes > 0 && e.Size > p.cfg.MaxBlockBytes {
+		return nil, ErrTooLarge
+	}
+
+	// pick victims from the bottom until the new entry fits
+	var victims []string
+	doomed := map[string]bool{}
+	count, bytes := len(p.entries), p.bytes
+	if p.over(count+1, bytes+e.Size) {
+		for _, v := range p.sortedLocked(true) {
+			if !p.over(count+1, bytes+e.Size) {
+				break
+			}
+			if doomed[v.TxID] {
+				continue
+			}
+			if !better(e, v) {
+				return nil, ErrPoolFull
+			}
+			for _, id := range p.withDescendantsLocked(v.TxID) {
+				if !doomed[id] {
+					doomed[id] = true
+					victims = append(victims, id)
+					count--
+					bytes -= p.entries[id].Size
+				}
+			}
+		}
+		if p.over(count+1, bytes+e.Size) {
+			return nil, ErrPoolFull
+		}
+		// evicting a parent of t would leave t spending outputs that no longer exist
+		producer := p.producersLocked()
+		for _, in := range t.Inputs {
+			if doomed[producer[in]] {
+				return nil, ErrPoolFull
+			}
+		}
+	}
+
+	if commit != nil {
+		if err := commit(); err != nil {
+			return nil, err
+		}
+	}
+	evicted := p.removeLocked(victims)
+	p.insertLocked(e)
+	return evicted, nil
+}
+
+func (p *Pool) over(count, bytes int) bool {
+	return (p.cfg.MaxTxs > 0 && count > p.cfg.MaxTxs) || (p.cfg.MaxBytes > 0 && bytes > p.cfg.MaxBytes)
+}
+
+func (p *Pool) insertLocked(e *Entry) {
+	p.entries[e.TxID] = e
+	p.bytes += e.Size
+	for _, in := range e.Tx.Inputs {
+		p.spentBy[in] = e.TxID
+	}
+}
+
+// Load puts the transactions of a disconnected block back into the pool, as
+// if they arrived now. The limits of Add apply: a transaction too large for a
+// block is refused, and while the pool is over its limits the lowest fee-rate
+// transactions are evicted, loaded or not. Every refused or evicted
+// transaction is returned, with its descendants and descendants first, for
+// the caller to undo.
+func (p *Pool) Load(txs []*utxo.Transaction) []*utxo.Transaction {
+	now := p.now()
+	return p.load(txs, func(*utxo.Transaction) time.Time { return now })
+}
+
+// Reload is Load for the pending transactions stored before a restart. Each
+// keeps the time the node accepted it, as acceptedAt reports, so restarting
+// does not reset its expiry. A zero time, or one after now, counts as now.
+func (p *Pool) Reload(txs []*utxo.Transaction, acceptedAt func(*utxo.Transaction) time.Time) []*utxo.Transaction {
+	now := p.now()
+	return p.load(txs, func(t *utxo.Transaction) time.Time {
+		if at := acceptedAt(t); !at.IsZero() && at.Before(now) {
+			return at
+		}
+		return now
+	})
+}
+
+func (p *Pool) load(txs []*utxo.Transaction, addedAt func(*utxo.Transaction) time.Time) []*utxo.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var tooLarge []string
+	for _, t := range txs {
+		if _, ok := p.entries[t.ID]; ok {
+			continue
+		}
+		// insert first, so a refused tx takes the loaded txs spending it along
+		e := newEntry(t, addedAt(t))
+		p.insertLocked(e)
+		if p.cfg.MaxBlockBytes > 0 && e.Size > p.cfg.MaxBlockBytes {
+			tooLarge = append(tooLarge, e.TxID)
+		}
+	}
+	dropped := p.removeTreesLocked(tooLarge)
+	for _, v := range p.sortedLocked(true) {
+		if !p.over(len(p.entries), p.bytes) {
+			break
+		}
+		dropped = append(dropped, p.removeTreesLocked([]string{v.TxID})...)
+	}
+	return dropped
+}
+
+// withDescendantsLocked returns id and every pooled tx that (transitively)
+// spends its outputs, descendants first.
+func (p *Pool) withDescendantsLocked(id string) []string {
+	var out []string
+	seen := map[string]bool{}
+	var visit func(string)
+	visit = func(id string) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		e, ok := p.entries[id]
+		if !ok {
+			return
+		}
+		for i := range e.Tx.Outputs {
+			if child, ok := p.spentBy[e.Tx.OutputUTXOID(i)]; ok {
+				visit(child)
+			}
+		}
+		out = append(out, id)
+	}
+	visit(id)
+	return out
+}
+
+func (p *Pool) removeLocked(ids []string) []*utxo.Transaction {
+	res := make([]*utxo.Transaction, 0, len(ids))
+	for _, id := range ids {
+		e, ok := p.entries[id]
+		if !ok {
+			continue
+		}
+		delete(p.entries, id)
+		p.bytes -= e.Size
+		for _, in := range e.Tx.Inputs {
+			if p.spentBy[in] == id {
+				delete(p.spentBy, in)
+			}
+		}
+		res = append(res, e.Tx)
+	}
+	return res
+}
+
+// Remove drops transactions from the pool (e.g. once mined). Descendants are kept.
+func (p *Pool) Remove(ids []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeLocked(ids)
+}
+
+// removeTreesLocked removes each of roots together with its descendants and
+// returns them descendants first.
+func (p *Pool) removeTreesLocked(roots []string) []*utxo.Transaction {
+	var ids []string
+	doomed := map[string]bool{}
+	for _, root := range roots {
+		for _, id := range p.withDescendantsLocked(root) {
+			if !doomed[id] {
+				doomed[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return p.removeLocked(ids)
+}
+
+// RemoveWithDescendants drops each of ids together with its descendants and
+// returns them descendants first, e.g. transactions a mined block was
+// rejected for.
+func (p *Pool) RemoveWithDescendants(ids []string) []*utxo.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.removeTreesLocked(ids)
+}
+
+// RemoveSpenders drops every pooled transaction spending one of utxoIDs,
+// together with its descendants, and returns them descendants first. It is
+// used when those outputs stop existing, e.g. a coinbase that is reorganized out.
+func (p *Pool) RemoveSpenders(utxoIDs []string) []*utxo.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var roots []string
+	for _, id := range utxoIDs {
+		if txID, ok := p.spentBy[id]; ok {
+			roots = append(roots, txID)
+		}
+	}
+	return p.removeTreesLocked(roots)
+}
+
+// RemoveConflicts drops every pooled transaction that can no longer be mined
+// once t is: those spending any of t's inputs and, for a signed t, the
+// sender's transactions with a nonce at or below t's. Their descendants go
+// too; all are returned descendants first. t itself is left alone.
+func (p *Pool) RemoveConflicts(t *utxo.Transaction) []*utxo.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var roots []string
+	for _, in := range t.Inputs {
+		if id, ok := p.spentBy[in]; ok && id != t.ID {
+			roots = append(roots, id)
+		}
+	}
+	if t.SenderPublicKey != "" {
+		for id, e := range p.entries {
+			if id != t.ID && e.Tx.SenderPublicKey != "" && e.Tx.Sender == t.Sender && e.Tx.Nonce <= t.Nonce {
+				roots = append(roots, id)
+			}
+		}
+	}
+	return p.removeTreesLocked(roots)
+}
+
+// Expire drops every transaction older than the configured expiry, together
+// with its descendants, and returns them descendants first.
+func (p *Pool) Expire() []*utxo.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cfg.Expiry <= 0 {
+		return nil
+	}
+	cutoff := p.now().Add(-p.cfg.Expiry)
+	var roots []string
+	for _, e := range p.sortedLocked(true) {
+		if !e.AddedAt.After(cutoff) {
+			roots = append(roots, e.TxID)
+		}
+	}
+	return p.removeTreesLocked(roots)
+}
+
+// sortedLocked returns entries best first, or worst first when ascending.
+func (p *Pool) sortedLocked(ascending bool) []*Entry {
+	list := make([]*Entry, 0, len(p.entries))
+	for _, e := range p.entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if ascending {
+			return better(list[j], list[i])
+		}
+		return better(list[i], list[j])
+	})
+	return list
+}
+
+// producersLocked maps every output UTXO ID created by a pooled tx to that tx.
+func (p *Pool) producersLocked() map[string]string {
+	producer := map[string]string{}
+	for id, e := range p.entries {
+		for i := range e.Tx.Outputs {
+			producer[e.Tx.OutputUTXOID(i)] = id
+		}
+	}
+	return producer
+}
+
+// parentsLocked returns the pooled txs that must be mined before e: those
+// whose outputs e spends, and the sender's pending txs with a lower nonce.
+func (p *Pool) parentsLocked(e *Entry, producer map[string]string, bySender map[string][]*Entry) []string {
+	var parents []string
+	for _, in := range e.Tx.Inputs {
+		if id, ok := producer[in]; ok && id != e.TxID {
+			parents = append(parents, id)
+		}
+	}
+	if e.Tx.SenderPublicKey != "" {
+		for _, other := range bySender[e.Tx.Sender] {
+			if other.Tx.Nonce < e.Tx.Nonce {
+				parents = append(parents, other.TxID)
+			}
+		}
+	}
+	return parents
+}
+
+// SelectForBlock returns up to maxTxs transactions for the next block, best
+// fee rate first, within the configured block byte limit. A transaction is
+// only included after every pooled transaction it depends on, so the result
+// can be mined in order. reservedBytes is held back for the coinbase.
+func (p *Pool) SelectForBlock(maxTxs, reservedBytes int) []*utxo.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cfg.MaxBlockTxs > 0 && (maxTxs <= 0 || maxTxs > p.cfg.MaxBlockTxs) {
+		maxTxs = p.cfg.MaxBlockTxs
+	}
+	budget := p.cfg.MaxBlockBytes - reservedBytes
+	unlimitedBytes := p.cfg.MaxBlockBytes <= 0
+
+	sorted := p.sortedLocked(false)
+	bySender := map[string][]*Entry{}
+	for _, e := range sorted {
+		if e.Tx.SenderPublicKey != "" {
+			bySender[e.Tx.Sender] = append(bySender[e.Tx.Sender], e)
+		}
+	}
+	producer := p.producersLocked()
+	parents := make(map[string][]string, len(sorted))
+	for _, e := range sorted {
+		parents[e.TxID] = p.parentsLocked(e, producer, bySender)
+	}
+
+	included := map[string]bool{}
+	skipped := map[string]bool{}
+	var res []*utxo.Transaction
+	// repeat passes so a child whose parent ranked lower is picked up once the parent is in
+	for progress := true; progress; {
+		progress = false
+		for _, e := range sorted {
+			if included[e.TxID] || skipped[e.TxID] {
+				continue
+			}
+			if maxTxs > 0 && len(res) >= maxTxs {
+				return res
+			}
+			ready := true
+			for _, parent := range parents[e.TxID] {
+				if !included[parent] {
+					ready = false
+					break
+				}
+			}
+			if !ready {
+				continue
+			}
+			if !unlimitedBytes && e.Size > budget {
+				// will never fit in this block; its descendants can't either
+				skipped[e.TxID] = true
+				continue
+			}
+			included[e.TxID] = true
+			budget -= e.Size
+			res = append(res, e.Tx)
+			progress = true
+		}
+	}
+	return res
+}
+
+// Get returns a pooled transaction.
+func (p *Pool) Get(id string) (*utxo.Transaction, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.entries[id]
+	if !ok {
+		return nil, false
+	}
+	return e.Tx, true
+}
+
+// Len returns the number of pooled transactions.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.entries)
+}
+
+// Txs returns every pooled transaction, best fee rate first.
+func (p *Pool) Txs() []*utxo.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sorted := p.sortedLocked(false)
+	res := make([]*utxo.Transaction, 0, len(sorted))
+	for _, e := range sorted {
+		res = append(res, e.Tx)
+	}
+	return res
+}
+
+// Entries returns up to limit entries, best fee rate first (all when limit <= 0).
+func (p *Pool) Entries(limit int) []Entry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sorted := p.sortedLocked(false)
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	res := make([]Entry, 0, len(sorted))
+	for _, e := range sorted {
+		res = append(res, *e)
+	}
+	return res
+}
+
+// Stats summarizes the pool.
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := Stats{Count: len(p.entries), Bytes: p.bytes, Config: p.cfg}
+	first := true
+	for _, e := range p.entries {
+		st.TotalFees += e.Fee
+		if first || e.FeeRate < st.MinFeeRate {
+			st.MinFeeRate = e.FeeRate
+		}
+		if first || e.FeeRate > st.MaxFeeRate {
+			st.MaxFeeRate = e.FeeRate
+		}
+		if st.Oldest == nil || e.AddedAt.Before(*st.Oldest) {
+			at := e.AddedAt
+			st.Oldest = &at
+		}
+		first = false
+	}
+	return st
+}
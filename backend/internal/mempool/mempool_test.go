@@ -0,0 +1,286 @@
+package mempool
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTx returns a tx paying fee that spends parent's first output, or a
+// confirmed output when parent is nil. Txs with the same note are the same size.
+func newTx(id string, fee int64, parent *utxo.Transaction, note string) *utxo.Transaction {
+	in := (&utxo.Transaction{ID: "confirmed-" + id}).OutputUTXOID(0)
+	if parent != nil {
+		in = parent.OutputUTXOID(0)
+	}
+	return &utxo.Transaction{
+		ID:       id,
+		Sender:   "alice",
+		Receiver: "bob",
+		Amount:   10,
+		Note:     note,
+		Inputs:   []string{in},
+		Outputs:  []utxo.TxOutput{{Recipient: "bob", Amount: 10}},
+		Fee:      fee,
+	}
+}
+
+// testPool returns a pool whose clock stands still until the test moves *now.
+func testPool(cfg Config) (*Pool, *time.Time) {
+	now := epoch
+	p := New(cfg)
+	p.now = func() time.Time { return now }
+	return p, &now
+}
+
+func ids(txs []*utxo.Transaction) []string {
+	res := make([]string, 0, len(txs))
+	for _, t := range txs {
+		res = append(res, t.ID)
+	}
+	return res
+}
+
+func mustAdd(t *testing.T, p *Pool, txs ...*utxo.Transaction) {
+	t.Helper()
+	for _, tx := range txs {
+		if evicted, err := p.Add(tx); err != nil || len(evicted) > 0 {
+			t.Fatalf("add %s: evicted %v, err %v", tx.ID, ids(evicted), err)
+		}
+	}
+}
+
+func TestAddEvictsLowestFeeRate(t *testing.T) {
+	p, now := testPool(Config{MaxTxs: 3})
+	low := newTx("low", 1, nil, "")
+	// a high fee rate does not save a child whose parent is evicted
+	lowChild := newTx("low-child", 100, low, "")
+	mustAdd(t, p, low, lowChild, newTx("mid", 50, nil, ""))
+
+	evicted, err := p.Add(newTx("new", 60, nil, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(evicted), []string{"low-child", "low"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("evicted %v, want %v", got, want)
+	}
+	mustAdd(t, p, newTx("high", 70, nil, ""))
+	if got, want := ids(p.Txs()), []string{"high", "new", "mid"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pool %v, want %v", got, want)
+	}
+
+	// full again: a tx paying no more than the worst is refused; on a tie
+	// the earlier arrival stays
+	*now = now.Add(time.Minute)
+	for _, fee := range []int64{10, 50} {
+		if _, err := p.Add(newTx("cheap", fee, nil, "")); !errors.Is(err, ErrPoolFull) {
+			t.Errorf("fee %d: err = %v, want ErrPoolFull", fee, err)
+		}
+	}
+	// so is one whose parent would have to go to make room for it
+	mid, _ := p.Get("mid")
+	if _, err := p.Add(newTx("mid-child", 1000, mid, "")); !errors.Is(err, ErrPoolFull) {
+		t.Errorf("child of the evicted tx: err = %v, want ErrPoolFull", err)
+	}
+	if p.Len() != 3 {
+		t.Errorf("refusals changed the pool: %v", ids(p.Txs()))
+	}
+}
+
+func TestAddWithCommitFailureEvictsNothing(t *testing.T) {
+	p, _ := testPool(Config{MaxTxs: 2})
+	mustAdd(t, p, newTx("low", 1, nil, ""), newTx("mid", 50, nil, ""))
+
+	failed := errors.New("store down")
+	evicted, err := p.AddWithCommit(newTx("new", 60, nil, ""), func() error { return failed })
+	if !errors.Is(err, failed) || len(evicted) != 0 {
+		t.Fatalf("evicted %v, err = %v, want the commit error", ids(evicted), err)
+	}
+	if got, want := ids(p.Txs()), []string{"mid", "low"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pool %v, want %v", got, want)
+	}
+
+	// a refused tx never reaches the commit
+	if _, err := p.AddWithCommit(newTx("cheap", 0, nil, ""), func() error {
+		t.Error("commit ran for a refused tx")
+		return nil
+	}); !errors.Is(err, ErrPoolFull) {
+		t.Errorf("err = %v, want ErrPoolFull", err)
+	}
+
+	committed := false
+	evicted, err = p.AddWithCommit(newTx("new", 60, nil, ""), func() error { committed = true; return nil })
+	if err != nil || !committed {
+		t.Fatalf("committed %v, err = %v", committed, err)
+	}
+	if got, want := ids(evicted), []string{"low"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("evicted %v, want %v", got, want)
+	}
+}
+
+func TestAddEvictsToFitBytes(t *testing.T) {
+	small := newTx("small", 1, nil, "")
+	big := newTx("big", 5000, nil, strings.Repeat("x", small.Size()/2))
+	p, _ := testPool(Config{MaxBytes: 2*small.Size() + 10})
+	a, b := newTx("a", 2, nil, ""), newTx("b", 3, nil, "")
+	mustAdd(t, p, a, b)
+
+	// big needs both slots' bytes, and pays enough to take them
+	evicted, err := p.Add(big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(evicted), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("evicted %v, want %v", got, want)
+	}
+	if st := p.Stats(); st.Count != 1 || st.Bytes != big.Size() {
+		t.Errorf("stats %+v, want only big", st)
+	}
+}
+
+func TestAddTooLarge(t *testing.T) {
+	tx := newTx("tx", 1000, nil, strings.Repeat("x", 100))
+	p, _ := testPool(Config{MaxBlockBytes: tx.Size() - 1})
+	if _, err := p.Add(tx); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("err = %v, want ErrTooLarge", err)
+	}
+	mustAdd(t, p, newTx("small", 1, nil, ""))
+	if _, err := p.Add(newTx("small", 1, nil, "")); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("err = %v, want ErrDuplicate", err)
+	}
+}
+
+func TestSelectForBlockParentsFirst(t *testing.T) {
+	parent := newTx("parent", 1, nil, "")
+	child := newTx("child", 1000, parent, "")
+	other := newTx("other", 50, nil, "")
+	// a signed sender's nonces go in order whatever they pay
+	nonce1, nonce2 := newTx("nonce-1", 2, nil, ""), newTx("nonce-2", 900, nil, "")
+	for i, tx := range []*utxo.Transaction{nonce1, nonce2} {
+		tx.Sender, tx.SenderPublicKey, tx.Nonce = "carol", "carol-key", uint64(i+1)
+	}
+	limit := 100 * parent.Size()
+	p, _ := testPool(Config{MaxBlockBytes: limit})
+	mustAdd(t, p, child, parent, other, nonce2, nonce1)
+	firstThree := other.Size() + nonce1.Size() + parent.Size()
+
+	tests := []struct {
+		name          string
+		maxTxs        int
+		reservedBytes int
+		want          []string
+	}{
+		{"no limit", 0, 0, []string{"other", "nonce-1", "parent", "child", "nonce-2"}},
+		{"tx limit", 3, 0, []string{"other", "nonce-1", "parent"}},
+		{"byte limit", 0, limit - firstThree, []string{"other", "nonce-1", "parent"}},
+		// no room for the parent leaves no room for the child
+		{"byte limit below the parent", 0, limit - firstThree + 1, []string{"other", "nonce-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(p.SelectForBlock(tt.maxTxs, tt.reservedBytes)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpire(t *testing.T) {
+	p, now := testPool(Config{Expiry: time.Hour})
+	old := newTx("old", 1, nil, "")
+	mustAdd(t, p, old)
+	*now = now.Add(30 * time.Minute)
+	// a young child goes with its expired parent
+	mustAdd(t, p, newTx("old-child", 1, old, ""), newTx("young", 1, nil, ""))
+
+	if got := p.Expire(); len(got) != 0 {
+		t.Fatalf("expired %v before the hour", ids(got))
+	}
+	*now = epoch.Add(time.Hour)
+	if got, want := ids(p.Expire()), []string{"old-child", "old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expired %v, want %v", got, want)
+	}
+	*now = epoch.Add(90 * time.Minute)
+	if got, want := ids(p.Expire()), []string{"young"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expired %v, want %v", got, want)
+	}
+	if p.Len() != 0 {
+		t.Errorf("%d txs left", p.Len())
+	}
+}
+
+func TestLoadAppliesLimits(t *testing.T) {
+	p, now := testPool(Config{MaxTxs: 3, MaxBlockBytes: 2 * newTx("", 0, nil, "").Size(), Expiry: time.Hour})
+	mustAdd(t, p, newTx("pooled", 5, nil, ""))
+	*now = epoch.Add(time.Minute)
+
+	big := newTx("big", 1000, nil, strings.Repeat("x", 500))
+	cheap := newTx("cheap", 1, nil, "")
+	txs := []*utxo.Transaction{
+		big, newTx("big-child", 1000, big, ""),
+		cheap, newTx("cheap-child", 2, cheap, ""),
+		newTx("a", 10, nil, ""), newTx("b", 20, nil, ""),
+	}
+	for _, tx := range txs {
+		// a disconnected block's txs arrive now, however long ago they were accepted
+		tx.Timestamp = epoch.Add(-24 * time.Hour)
+	}
+	dropped := p.Load(txs)
+	if got, want := ids(dropped), []string{"big-child", "big", "cheap-child", "cheap"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dropped %v, want %v", got, want)
+	}
+	if got, want := ids(p.Txs()), []string{"b", "a", "pooled"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pool %v, want %v", got, want)
+	}
+	for _, e := range p.Entries(0) {
+		want := *now
+		if e.TxID == "pooled" {
+			want = epoch
+		}
+		if !e.AddedAt.Equal(want) {
+			t.Errorf("%s added at %v, want %v", e.TxID, e.AddedAt, want)
+		}
+	}
+	if got := p.Expire(); len(got) != 0 {
+		t.Errorf("loaded txs expired at once: %v", ids(got))
+	}
+
+	// a load past the limits evicts pooled txs paying less
+	dropped = p.Load([]*utxo.Transaction{newTx("c", 30, nil, "")})
+	if got, want := ids(dropped), []string{"pooled"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dropped %v, want %v", got, want)
+	}
+	if got := ids(p.Load([]*utxo.Transaction{txs[4]})); len(got) != 0 {
+		t.Errorf("reloading a pooled tx dropped %v", got)
+	}
+}
+
+func TestReloadKeepsAcceptanceTime(t *testing.T) {
+	p, now := testPool(Config{Expiry: time.Hour})
+	*now = epoch.Add(2 * time.Hour)
+	txs := []*utxo.Transaction{newTx("old", 1, nil, ""), newTx("recent", 1, nil, ""), newTx("undated", 1, nil, ""), newTx("future", 1, nil, "")}
+	accepted := map[string]time.Time{"old": epoch, "recent": now.Add(-time.Minute), "future": now.Add(24 * time.Hour)}
+	for _, tx := range txs {
+		// the tx's own timestamp is set by whoever relayed it and plays no part
+		tx.Timestamp = now.Add(365 * 24 * time.Hour)
+	}
+	if dropped := p.Reload(txs, func(t *utxo.Transaction) time.Time { return accepted[t.ID] }); len(dropped) != 0 {
+		t.Fatalf("dropped %v", ids(dropped))
+	}
+	want := map[string]time.Time{"old": epoch, "recent": accepted["recent"], "undated": *now, "future": *now}
+	for _, e := range p.Entries(0) {
+		if !e.AddedAt.Equal(want[e.TxID]) {
+			t.Errorf("%s added at %v, want %v", e.TxID, e.AddedAt, want[e.TxID])
+		}
+	}
+	// a restart does not give a tx accepted over the expiry ago a new lease
+	if got, want := ids(p.Expire()), []string{"old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expired %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,321 @@
+// Package miner runs proof-of-work in the background instead of inside an
+// HTTP request. The node supplies block templates and accepts solved blocks
+// through Backend; the Service decides when to mine, can be started and
+// stopped at any time, and reports its hash rate and current template.
+package miner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+)
+
+// ErrStaleTemplate is returned by Backend.SubmitBlock when the chain tip or
+// the mempool changed while the template was being mined.
+var ErrStaleTemplate = errors.New("block template is stale")
+
+// ErrRunning is returned by Start when the service is already running.
+var ErrRunning = errors.New("miner already running")
+
+// errSuperseded ends a template abandoned because the chain tip moved.
+var errSuperseded = errors.New("block template superseded by a new tip")
+
+// After a failed template, e.g. a block the node rejected, the loop waits
+// before building the next: retryDelay at first, doubling up to maxRetryDelay
+// while the failures go on.
+const (
+	retryDelay    = time.Second
+	maxRetryDelay = time.Minute
+)
+
+// Template is an unsolved block plus the economics of its coinbase.
+type Template struct {
+	Block  *blockchain.Block
+	Fees   int64
+	Reward int64
+}
+
+// Backend is implemented by the node: it builds templates from the current
+// tip and mempool and commits solved blocks.
+type Backend interface {
+	// NewTemplate returns a template paying minerWallet, or nil when there is
+	// nothing to mine (no pending transactions and allowEmpty is false).
+	NewTemplate(minerWallet string, allowEmpty bool) (*Template, error)
+	// SubmitBlock commits a solved template, or returns ErrStaleTemplate.
+	SubmitBlock(t *Template) error
+}
+
+// Config controls when the service mines.
+type Config struct {
+	MinerWallet string `json:"miner_wallet"`
+	// Interval mines a block (even an empty one) at least this often; 0 mines only on demand.
+	Interval time.Duration `json:"interval"`
+	// Workers is the number of PoW goroutines; 0 uses every core.
+	Workers int `json:"workers"`
+}
+
+// TemplateInfo describes the block currently being mined.
+type TemplateInfo struct {
+	Height     int64     `json:"height"`
+	PrevHash   string    `json:"previous_hash"`
+	MerkleRoot string    `json:"merkle_root"`
+	TxCount    int       `json:"tx_count"`
+	Bits       string    `json:"bits"` // compact target, hex
+	Fees       int64     `json:"fees"`
+	Reward     int64     `json:"reward"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+// Status is a snapshot of the service for the admin API.
+type Status struct {
+	Running     bool          `json:"running"`
+	Config      Config        `json:"config"`
+	HashRate    float64       `json:"hash_rate"` // hashes per second over the current/last template
+	TotalHashes int64         `json:"total_hashes"`
+	BlocksMined int           `json:"blocks_mined"`
+	LastBlock   string        `json:"last_block_hash,omitempty"`
+	LastError   string        `json:"last_error,omitempty"`
+	Template    *TemplateInfo `json:"template"`
+}
+
+// Service is the background miner. The zero value is not usable; call New.
+type Service struct {
+	backend Backend
+
+	mu       sync.Mutex
+	cfg      Config
+	cancel   context.CancelFunc
+	done     chan struct{}
+	template *TemplateInfo
+	abort    context.CancelFunc // cancels the template being mined
+	tips     uint64             // NotifyTip calls so far
+	rate     float64
+	mined    int
+	last     string
+	lastErr  string
+
+	hashes int64 // atomic, attempts on the current template
+	total  int64 // atomic, attempts since start
+	wake   chan struct{}
+}
+
+// New returns a stopped service.
+func New(backend Backend) *Service {
+	return &Service{backend: backend, wake: make(chan struct{}, 1)}
+}
+
+// Start runs the mining loop in the background until ctx is done or Stop is called.
+func (s *Service) Start(ctx context.Context, cfg Config) error {
+	if cfg.MinerWallet == "" {
+		return errors.New("miner wallet is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return ErrRunning
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.cfg = cfg
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	s.lastErr = ""
+	go s.run(ctx, cfg, s.done)
+	return nil
+}
+
+// Stop cancels mining (including a solve in progress) and waits for the loop to exit.
+func (s *Service) Stop() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Notify wakes the loop, e.g. after a transaction entered the mempool.
+// It never blocks.
+func (s *Service) Notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// NotifyTip tells the service the chain tip is now hash. A template built on
+// another tip can no longer be submitted, so its search is abandoned and the
+// loop woken to mine on the new tip. It never blocks.
+func (s *Service) NotifyTip(hash string) {
+	s.mu.Lock()
+	s.tips++
+	if s.template != nil && s.template.PrevHash != hash {
+		s.abort()
+	}
+	s.mu.Unlock()
+	s.Notify()
+}
+
+// Status returns a snapshot of the service.
+func (s *Service) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Status{
+		Running:     s.cancel != nil,
+		Config:      s.cfg,
+		HashRate:    s.rate,
+		TotalHashes: atomic.LoadInt64(&s.total) + atomic.LoadInt64(&s.hashes),
+		BlocksMined: s.mined,
+		LastBlock:   s.last,
+		LastError:   s.lastErr,
+	}
+	if s.template != nil {
+		t := *s.template
+		st.Template = &t
+		if secs := time.Since(t.StartedAt).Seconds(); secs > 0 {
+			st.HashRate = float64(atomic.LoadInt64(&s.hashes)) / secs
+		}
+	}
+	return st
+}
+
+func (s *Service) run(ctx context.Context, cfg Config, done chan struct{}) {
+	defer func() {
+		s.mu.Lock()
+		s.cancel, s.done, s.template = nil, nil, nil
+		s.mu.Unlock()
+		close(done)
+	}()
+
+	var tick <-chan time.Time
+	if cfg.Interval > 0 {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	// pick up anything already pending
+	s.Notify()
+	delay := retryDelay
+	for {
+		allowEmpty := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-tick:
+			allowEmpty = true
+		}
+		// keep mining while there is work, so a burst of txs does not wait for the next wake-up
+		for {
+			mined, err := s.mineOnce(ctx, cfg, allowEmpty)
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, errSuperseded) {
+				// mine the same kind of block on the new tip
+				continue
+			}
+			if err != nil {
+				s.setError(err)
+				// the next template is likely the same, so do not redo the work at once
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				if delay *= 2; delay > maxRetryDelay {
+					delay = maxRetryDelay
+				}
+				break
+			}
+			delay = retryDelay
+			if !mined {
+				break
+			}
+			allowEmpty = false
+		}
+	}
+}
+
+// mineOnce mines and submits one block. It reports false when there was nothing to mine.
+func (s *Service) mineOnce(ctx context.Context, cfg Config, allowEmpty bool) (bool, error) {
+	s.mu.Lock()
+	tips := s.tips
+	s.mu.Unlock()
+	t, err := s.backend.NewTemplate(cfg.MinerWallet, allowEmpty)
+	if err != nil || t == nil {
+		return false, err
+	}
+	b := t.Block
+	tctx, abort := context.WithCancel(ctx)
+	defer abort()
+	s.mu.Lock()
+	s.template = &TemplateInfo{
+		Height:     b.Index,
+		PrevHash:   b.PreviousHash,
+		MerkleRoot: b.MerkleRoot,
+		TxCount:    len(b.Transactions),
+		Bits:       fmt.Sprintf("%08x", b.Bits),
+		Fees:       t.Fees,
+		Reward:     t.Reward,
+		StartedAt:  time.Now(),
+	}
+	s.abort = abort
+	if s.tips != tips {
+		// the tip moved while the template was built
+		abort()
+	}
+	s.mu.Unlock()
+	atomic.StoreInt64(&s.hashes, 0)
+
+	_, err = blockchain.MineBlockParallel(tctx, b, blockchain.MineOptions{Workers: cfg.Workers}, &s.hashes)
+	s.finishTemplate()
+	if err != nil {
+		if ctx.Err() == nil && tctx.Err() != nil {
+			return false, errSuperseded
+		}
+		return false, err
+	}
+	if err := s.backend.SubmitBlock(t); err != nil {
+		if errors.Is(err, ErrStaleTemplate) {
+			// someone else extended the chain; build a fresh template
+			return true, nil
+		}
+		return false, err
+	}
+	s.mu.Lock()
+	s.mined++
+	s.last = b.Hash
+	s.lastErr = ""
+	s.mu.Unlock()
+	log.Printf("miner: mined block %d %s (%d txs)", b.Index, b.Hash, len(b.Transactions))
+	return true, nil
+}
+
+// finishTemplate folds the template's hashes into the totals and records its hash rate.
+func (s *Service) finishTemplate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := atomic.SwapInt64(&s.hashes, 0)
+	atomic.AddInt64(&s.total, n)
+	if s.template != nil {
+		if secs := time.Since(s.template.StartedAt).Seconds(); secs > 0 {
+			s.rate = float64(n) / secs
+		}
+	}
+	s.template, s.abort = nil, nil
+}
+
+func (s *Service) setError(err error) {
+	log.Printf("miner: %v", err)
+	s.mu.Lock()
+	s.lastErr = err.Error()
+	s.mu.Unlock()
+}
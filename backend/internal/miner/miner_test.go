@@ -0,0 +1,252 @@
+package miner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+)
+
+var (
+	easyBits = blockchain.DifficultyToBits(1)
+	// hardBits is never solved while a test runs
+	hardBits = blockchain.DifficultyToBits(20)
+)
+
+// fakeBackend is a chain of bare headers. It builds empty templates on its
+// tip, and on demand only while pending is positive.
+type fakeBackend struct {
+	mu        sync.Mutex
+	tip       string
+	height    int64
+	bits      uint32
+	pending   int
+	reject    error // returned by SubmitBlock when set
+	templates int
+	submitted []*blockchain.Block
+}
+
+func newFakeBackend(bits uint32) *fakeBackend {
+	return &fakeBackend{tip: "genesis", bits: bits}
+}
+
+func (f *fakeBackend) NewTemplate(minerWallet string, allowEmpty bool) (*Template, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !allowEmpty && f.pending == 0 {
+		return nil, nil
+	}
+	f.templates++
+	return &Template{Block: blockchain.NewBlockTemplate(f.height+1, f.tip, f.bits, nil), Reward: 50}, nil
+}
+
+func (f *fakeBackend) SubmitBlock(t *Template) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if t.Block.PreviousHash != f.tip {
+		return ErrStaleTemplate
+	}
+	if f.reject != nil {
+		return f.reject
+	}
+	f.tip, f.height = t.Block.Hash, t.Block.Index
+	if f.pending > 0 {
+		f.pending--
+	}
+	f.submitted = append(f.submitted, t.Block)
+	return nil
+}
+
+// extend moves the tip as if a peer's block arrived, and sets the target of
+// the blocks after it.
+func (f *fakeBackend) extend(hash string, bits uint32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tip, f.height, f.bits = hash, f.height+1, bits
+}
+
+func (f *fakeBackend) blocks() []*blockchain.Block {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*blockchain.Block(nil), f.submitted...)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestStartStop(t *testing.T) {
+	s := New(newFakeBackend(easyBits))
+	if err := s.Start(context.Background(), Config{}); err == nil {
+		t.Fatal("started without a miner wallet")
+	}
+	cfg := Config{MinerWallet: "miner", Workers: 2}
+	for round := 0; round < 2; round++ {
+		if err := s.Start(context.Background(), cfg); err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+		if err := s.Start(context.Background(), cfg); !errors.Is(err, ErrRunning) {
+			t.Fatalf("second start: err = %v, want ErrRunning", err)
+		}
+		if st := s.Status(); !st.Running || st.Config != cfg {
+			t.Fatalf("status %+v, want running with %+v", st, cfg)
+		}
+		s.Stop()
+		if s.Status().Running {
+			t.Fatal("still running after Stop")
+		}
+	}
+	// stopping a stopped service is a no-op
+	s.Stop()
+}
+
+func TestStopOnContextDone(t *testing.T) {
+	s := New(newFakeBackend(easyBits))
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Start(ctx, Config{MinerWallet: "miner"}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	waitFor(t, "the loop to exit", func() bool { return !s.Status().Running })
+}
+
+func TestMinesPendingWork(t *testing.T) {
+	f := newFakeBackend(easyBits)
+	f.pending = 3
+	s := New(f)
+	if err := s.Start(context.Background(), Config{MinerWallet: "miner", Workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	waitFor(t, "three blocks", func() bool { return s.Status().BlocksMined == 3 })
+
+	prev := "genesis"
+	for i, b := range f.blocks() {
+		if b.PreviousHash != prev || b.Index != int64(i+1) {
+			t.Errorf("block %d: index %d on %s, want %d on %s", i, b.Index, b.PreviousHash, i+1, prev)
+		}
+		if b.ComputeHash() != b.Hash || !blockchain.HashMeetsTarget(b.Hash, b.Bits) {
+			t.Errorf("block %d: %s is not a solution", i, b.Hash)
+		}
+		prev = b.Hash
+	}
+	if st := s.Status(); st.LastBlock != prev || st.LastError != "" {
+		t.Errorf("last block %s (error %q), want %s", st.LastBlock, st.LastError, prev)
+	}
+}
+
+func TestIntervalMinesEmptyBlocks(t *testing.T) {
+	f := newFakeBackend(easyBits)
+	s := New(f)
+	if err := s.Start(context.Background(), Config{MinerWallet: "miner", Interval: 10 * time.Millisecond, Workers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	waitFor(t, "an empty block", func() bool { return len(f.blocks()) > 0 })
+}
+
+func TestStatusWhileMining(t *testing.T) {
+	f := newFakeBackend(hardBits)
+	f.pending = 1
+	s := New(f)
+	if err := s.Start(context.Background(), Config{MinerWallet: "miner", Workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "hashes on the template", func() bool {
+		st := s.Status()
+		return st.Template != nil && st.TotalHashes > 0 && st.HashRate > 0
+	})
+	st := s.Status()
+	want := TemplateInfo{Height: 1, PrevHash: "genesis", Bits: fmt.Sprintf("%08x", hardBits), Reward: 50}
+	got := *st.Template
+	got.MerkleRoot, got.StartedAt = "", time.Time{}
+	if got != want {
+		t.Errorf("template %+v, want %+v", got, want)
+	}
+	if st.BlocksMined != 0 {
+		t.Errorf("%d blocks mined at an unreachable target", st.BlocksMined)
+	}
+
+	s.Stop()
+	st = s.Status()
+	if st.Running || st.Template != nil {
+		t.Errorf("after Stop: running %v, template %+v", st.Running, st.Template)
+	}
+	if st.TotalHashes == 0 || st.HashRate == 0 {
+		t.Errorf("after Stop: %d hashes at %f/s, want the abandoned template's", st.TotalHashes, st.HashRate)
+	}
+}
+
+func TestNewTipAbandonsTemplate(t *testing.T) {
+	f := newFakeBackend(hardBits)
+	f.pending = 1
+	s := New(f)
+	if err := s.Start(context.Background(), Config{MinerWallet: "miner", Workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	waitFor(t, "a template on genesis", func() bool {
+		tmpl := s.Status().Template
+		return tmpl != nil && tmpl.PrevHash == "genesis"
+	})
+
+	// a tip the template was built on changes nothing
+	s.NotifyTip("genesis")
+	time.Sleep(20 * time.Millisecond)
+	if tmpl := s.Status().Template; tmpl == nil || tmpl.PrevHash != "genesis" {
+		t.Fatalf("template %+v, want the one on genesis", tmpl)
+	}
+
+	// a peer's block arrives; its successor is easy, so only a template
+	// rebuilt on it gets mined
+	f.extend("peer", easyBits)
+	s.NotifyTip("peer")
+	waitFor(t, "a block on the new tip", func() bool { return len(f.blocks()) == 1 })
+	if b := f.blocks()[0]; b.PreviousHash != "peer" || b.Index != 2 {
+		t.Errorf("mined block %d on %s, want 2 on peer", b.Index, b.PreviousHash)
+	}
+	if st := s.Status(); st.LastError != "" {
+		t.Errorf("last error %q after a new tip", st.LastError)
+	}
+}
+
+func TestRejectedBlockBacksOff(t *testing.T) {
+	f := newFakeBackend(easyBits)
+	f.pending = 1
+	f.reject = errors.New("invalid block")
+	s := New(f)
+	if err := s.Start(context.Background(), Config{MinerWallet: "miner", Workers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	waitFor(t, "the rejection", func() bool { return s.Status().LastError != "" })
+
+	// new work arriving does not rebuild the rejected template at once
+	for i := 0; i < 5; i++ {
+		s.Notify()
+		time.Sleep(20 * time.Millisecond)
+	}
+	f.mu.Lock()
+	templates := f.templates
+	f.reject = nil
+	f.mu.Unlock()
+	if templates != 1 {
+		t.Errorf("%d templates within the back-off, want 1", templates)
+	}
+	// once the delay passes, mining resumes
+	waitFor(t, "a block after the back-off", func() bool { return len(f.blocks()) == 1 })
+	if st := s.Status(); st.LastError != "" {
+		t.Errorf("last error %q after a mined block", st.LastError)
+	}
+}
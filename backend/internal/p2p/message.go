@@ -0,0 +1,164 @@
+// Package p2p connects nodes into a network. Nodes talk over TCP: after a
+// version handshake that checks both are on the same chain, they gossip new
+// transactions and blocks to each other. A node behind its peers catches up
+// by downloading and validating their headers first, then the block bodies.
+// A peer that sends malformed or invalid data is disconnected and banned for a while.
+package p2p
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+)
+
+// ProtocolVersion is the wire protocol spoken by this node.
+const ProtocolVersion = 1
+
+// Sync request limits. A peer asking for more is misbehaving.
+const (
+	// MaxHeaders is the most headers one getheaders request returns.
+	MaxHeaders = 2000
+	// MaxBlocksPerRequest is the most hashes one getblocks request may name.
+	MaxBlocksPerRequest = 16
+)
+
+// MaxMessageSize bounds a single message on the wire, so a peer cannot make
+// us allocate without limit. It leaves room for the largest block.
+const MaxMessageSize = 8 << 20
+
+// Message types.
+const (
+	MsgVersion = "version"
+	MsgVerack  = "verack"
+	MsgPing    = "ping"
+	MsgPong    = "pong"
+	MsgTx      = "tx"
+	MsgBlock   = "block"
+
+	MsgGetHeaders = "getheaders"
+	MsgHeaders    = "headers"
+	MsgGetBlocks  = "getblocks"
+	MsgBlocks     = "blocks"
+)
+
+var (
+	// ErrMessageTooLarge is returned when a frame exceeds MaxMessageSize.
+	ErrMessageTooLarge = errors.New("p2p message too large")
+	// errMalformed marks frames that are not valid messages.
+	errMalformed = errors.New("malformed message")
+)
+
+// Message is one frame on the wire: a type and its JSON payload.
+type Message struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Version opens the handshake. Each side sends one and checks the other's.
+type Version struct {
+	Protocol    int    `json:"protocol"`
+	ChainID     string `json:"chain_id"`
+	GenesisHash string `json:"genesis_hash"`
+	TipHeight   int64  `json:"tip_height"`
+	TipHash     string `json:"tip_hash"`
+	// NodeID is random per process; it lets a node notice it dialed itself.
+	NodeID string `json:"node_id"`
+	// ListenAddr is where the sender accepts connections ("" if it does not).
+	ListenAddr string `json:"listen_addr,omitempty"`
+}
+
+// Ping carries a nonce the Pong echoes back.
+type Ping struct {
+	Nonce uint64 `json:"nonce"`
+}
+
+// GetHeaders asks for main-chain headers after the first locator hash the
+// peer knows. Locator runs from the requester's tip back to genesis.
+type GetHeaders struct {
+	ID      uint64   `json:"id"`
+	Locator []string `json:"locator"`
+	Max     int      `json:"max"`
+}
+
+// Headers answers GetHeaders, in ascending height.
+type Headers struct {
+	ID      uint64                   `json:"id"`
+	Headers []blockchain.BlockHeader `json:"headers"`
+}
+
+// GetBlocks asks for full blocks by hash.
+type GetBlocks struct {
+	ID     uint64   `json:"id"`
+	Hashes []string `json:"hashes"`
+}
+
+// Blocks answers GetBlocks. Blocks the peer does not have, or that would
+// overflow the message, are left out; the requester asks again for them.
+type Blocks struct {
+	ID     uint64              `json:"id"`
+	Blocks []*blockchain.Block `json:"blocks"`
+}
+
+func newMessage(typ string, payload interface{}) (*Message, error) {
+	m := &Message{Type: typ}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("encode %s: %w", typ, err)
+		}
+		m.Payload = data
+	}
+	return m, nil
+}
+
+// decode unmarshals the payload into v.
+func (m *Message) decode(v interface{}) error {
+	if err := json.Unmarshal(m.Payload, v); err != nil {
+		return fmt.Errorf("decode %s: %w", m.Type, err)
+	}
+	return nil
+}
+
+// writeMessage writes m as a 4-byte big-endian length followed by its JSON encoding.
+func writeMessage(w io.Writer, m *Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err = w.Write(frame)
+	return err
+}
+
+// readMessage reads one frame written by writeMessage.
+func readMessage(r io.Reader) (*Message, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > MaxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	m := &Message{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%w: %v", errMalformed, err)
+	}
+	if m.Type == "" {
+		return nil, fmt.Errorf("%w: no type", errMalformed)
+	}
+	return m, nil
+}
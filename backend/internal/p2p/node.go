@@ -0,0 +1,636 @@
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+var (
+	// ErrInvalid marks data that breaks consensus rules. Backends wrap it so
+	// the node knows to ban the peer that sent the data.
+	ErrInvalid = errors.New("invalid data from peer")
+	// ErrWrongChain is returned by the handshake when the peer runs another chain.
+	ErrWrongChain = errors.New("peer is on a different chain")
+	// ErrBanned is returned when dialing an address that is still banned.
+	ErrBanned = errors.New("peer is banned")
+
+	errSelfConnection = errors.New("connected to self")
+	errTooManyPeers   = errors.New("too many peers")
+	errNodeStopped    = errors.New("node stopped")
+)
+
+// Backend is the chain state the network layer reads from and feeds into.
+type Backend interface {
+	// Tip returns the height and hash of the last main-chain block.
+	Tip() (int64, string)
+	// ReceiveTx validates and stores a transaction from a peer. It reports
+	// whether the transaction was new and should be relayed. Errors wrapping
+	// ErrInvalid get the sending peer banned.
+	ReceiveTx(t *utxo.Transaction) (bool, error)
+	// ReceiveBlock is ReceiveTx for blocks.
+	ReceiveBlock(b *blockchain.Block) (bool, error)
+
+	// Locator returns the main chain's block locator (see IndexEntry.Locator).
+	Locator() []string
+	// HeadersAfter returns up to max main-chain headers after the last
+	// block the locator shares with the main chain.
+	HeadersAfter(locator []string, max int) []blockchain.BlockHeader
+	// CheckHeaders validates a run of headers extending a known block:
+	// linkage, targets and proof of work. Errors wrapping ErrInvalid mean the
+	// headers break consensus rules.
+	CheckHeaders(headers []blockchain.BlockHeader) error
+	// BlockByHash returns a stored block, on the main chain or a side branch.
+	BlockByHash(hash string) (*blockchain.Block, error)
+}
+
+// Config describes a node. Zero durations and limits take defaults.
+type Config struct {
+	// ListenAddr is where to accept peers, e.g. ":9333". Empty means outbound only.
+	ListenAddr string
+	// Peers are dialed on start and redialed while disconnected.
+	Peers []string
+
+	ChainID     string
+	GenesisHash string
+
+	MaxPeers         int
+	HandshakeTimeout time.Duration
+	PingInterval     time.Duration
+	BanDuration      time.Duration
+	RedialInterval   time.Duration
+	// RequestTimeout bounds the wait for a sync response; a sync peer that
+	// misses it is dropped.
+	RequestTimeout time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxPeers <= 0 {
+		c.MaxPeers = 32
+	}
+	if c.HandshakeTimeout <= 0 {
+		c.HandshakeTimeout = 10 * time.Second
+	}
+	if c.PingInterval <= 0 {
+		c.PingInterval = 30 * time.Second
+	}
+	if c.BanDuration <= 0 {
+		c.BanDuration = time.Hour
+	}
+	if c.RedialInterval <= 0 {
+		c.RedialInterval = 30 * time.Second
+	}
+	if c.RequestTimeout <= 0 {
+		c.RequestTimeout = 30 * time.Second
+	}
+	return c
+}
+
+// Node is one participant in the network. It owns the peer connections and
+// relays what its Backend accepts.
+type Node struct {
+	cfg     Config
+	backend Backend
+	id      string
+	seen    *seenSet
+
+	mu      sync.Mutex
+	ln      net.Listener
+	ctx     context.Context
+	cancel  context.CancelFunc
+	peers   map[string]*peer     // by node ID
+	banned  map[string]time.Time // IP or dialed address -> ban expiry
+	dialIDs map[string]string    // configured address -> node ID it answered with
+
+	sync          syncState
+	reqMu         sync.Mutex
+	requests      map[uint64]pendingRequest
+	lastRequestID uint64
+
+	wg sync.WaitGroup
+}
+
+// NewNode returns a node that is not yet listening or dialing; call Start.
+func NewNode(cfg Config, backend Backend) *Node {
+	var id [16]byte
+	rand.Read(id[:])
+	return &Node{
+		cfg:      cfg.withDefaults(),
+		backend:  backend,
+		id:       hex.EncodeToString(id[:]),
+		seen:     newSeenSet(50000),
+		peers:    map[string]*peer{},
+		banned:   map[string]time.Time{},
+		dialIDs:  map[string]string{},
+		sync:     syncState{wake: make(chan struct{}, 1)},
+		requests: map[uint64]pendingRequest{},
+	}
+}
+
+// ID returns the node's random identity for this process.
+func (n *Node) ID() string { return n.id }
+
+// Start listens (if configured) and begins dialing the configured peers.
+// The node runs until ctx is cancelled or Stop is called.
+func (n *Node) Start(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ctx != nil {
+		return errors.New("p2p node already started")
+	}
+	n.ctx, n.cancel = context.WithCancel(ctx)
+	if n.cfg.ListenAddr != "" {
+		ln, err := net.Listen("tcp", n.cfg.ListenAddr)
+		if err != nil {
+			n.cancel()
+			return fmt.Errorf("p2p listen: %w", err)
+		}
+		n.ln = ln
+		n.wg.Add(1)
+		go n.acceptLoop(ln)
+	}
+	for _, addr := range n.cfg.Peers {
+		n.wg.Add(1)
+		go n.dialLoop(addr)
+	}
+	n.wg.Add(2)
+	go n.syncLoop()
+	go func() {
+		defer n.wg.Done()
+		<-n.ctx.Done()
+		n.shutdown()
+	}()
+	return nil
+}
+
+// Stop disconnects every peer and waits for the node's goroutines to exit.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	cancel := n.cancel
+	n.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	n.wg.Wait()
+}
+
+func (n *Node) shutdown() {
+	n.mu.Lock()
+	if n.ln != nil {
+		n.ln.Close()
+	}
+	peers := make([]*peer, 0, len(n.peers))
+	for _, p := range n.peers {
+		peers = append(peers, p)
+	}
+	n.mu.Unlock()
+	for _, p := range peers {
+		p.close()
+	}
+}
+
+// Addr returns the address the node listens on, or "" if it does not.
+func (n *Node) Addr() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ln == nil {
+		return ""
+	}
+	return n.ln.Addr().String()
+}
+
+// Peers lists the connected peers, ordered by address.
+func (n *Node) Peers() []PeerInfo {
+	n.mu.Lock()
+	out := make([]PeerInfo, 0, len(n.peers))
+	for _, p := range n.peers {
+		out = append(out, p.info())
+	}
+	n.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Addr < out[j].Addr })
+	return out
+}
+
+// BestPeerHeight is the highest tip any connected peer has announced.
+func (n *Node) BestPeerHeight() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	best := int64(-1)
+	for _, p := range n.peers {
+		if h := p.info().TipHeight; h > best {
+			best = h
+		}
+	}
+	return best
+}
+
+// BroadcastTx announces a locally created transaction to every peer.
+func (n *Node) BroadcastTx(t *utxo.Transaction) {
+	n.seen.add("tx:" + t.ID)
+	n.broadcast(MsgTx, t, nil)
+}
+
+// BroadcastBlock announces a locally mined block to every peer.
+func (n *Node) BroadcastBlock(b *blockchain.Block) {
+	n.seen.add("block:" + b.Hash)
+	n.broadcast(MsgBlock, b, nil)
+}
+
+func (n *Node) broadcast(typ string, payload interface{}, except *peer) {
+	m, err := newMessage(typ, payload)
+	if err != nil {
+		log.Printf("p2p: %v", err)
+		return
+	}
+	n.mu.Lock()
+	peers := make([]*peer, 0, len(n.peers))
+	for _, p := range n.peers {
+		if p != except {
+			peers = append(peers, p)
+		}
+	}
+	n.mu.Unlock()
+	for _, p := range peers {
+		p.send(m)
+	}
+}
+
+// Connect dials addr and completes the handshake before returning.
+func (n *Node) Connect(addr string) error {
+	n.mu.Lock()
+	ctx := n.ctx
+	n.mu.Unlock()
+	if ctx == nil || ctx.Err() != nil {
+		return errNodeStopped
+	}
+	if host, _, err := net.SplitHostPort(addr); n.isBanned(addr) || err == nil && n.isBanned(host) {
+		return ErrBanned
+	}
+	d := net.Dialer{Timeout: n.cfg.HandshakeTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	v, err := n.handshake(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	n.mu.Lock()
+	n.dialIDs[addr] = v.NodeID
+	n.mu.Unlock()
+	return n.startPeer(newPeer(n, conn, addr, false, v))
+}
+
+// dialLoop keeps one configured peer connected.
+func (n *Node) dialLoop(addr string) {
+	defer n.wg.Done()
+	for {
+		if !n.connectedTo(addr) {
+			if err := n.Connect(addr); err != nil && !errors.Is(err, errNodeStopped) {
+				log.Printf("p2p: dial %s: %v", addr, err)
+			}
+		}
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-time.After(n.cfg.RedialInterval):
+		}
+	}
+}
+
+// connectedTo reports whether addr, or the node that last answered there,
+// is already a peer (possibly through a connection it opened to us).
+func (n *Node) connectedTo(addr string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if id, ok := n.dialIDs[addr]; ok {
+		if _, ok := n.peers[id]; ok {
+			return true
+		}
+	}
+	for _, p := range n.peers {
+		if p.addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) acceptLoop(ln net.Listener) {
+	defer n.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if n.ctx.Err() == nil {
+				log.Printf("p2p: accept: %v", err)
+			}
+			return
+		}
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.acceptPeer(conn)
+		}()
+	}
+}
+
+func (n *Node) acceptPeer(conn net.Conn) {
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if n.isBanned(host) {
+		conn.Close()
+		return
+	}
+	v, err := n.handshake(conn)
+	if err != nil {
+		if !errors.Is(err, errSelfConnection) {
+			log.Printf("p2p: handshake with %s: %v", conn.RemoteAddr(), err)
+		}
+		conn.Close()
+		return
+	}
+	// Known by the address the peer says it listens on, so an inbound
+	// peer and a dial to it are recognised as one.
+	addr := conn.RemoteAddr().String()
+	if _, port, err := net.SplitHostPort(v.ListenAddr); err == nil && port != "0" {
+		addr = net.JoinHostPort(host, port)
+	}
+	if err := n.startPeer(newPeer(n, conn, addr, true, v)); err != nil {
+		log.Printf("p2p: %s: %v", addr, err)
+	}
+}
+
+// handshake exchanges version and verack messages on a fresh connection.
+func (n *Node) handshake(conn net.Conn) (Version, error) {
+	conn.SetDeadline(time.Now().Add(n.cfg.HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	height, hash := n.backend.Tip()
+	mine := Version{
+		Protocol:    ProtocolVersion,
+		ChainID:     n.cfg.ChainID,
+		GenesisHash: n.cfg.GenesisHash,
+		TipHeight:   height,
+		TipHash:     hash,
+		NodeID:      n.id,
+		ListenAddr:  n.Addr(),
+	}
+	m, err := newMessage(MsgVersion, mine)
+	if err != nil {
+		return Version{}, err
+	}
+	if err := writeMessage(conn, m); err != nil {
+		return Version{}, err
+	}
+
+	var theirs Version
+	if m, err = readMessage(conn); err != nil {
+		return Version{}, err
+	}
+	if m.Type != MsgVersion {
+		return Version{}, fmt.Errorf("expected %s, got %s", MsgVersion, m.Type)
+	}
+	if err := m.decode(&theirs); err != nil {
+		return Version{}, err
+	}
+	switch {
+	case theirs.Protocol != ProtocolVersion:
+		return Version{}, fmt.Errorf("unsupported protocol version %d", theirs.Protocol)
+	case theirs.ChainID != n.cfg.ChainID:
+		return Version{}, fmt.Errorf("%w: chain id %q", ErrWrongChain, theirs.ChainID)
+	case theirs.GenesisHash != n.cfg.GenesisHash:
+		return Version{}, fmt.Errorf("%w: genesis %s", ErrWrongChain, theirs.GenesisHash)
+	case theirs.NodeID == n.id:
+		return Version{}, errSelfConnection
+	}
+
+	if m, err = newMessage(MsgVerack, nil); err != nil {
+		return Version{}, err
+	}
+	if err := writeMessage(conn, m); err != nil {
+		return Version{}, err
+	}
+	if m, err = readMessage(conn); err != nil {
+		return Version{}, err
+	}
+	if m.Type != MsgVerack {
+		return Version{}, fmt.Errorf("expected %s, got %s", MsgVerack, m.Type)
+	}
+	return theirs, nil
+}
+
+// startPeer registers p and runs it in the background. When the two nodes
+// already share a connection, both keep the one opened by the node with
+// the smaller ID, so simultaneous dials settle on a single link.
+func (n *Node) startPeer(p *peer) error {
+	n.mu.Lock()
+	if n.ctx.Err() != nil {
+		n.mu.Unlock()
+		p.conn.Close()
+		return errNodeStopped
+	}
+	id := p.version.NodeID
+	var replaced *peer
+	if old, ok := n.peers[id]; ok {
+		if n.dialer(p) >= n.dialer(old) {
+			n.mu.Unlock()
+			p.conn.Close()
+			return nil
+		}
+		replaced = old
+	} else if len(n.peers) >= n.cfg.MaxPeers {
+		n.mu.Unlock()
+		p.conn.Close()
+		return errTooManyPeers
+	}
+	n.peers[id] = p
+	n.mu.Unlock()
+
+	if replaced != nil {
+		replaced.close()
+	}
+	log.Printf("p2p: connected to %s (inbound=%v, height %d)", p.addr, p.inbound, p.version.TipHeight)
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		p.run()
+	}()
+	if height, _ := n.backend.Tip(); p.version.TipHeight > height {
+		n.wakeSync()
+	}
+	return nil
+}
+
+// dialer returns the ID of the node that opened p's connection.
+func (n *Node) dialer(p *peer) string {
+	if p.inbound {
+		return p.version.NodeID
+	}
+	return n.id
+}
+
+func (n *Node) removePeer(p *peer) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.peers[p.version.NodeID] == p {
+		delete(n.peers, p.version.NodeID)
+	}
+}
+
+// misbehaving disconnects p and refuses it for BanDuration. The ban is keyed
+// by the remote IP, since an inbound peer picks the port it announces and
+// could come back under another; a dialed peer's address is banned too, as it
+// may be a host name.
+func (n *Node) misbehaving(p *peer, reason error) {
+	log.Printf("p2p: banning %s: %v", p.addr, reason)
+	until := time.Now().Add(n.cfg.BanDuration)
+	n.mu.Lock()
+	if host, _, err := net.SplitHostPort(p.conn.RemoteAddr().String()); err == nil {
+		n.banned[host] = until
+	}
+	if !p.inbound {
+		n.banned[p.addr] = until
+	}
+	n.mu.Unlock()
+	p.close()
+}
+
+func (n *Node) isBanned(addr string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	until, ok := n.banned[addr]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(n.banned, addr)
+		return false
+	}
+	return true
+}
+
+// handle processes one message from an established peer.
+func (n *Node) handle(p *peer, m *Message) error {
+	switch m.Type {
+	case MsgPing:
+		var ping Ping
+		if err := m.decode(&ping); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		pong, err := newMessage(MsgPong, ping)
+		if err != nil {
+			return err
+		}
+		p.send(pong)
+	case MsgPong:
+	case MsgTx:
+		var t utxo.Transaction
+		if err := m.decode(&t); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		key := "tx:" + t.ID
+		if !n.seen.add(key) {
+			return nil
+		}
+		relay, err := n.backend.ReceiveTx(&t)
+		if err != nil {
+			// let another peer offer it again once we can accept it
+			n.seen.remove(key)
+			return err
+		}
+		if relay {
+			n.broadcast(MsgTx, &t, p)
+		}
+	case MsgBlock:
+		var b blockchain.Block
+		if err := m.decode(&b); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		key := "block:" + b.Hash
+		if !n.seen.add(key) {
+			return nil
+		}
+		relay, err := n.backend.ReceiveBlock(&b)
+		if err != nil {
+			n.seen.remove(key)
+			if errors.Is(err, blockchain.ErrOrphanBlock) {
+				// we are missing its ancestors; sync will fetch them
+				p.noteClaim(b.Index)
+				n.wakeSync()
+				return nil
+			}
+			return err
+		}
+		p.noteHeight(b.Index)
+		if relay {
+			n.broadcast(MsgBlock, &b, p)
+		}
+	case MsgGetHeaders:
+		return n.serveHeaders(p, m)
+	case MsgGetBlocks:
+		return n.serveBlocks(p, m)
+	case MsgHeaders, MsgBlocks:
+		return n.deliver(p, m)
+	case MsgVersion, MsgVerack:
+		return fmt.Errorf("%w: %s after handshake", ErrInvalid, m.Type)
+	}
+	// unknown types are ignored so newer peers can extend the protocol
+	return nil
+}
+
+// seenSet remembers the most recent keys up to a fixed capacity, so an
+// item bouncing around the network is processed once.
+type seenSet struct {
+	mu    sync.Mutex
+	keys  map[string]int // key -> its slot in order
+	order []string
+	next  int
+}
+
+func newSeenSet(capacity int) *seenSet {
+	return &seenSet{keys: make(map[string]int, capacity), order: make([]string, 0, capacity)}
+}
+
+// add records key and reports whether it was new.
+func (s *seenSet) add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key]; ok {
+		return false
+	}
+	slot := len(s.order)
+	if slot < cap(s.order) {
+		s.order = append(s.order, key)
+	} else {
+		slot = s.next
+		// a key removed and added again since lives in another slot
+		old := s.order[slot]
+		if i, ok := s.keys[old]; ok && i == slot {
+			delete(s.keys, old)
+		}
+		s.order[slot] = key
+		s.next = (s.next + 1) % len(s.order)
+	}
+	s.keys[key] = slot
+	return true
+}
+
+// remove forgets key, so it counts as new when next added. Its old slot in
+// order is left behind; when add overwrites that slot, a key added again in
+// the meantime is kept.
+func (s *seenSet) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+}
@@ -0,0 +1,89 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+// rejectingBackend is an empty chain that finds every tx invalid.
+type rejectingBackend struct{}
+
+func (rejectingBackend) Tip() (int64, string)                                { return 0, "genesis" }
+func (rejectingBackend) ReceiveTx(*utxo.Transaction) (bool, error)           { return false, ErrInvalid }
+func (rejectingBackend) ReceiveBlock(*blockchain.Block) (bool, error)        { return false, nil }
+func (rejectingBackend) Locator() []string                                   { return nil }
+func (rejectingBackend) HeadersAfter([]string, int) []blockchain.BlockHeader { return nil }
+func (rejectingBackend) CheckHeaders([]blockchain.BlockHeader) error         { return nil }
+func (rejectingBackend) BlockByHash(string) (*blockchain.Block, error)       { return nil, nil }
+
+func startNode(t *testing.T) *Node {
+	t.Helper()
+	n := NewNode(Config{ListenAddr: "127.0.0.1:0", ChainID: "test-net", GenesisHash: "genesis"}, rejectingBackend{})
+	if err := n.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Stop)
+	return n
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestInboundBanCoversOtherPorts(t *testing.T) {
+	n := startNode(t)
+	evil := startNode(t)
+	if err := evil.Connect(n.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the peer", func() bool { return len(n.Peers()) == 1 })
+	evil.BroadcastTx(&utxo.Transaction{ID: "forged"})
+	waitFor(t, "the ban", func() bool { return len(n.Peers()) == 0 })
+
+	// the same host announcing another listen port is still refused
+	again := startNode(t)
+	if again.Addr() == evil.Addr() {
+		t.Fatal("both nodes listen on one address")
+	}
+	again.Connect(n.Addr())
+	time.Sleep(100 * time.Millisecond)
+	if peers := n.Peers(); len(peers) != 0 {
+		t.Fatalf("banned host reconnected as %+v", peers)
+	}
+	// and so is a dial to it
+	if err := n.Connect(again.Addr()); !errors.Is(err, ErrBanned) {
+		t.Errorf("dial to the banned host: err = %v, want ErrBanned", err)
+	}
+}
+
+func TestSeenSetKeepsKeyAddedAgain(t *testing.T) {
+	s := newSeenSet(3)
+	s.add("a")
+	s.add("b")
+	s.remove("a")
+	if !s.add("a") {
+		t.Fatal("a removed key is not new")
+	}
+	// evicting a's first slot must not forget it
+	s.add("c")
+	if s.add("a") {
+		t.Error("a key added again was forgotten with its old slot")
+	}
+	// b's slot is next; it goes as usual
+	s.add("d")
+	if !s.add("b") {
+		t.Error("the oldest key was not evicted")
+	}
+}
@@ -0,0 +1,180 @@
+package p2p
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sendQueueSize is how many messages may wait for a slow peer before it is dropped.
+const sendQueueSize = 256
+
+// PeerInfo describes a connected peer.
+type PeerInfo struct {
+	Addr        string    `json:"addr"`
+	NodeID      string    `json:"node_id"`
+	Inbound     bool      `json:"inbound"`
+	TipHeight   int64     `json:"tip_height"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// peer is a connection that completed the handshake.
+type peer struct {
+	node    *Node
+	conn    net.Conn
+	addr    string // the dialed address, or ip:advertised-port for inbound peers
+	inbound bool
+	version Version
+	since   time.Time
+	// tipHeight is the peer's best height: announced in the handshake, then
+	// raised by blocks and headers from it that validated.
+	tipHeight int64
+	// claimed is the height of a block from the peer whose ancestors we
+	// lack, so it could not be validated. Sync asks the peer for the headers
+	// leading to it, but nothing else relies on it.
+	claimed int64
+	// syncedTo is the syncHeight at which syncing from the peer last brought nothing new.
+	syncedTo int64
+
+	out       chan *Message
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPeer(n *Node, conn net.Conn, addr string, inbound bool, v Version) *peer {
+	return &peer{
+		node:      n,
+		conn:      conn,
+		addr:      addr,
+		inbound:   inbound,
+		version:   v,
+		since:     time.Now().UTC(),
+		tipHeight: v.TipHeight,
+		out:       make(chan *Message, sendQueueSize),
+		closed:    make(chan struct{}),
+	}
+}
+
+func (p *peer) info() PeerInfo {
+	return PeerInfo{
+		Addr:        p.addr,
+		NodeID:      p.version.NodeID,
+		Inbound:     p.inbound,
+		TipHeight:   atomic.LoadInt64(&p.tipHeight),
+		ConnectedAt: p.since,
+	}
+}
+
+// noteHeight raises the peer's known tip height once a block or header at h
+// from it validated.
+func (p *peer) noteHeight(h int64) {
+	raise(&p.tipHeight, h)
+}
+
+// noteClaim records an orphan block at h from the peer, for sync to check.
+func (p *peer) noteClaim(h int64) {
+	raise(&p.claimed, h)
+}
+
+// syncHeight is how far syncing from the peer might take us.
+func (p *peer) syncHeight() int64 {
+	h := atomic.LoadInt64(&p.tipHeight)
+	if c := atomic.LoadInt64(&p.claimed); c > h {
+		return c
+	}
+	return h
+}
+
+// raise atomically sets *v to h if h is higher.
+func raise(v *int64, h int64) {
+	for {
+		cur := atomic.LoadInt64(v)
+		if h <= cur || atomic.CompareAndSwapInt64(v, cur, h) {
+			return
+		}
+	}
+}
+
+// send queues m without blocking. A peer too slow to drain its queue is dropped.
+func (p *peer) send(m *Message) {
+	select {
+	case <-p.closed:
+		return
+	default:
+	}
+	select {
+	case p.out <- m:
+	default:
+		log.Printf("p2p: dropping %s: send queue full", p.addr)
+		p.close()
+	}
+}
+
+func (p *peer) close() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		p.conn.Close()
+		p.node.removePeer(p)
+	})
+}
+
+// run starts the peer's reader and writer and returns once both have stopped.
+func (p *peer) run() {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.writeLoop()
+	}()
+	go func() {
+		defer wg.Done()
+		p.readLoop()
+	}()
+	wg.Wait()
+}
+
+func (p *peer) readLoop() {
+	defer p.close()
+	for {
+		// pings keep a healthy connection busy well within this window
+		p.conn.SetReadDeadline(time.Now().Add(3 * p.node.cfg.PingInterval))
+		m, err := readMessage(p.conn)
+		if err != nil {
+			if errors.Is(err, errMalformed) || errors.Is(err, ErrMessageTooLarge) {
+				p.node.misbehaving(p, err)
+			}
+			return
+		}
+		if err := p.node.handle(p, m); err != nil {
+			if errors.Is(err, ErrInvalid) {
+				p.node.misbehaving(p, err)
+				return
+			}
+			log.Printf("p2p: %s from %s not accepted: %v", m.Type, p.addr, err)
+		}
+	}
+}
+
+func (p *peer) writeLoop() {
+	ticker := time.NewTicker(p.node.cfg.PingInterval)
+	defer ticker.Stop()
+	for {
+		var m *Message
+		select {
+		case <-p.closed:
+			return
+		case m = <-p.out:
+		case <-ticker.C:
+			m, _ = newMessage(MsgPing, Ping{Nonce: rand.Uint64()})
+		}
+		p.conn.SetWriteDeadline(time.Now().Add(p.node.cfg.PingInterval))
+		if err := writeMessage(p.conn, m); err != nil {
+			p.close()
+			return
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+)
+
+// A node that is behind its peers syncs header first: it asks one peer for
+// the headers after its own tip, checks their proof of work and linkage,
+// then downloads the bodies from every peer that has them, a window at a
+// time, and connects them in order. Connecting rebuilds the UTXO set the
+// same way blocks arriving by gossip do.
+
+const (
+	// downloadWindow is how many blocks are fetched before they are connected.
+	downloadWindow = 128
+	// maxDownloadPeers bounds how many peers serve one window in parallel.
+	maxDownloadPeers = 8
+	// downloadRounds is how often missing blocks are re-requested before giving up.
+	downloadRounds = 3
+	syncInterval   = 10 * time.Second
+)
+
+var (
+	errRequestTimeout = errors.New("request timed out")
+	errPeerGone       = errors.New("peer disconnected")
+)
+
+// SyncStatus reports the progress of header-first sync.
+type SyncStatus struct {
+	Syncing bool `json:"syncing"`
+	// Peer is the address headers are being fetched from.
+	Peer string `json:"peer,omitempty"`
+	// Height is the local tip; TargetHeight the best height a peer announced.
+	Height       int64 `json:"height"`
+	TargetHeight int64 `json:"target_height"`
+	// HeaderHeight is the last header validated in the current sync.
+	HeaderHeight     int64      `json:"header_height"`
+	StartHeight      int64      `json:"start_height"`
+	BlocksDownloaded int64      `json:"blocks_downloaded"`
+	Progress         float64    `json:"progress"`
+	StartedAt        *time.Time `json:"started_at,omitempty"`
+	LastError        string     `json:"last_error,omitempty"`
+}
+
+// syncState is the mutable part of SyncStatus plus the sync loop's wake-up.
+type syncState struct {
+	mu     sync.Mutex
+	status SyncStatus
+	wake   chan struct{}
+}
+
+type pendingRequest struct {
+	peer *peer
+	resp chan *Message
+}
+
+// SyncStatus returns the current sync progress.
+func (n *Node) SyncStatus() SyncStatus {
+	height, _ := n.backend.Tip()
+	target := n.BestPeerHeight()
+	n.sync.mu.Lock()
+	st := n.sync.status
+	n.sync.mu.Unlock()
+	st.Height = height
+	if target > st.TargetHeight {
+		st.TargetHeight = target
+	}
+	switch {
+	case height >= st.TargetHeight:
+		st.Progress = 1
+	case st.TargetHeight > st.StartHeight:
+		st.Progress = float64(height-st.StartHeight) / float64(st.TargetHeight-st.StartHeight)
+	}
+	return st
+}
+
+func (n *Node) updateSync(f func(st *SyncStatus)) {
+	n.sync.mu.Lock()
+	f(&n.sync.status)
+	n.sync.mu.Unlock()
+}
+
+// wakeSync asks the sync loop to check the peers' heights now.
+func (n *Node) wakeSync() {
+	select {
+	case n.sync.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) syncLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.sync.wake:
+		}
+		n.syncOnce()
+	}
+}
+
+// syncOnce catches up with the best peers until none is ahead.
+func (n *Node) syncOnce() {
+	defer n.updateSync(func(st *SyncStatus) {
+		st.Syncing = false
+		st.Peer = ""
+	})
+	for n.ctx.Err() == nil {
+		p := n.syncPeer()
+		if p == nil {
+			return
+		}
+		height, _ := n.backend.Tip()
+		n.updateSync(func(st *SyncStatus) {
+			if !st.Syncing {
+				now := time.Now().UTC()
+				*st = SyncStatus{Syncing: true, StartHeight: height, StartedAt: &now}
+			}
+			st.Peer = p.addr
+			st.TargetHeight = atomic.LoadInt64(&p.tipHeight)
+		})
+		progressed, err := n.syncFrom(p)
+		if err != nil {
+			n.updateSync(func(st *SyncStatus) { st.LastError = err.Error() })
+			if errors.Is(err, ErrInvalid) {
+				n.misbehaving(p, err)
+				continue
+			}
+			if errors.Is(err, errRequestTimeout) {
+				// a peer announcing a tip it never serves would hold sync
+				// up for good; drop it, without a ban, and try the next
+				log.Printf("p2p: dropping %s: %v", p.addr, err)
+				p.close()
+				continue
+			}
+			log.Printf("p2p: sync from %s: %v", p.addr, err)
+			return
+		}
+		if !progressed {
+			// its chain has nothing we lack; wait until it announces more
+			atomic.StoreInt64(&p.syncedTo, p.syncHeight())
+		}
+	}
+}
+
+// syncPeer picks the peer announcing the highest tip above ours.
+func (n *Node) syncPeer() *peer {
+	height, _ := n.backend.Tip()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var best *peer
+	for _, p := range n.peers {
+		h := p.syncHeight()
+		if h <= height || h <= atomic.LoadInt64(&p.syncedTo) {
+			continue
+		}
+		if best == nil || h > best.syncHeight() {
+			best = p
+		}
+	}
+	return best
+}
+
+// syncFrom fetches one batch of headers from p and connects their blocks.
+// It reports whether any block was new.
+func (n *Node) syncFrom(p *peer) (bool, error) {
+	var resp Headers
+	id := n.nextRequestID()
+	if err := n.request(p, MsgGetHeaders, GetHeaders{ID: id, Locator: n.backend.Locator(), Max: MaxHeaders}, id, &resp); err != nil {
+		return false, err
+	}
+	if len(resp.Headers) > MaxHeaders {
+		return false, fmt.Errorf("%w: %d headers in one response", ErrInvalid, len(resp.Headers))
+	}
+	if len(resp.Headers) == 0 {
+		return false, nil
+	}
+	if err := n.backend.CheckHeaders(resp.Headers); err != nil {
+		// the locator ends at genesis, so an honest peer's headers always connect
+		if errors.Is(err, blockchain.ErrOrphanBlock) {
+			return false, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		return false, err
+	}
+	last := resp.Headers[len(resp.Headers)-1]
+	p.noteHeight(last.Index)
+	n.updateSync(func(st *SyncStatus) { st.HeaderHeight = last.Index })
+
+	progressed := false
+	for start := 0; start < len(resp.Headers); start += downloadWindow {
+		end := start + downloadWindow
+		if end > len(resp.Headers) {
+			end = len(resp.Headers)
+		}
+		blocks, err := n.downloadBlocks(resp.Headers[start:end], p)
+		if err != nil {
+			return progressed, err
+		}
+		for _, b := range blocks {
+			// the body matches a header p vouched for, so a bad block is p's chain's fault
+			isNew, err := n.backend.ReceiveBlock(b)
+			if err != nil {
+				return progressed, err
+			}
+			n.seen.add("block:" + b.Hash)
+			if isNew {
+				progressed = true
+				n.updateSync(func(st *SyncStatus) { st.BlocksDownloaded++ })
+			}
+		}
+	}
+	return progressed, nil
+}
+
+// downloadBlocks fetches the bodies for headers, spreading requests over
+// the peers whose chains reach that far. The blocks come back in header order.
+func (n *Node) downloadBlocks(headers []blockchain.BlockHeader, source *peer) ([]*blockchain.Block, error) {
+	blocks := make([]*blockchain.Block, len(headers))
+	failed := map[*peer]bool{}
+	for round := 0; round < downloadRounds; round++ {
+		var todo [][]int
+		var chunk []int
+		for i := range headers {
+			if blocks[i] != nil {
+				continue
+			}
+			if chunk = append(chunk, i); len(chunk) == MaxBlocksPerRequest {
+				todo, chunk = append(todo, chunk), nil
+			}
+		}
+		if len(chunk) > 0 {
+			todo = append(todo, chunk)
+		}
+		if len(todo) == 0 {
+			return blocks, nil
+		}
+
+		peers := n.downloadPeers(headers[len(headers)-1].Index, source, failed)
+		if len(peers) == 0 {
+			break
+		}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, p := range peers {
+			wg.Add(1)
+			go func(p *peer) {
+				defer wg.Done()
+				for {
+					mu.Lock()
+					if len(todo) == 0 {
+						mu.Unlock()
+						return
+					}
+					chunk := todo[len(todo)-1]
+					todo = todo[:len(todo)-1]
+					mu.Unlock()
+
+					got, err := n.fetchBlocks(p, headers, chunk)
+					mu.Lock()
+					var missing []int
+					for _, i := range chunk {
+						if b, ok := got[headers[i].Hash]; ok {
+							blocks[i] = b
+						} else {
+							missing = append(missing, i)
+						}
+					}
+					if len(missing) > 0 {
+						todo = append(todo, missing)
+					}
+					if err != nil || len(missing) == len(chunk) {
+						// leave the rest to other peers
+						failed[p] = true
+						mu.Unlock()
+						if errors.Is(err, ErrInvalid) {
+							n.misbehaving(p, err)
+						}
+						return
+					}
+					mu.Unlock()
+				}
+			}(p)
+		}
+		wg.Wait()
+	}
+	for i, b := range blocks {
+		if b == nil {
+			return nil, fmt.Errorf("block %d (%s) not available from any peer", headers[i].Index, headers[i].Hash)
+		}
+	}
+	return blocks, nil
+}
+
+// downloadPeers returns up to maxDownloadPeers peers that announced a tip
+// at or above height, always including source, which sent the headers.
+func (n *Node) downloadPeers(height int64, source *peer, failed map[*peer]bool) []*peer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var out []*peer
+	if !failed[source] {
+		out = append(out, source)
+	}
+	for _, p := range n.peers {
+		if len(out) == maxDownloadPeers {
+			break
+		}
+		if p != source && !failed[p] && atomic.LoadInt64(&p.tipHeight) >= height {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// fetchBlocks requests the blocks for headers[i], i in chunk, from p. Each
+// block returned must hash to the header asked for and match its Merkle root.
+func (n *Node) fetchBlocks(p *peer, headers []blockchain.BlockHeader, chunk []int) (map[string]*blockchain.Block, error) {
+	want := make(map[string]bool, len(chunk))
+	hashes := make([]string, len(chunk))
+	for j, i := range chunk {
+		hashes[j] = headers[i].Hash
+		want[headers[i].Hash] = true
+	}
+	var resp Blocks
+	id := n.nextRequestID()
+	if err := n.request(p, MsgGetBlocks, GetBlocks{ID: id, Hashes: hashes}, id, &resp); err != nil {
+		return nil, err
+	}
+	got := make(map[string]*blockchain.Block, len(resp.Blocks))
+	for _, b := range resp.Blocks {
+		if b == nil || !want[b.Hash] {
+			return nil, fmt.Errorf("%w: unrequested block in response", ErrInvalid)
+		}
+		if b.ComputeHash() != b.Hash || !b.VerifyTxIDs() || !b.VerifyMerkleRoot() || !b.VerifyWitnessRoot() {
+			return nil, fmt.Errorf("%w: block %s does not match its header", ErrInvalid, b.Hash)
+		}
+		got[b.Hash] = b
+	}
+	return got, nil
+}
+
+func (n *Node) nextRequestID() uint64 {
+	return atomic.AddUint64(&n.lastRequestID, 1)
+}
+
+// request sends payload to p and decodes the response carrying id into resp.
+func (n *Node) request(p *peer, typ string, payload interface{}, id uint64, resp interface{}) error {
+	m, err := newMessage(typ, payload)
+	if err != nil {
+		return err
+	}
+	ch := make(chan *Message, 1)
+	n.reqMu.Lock()
+	n.requests[id] = pendingRequest{peer: p, resp: ch}
+	n.reqMu.Unlock()
+	defer func() {
+		n.reqMu.Lock()
+		delete(n.requests, id)
+		n.reqMu.Unlock()
+	}()
+
+	p.send(m)
+	timer := time.NewTimer(n.cfg.RequestTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-ch:
+		if err := r.decode(resp); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("%s to %s: %w", typ, p.addr, errRequestTimeout)
+	case <-p.closed:
+		return errPeerGone
+	case <-n.ctx.Done():
+		return errNodeStopped
+	}
+}
+
+// deliver hands a response to the request waiting for it. Responses nobody
+// asked p for are dropped.
+func (n *Node) deliver(p *peer, m *Message) error {
+	var r struct {
+		ID uint64 `json:"id"`
+	}
+	if err := m.decode(&r); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	n.reqMu.Lock()
+	req, ok := n.requests[r.ID]
+	n.reqMu.Unlock()
+	if ok && req.peer == p {
+		select {
+		case req.resp <- m:
+		default:
+		}
+	}
+	return nil
+}
+
+// serveHeaders answers a getheaders request.
+func (n *Node) serveHeaders(p *peer, m *Message) error {
+	var req GetHeaders
+	if err := m.decode(&req); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	max := req.Max
+	if max <= 0 || max > MaxHeaders {
+		max = MaxHeaders
+	}
+	resp, err := newMessage(MsgHeaders, Headers{ID: req.ID, Headers: n.backend.HeadersAfter(req.Locator, max)})
+	if err != nil {
+		return err
+	}
+	p.send(resp)
+	return nil
+}
+
+// serveBlocks answers a getblocks request with the blocks we have, up to
+// what fits in one message.
+func (n *Node) serveBlocks(p *peer, m *Message) error {
+	var req GetBlocks
+	if err := m.decode(&req); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if len(req.Hashes) > MaxBlocksPerRequest {
+		return fmt.Errorf("%w: %d blocks requested at once", ErrInvalid, len(req.Hashes))
+	}
+	out := Blocks{ID: req.ID, Blocks: []*blockchain.Block{}}
+	size := 0
+	for _, hash := range req.Hashes {
+		b, err := n.backend.BlockByHash(hash)
+		if err != nil {
+			continue
+		}
+		data, err := json.Marshal(b)
+		if err != nil {
+			continue
+		}
+		// headroom for the envelope
+		if size += len(data); size > MaxMessageSize*3/4 && len(out.Blocks) > 0 {
+			break
+		}
+		out.Blocks = append(out.Blocks, b)
+	}
+	resp, err := newMessage(MsgBlocks, out)
+	if err != nil {
+		return err
+	}
+	p.send(resp)
+	return nil
+}
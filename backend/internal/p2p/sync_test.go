@@ -0,0 +1,327 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+var testBits = blockchain.DifficultyToBits(1)
+
+// testChain is a Backend that checks linkage and proof of work only, never
+// the transactions, and follows the branch with the most work.
+type testChain struct {
+	name   string // stamped into the blocks it mines, so two chains' differ
+	mu     sync.Mutex
+	index  *blockchain.BlockIndex
+	blocks map[string]*blockchain.Block
+	tip    *blockchain.IndexEntry
+}
+
+func newTestChain(t *testing.T, name string, genesis *blockchain.Block) *testChain {
+	t.Helper()
+	c := &testChain{name: name, index: blockchain.NewBlockIndex(), blocks: map[string]*blockchain.Block{}}
+	if _, err := c.ReceiveBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// extend mines n blocks on top of the block with hash from and returns the last.
+func (c *testChain) extend(t *testing.T, from string, n int) string {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		parent := c.index.Get(from)
+		tx := utxo.Transaction{Note: fmt.Sprintf("%s %d", c.name, parent.Height()+1)}
+		tx.ID = tx.ComputeID()
+		b := blockchain.CreateBlock(parent.Height()+1, from, []utxo.Transaction{tx}, testBits)
+		if _, err := c.ReceiveBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		from = b.Hash
+	}
+	return from
+}
+
+func (c *testChain) Tip() (int64, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tip.Height(), c.tip.Hash()
+}
+
+func (c *testChain) ReceiveTx(*utxo.Transaction) (bool, error) { return false, nil }
+
+func (c *testChain) ReceiveBlock(b *blockchain.Block) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.index.Get(b.Hash) != nil {
+		return false, nil
+	}
+	if b.ComputeHash() != b.Hash || !blockchain.HashMeetsTarget(b.Hash, b.Bits) {
+		return false, fmt.Errorf("%w: block %s fails proof of work", ErrInvalid, b.Hash)
+	}
+	if _, err := c.index.Add(b.Header()); err != nil {
+		return false, err
+	}
+	c.blocks[b.Hash] = b
+	c.tip = c.index.Best()
+	return true, nil
+}
+
+func (c *testChain) Locator() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tip.Locator()
+}
+
+func (c *testChain) HeadersAfter(locator []string, max int) []blockchain.BlockHeader {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var from int64
+	for _, hash := range locator {
+		if e := c.index.Get(hash); e != nil && c.tip.Ancestor(e.Height()) == e {
+			from = e.Height()
+			break
+		}
+	}
+	var out []blockchain.BlockHeader
+	for h := from + 1; h <= c.tip.Height() && len(out) < max; h++ {
+		out = append(out, c.tip.Ancestor(h).Header)
+	}
+	return out
+}
+
+func (c *testChain) CheckHeaders(headers []blockchain.BlockHeader) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	if c.index.Get(headers[0].PreviousHash) == nil {
+		return fmt.Errorf("header %d: %w", headers[0].Index, blockchain.ErrOrphanBlock)
+	}
+	prev := headers[0].PreviousHash
+	for i := range headers {
+		h := &headers[i]
+		if h.PreviousHash != prev {
+			return fmt.Errorf("%w: header %d does not link to the one before", ErrInvalid, h.Index)
+		}
+		if h.ComputeHash() != h.Hash || !blockchain.HashMeetsTarget(h.Hash, h.Bits) {
+			return fmt.Errorf("%w: header %d fails proof of work", ErrInvalid, h.Index)
+		}
+		prev = h.Hash
+	}
+	return nil
+}
+
+func (c *testChain) BlockByHash(hash string) (*blockchain.Block, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b, ok := c.blocks[hash]; ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("block %s not found", hash)
+}
+
+// forgingChain serves its chain's headers after passing them through forge.
+type forgingChain struct {
+	*testChain
+	forge func([]blockchain.BlockHeader) []blockchain.BlockHeader
+}
+
+func (c forgingChain) HeadersAfter(locator []string, max int) []blockchain.BlockHeader {
+	return c.forge(c.testChain.HeadersAfter(locator, max))
+}
+
+// stallingChain announces a tip far ahead and never answers for headers.
+type stallingChain struct {
+	*testChain
+	release chan struct{}
+}
+
+func (c stallingChain) Tip() (int64, string) { return 1000, "far-ahead" }
+
+func (c stallingChain) HeadersAfter([]string, int) []blockchain.BlockHeader {
+	<-c.release
+	return nil
+}
+
+// refusingChain fails to store any block, through no fault of the sender.
+type refusingChain struct {
+	*testChain
+	called chan struct{}
+}
+
+func (c refusingChain) ReceiveBlock(*blockchain.Block) (bool, error) {
+	c.called <- struct{}{}
+	return false, errors.New("disk full")
+}
+
+func startSyncNode(t *testing.T, genesis *blockchain.Block, backend Backend) *Node {
+	t.Helper()
+	n := NewNode(Config{
+		ListenAddr:     "127.0.0.1:0",
+		ChainID:        "test-net",
+		GenesisHash:    genesis.Hash,
+		RequestTimeout: 200 * time.Millisecond,
+	}, backend)
+	if err := n.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Stop)
+	return n
+}
+
+func waitForTip(t *testing.T, c *testChain, hash string) {
+	t.Helper()
+	waitFor(t, "tip "+hash, func() bool {
+		_, tip := c.Tip()
+		return tip == hash
+	})
+}
+
+func TestSyncCatchesUpAcrossReorg(t *testing.T) {
+	genesis := blockchain.CreateBlock(0, "", nil, testBits)
+	local, remote := newTestChain(t, "local", genesis), newTestChain(t, "remote", genesis)
+	shared := remote.extend(t, genesis.Hash, 2)
+	for h := int64(1); h <= 2; h++ {
+		b, _ := remote.BlockByHash(remote.index.Get(shared).Ancestor(h).Hash())
+		if _, err := local.ReceiveBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// local moves on to a branch of its own; remote's is longer
+	stale := local.extend(t, shared, 3)
+	best := remote.extend(t, shared, 6)
+
+	n := startSyncNode(t, genesis, local)
+	peer := startSyncNode(t, genesis, remote)
+	if err := n.Connect(peer.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	waitForTip(t, local, best)
+	if height, _ := local.Tip(); height != 8 {
+		t.Errorf("height %d after the reorg, want 8", height)
+	}
+	if local.index.Get(stale) == nil {
+		t.Error("the old branch was forgotten")
+	}
+	if st := n.SyncStatus(); st.Syncing || st.BlocksDownloaded != 6 || st.Progress != 1 {
+		t.Errorf("sync status after catching up: %+v", st)
+	}
+}
+
+func TestSyncBansPeerWithBadHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		forge func([]blockchain.BlockHeader) []blockchain.BlockHeader
+	}{
+		{"not connecting", func(hs []blockchain.BlockHeader) []blockchain.BlockHeader {
+			return hs[1:]
+		}},
+		{"broken link", func(hs []blockchain.BlockHeader) []blockchain.BlockHeader {
+			return append(hs[:2:2], hs[3:]...)
+		}},
+		{"invalid proof of work", func(hs []blockchain.BlockHeader) []blockchain.BlockHeader {
+			// a target no mined hash meets, rehashed so only the work is wrong
+			hs[1].Bits = blockchain.DifficultyToBits(60)
+			hs[1].Hash = hs[1].ComputeHash()
+			return hs
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			genesis := blockchain.CreateBlock(0, "", nil, testBits)
+			local, honest, evil := newTestChain(t, "local", genesis), newTestChain(t, "honest", genesis), newTestChain(t, "evil", genesis)
+			want := honest.extend(t, genesis.Hash, 3)
+			evil.extend(t, genesis.Hash, 5)
+
+			n := startSyncNode(t, genesis, local)
+			bad := startSyncNode(t, genesis, forgingChain{evil, tt.forge})
+			good := startSyncNode(t, genesis, honest)
+			// bans are by host, so the honest peer must already be connected
+			for _, peer := range []*Node{good, bad} {
+				if err := n.Connect(peer.Addr()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			waitFor(t, "the ban", func() bool { return n.isBanned(bad.Addr()) })
+			waitForTip(t, local, want)
+			// none of the longer forged chain was taken
+			if height, _ := local.Tip(); height != 3 {
+				t.Errorf("height %d, want 3", height)
+			}
+		})
+	}
+}
+
+func TestSyncDropsStallingPeer(t *testing.T) {
+	genesis := blockchain.CreateBlock(0, "", nil, testBits)
+	local, honest := newTestChain(t, "local", genesis), newTestChain(t, "honest", genesis)
+	want := honest.extend(t, genesis.Hash, 4)
+	release := make(chan struct{})
+	defer close(release)
+
+	n := startSyncNode(t, genesis, local)
+	stall := startSyncNode(t, genesis, stallingChain{newTestChain(t, "stall", genesis), release})
+	good := startSyncNode(t, genesis, honest)
+	if err := n.Connect(stall.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Connect(good.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	// the stalling peer announces the higher tip, so it is asked first
+	waitForTip(t, local, want)
+	if n.isBanned(stall.Addr()) {
+		t.Error("a peer that only timed out was banned")
+	}
+	if st := n.SyncStatus(); st.LastError == "" {
+		t.Errorf("no error recorded for the stalled request: %+v", st)
+	}
+}
+
+func TestBlockRaisesPeerHeightOnlyOnceValid(t *testing.T) {
+	genesis := blockchain.CreateBlock(0, "", nil, testBits)
+	remote := newTestChain(t, "remote", genesis)
+	refusing := refusingChain{newTestChain(t, "local", genesis), make(chan struct{}, 1)}
+	n := startSyncNode(t, genesis, refusing)
+	peer := startSyncNode(t, genesis, remote)
+	if err := n.Connect(peer.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the peer", func() bool { return len(n.Peers()) == 1 })
+
+	b, _ := remote.BlockByHash(remote.extend(t, genesis.Hash, 1))
+	peer.BroadcastBlock(b)
+	<-refusing.called
+	time.Sleep(50 * time.Millisecond)
+	if peers := n.Peers(); len(peers) != 1 || peers[0].TipHeight != 0 {
+		t.Errorf("peers after a block that was not stored: %+v", peers)
+	}
+}
+
+func TestOrphanBlockSyncsFromSender(t *testing.T) {
+	genesis := blockchain.CreateBlock(0, "", nil, testBits)
+	local, remote := newTestChain(t, "local", genesis), newTestChain(t, "remote", genesis)
+	n := startSyncNode(t, genesis, local)
+	peer := startSyncNode(t, genesis, remote)
+	if err := n.Connect(peer.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the peer", func() bool { return len(n.Peers()) == 1 })
+
+	// only the newest of three blocks is relayed; its height is a claim
+	// until the headers leading to it check out
+	tip := remote.extend(t, genesis.Hash, 3)
+	b, _ := remote.BlockByHash(tip)
+	peer.BroadcastBlock(b)
+	waitForTip(t, local, tip)
+	if peers := n.Peers(); len(peers) != 1 || peers[0].TipHeight != 3 {
+		t.Errorf("peers after syncing to the orphan: %+v", peers)
+	}
+}
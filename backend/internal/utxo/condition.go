@@ -0,0 +1,294 @@
+package utxo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Spending conditions.
+//
+// An output may carry a Condition that must hold before it can be spent. A
+// condition is a small tree with no loops or variables, so checking one costs
+// at most its size:
+//
+//	{"after": 1200}                  absolute timelock: spent in block 1200 or later
+//	{"after_time": 1767225600}       absolute timelock: spent in a block whose parent's median time past is at or after this Unix time
+//	{"older": 144}                   relative timelock: spent at least 144 blocks after the output was confirmed
+//	{"hash": "<hex sha256>"}         hash lock: the spend reveals a preimage (Transaction.Preimages)
+//	{"signer": "<wallet id>"}        the spend is sent by this wallet
+//	{"all": [...]}, {"any": [...]}   every one, or at least one, of the sub-conditions
+//
+// The wallets named in signer leaves are the ones that may spend the output.
+// A spend that meets the condition without a signer leaf, such as the second
+// branch of {"any": [{"signer": bob}, {"after": 1200}]}, must come from the
+// output's recipient, so no branch leaves an output to anyone. A hashed
+// timelock contract paying bob against a secret, refundable to alice from
+// block 5000:
+//
+//	{"any": [{"all": [{"signer": bob}, {"hash": H}]},
+//	         {"all": [{"signer": alice}, {"after": 5000}]}]}
+
+const (
+	// MaxConditionDepth and MaxConditionNodes bound a condition's nesting and size.
+	MaxConditionDepth = 4
+	MaxConditionNodes = 16
+	// MaxPreimages and MaxPreimageSize bound the preimages one transaction reveals.
+	MaxPreimages    = 8
+	MaxPreimageSize = 64
+)
+
+// ErrInvalidCondition is returned for a malformed condition.
+var ErrInvalidCondition = errors.New("invalid spending condition")
+
+// ErrLocked marks a spend refused only because a timelock has not been
+// reached yet: the same spend may be allowed in a later block.
+var ErrLocked = errors.New("locked")
+
+// lockError is a timelock that does not hold yet; it matches ErrLocked.
+type lockError struct{ msg string }
+
+func (e *lockError) Error() string        { return e.msg }
+func (e *lockError) Is(target error) bool { return target == ErrLocked }
+
+func locked(format string, args ...interface{}) error {
+	return &lockError{fmt.Sprintf(format, args...)}
+}
+
+// Condition is a spending condition; exactly one of its fields is set.
+type Condition struct {
+	After     int64       `json:"after,omitempty"`
+	AfterTime int64       `json:"after_time,omitempty"`
+	Older     int64       `json:"older,omitempty"`
+	Hash      string      `json:"hash,omitempty"`
+	Signer    string      `json:"signer,omitempty"`
+	All       []Condition `json:"all,omitempty"`
+	Any       []Condition `json:"any,omitempty"`
+}
+
+// Condition kinds, as written in the canonical encoding.
+const (
+	condAfter byte = iota + 1
+	condAfterTime
+	condOlder
+	condHash
+	condSigner
+	condAll
+	condAny
+)
+
+// kind returns which field of c is set, or 0 unless exactly one is.
+func (c *Condition) kind() byte {
+	var k byte
+	n := 0
+	set := func(ok bool, kind byte) {
+		if ok {
+			k = kind
+			n++
+		}
+	}
+	set(c.After != 0, condAfter)
+	set(c.AfterTime != 0, condAfterTime)
+	set(c.Older != 0, condOlder)
+	set(c.Hash != "", condHash)
+	set(c.Signer != "", condSigner)
+	set(c.All != nil, condAll)
+	set(c.Any != nil, condAny)
+	if n != 1 {
+		return 0
+	}
+	return k
+}
+
+// Validate checks that c is well formed and within the size limits.
+func (c *Condition) Validate() error {
+	nodes := 0
+	return c.validate(1, &nodes)
+}
+
+func (c *Condition) validate(depth int, nodes *int) error {
+	if *nodes++; *nodes > MaxConditionNodes {
+		return fmt.Errorf("%w: more than %d nodes", ErrInvalidCondition, MaxConditionNodes)
+	}
+	if depth > MaxConditionDepth {
+		return fmt.Errorf("%w: nested deeper than %d", ErrInvalidCondition, MaxConditionDepth)
+	}
+	switch c.kind() {
+	case condAfter, condAfterTime, condOlder:
+		if c.After < 0 || c.AfterTime < 0 || c.Older < 0 {
+			return fmt.Errorf("%w: timelocks must be positive", ErrInvalidCondition)
+		}
+	case condHash:
+		if b, err := hex.DecodeString(c.Hash); err != nil || len(b) != sha256.Size || c.Hash != strings.ToLower(c.Hash) {
+			return fmt.Errorf("%w: hash must be a lowercase hex SHA-256", ErrInvalidCondition)
+		}
+	case condSigner:
+		// signers are wallet IDs, like output recipients
+	case condAll, condAny:
+		subs := c.All
+		if c.Any != nil {
+			subs = c.Any
+		}
+		if len(subs) == 0 {
+			return fmt.Errorf("%w: all/any needs at least one condition", ErrInvalidCondition)
+		}
+		for i := range subs {
+			if err := subs[i].validate(depth+1, nodes); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: each condition sets exactly one of after, after_time, older, hash, signer, all, any", ErrInvalidCondition)
+	}
+	return nil
+}
+
+// SpendContext is what a condition is checked against.
+type SpendContext struct {
+	// Sender is the wallet spending the output.
+	Sender string
+	// Height is that of the block the spend is in, or for a spend not yet
+	// mined the next height. Time is the median time past of the blocks
+	// before it, which no single miner can move forward.
+	Height int64
+	Time   time.Time
+	// ConfirmedAt is the height of the block that created the output, when
+	// Confirmed; an unconfirmed output satisfies no relative timelock.
+	ConfirmedAt int64
+	Confirmed   bool
+	// Hashes are the hex SHA-256 hashes of the spend's preimages.
+	Hashes map[string]bool
+}
+
+// Check returns nil if c holds for ctx, else why it does not.
+func (c *Condition) Check(ctx *SpendContext) error {
+	_, err := c.check(ctx)
+	return err
+}
+
+// check is Check, also reporting whether the way c holds passes through a
+// signer leaf. An any prefers an alternative that does.
+func (c *Condition) check(ctx *SpendContext) (signed bool, err error) {
+	switch c.kind() {
+	case condAfter:
+		if ctx.Height < c.After {
+			return false, locked("locked until block %d", c.After)
+		}
+	case condAfterTime:
+		if ctx.Time.Unix() < c.AfterTime {
+			return false, locked("locked until %s", time.Unix(c.AfterTime, 0).UTC().Format(time.RFC3339))
+		}
+	case condOlder:
+		if !ctx.Confirmed {
+			return false, locked("locked for %d blocks after confirmation, not yet confirmed", c.Older)
+		}
+		if ctx.Height-ctx.ConfirmedAt < c.Older {
+			return false, locked("locked until block %d (%d blocks after confirmation)", ctx.ConfirmedAt+c.Older, c.Older)
+		}
+	case condHash:
+		if !ctx.Hashes[c.Hash] {
+			return false, fmt.Errorf("no preimage of hash %s", c.Hash)
+		}
+	case condSigner:
+		if ctx.Sender != c.Signer {
+			return false, fmt.Errorf("must be spent by wallet %s", c.Signer)
+		}
+		return true, nil
+	case condAll:
+		for i := range c.All {
+			ok, err := c.All[i].check(ctx)
+			if err != nil {
+				return false, err
+			}
+			signed = signed || ok
+		}
+		return signed, nil
+	case condAny:
+		held, later := false, false
+		reasons := make([]string, 0, len(c.Any))
+		for i := range c.Any {
+			ok, err := c.Any[i].check(ctx)
+			if err != nil {
+				reasons = append(reasons, err.Error())
+				later = later || errors.Is(err, ErrLocked)
+				continue
+			}
+			if ok {
+				return true, nil
+			}
+			held = true
+		}
+		if held {
+			return false, nil
+		}
+		if later {
+			return false, locked("no alternative holds yet (%s)", strings.Join(reasons, "; "))
+		}
+		return false, fmt.Errorf("no alternative holds (%s)", strings.Join(reasons, "; "))
+	default:
+		return false, ErrInvalidCondition
+	}
+	return false, nil
+}
+
+// CheckSpend returns nil if ctx may spend u: an output without a condition
+// only by its owner, one with a condition by meeting it, and by its owner
+// too unless the condition holds through a signer leaf.
+func CheckSpend(u *UTXO, ctx *SpendContext) error {
+	if u.Condition == nil {
+		if ctx.Sender != u.WalletID {
+			return errors.New("does not belong to sender")
+		}
+		return nil
+	}
+	signed, err := u.Condition.check(ctx)
+	if errors.Is(err, ErrLocked) {
+		// only locked if the spend would be allowed once every timelock passed
+		open := *ctx
+		open.Height, open.Time = math.MaxInt64, time.Unix(1<<62, 0)
+		open.ConfirmedAt, open.Confirmed = 0, true
+		if ok, err := u.Condition.check(&open); err != nil {
+			return fmt.Errorf("spending condition not met: %v", err)
+		} else if !ok && ctx.Sender != u.WalletID {
+			return fmt.Errorf("spending condition not met: met without a signer, so must be spent by wallet %s", u.WalletID)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("spending condition not met: %w", err)
+	}
+	if !signed && ctx.Sender != u.WalletID {
+		return fmt.Errorf("spending condition not met: met without a signer, so must be spent by wallet %s", u.WalletID)
+	}
+	return nil
+}
+
+// HasConditions reports whether any of t's outputs carries a condition.
+func (t *Transaction) HasConditions() bool {
+	for _, o := range t.Outputs {
+		if o.Condition != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// PreimageHashes checks t's preimages and returns their hex SHA-256 hashes.
+func (t *Transaction) PreimageHashes() (map[string]bool, error) {
+	if len(t.Preimages) > MaxPreimages {
+		return nil, fmt.Errorf("%d preimages, limit %d", len(t.Preimages), MaxPreimages)
+	}
+	hashes := make(map[string]bool, len(t.Preimages))
+	for i, p := range t.Preimages {
+		b, err := hex.DecodeString(p)
+		if err != nil || len(b) == 0 || len(b) > MaxPreimageSize {
+			return nil, fmt.Errorf("preimage %d must be 1 to %d hex-encoded bytes", i, MaxPreimageSize)
+		}
+		h := sha256.Sum256(b)
+		hashes[hex.EncodeToString(h[:])] = true
+	}
+	return hashes, nil
+}
@@ -0,0 +1,193 @@
+package utxo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	alice = "alice"
+	bob   = "bob"
+	carol = "carol"
+)
+
+var (
+	secretHash = func() string {
+		h := sha256.Sum256([]byte("secret"))
+		return hex.EncodeToString(h[:])
+	}()
+	unlockTime = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// spendAt returns a spend by sender in block 100, whose median time past is
+// mtp, of an output confirmed in block 90, revealing the secret.
+func spendAt(sender string, mtp time.Time) *SpendContext {
+	return &SpendContext{Sender: sender, Height: 100, Time: mtp, ConfirmedAt: 90, Confirmed: true,
+		Hashes: map[string]bool{secretHash: true}}
+}
+
+func TestCheckSpend(t *testing.T) {
+	before, after := unlockTime.Add(-time.Second), unlockTime
+	htlc := &Condition{Any: []Condition{
+		{All: []Condition{{Signer: bob}, {Hash: secretHash}}},
+		{All: []Condition{{Signer: alice}, {After: 5000}}},
+	}}
+	// carol may spend from block 100; with the secret, so may the recipient
+	deepSigner := &Condition{Any: []Condition{
+		{All: []Condition{{After: 100}, {Any: []Condition{{Hash: secretHash}, {Signer: carol}}}}},
+	}}
+
+	tests := []struct {
+		name string
+		cond *Condition
+		ctx  *SpendContext
+		want string // empty: the spend is allowed
+	}{
+		{"no condition, owner", nil, spendAt(alice, after), ""},
+		{"no condition, someone else", nil, spendAt(bob, after), "does not belong to sender"},
+
+		{"after, reached", &Condition{After: 100}, spendAt(alice, after), ""},
+		{"after, not reached", &Condition{After: 101}, spendAt(alice, after), "locked until block 101"},
+		{"after, reached by someone else", &Condition{After: 100}, spendAt(bob, after), "must be spent by wallet alice"},
+
+		{"after_time, reached", &Condition{AfterTime: unlockTime.Unix()}, spendAt(alice, after), ""},
+		{"after_time, not reached", &Condition{AfterTime: unlockTime.Unix()}, spendAt(alice, before), "locked until 2026-01-01T00:00:00Z"},
+
+		{"older, old enough", &Condition{Older: 10}, spendAt(alice, after), ""},
+		{"older, too young", &Condition{Older: 11}, spendAt(alice, after), "locked until block 101"},
+		{"older, unconfirmed", &Condition{Older: 1}, &SpendContext{Sender: alice, Height: 100}, "not yet confirmed"},
+
+		{"hash, revealed", &Condition{Hash: secretHash}, spendAt(alice, after), ""},
+		{"hash, not revealed", &Condition{Hash: secretHash}, &SpendContext{Sender: alice, Height: 100}, "no preimage"},
+		{"hash, revealed by someone else", &Condition{Hash: secretHash}, spendAt(bob, after), "must be spent by wallet alice"},
+
+		{"signer, named", &Condition{Signer: bob}, spendAt(bob, after), ""},
+		{"signer, recipient not named", &Condition{Signer: bob}, spendAt(alice, after), "must be spent by wallet bob"},
+
+		{"all, every one holds", &Condition{All: []Condition{{Signer: bob}, {After: 100}, {Hash: secretHash}}}, spendAt(bob, after), ""},
+		{"all, one fails", &Condition{All: []Condition{{Signer: bob}, {After: 101}}}, spendAt(bob, after), "locked until block 101"},
+
+		{"any, one holds", &Condition{Any: []Condition{{After: 101}, {Hash: secretHash}}}, spendAt(alice, after), ""},
+		{"any, none holds", &Condition{Any: []Condition{{After: 101}, {Signer: bob}}}, spendAt(alice, after), "no alternative holds"},
+
+		// a branch without a signer leaves the output to the recipient, not to anyone
+		{"any signer or timelock, the signer", &Condition{Any: []Condition{{Signer: bob}, {After: 100}}}, spendAt(bob, after), ""},
+		{"any signer or timelock, the recipient after it", &Condition{Any: []Condition{{Signer: bob}, {After: 100}}}, spendAt(alice, after), ""},
+		{"any signer or timelock, the recipient before it", &Condition{Any: []Condition{{Signer: bob}, {After: 101}}}, spendAt(alice, after), "no alternative holds"},
+		{"any signer or timelock, a stranger after it", &Condition{Any: []Condition{{Signer: bob}, {After: 100}}}, spendAt(carol, after), "must be spent by wallet alice"},
+		// an unsigned branch that holds does not hide a signed one that also does
+		{"any prefers the signed branch", &Condition{Any: []Condition{{After: 100}, {Signer: bob}}}, spendAt(bob, after), ""},
+
+		{"nested, htlc claim", htlc, spendAt(bob, before), ""},
+		{"nested, htlc claim without the secret", htlc, &SpendContext{Sender: bob, Height: 100}, "no preimage"},
+		{"nested, htlc refund too early", htlc, spendAt(alice, after), "locked until block 5000"},
+		{"nested, htlc stranger with the secret", htlc, spendAt(carol, after), "no alternative holds"},
+		{"nested, any in all", &Condition{All: []Condition{
+			{Any: []Condition{{Signer: bob}, {Signer: carol}}},
+			{Any: []Condition{{After: 200}, {AfterTime: unlockTime.Unix()}}},
+		}}, spendAt(carol, after), ""},
+		{"nested, any in all, timelocks fail", &Condition{All: []Condition{
+			{Any: []Condition{{Signer: bob}, {Signer: carol}}},
+			{Any: []Condition{{After: 200}, {AfterTime: unlockTime.Unix()}}},
+		}}, spendAt(carol, before), "no alternative holds"},
+		{"nested, deep signer", deepSigner, spendAt(carol, after), ""},
+		{"nested, deep signer bypassed by a stranger", deepSigner, spendAt(bob, after), "must be spent by wallet alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cond != nil {
+				if err := tt.cond.Validate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			u := &UTXO{ID: "tx:0", TxID: "tx", WalletID: alice, Amount: 10, Condition: tt.cond}
+			err := CheckSpend(u, tt.ctx)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("refused: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionValidate(t *testing.T) {
+	deep := Condition{Signer: bob}
+	for i := 0; i < MaxConditionDepth; i++ {
+		deep = Condition{All: []Condition{deep}}
+	}
+	wide := Condition{Any: make([]Condition, MaxConditionNodes)}
+	for i := range wide.Any {
+		wide.Any[i] = Condition{After: int64(i + 1)}
+	}
+	tests := []struct {
+		name string
+		cond Condition
+		ok   bool
+	}{
+		{"leaf", Condition{After: 1}, true},
+		{"nothing set", Condition{}, false},
+		{"two fields set", Condition{After: 1, Signer: bob}, false},
+		{"negative timelock", Condition{Older: -1}, false},
+		{"uppercase hash", Condition{Hash: strings.ToUpper(secretHash)}, false},
+		{"short hash", Condition{Hash: secretHash[:62]}, false},
+		{"empty any", Condition{Any: []Condition{}}, false},
+		{"too deep", deep, false},
+		{"as deep as allowed", deep.All[0], true},
+		{"too many nodes", wide, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cond.Validate()
+			if tt.ok && err != nil {
+				t.Errorf("rejected: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidCondition) {
+				t.Errorf("err = %v, want ErrInvalidCondition", err)
+			}
+		})
+	}
+}
+
+// A spend refused only for a timelock not reached yet is ErrLocked: the same
+// spend is allowed later. One refused for anything else is not.
+func TestCheckSpendLocked(t *testing.T) {
+	after := unlockTime
+	tests := []struct {
+		name   string
+		cond   *Condition
+		ctx    *SpendContext
+		locked bool
+	}{
+		{"after", &Condition{After: 101}, spendAt(alice, after), true},
+		{"after_time", &Condition{AfterTime: unlockTime.Unix()}, spendAt(alice, after.Add(-time.Second)), true},
+		{"older", &Condition{Older: 11}, spendAt(alice, after), true},
+		{"older, unconfirmed", &Condition{Older: 1}, &SpendContext{Sender: alice, Height: 100}, true},
+		{"any, the timelocked branch later", &Condition{Any: []Condition{{Signer: bob}, {After: 101}}}, spendAt(alice, after), true},
+		{"all, signer and timelock", &Condition{All: []Condition{{After: 101}, {Signer: bob}}}, spendAt(bob, after), true},
+		{"all, timelock and a wrong signer", &Condition{All: []Condition{{After: 101}, {Signer: bob}}}, spendAt(carol, after), false},
+		{"any, a stranger after the timelock", &Condition{Any: []Condition{{Signer: bob}, {After: 101}}}, spendAt(carol, after), false},
+		{"hash", &Condition{Hash: secretHash}, &SpendContext{Sender: alice, Height: 100}, false},
+		{"no condition, someone else", nil, spendAt(bob, after), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &UTXO{ID: "tx:0", TxID: "tx", WalletID: alice, Amount: 10, Condition: tt.cond}
+			err := CheckSpend(u, tt.ctx)
+			if err == nil {
+				t.Fatal("spend allowed")
+			}
+			if got := errors.Is(err, ErrLocked); got != tt.locked {
+				t.Errorf("locked = %v, want %v (%v)", got, tt.locked, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package utxo
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// Canonical transaction encoding.
+//
+// The signed bytes of a transaction are a deterministic binary encoding of
+// every field that affects value transfer. The txid is the hex SHA-256 of the
+// same bytes, and the Ed25519 signature is made over them, so a signature
+// commits to every input and every output (including change), to the chain it
+// was made for and to the sender's nonce. The signature itself is not part of
+// the encoding; blocks commit to it through the witness hash below.
+//
+//	"DWTX"                  magic, 4 bytes
+//	version                 uint8
+//	chain_id                str
+//	sender                  str
+//	sender_public_key       str
+//	nonce                   uint64
+//	inputs                  uint32 count, then count × str (UTXO IDs, in tx order)
+//	outputs                 uint32 count, then count × (str recipient, int64 amount)
+//	note                    str
+//	timestamp               str (ClientTimestamp, exactly as signed)
+//	conditions              only if an output has a condition: uint32 count,
+//	                        then count × (uint32 output index, cond)
+//
+// cond is a uint8 kind, then for after (1), after_time (2) and older (3) an
+// int64, for hash (4) and signer (5) a str, and for all (6) and any (7) a
+// uint32 count followed by count × cond. Transactions without conditions
+// encode exactly as before they existed, so their txids are unchanged.
+//
+// str is a uint32 byte length followed by UTF-8 bytes. All integers are
+// big-endian. frontend/src/lib/txEncoding.js produces the same bytes.
+
+// TxEncodingVersion is the version byte of the canonical encoding.
+const TxEncodingVersion = 2
+
+var txMagic = []byte("DWTX")
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendInt64(b []byte, v int64) []byte {
+	return appendUint64(b, uint64(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// SigningBytes returns the canonical encoding of the transaction.
+func (t *Transaction) SigningBytes() []byte {
+	b := make([]byte, 0, 256)
+	b = append(b, txMagic...)
+	b = append(b, TxEncodingVersion)
+	b = appendString(b, t.ChainID)
+	b = appendString(b, t.Sender)
+	b = appendString(b, t.SenderPublicKey)
+	b = appendUint64(b, t.Nonce)
+	b = appendUint32(b, uint32(len(t.Inputs)))
+	for _, in := range t.Inputs {
+		b = appendString(b, in)
+	}
+	b = appendUint32(b, uint32(len(t.Outputs)))
+	for _, o := range t.Outputs {
+		b = appendString(b, o.Recipient)
+		b = appendInt64(b, o.Amount)
+	}
+	b = appendString(b, t.Note)
+	b = appendString(b, t.ClientTimestamp)
+	if t.HasConditions() {
+		var n uint32
+		for _, o := range t.Outputs {
+			if o.Condition != nil {
+				n++
+			}
+		}
+		b = appendUint32(b, n)
+		for i, o := range t.Outputs {
+			if o.Condition != nil {
+				b = appendUint32(b, uint32(i))
+				b = appendCondition(b, o.Condition)
+			}
+		}
+	}
+	return b
+}
+
+func appendCondition(b []byte, c *Condition) []byte {
+	k := c.kind()
+	b = append(b, k)
+	switch k {
+	case condAfter:
+		b = appendInt64(b, c.After)
+	case condAfterTime:
+		b = appendInt64(b, c.AfterTime)
+	case condOlder:
+		b = appendInt64(b, c.Older)
+	case condHash:
+		b = appendString(b, c.Hash)
+	case condSigner:
+		b = appendString(b, c.Signer)
+	case condAll, condAny:
+		subs := c.All
+		if k == condAny {
+			subs = c.Any
+		}
+		b = appendUint32(b, uint32(len(subs)))
+		for i := range subs {
+			b = appendCondition(b, &subs[i])
+		}
+	}
+	return b
+}
+
+// ComputeID returns the txid: hex SHA-256 of the canonical encoding.
+func (t *Transaction) ComputeID() string {
+	h := sha256.Sum256(t.SigningBytes())
+	return hex.EncodeToString(h[:])
+}
+
+// Witness encoding.
+//
+// The txid leaves out what a transaction carries besides its signed bytes:
+// its signatures and preimages, and the fee, receiver and amount, which are
+// derived from the signed fields and checked against them. A block commits to
+// all of it through each transaction's witness hash, the hex SHA-256 of
+//
+//	"DWWT"                  magic, 4 bytes
+//	txid                    str
+//	fee                     int64
+//	receiver                str
+//	amount                  int64
+//	signature               str (the raw signature bytes)
+//	signatures              uint32 count, then count × (str public key, str signature bytes)
+//	preimages               uint32 count, then count × str (hex, as carried)
+//
+// in the encoding above. Timestamp is informational; no rule reads it.
+
+var witnessMagic = []byte("DWWT")
+
+// WitnessHash returns the witness hash: hex SHA-256 of the txid and the
+// fields a block must commit to besides it.
+func (t *Transaction) WitnessHash() string {
+	b := make([]byte, 0, 256)
+	b = append(b, witnessMagic...)
+	b = appendString(b, t.ID)
+	b = appendInt64(b, t.Fee)
+	b = appendString(b, t.Receiver)
+	b = appendInt64(b, t.Amount)
+	b = appendString(b, string(t.Signature))
+	b = appendUint32(b, uint32(len(t.Signatures)))
+	for _, s := range t.Signatures {
+		b = appendString(b, s.PublicKey)
+		b = appendString(b, string(s.Signature))
+	}
+	b = appendUint32(b, uint32(len(t.Preimages)))
+	for _, p := range t.Preimages {
+		b = appendString(b, p)
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// Sign sets SenderPublicKey, ID and Signature using priv. Callers fill in every
+// other field first; changing any of them afterwards invalidates the signature.
+func (t *Transaction) Sign(priv ed25519.PrivateKey) {
+	t.SenderPublicKey = base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey))
+	msg := t.SigningBytes()
+	t.ID = t.ComputeID()
+	t.Signature = ed25519.Sign(priv, msg)
+}
+
+// Size is the transaction's serialized size in bytes (canonical encoding plus
+// signatures and preimages), used for fee rates and block byte limits.
+func (t *Transaction) Size() int {
+	n := len(t.SigningBytes()) + len(t.Signature)
+	for _, s := range t.Signatures {
+		n += len(s.PublicKey) + len(s.Signature)
+	}
+	for _, p := range t.Preimages {
+		n += len(p) / 2
+	}
+	return n
+}
@@ -3,10 +3,35 @@ package utxo
 import (
     "crypto/sha256"
     "encoding/hex"
+    "errors"
+    "fmt"
+    "math"
     "sync"
     "time"
 )
 
+const (
+    // SystemSender is the sender of admin-issued funding transactions (no
+    // inputs). Those mined in blocks are signed by the network authority.
+    SystemSender = "system"
+    // FundingNote marks admin funding transactions.
+    FundingNote = "admin_funding"
+    // ZakatNote marks node-generated zakat deductions, which carry no user
+    // signature; the network authority signs them instead.
+    ZakatNote = "zakat_deduction"
+    // CoinbaseSender is the sender of block reward transactions (no inputs).
+    CoinbaseSender = "coinbase"
+    // MaxTxOutputs caps outputs per transaction (change included); UTXO IDs
+    // encode the output index in a single byte.
+    MaxTxOutputs = 256
+)
+
+// ZakatDue is the zakat owed on amount: 2.5%, rounded down.
+func ZakatDue(amount int64) int64 {
+    // split so amount*25 cannot overflow
+    return amount/1000*25 + amount%1000*25/1000
+}
+
 // Amounts are stored as integer minor units (e.g., cents) to avoid floating point issues.
 type UTXO struct {
     ID        string `json:"id"`
@@ -16,11 +41,15 @@ type UTXO struct {
     Amount    int64  `json:"amount"`
     Spent     bool   `json:"spent"`
     CreatedAt time.Time `json:"created_at"`
+    // Condition is the creating output's spending condition, if any.
+    Condition *Condition `json:"condition,omitempty"`
 }
 
 type TxOutput struct {
     Recipient string `json:"recipient"`
     Amount    int64  `json:"amount"`
+    // Condition, if set, must hold for the output to be spent (see condition.go).
+    Condition *Condition `json:"condition,omitempty"`
 }
 
 type Transaction struct {
@@ -34,13 +63,87 @@ type Transaction struct {
     Signature       []byte     `json:"signature"`
     Inputs          []string   `json:"inputs"` // UTXO IDs
     Outputs         []TxOutput `json:"outputs"`
+    // ClientTimestamp is the exact timestamp string the sender signed.
+    ClientTimestamp string     `json:"client_timestamp,omitempty"`
+    // ChainID binds the signature to one network so it cannot be replayed on another.
+    ChainID         string     `json:"chain_id,omitempty"`
+    // Nonce is the sender's sequence number: each signed tx must use the
+    // wallet's last accepted nonce + 1. System transactions use 0.
+    Nonce           uint64     `json:"nonce"`
+    // Fee is inputs minus outputs, claimed by the coinbase of the block that
+    // mines the tx. It is derived (and checked) rather than signed.
+    Fee             int64      `json:"fee"`
+    // Signatures are the co-signers' signatures when the sender is a multisig
+    // wallet (SenderPublicKey is then its policy and Signature stays empty).
+    Signatures      []TxSignature `json:"signatures,omitempty"`
+    // Preimages (hex) unlock the hash locks of the outputs this tx spends.
+    // Like signatures they are not part of the signed bytes.
+    Preimages       []string   `json:"preimages,omitempty"`
+}
+
+// TxSignature is one co-signer's signature of a multisig transaction.
+type TxSignature struct {
+    PublicKey string `json:"public_key"` // base64, one of the policy's keys
+    Signature []byte `json:"signature"`
+}
+
+// IsCoinbase reports whether t is a block reward transaction.
+func (t *Transaction) IsCoinbase() bool {
+    return t.Sender == CoinbaseSender
+}
+
+// SumOutputs checks that every output has a recipient and a positive amount,
+// that there are at most MaxTxOutputs of them, and returns their total.
+func SumOutputs(outs []TxOutput) (int64, error) {
+    if len(outs) == 0 {
+        return 0, errors.New("transaction has no outputs")
+    }
+    if len(outs) > MaxTxOutputs {
+        return 0, fmt.Errorf("too many outputs: %d > %d", len(outs), MaxTxOutputs)
+    }
+    var total int64
+    for i, o := range outs {
+        if o.Recipient == "" {
+            return 0, fmt.Errorf("output %d has no recipient", i)
+        }
+        if o.Amount <= 0 {
+            return 0, fmt.Errorf("output %d amount must be positive", i)
+        }
+        if o.Amount > math.MaxInt64-total {
+            return 0, errors.New("output total overflows")
+        }
+        if o.Condition != nil {
+            if err := o.Condition.Validate(); err != nil {
+                return 0, fmt.Errorf("output %d: %w", i, err)
+            }
+        }
+        total += o.Amount
+    }
+    return total, nil
+}
+
+// OutputUTXOID returns the ID of the UTXO created by output index of this tx.
+func (t *Transaction) OutputUTXOID(index int) string {
+    return calcUTXOID(t.ID, index)
+}
+
+// OutputUTXOs builds (without storing) the UTXOs created by this tx's outputs.
+func (t *Transaction) OutputUTXOs() []*UTXO {
+    res := make([]*UTXO, 0, len(t.Outputs))
+    for i, o := range t.Outputs {
+        u := NewUTXO(t.ID, i, o.Recipient, o.Amount)
+        u.Condition = o.Condition
+        res = append(res, u)
+    }
+    return res
 }
 
 // In-memory stores for quick testing before DB integration.
+// Pending transactions live in the mempool package.
 var (
     mu         sync.RWMutex
-    UTXOSet    = map[string]*UTXO{}
-    PendingTxs = map[string]*Transaction{}
+    // UTXOSet mirrors the stored outputs, indexed by wallet.
+    UTXOSet    = NewSet()
     Wallets    = map[string]string{} // walletID -> publicKey (base64)
 )
 
@@ -51,10 +154,10 @@ func calcUTXOID(txid string, index int) string {
     return hex.EncodeToString(h.Sum(nil))
 }
 
-func CreateUTXO(txid string, index int, walletID string, amount int64) *UTXO {
-    id := calcUTXOID(txid, index)
-    u := &UTXO{
-        ID:       id,
+// NewUTXO builds an unspent output without adding it to the in-memory set.
+func NewUTXO(txid string, index int, walletID string, amount int64) *UTXO {
+    return &UTXO{
+        ID:       calcUTXOID(txid, index),
         TxID:     txid,
         Index:    index,
         WalletID: walletID,
@@ -62,40 +165,30 @@ func CreateUTXO(txid string, index int, walletID string, amount int64) *UTXO {
         Spent:    false,
         CreatedAt: time.Now().UTC(),
     }
-    mu.Lock()
-    defer mu.Unlock()
-    UTXOSet[id] = u
+}
+
+// AddUTXO inserts (or replaces) an output in the in-memory set.
+func AddUTXO(u *UTXO) {
+    UTXOSet.Add(u)
+}
+
+func CreateUTXO(txid string, index int, walletID string, amount int64) *UTXO {
+    u := NewUTXO(txid, index, walletID, amount)
+    AddUTXO(u)
     return u
 }
 
 func GetUTXO(id string) (*UTXO, bool) {
-    mu.RLock()
-    defer mu.RUnlock()
-    u, ok := UTXOSet[id]
-    return u, ok
+    return UTXOSet.Get(id)
 }
 
 func MarkUTXOSpent(id string) bool {
-    mu.Lock()
-    defer mu.Unlock()
-    u, ok := UTXOSet[id]
-    if !ok || u.Spent {
-        return false
-    }
-    u.Spent = true
-    return true
+    return UTXOSet.MarkSpent(id)
 }
 
+// WalletBalance returns the wallet's unspent total without scanning the set.
 func WalletBalance(walletID string) int64 {
-    mu.RLock()
-    defer mu.RUnlock()
-    var sum int64
-    for _, u := range UTXOSet {
-        if u.WalletID == walletID && !u.Spent {
-            sum += u.Amount
-        }
-    }
-    return sum
+    return UTXOSet.Balance(walletID)
 }
 
 // RegisterWallet stores a wallet public key (base64) for a walletID.
@@ -113,28 +206,18 @@ func GetWalletPublicKey(walletID string) (string, bool) {
     return p, ok
 }
 
-// AddPendingTx adds a transaction to the pending pool.
-func AddPendingTx(tx *Transaction) {
-    mu.Lock()
-    defer mu.Unlock()
-    PendingTxs[tx.ID] = tx
+// MarkUTXOUnspent returns an output to the spendable set (its spending tx left the mempool).
+func MarkUTXOUnspent(id string) {
+    UTXOSet.MarkUnspent(id)
 }
 
-// GetPendingTx returns a pending transaction by id.
-func GetPendingTx(id string) (*Transaction, bool) {
-    mu.RLock()
-    defer mu.RUnlock()
-    t, ok := PendingTxs[id]
-    return t, ok
+// RemoveUTXO deletes an output from the in-memory set.
+func RemoveUTXO(id string) {
+    UTXOSet.Remove(id)
 }
 
-// GetPendingTransactions returns all pending transactions.
-func GetPendingTransactions() []*Transaction {
-    mu.RLock()
-    defer mu.RUnlock()
-    result := make([]*Transaction, 0, len(PendingTxs))
-    for _, tx := range PendingTxs {
-        result = append(result, tx)
-    }
-    return result
+// ResetUTXOSet replaces the in-memory set wholesale, e.g. with one rebuilt
+// from the chain at startup.
+func ResetUTXOSet(set map[string]*UTXO) {
+    UTXOSet.Reset(set)
 }
@@ -0,0 +1,76 @@
+package utxo
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/student/decentralized-wallet/internal/crypto"
+)
+
+// ErrBadCoSignature is returned for a signature that is not a valid signature
+// of the transaction by one of its policy's keys.
+var ErrBadCoSignature = errors.New("invalid co-signature")
+
+// IsMultisig reports whether t is sent from a multisig wallet.
+func (t *Transaction) IsMultisig() bool {
+	return crypto.IsMultisigPolicy(t.SenderPublicKey)
+}
+
+// HasSignature reports whether t carries a signature of either kind.
+func (t *Transaction) HasSignature() bool {
+	return len(t.Signature) > 0 || len(t.Signatures) > 0
+}
+
+// AddSignature adds pub's signature of t, replacing any earlier one by the
+// same key. It fails unless t is a multisig transaction, pub is one of its
+// policy's keys and sig is valid. Signatures are not part of the signed
+// bytes, so adding one leaves the txid unchanged.
+func (t *Transaction) AddSignature(pub string, sig []byte) error {
+	p, err := crypto.ParseMultisigPolicy(t.SenderPublicKey)
+	if err != nil {
+		return err
+	}
+	if !p.HasKey(pub) {
+		return fmt.Errorf("%w: key is not one of the wallet's", ErrBadCoSignature)
+	}
+	if ok, _ := crypto.VerifyEd25519Signature(pub, t.SigningBytes(), base64.StdEncoding.EncodeToString(sig)); !ok {
+		return fmt.Errorf("%w: signature does not verify", ErrBadCoSignature)
+	}
+	for i := range t.Signatures {
+		if t.Signatures[i].PublicKey == pub {
+			t.Signatures[i].Signature = sig
+			return nil
+		}
+	}
+	t.Signatures = append(t.Signatures, TxSignature{PublicKey: pub, Signature: sig})
+	return nil
+}
+
+// CoSign signs a multisig transaction with priv, one of its policy's keys.
+func (t *Transaction) CoSign(priv ed25519.PrivateKey) error {
+	pub := base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey))
+	return t.AddSignature(pub, ed25519.Sign(priv, t.SigningBytes()))
+}
+
+// CountSignatures returns how many distinct keys of a multisig transaction's
+// policy have a valid signature on it, and the policy. Signatures by other
+// keys, invalid ones and repeats of a key are not counted.
+func (t *Transaction) CountSignatures() (int, *crypto.MultisigPolicy, error) {
+	p, err := crypto.ParseMultisigPolicy(t.SenderPublicKey)
+	if err != nil {
+		return 0, nil, err
+	}
+	msg := t.SigningBytes()
+	counted := map[string]bool{}
+	for _, s := range t.Signatures {
+		if counted[s.PublicKey] || !p.HasKey(s.PublicKey) {
+			continue
+		}
+		if ok, _ := crypto.VerifyEd25519Signature(s.PublicKey, msg, base64.StdEncoding.EncodeToString(s.Signature)); ok {
+			counted[s.PublicKey] = true
+		}
+	}
+	return len(counted), p, nil
+}
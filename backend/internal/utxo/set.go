@@ -0,0 +1,282 @@
+package utxo
+
+import (
+	"sort"
+	"sync"
+)
+
+// Set is an in-memory UTXO set indexed by wallet. Spent outputs stay in the
+// set (a pending spend can still be undone) but leave their wallet's index,
+// so balances and listings only ever touch the wallet's unspent outputs.
+// It is safe for concurrent use; accessors return copies.
+type Set struct {
+	mu       sync.RWMutex
+	utxos    map[string]*UTXO
+	byWallet map[string]*walletIndex
+}
+
+// walletIndex holds one wallet's unspent outputs, their IDs in order for
+// paging, and their running total.
+type walletIndex struct {
+	utxos   map[string]*UTXO
+	ids     sortedIDs
+	balance int64
+}
+
+// NewSet returns an empty set.
+func NewSet() *Set {
+	return &Set{utxos: map[string]*UTXO{}, byWallet: map[string]*walletIndex{}}
+}
+
+func (s *Set) index(u *UTXO) {
+	w := s.byWallet[u.WalletID]
+	if w == nil {
+		w = &walletIndex{utxos: map[string]*UTXO{}}
+		s.byWallet[u.WalletID] = w
+	}
+	w.utxos[u.ID] = u
+	w.ids.insert(u.ID)
+	w.balance += u.Amount
+}
+
+func (s *Set) unindex(u *UTXO) {
+	w := s.byWallet[u.WalletID]
+	if w == nil {
+		return
+	}
+	if _, ok := w.utxos[u.ID]; !ok {
+		return
+	}
+	delete(w.utxos, u.ID)
+	w.ids.remove(u.ID)
+	w.balance -= u.Amount
+	if len(w.utxos) == 0 {
+		delete(s.byWallet, u.WalletID)
+	}
+}
+
+// Add inserts (or replaces) a copy of u.
+func (s *Set) Add(u *UTXO) {
+	c := *u
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.utxos[c.ID]; ok && !old.Spent {
+		s.unindex(old)
+	}
+	s.utxos[c.ID] = &c
+	if !c.Spent {
+		s.index(&c)
+	}
+}
+
+// Get returns a copy of the output with the given ID, spent or not.
+func (s *Set) Get(id string) (*UTXO, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.utxos[id]
+	if !ok {
+		return nil, false
+	}
+	c := *u
+	return &c, true
+}
+
+// MarkSpent flags an unspent output as spent. It reports false if the output
+// is unknown or already spent.
+func (s *Set) MarkSpent(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.utxos[id]
+	if !ok || u.Spent {
+		return false
+	}
+	u.Spent = true
+	s.unindex(u)
+	return true
+}
+
+// MarkUnspent returns a spent output to its wallet.
+func (s *Set) MarkUnspent(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.utxos[id]; ok && u.Spent {
+		u.Spent = false
+		s.index(u)
+	}
+}
+
+// Remove deletes an output.
+func (s *Set) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.utxos[id]; ok {
+		if !u.Spent {
+			s.unindex(u)
+		}
+		delete(s.utxos, id)
+	}
+}
+
+// Reset replaces the contents with copies of the outputs in utxos.
+func (s *Set) Reset(utxos map[string]*UTXO) {
+	fresh := NewSet()
+	for _, u := range utxos {
+		c := *u
+		fresh.utxos[c.ID] = &c
+		if !c.Spent {
+			fresh.index(&c)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.utxos, s.byWallet = fresh.utxos, fresh.byWallet
+}
+
+// Len returns how many outputs the set holds, spent ones included.
+func (s *Set) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.utxos)
+}
+
+// Balance returns the total of walletID's unspent outputs.
+func (s *Set) Balance(walletID string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if w := s.byWallet[walletID]; w != nil {
+		return w.balance
+	}
+	return 0
+}
+
+// Count returns how many unspent outputs walletID has.
+func (s *Set) Count(walletID string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if w := s.byWallet[walletID]; w != nil {
+		return len(w.utxos)
+	}
+	return 0
+}
+
+// List returns up to limit of walletID's unspent outputs in ascending ID
+// order, starting after the ID after ("" for the first page). next is the
+// cursor for the following page, or "" when there are no more. A limit <= 0
+// returns them all. Pages stay consistent while outputs come and go between
+// them, and cost O(log n + limit) however many outputs the wallet has.
+func (s *Set) List(walletID, after string, limit int) (page []UTXO, next string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	w := s.byWallet[walletID]
+	if w == nil {
+		return []UTXO{}, ""
+	}
+	if limit <= 0 || limit > len(w.utxos) {
+		limit = len(w.utxos)
+	}
+	// one extra ID tells us whether another page follows
+	ids := w.ids.after(after, limit+1)
+	more := len(ids) > limit
+	if more {
+		ids = ids[:limit]
+	}
+	page = make([]UTXO, 0, len(ids))
+	for _, id := range ids {
+		page = append(page, *w.utxos[id])
+	}
+	if more {
+		next = ids[len(ids)-1]
+	}
+	return page, next
+}
+
+// Snapshot returns a copy of every output, spent ones included.
+func (s *Set) Snapshot() map[string]UTXO {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[string]UTXO, len(s.utxos))
+	for id, u := range s.utxos {
+		res[id] = *u
+	}
+	return res
+}
+
+// sortedIDs keeps strings in ascending order as a list of sorted chunks, so
+// inserts and removals shift at most one chunk instead of the whole list.
+type sortedIDs struct {
+	chunks [][]string
+}
+
+// maxChunk is the size at which a chunk splits in two.
+const maxChunk = 512
+
+// chunkFor returns the index of the chunk id belongs in: the last one whose
+// first ID is not above it.
+func (l *sortedIDs) chunkFor(id string) int {
+	i := sort.Search(len(l.chunks), func(i int) bool { return l.chunks[i][0] > id })
+	if i > 0 {
+		i--
+	}
+	return i
+}
+
+func (l *sortedIDs) insert(id string) {
+	if len(l.chunks) == 0 {
+		l.chunks = [][]string{{id}}
+		return
+	}
+	i := l.chunkFor(id)
+	c := l.chunks[i]
+	j := sort.SearchStrings(c, id)
+	if j < len(c) && c[j] == id {
+		return
+	}
+	c = append(c, "")
+	copy(c[j+1:], c[j:])
+	c[j] = id
+	if len(c) < maxChunk {
+		l.chunks[i] = c
+		return
+	}
+	half := len(c) / 2
+	left := append(make([]string, 0, maxChunk), c[:half]...)
+	right := append(make([]string, 0, maxChunk), c[half:]...)
+	l.chunks = append(l.chunks, nil)
+	copy(l.chunks[i+2:], l.chunks[i+1:])
+	l.chunks[i], l.chunks[i+1] = left, right
+}
+
+func (l *sortedIDs) remove(id string) {
+	if len(l.chunks) == 0 {
+		return
+	}
+	i := l.chunkFor(id)
+	c := l.chunks[i]
+	j := sort.SearchStrings(c, id)
+	if j == len(c) || c[j] != id {
+		return
+	}
+	c = append(c[:j], c[j+1:]...)
+	if len(c) > 0 {
+		l.chunks[i] = c
+		return
+	}
+	l.chunks = append(l.chunks[:i], l.chunks[i+1:]...)
+}
+
+// after returns up to n IDs greater than after, in order.
+func (l *sortedIDs) after(after string, n int) []string {
+	res := make([]string, 0, n)
+	i := sort.Search(len(l.chunks), func(i int) bool {
+		c := l.chunks[i]
+		return c[len(c)-1] > after
+	})
+	for ; i < len(l.chunks) && len(res) < n; i++ {
+		c := l.chunks[i]
+		j := sort.Search(len(c), func(j int) bool { return c[j] > after })
+		for ; j < len(c) && len(res) < n; j++ {
+			res = append(res, c[j])
+		}
+	}
+	return res
+}
@@ -0,0 +1,151 @@
+package utxo
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// benchSize UTXOs spread over benchWallets wallets (100 each), plus one
+// wallet holding benchWhale of them.
+const (
+	benchSize    = 1000000
+	benchWallets = 10000
+	benchWhale   = 100000
+)
+
+var (
+	benchOnce sync.Once
+	benchSet  *Set
+	benchMap  map[string]*UTXO
+)
+
+func benchWallet(i int) string { return "wallet-" + strconv.Itoa(i%benchWallets) }
+
+// loadBench builds the shared million-UTXO set once; benchmarks that modify
+// it undo their changes.
+func loadBench(b *testing.B) *Set {
+	benchOnce.Do(func() {
+		benchMap = make(map[string]*UTXO, benchSize)
+		for i := 0; i < benchSize; i++ {
+			wallet := benchWallet(i)
+			if i < benchWhale {
+				wallet = "whale"
+			}
+			u := NewUTXO("tx-"+strconv.Itoa(i), 0, wallet, int64(i%1000+1))
+			benchMap[u.ID] = u
+		}
+		benchSet = NewSet()
+		benchSet.Reset(benchMap)
+	})
+	b.ResetTimer()
+	return benchSet
+}
+
+// BenchmarkBalanceScan is the full-map scan WalletBalance used to do.
+func BenchmarkBalanceScan(b *testing.B) {
+	loadBench(b)
+	for i := 0; i < b.N; i++ {
+		wallet := benchWallet(i)
+		var sum int64
+		for _, u := range benchMap {
+			if u.WalletID == wallet && !u.Spent {
+				sum += u.Amount
+			}
+		}
+	}
+}
+
+func BenchmarkBalance(b *testing.B) {
+	s := loadBench(b)
+	for i := 0; i < b.N; i++ {
+		s.Balance(benchWallet(i))
+	}
+}
+
+func BenchmarkBalanceParallel(b *testing.B) {
+	s := loadBench(b)
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			s.Balance(benchWallet(i))
+		}
+	})
+}
+
+func BenchmarkList(b *testing.B) {
+	s := loadBench(b)
+	b.Run("wallet", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			s.List(benchWallet(i), "", 50)
+		}
+	})
+	b.Run("whale/first-page", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			s.List("whale", "", 100)
+		}
+	})
+	// walks every page of the whale's outputs; reported per page
+	b.Run("whale/all-pages", func(b *testing.B) {
+		pages := 0
+		for i := 0; i < b.N; i++ {
+			for after := ""; ; {
+				_, next := s.List("whale", after, 1000)
+				pages++
+				if next == "" {
+					break
+				}
+				after = next
+			}
+		}
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(pages), "ns/page")
+	})
+}
+
+// BenchmarkSpendCycle marks an output spent and back, as a mempool
+// admission followed by an eviction does.
+func BenchmarkSpendCycle(b *testing.B) {
+	s := loadBench(b)
+	for i := 0; i < b.N; i++ {
+		id := calcUTXOID("tx-"+strconv.Itoa(i%benchSize), 0)
+		if !s.MarkSpent(id) {
+			b.Fatalf("%s not spendable", id)
+		}
+		s.MarkUnspent(id)
+	}
+}
+
+func BenchmarkAddRemove(b *testing.B) {
+	s := loadBench(b)
+	for i := 0; i < b.N; i++ {
+		u := NewUTXO("bench-new", i%MaxTxOutputs, benchWallet(i), 1)
+		s.Add(u)
+		s.Remove(u.ID)
+	}
+}
+
+// BenchmarkMixedParallel runs balance reads, page reads and spend cycles
+// from every CPU at once, nine reads to each write.
+func BenchmarkMixedParallel(b *testing.B) {
+	s := loadBench(b)
+	var worker sync.Mutex
+	next := 0
+	b.RunParallel(func(pb *testing.PB) {
+		worker.Lock()
+		base := next * benchSize / 64
+		next++
+		worker.Unlock()
+		for i := 0; pb.Next(); i++ {
+			switch i % 10 {
+			case 0:
+				id := calcUTXOID("tx-"+strconv.Itoa((base+i)%benchSize), 0)
+				if s.MarkSpent(id) {
+					s.MarkUnspent(id)
+				}
+			case 1:
+				s.List(benchWallet(base+i), "", 20)
+			default:
+				s.Balance(benchWallet(base + i))
+			}
+		}
+	})
+}
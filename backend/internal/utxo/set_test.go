@@ -0,0 +1,258 @@
+package utxo
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// checkIDs fails unless l holds exactly want, in sorted, non-empty chunks
+// below maxChunk.
+func checkIDs(t *testing.T, l *sortedIDs, want []string) {
+	t.Helper()
+	want = append([]string(nil), want...)
+	sort.Strings(want)
+	var got []string
+	for i, c := range l.chunks {
+		if len(c) == 0 || len(c) >= maxChunk {
+			t.Fatalf("chunk %d holds %d IDs", i, len(c))
+		}
+		got = append(got, c...)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("holds %d IDs, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("ID %d is %s, want %s", i, got[i], want[i])
+		}
+	}
+	if all := l.after("", len(want)+1); len(all) != len(want) || len(want) > 0 && !reflect.DeepEqual(all, want) {
+		t.Fatal("after(\"\") does not walk every ID in order")
+	}
+}
+
+func testID(i int) string { return fmt.Sprintf("id-%06d", i) }
+
+func TestSortedIDsSplitsChunks(t *testing.T) {
+	var l sortedIDs
+	var ids []string
+	for _, i := range rand.New(rand.NewSource(1)).Perm(3 * maxChunk) {
+		l.insert(testID(i))
+		ids = append(ids, testID(i))
+	}
+	if len(l.chunks) < 3 {
+		t.Fatalf("%d IDs in %d chunks", len(ids), len(l.chunks))
+	}
+	checkIDs(t, &l, ids)
+
+	// inserting an ID again changes nothing
+	l.insert(ids[0])
+	checkIDs(t, &l, ids)
+
+	// in ascending order every insert lands in the last chunk
+	var asc sortedIDs
+	for i := 0; i < 2*maxChunk; i++ {
+		asc.insert(testID(i))
+	}
+	ids = ids[:0]
+	for i := 0; i < 2*maxChunk; i++ {
+		ids = append(ids, testID(i))
+	}
+	checkIDs(t, &asc, ids)
+}
+
+func TestSortedIDsRemove(t *testing.T) {
+	var l sortedIDs
+	var ids []string
+	for i := 0; i < 2*maxChunk; i += 2 {
+		l.insert(testID(i))
+		ids = append(ids, testID(i))
+	}
+	for i := 0; i < maxChunk; i++ {
+		l.insert(testID(2*maxChunk + i))
+		ids = append(ids, testID(2*maxChunk+i))
+	}
+	if len(l.chunks) < 2 {
+		t.Fatalf("%d chunks, want several", len(l.chunks))
+	}
+	with := func(id string) {
+		l.insert(id)
+		ids = append(ids, id)
+		checkIDs(t, &l, ids)
+	}
+	without := func(id string) {
+		l.remove(id)
+		for i, x := range ids {
+			if x == id {
+				ids = append(ids[:i], ids[i+1:]...)
+				break
+			}
+		}
+		checkIDs(t, &l, ids)
+	}
+
+	// the first ID of a chunk: IDs below the chunk's new first still sort
+	// into place, the removed one included
+	first := l.chunks[1][0]
+	without(first)
+	var n int
+	fmt.Sscanf(first, "id-%06d", &n)
+	with(testID(n + 1))
+	with(first)
+
+	// removing an unknown ID, or one past the end, changes nothing
+	l.remove("id-")
+	l.remove("zz")
+	checkIDs(t, &l, ids)
+
+	// emptying a chunk drops it
+	chunks := len(l.chunks)
+	for _, id := range append([]string(nil), l.chunks[0]...) {
+		without(id)
+	}
+	if len(l.chunks) != chunks-1 {
+		t.Fatalf("%d chunks after emptying one of %d", len(l.chunks), chunks)
+	}
+	for len(ids) > 0 {
+		without(ids[len(ids)/2])
+	}
+	if len(l.chunks) != 0 {
+		t.Fatalf("%d chunks left", len(l.chunks))
+	}
+	if got := l.after("", 10); len(got) != 0 {
+		t.Fatalf("after on an empty list: %v", got)
+	}
+}
+
+func TestListPagesWhileOutputsChange(t *testing.T) {
+	s := NewSet()
+	for i := 0; i < 3*maxChunk; i++ {
+		s.Add(NewUTXO(fmt.Sprintf("tx-%d", i), 0, alice, 1))
+	}
+	r := rand.New(rand.NewSource(2))
+	seen := map[string]bool{}
+	removed := map[string]bool{}
+	added := map[string]bool{}
+	last := ""
+	for after, pages := "", 0; ; pages++ {
+		page, next := s.List(alice, after, 100)
+		for _, u := range page {
+			if u.ID <= last {
+				t.Fatalf("page %d: %s after %s", pages, u.ID, last)
+			}
+			if seen[u.ID] || removed[u.ID] {
+				t.Fatalf("page %d: %s listed again or after its removal", pages, u.ID)
+			}
+			seen[u.ID], last = true, u.ID
+		}
+		if next == "" {
+			break
+		}
+		if next != last {
+			t.Fatalf("cursor %s, want the page's last ID %s", next, last)
+		}
+		// between pages: the cursor itself and a few others go, new outputs arrive
+		s.MarkSpent(next)
+		removed[next] = true
+		all, _ := s.List(alice, "", 0)
+		for k := 0; k < 3; k++ {
+			u := all[r.Intn(len(all))]
+			s.Remove(u.ID)
+			removed[u.ID] = true
+		}
+		for k := 0; k < 3; k++ {
+			u := NewUTXO(fmt.Sprintf("new-%d-%d", pages, k), 0, alice, 1)
+			s.Add(u)
+			added[u.ID] = true
+		}
+		after = next
+	}
+
+	// every output there from the start and never removed was listed; ones
+	// added midway may fall behind the cursor
+	for _, u := range mustList(s, alice) {
+		if !seen[u.ID] && !added[u.ID] {
+			t.Errorf("%s was never listed", u.ID)
+		}
+	}
+	if got, want := s.Balance(alice), int64(len(mustList(s, alice))); got != want || int64(s.Count(alice)) != want {
+		t.Errorf("balance %d and count %d, want %d", got, s.Count(alice), want)
+	}
+}
+
+func mustList(s *Set, wallet string) []UTXO {
+	page, _ := s.List(wallet, "", 0)
+	return page
+}
+
+func TestAddReplacesOtherWalletsOutput(t *testing.T) {
+	s := NewSet()
+	u := NewUTXO("tx", 0, alice, 10)
+	s.Add(u)
+	u.Amount = 99 // the set keeps its own copy
+	if got := s.Balance(alice); got != 10 {
+		t.Fatalf("alice has %d, want 10", got)
+	}
+
+	s.Add(&UTXO{ID: u.ID, TxID: "tx", WalletID: bob, Amount: 30})
+	if s.Balance(alice) != 0 || s.Count(alice) != 0 || len(mustList(s, alice)) != 0 {
+		t.Errorf("alice keeps the output: balance %d, count %d", s.Balance(alice), s.Count(alice))
+	}
+	if page := mustList(s, bob); s.Balance(bob) != 30 || len(page) != 1 || page[0].ID != u.ID {
+		t.Errorf("bob has %d in %v, want the output worth 30", s.Balance(bob), page)
+	}
+
+	// replacing with a spent copy leaves the wallet, and MarkUnspent brings it back
+	s.Add(&UTXO{ID: u.ID, TxID: "tx", WalletID: carol, Amount: 5, Spent: true})
+	if s.Balance(bob) != 0 || s.Balance(carol) != 0 || s.Len() != 1 {
+		t.Errorf("balances bob %d, carol %d, %d outputs", s.Balance(bob), s.Balance(carol), s.Len())
+	}
+	s.MarkUnspent(u.ID)
+	if s.Balance(carol) != 5 {
+		t.Errorf("carol has %d, want 5", s.Balance(carol))
+	}
+}
+
+func TestBalanceAfterSpendAndUnspend(t *testing.T) {
+	s := NewSet()
+	a, b := NewUTXO("tx", 0, alice, 10), NewUTXO("tx", 1, alice, 20)
+	s.Add(a)
+	s.Add(b)
+	balance := func(want int64, count int) {
+		t.Helper()
+		if got := s.Balance(alice); got != want || s.Count(alice) != count {
+			t.Fatalf("balance %d over %d outputs, want %d over %d", got, s.Count(alice), want, count)
+		}
+	}
+	balance(30, 2)
+
+	if !s.MarkSpent(a.ID) {
+		t.Fatal("MarkSpent refused an unspent output")
+	}
+	balance(20, 1)
+	if s.MarkSpent(a.ID) || s.MarkSpent("unknown") {
+		t.Fatal("MarkSpent accepted a spent or unknown output")
+	}
+	balance(20, 1)
+	if got, ok := s.Get(a.ID); !ok || !got.Spent {
+		t.Fatalf("spent output: %+v, %v", got, ok)
+	}
+
+	s.MarkUnspent(a.ID)
+	s.MarkUnspent(a.ID)
+	s.MarkUnspent(b.ID)
+	balance(30, 2)
+
+	// a spent output can go without touching the balance
+	s.MarkSpent(b.ID)
+	s.Remove(b.ID)
+	balance(10, 1)
+	s.Remove(a.ID)
+	balance(0, 0)
+	if _, ok := s.byWallet[alice]; ok {
+		t.Error("empty wallet index kept")
+	}
+}
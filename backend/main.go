@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/student/decentralized-wallet/internal/api"
+	"github.com/student/decentralized-wallet/internal/chainparams"
+	"github.com/student/decentralized-wallet/internal/crypto"
 	"github.com/student/decentralized-wallet/internal/db"
+	"github.com/student/decentralized-wallet/internal/miner"
+	"github.com/student/decentralized-wallet/internal/p2p"
 )
 
 // initFirestoreFromEnv decodes FIREBASE_JSON_B64 and sets GOOGLE_APPLICATION_CREDENTIALS for Fly.io deployment
@@ -32,6 +39,41 @@ func initFirestoreFromEnv() error {
 	return nil
 }
 
+// openStore selects the storage backend. STORAGE_BACKEND may be "firestore",
+// "memory" or "file" (STORAGE_PATH, default ./data/wallet.db). When unset,
+// Firestore is used if credentials are available, otherwise in-memory.
+func openStore() (db.Store, error) {
+	backend := os.Getenv("STORAGE_BACKEND")
+	switch backend {
+	case "memory":
+		return db.NewMemoryStore(), nil
+	case "file":
+		path := os.Getenv("STORAGE_PATH")
+		if path == "" {
+			path = filepath.Join("data", "wallet.db")
+		}
+		return db.OpenFileStore(path)
+	case "firestore", "":
+		if db.FSClient != nil {
+			return db.NewFirestoreStore(db.FSClient), nil
+		}
+		if backend == "firestore" {
+			return nil, fmt.Errorf("STORAGE_BACKEND=firestore but Firestore is not initialized")
+		}
+		return db.NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+}
+
+// loadChainParams reads the network's consensus parameters from the JSON or
+// YAML file named by CHAIN_PARAMS, or builds them from environment variables.
+func loadChainParams() (*chainparams.Params, error) {
+	if path := os.Getenv("CHAIN_PARAMS"); path != "" {
+		return chainparams.Load(path)
+	}
+	return chainparams.FromEnv()
+}
+
 func main() {
 	// Decode Firebase credentials if provided via Fly.io secret
 	if err := initFirestoreFromEnv(); err != nil {
@@ -43,19 +85,56 @@ func main() {
 		addr = ":" + v
 	}
 
-	// initialize Firebase / Firestore if credentials are provided
+	// initialize Firebase (auth, and Firestore if selected) when credentials are provided
 	if err := db.InitFirebase(); err != nil {
-		log.Printf("Firestore init warning: %v -- continuing with in-memory stores", err)
-	} else {
-		// ensure firestore client closed on exit
-		defer func() {
-			if db.FSClient != nil {
-				_ = db.FSClient.Close()
-			}
-		}()
+		log.Printf("Firebase init warning: %v -- continuing without Firebase", err)
+	}
+
+	store, err := openStore()
+	if err != nil {
+		log.Fatalf("failed to open store: %v", err)
 	}
+	// ensure the store is flushed/closed on exit
+	defer store.Close()
+	log.Printf("Using %s storage backend", store.Backend())
 
-	handler := api.NewRouter()
+	params, err := loadChainParams()
+	if err != nil {
+		log.Fatalf("failed to load chain params: %v", err)
+	}
+	// refuse to run on a store that belongs to another chain
+	if err := db.EnsureGenesis(store, params.Genesis()); err != nil {
+		log.Fatalf("genesis check failed: %v", err)
+	}
+	log.Printf("Chain %s, genesis %s", params.ChainID, params.Genesis().Hash)
+
+	srv := api.NewServer(store, params)
+	// the node holding the network authority's key (AUTHORITY_PRIVATE_KEY, base64) signs zakat deductions
+	if v := os.Getenv("AUTHORITY_PRIVATE_KEY"); v != "" {
+		key, err := crypto.ParsePrivateKey(v)
+		if err != nil {
+			log.Fatalf("AUTHORITY_PRIVATE_KEY: %v", err)
+		}
+		if err := srv.SetAuthority(key); err != nil {
+			log.Fatalf("AUTHORITY_PRIVATE_KEY: %v", err)
+		}
+	}
+	handler := srv.Router()
+	// join the p2p network (P2P_LISTEN address, P2P_PEERS comma-separated host:port list)
+	if listen, peers := os.Getenv("P2P_LISTEN"), os.Getenv("P2P_PEERS"); listen != "" || peers != "" {
+		cfg := p2p.Config{ListenAddr: listen, ChainID: params.ChainID, GenesisHash: params.Genesis().Hash}
+		for _, p := range strings.Split(peers, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				cfg.Peers = append(cfg.Peers, p)
+			}
+		}
+		node := p2p.NewNode(cfg, srv)
+		if err := node.Start(context.Background()); err != nil {
+			log.Fatalf("p2p: %v", err)
+		}
+		srv.SetNode(node)
+		log.Printf("P2P node %s listening on %q, peers %v", node.ID(), node.Addr(), cfg.Peers)
+	}
 	// start zakat scheduler (daily check) in background
 	go func() {
 		for {
@@ -64,13 +143,13 @@ func main() {
 			// run on 1st of month at 00:00 UTC (if close enough)
 			if now.Day() == 1 && now.Hour() == 0 {
 				// trigger zakat via admin handler logic directly
-				zakatPool := os.Getenv("ZAKAT_POOL_WALLET_ID")
+				zakatPool := params.ZakatPoolWallet
 				if zakatPool != "" {
 					// call compute for each wallet
-					wallets, err := db.ListAllWalletIDs()
+					wallets, err := store.ListAllWalletIDs()
 					if err == nil {
 						for _, w := range wallets {
-							_, _ = api.ComputeZakatForWallet(w, zakatPool)
+							_, _ = srv.ComputeZakatForWallet(w, zakatPool)
 						}
 					}
 				}
@@ -80,6 +159,29 @@ func main() {
 			time.Sleep(1 * time.Hour)
 		}
 	}()
+	// expire stale mempool transactions, returning their inputs to unspent
+	go func() {
+		for range time.Tick(time.Minute) {
+			if n := srv.ExpireMempool(); n > 0 {
+				log.Printf("mempool: expired %d transactions", n)
+			}
+		}
+	}()
+	// optionally start the background miner (MINER_AUTOSTART=true, MINER_WALLET, MINER_INTERVAL seconds, MINER_WORKERS)
+	if os.Getenv("MINER_AUTOSTART") == "true" {
+		interval, _ := strconv.Atoi(os.Getenv("MINER_INTERVAL"))
+		workers, _ := strconv.Atoi(os.Getenv("MINER_WORKERS"))
+		minerWallet, err := srv.MinerWalletID(os.Getenv("MINER_WALLET"))
+		cfg := miner.Config{MinerWallet: minerWallet, Interval: time.Duration(interval) * time.Second, Workers: workers}
+		if err == nil {
+			err = srv.Miner().Start(context.Background(), cfg)
+		}
+		if err != nil {
+			log.Printf("miner autostart failed: %v", err)
+		} else {
+			log.Printf("Background miner started for wallet %s", cfg.MinerWallet)
+		}
+	}
 	log.Printf("Starting backend server on %s\n", addr)
 	if err := http.ListenAndServe(addr, handler); err != nil {
 		log.Fatal(err)
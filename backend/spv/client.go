@@ -0,0 +1,118 @@
+package spv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Client follows a node's header chain over its HTTP API and verifies
+// transactions against it. The node is only a source of data: every header
+// and proof it serves is checked locally.
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+	Chain   *HeaderChain
+}
+
+// NewClient returns a client for the node at baseURL (e.g. "http://localhost:8080").
+func NewClient(baseURL string, chain *HeaderChain) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTP: http.DefaultClient, Chain: chain}
+}
+
+type headersResp struct {
+	TipHeight *int64   `json:"tip_height"`
+	Headers   []Header `json:"headers"`
+}
+
+// Sync downloads and verifies headers until the chain reaches the node's
+// tip. If the node has reorganized below our tip it steps back until the
+// headers connect, then follows the node's branch if it has more work.
+func (c *Client) Sync(ctx context.Context) error {
+	var back int64
+	for {
+		from := c.Chain.Height() + 1 - back
+		if from < 1 {
+			from = 1
+		}
+		var resp headersResp
+		if err := c.get(ctx, fmt.Sprintf("/api/headers?from=%d", from), &resp); err != nil {
+			return err
+		}
+		if len(resp.Headers) == 0 {
+			return nil
+		}
+		err := c.Chain.Append(resp.Headers)
+		switch {
+		case err == nil:
+			back = 0
+			if resp.TipHeight != nil && resp.Headers[len(resp.Headers)-1].Index >= *resp.TipHeight {
+				return nil
+			}
+		case errors.Is(err, ErrDisconnected) && from > 1:
+			back = back*2 + 1
+		default:
+			return err
+		}
+	}
+}
+
+// VerifyTx fetches the inclusion proof for txID and checks it against the
+// verified header chain, syncing first if the block is newer than our tip.
+// It returns the proof and the block's confirmations.
+func (c *Client) VerifyTx(ctx context.Context, txID string) (*Proof, int64, error) {
+	var p Proof
+	if err := c.get(ctx, "/api/txs/"+txID+"/proof", &p); err != nil {
+		return nil, 0, err
+	}
+	if p.TxID != txID {
+		return nil, 0, fmt.Errorf("%w: proof is for tx %s", ErrBadProof, p.TxID)
+	}
+	conf, err := c.Chain.VerifyInclusion(&p)
+	if errors.Is(err, ErrUnknownBlock) || errors.Is(err, ErrNotInChain) {
+		if err := c.Sync(ctx); err != nil {
+			return nil, 0, err
+		}
+		conf, err = c.Chain.VerifyInclusion(&p)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return &p, conf, nil
+}
+
+// VerifyPayment checks that txID is in the verified chain and returns how
+// much it pays walletID outright (see PaidTo), with its confirmations.
+// Outputs under a spending condition are left out; use VerifyTx and LockedTo
+// for those.
+func (c *Client) VerifyPayment(ctx context.Context, txID, walletID string) (int64, int64, error) {
+	p, conf, err := c.VerifyTx(ctx, txID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if p.Tx == nil {
+		return 0, 0, errors.New("node did not return the transaction body")
+	}
+	return PaidTo(p.Tx, walletID), conf, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,208 @@
+// Package spv verifies payments the way a light client does: it keeps only
+// the chain of block headers, checks their linkage and proof of work itself,
+// and accepts a transaction once a Merkle proof ties it to one of those
+// headers. It never needs block bodies or trusts a node's balance figures.
+package spv
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/chainparams"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+// Header, TargetRules and ProofStep are the node's own types, re-exported so
+// callers outside this module can name them.
+type (
+	Header      = blockchain.BlockHeader
+	TargetRules = blockchain.TargetRules
+	ProofStep   = blockchain.ProofStep
+	Transaction = utxo.Transaction
+)
+
+var (
+	// ErrUnknownBlock is returned for a proof above the verified header chain; sync and retry.
+	ErrUnknownBlock = errors.New("block not in verified header chain")
+	// ErrNotInChain is returned when a proof names a block the verified chain does not contain.
+	ErrNotInChain = errors.New("block hash does not match verified header chain")
+	// ErrBadProof is returned when the Merkle path does not lead to the header's root.
+	ErrBadProof = errors.New("merkle proof does not verify")
+	// ErrLessWork is returned by Append for a competing branch with no more work than ours.
+	ErrLessWork = errors.New("branch has no more work than the verified chain")
+	// ErrDisconnected is returned by Append when the headers do not attach to the chain.
+	ErrDisconnected = errors.New("headers do not connect to the verified chain")
+)
+
+// HeaderChain is a verified chain of headers from a trusted genesis. It is
+// safe for concurrent use.
+type HeaderChain struct {
+	mu      sync.RWMutex
+	rules   TargetRules
+	headers []Header   // by height; headers[0] is genesis
+	work    []*big.Int // cumulative work by height
+}
+
+// NewHeaderChain starts a chain at genesis, which the caller trusts (it
+// comes from the network's chain params, not from a node).
+func NewHeaderChain(genesis Header, rules TargetRules) *HeaderChain {
+	return &HeaderChain{
+		rules:   rules,
+		headers: []Header{genesis},
+		work:    []*big.Int{blockchain.Work(genesis.Bits)},
+	}
+}
+
+// NewHeaderChainFromParams starts a chain at the network's genesis block.
+func NewHeaderChainFromParams(p *chainparams.Params) *HeaderChain {
+	return NewHeaderChain(p.Genesis().Header(), p.TargetRules())
+}
+
+// Height returns the height of the last verified header.
+func (c *HeaderChain) Height() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return int64(len(c.headers) - 1)
+}
+
+// Tip returns the last verified header.
+func (c *HeaderChain) Tip() Header {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.headers[len(c.headers)-1]
+}
+
+// ChainWork returns the cumulative proof of work of the verified chain.
+func (c *HeaderChain) ChainWork() *big.Int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return new(big.Int).Set(c.work[len(c.work)-1])
+}
+
+// Header returns the verified header at height.
+func (c *HeaderChain) Header(height int64) (Header, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if height < 0 || height >= int64(len(c.headers)) {
+		return Header{}, false
+	}
+	return c.headers[height], true
+}
+
+// Append verifies headers, ascending and contiguous, and adds them. They may
+// extend the tip or fork below it: a fork replaces the blocks above the fork
+// point only if it carries more work, like a full node's reorganization.
+// Headers already in the chain are skipped.
+func (c *HeaderChain) Append(headers []Header) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// skip what we already have
+	for len(headers) > 0 {
+		h := headers[0]
+		if h.Index < 0 || h.Index >= int64(len(c.headers)) || c.headers[h.Index].Hash != h.Hash {
+			break
+		}
+		headers = headers[1:]
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	fork := headers[0].Index - 1
+	if fork < 0 || fork >= int64(len(c.headers)) || c.headers[fork].Hash != headers[0].PreviousHash {
+		return fmt.Errorf("%w: header %d links to %s", ErrDisconnected, headers[0].Index, headers[0].PreviousHash)
+	}
+
+	ancestor := func(height int64) (*Header, error) {
+		switch {
+		case height >= 0 && height <= fork:
+			return &c.headers[height], nil
+		case height > fork && height-fork-1 < int64(len(headers)):
+			return &headers[height-fork-1], nil
+		}
+		return nil, fmt.Errorf("header %d unknown", height)
+	}
+	work := new(big.Int).Set(c.work[fork])
+	branchWork := make([]*big.Int, len(headers))
+	prev := &c.headers[fork]
+	for i := range headers {
+		h := &headers[i]
+		if err := blockchain.CheckHeader(h, prev, c.rules, ancestor); err != nil {
+			return err
+		}
+		work = new(big.Int).Add(work, blockchain.Work(h.Bits))
+		branchWork[i] = work
+		prev = h
+	}
+
+	if fork < int64(len(c.headers))-1 && work.Cmp(c.work[len(c.work)-1]) <= 0 {
+		return ErrLessWork
+	}
+	c.headers = append(c.headers[:fork+1], headers...)
+	c.work = append(c.work[:fork+1], branchWork...)
+	return nil
+}
+
+// Proof is what GET /api/txs/{id}/proof returns: the Merkle path for a
+// transaction, the header of the block holding it and the transaction itself.
+type Proof struct {
+	TxID       string       `json:"tx_id"`
+	BlockIndex int64        `json:"block_index"`
+	BlockHash  string       `json:"block_hash"`
+	MerkleRoot string       `json:"merkle_root"`
+	Index      int          `json:"index"`
+	Path       []ProofStep  `json:"path"`
+	Header     *Header      `json:"header,omitempty"`
+	Tx         *Transaction `json:"tx,omitempty"`
+}
+
+// VerifyInclusion checks that p's transaction is in a block of the verified
+// chain and returns the block's confirmations (1 for the tip). Only the
+// verified headers are trusted; p's own header and root are not.
+func (c *HeaderChain) VerifyInclusion(p *Proof) (int64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if p.BlockIndex < 0 || p.BlockIndex >= int64(len(c.headers)) {
+		return 0, fmt.Errorf("block %d: %w", p.BlockIndex, ErrUnknownBlock)
+	}
+	h := c.headers[p.BlockIndex]
+	if h.Hash != p.BlockHash {
+		return 0, fmt.Errorf("block %d is %s, proof names %s: %w", p.BlockIndex, h.Hash, p.BlockHash, ErrNotInChain)
+	}
+	if !blockchain.VerifyMerkleProof(p.TxID, p.Path, h.MerkleRoot) {
+		return 0, ErrBadProof
+	}
+	// the id commits to the tx contents, so a proven id proves the body too
+	if p.Tx != nil && p.Tx.ComputeID() != p.TxID {
+		return 0, fmt.Errorf("%w: tx body does not hash to %s", ErrBadProof, p.TxID)
+	}
+	return int64(len(c.headers)) - p.BlockIndex, nil
+}
+
+// PaidTo returns what t pays walletID outright, across its unconditioned
+// outputs. An output with a spending condition is not a payment yet: it may
+// be locked for a long time, or claimable by someone else; see LockedTo.
+func PaidTo(t *Transaction, walletID string) int64 {
+	var total int64
+	for _, o := range t.Outputs {
+		if o.Recipient == walletID && o.Condition == nil {
+			total += o.Amount
+		}
+	}
+	return total
+}
+
+// LockedTo returns what t pays walletID in outputs with a spending
+// condition, which the caller must inspect before counting them as paid.
+func LockedTo(t *Transaction, walletID string) int64 {
+	var total int64
+	for _, o := range t.Outputs {
+		if o.Recipient == walletID && o.Condition != nil {
+			total += o.Amount
+		}
+	}
+	return total
+}
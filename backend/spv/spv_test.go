@@ -0,0 +1,213 @@
+package spv
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/student/decentralized-wallet/internal/blockchain"
+	"github.com/student/decentralized-wallet/internal/chainparams"
+	"github.com/student/decentralized-wallet/internal/utxo"
+)
+
+func TestPaidToSkipsConditionedOutputs(t *testing.T) {
+	tx := &Transaction{Outputs: []utxo.TxOutput{
+		{Recipient: "bob", Amount: 100},
+		{Recipient: "alice", Amount: 50},
+		// locked for years, or refundable to alice: not paid to bob yet
+		{Recipient: "bob", Amount: 1000, Condition: &utxo.Condition{After: 1_000_000}},
+		{Recipient: "bob", Amount: 500, Condition: &utxo.Condition{Any: []utxo.Condition{{Signer: "bob"}, {Signer: "alice"}}}},
+		{Recipient: "bob", Amount: 7},
+	}}
+	if got := PaidTo(tx, "bob"); got != 107 {
+		t.Errorf("PaidTo(bob) = %d, want 107", got)
+	}
+	if got := LockedTo(tx, "bob"); got != 1500 {
+		t.Errorf("LockedTo(bob) = %d, want 1500", got)
+	}
+	if got, locked := PaidTo(tx, "alice"), LockedTo(tx, "alice"); got != 50 || locked != 0 {
+		t.Errorf("alice paid %d, locked %d, want 50 and 0", got, locked)
+	}
+}
+
+// testParams is a network with a fixed, easy target.
+func testParams() *chainparams.Params {
+	p := chainparams.Default()
+	p.Difficulty.InitialDifficulty = 1
+	p.Difficulty.RetargetInterval = 0
+	return p
+}
+
+// mineBranch mines n blocks on prev, each paying tag so branches differ.
+func mineBranch(p *chainparams.Params, prev Header, n int, tag string) []*blockchain.Block {
+	var blocks []*blockchain.Block
+	for i := 0; i < n; i++ {
+		var txs []utxo.Transaction
+		for j := 0; j < 4; j++ {
+			tx := utxo.Transaction{Sender: utxo.SystemSender, Receiver: tag, Amount: int64(j + 1),
+				Outputs: []utxo.TxOutput{{Recipient: tag, Amount: int64(j + 1)}}, Note: fmt.Sprint(prev.Index+1, tag)}
+			tx.ID = tx.ComputeID()
+			txs = append(txs, tx)
+		}
+		b := blockchain.NewBlockTemplate(prev.Index+1, prev.Hash, p.InitialBits(), txs)
+		// the miner moves past this, so timestamps always increase
+		b.Timestamp = prev.Timestamp
+		blockchain.MineBlock(b)
+		blocks = append(blocks, b)
+		prev = b.Header()
+	}
+	return blocks
+}
+
+func headersOf(blocks []*blockchain.Block) []Header {
+	hs := make([]Header, 0, len(blocks))
+	for _, b := range blocks {
+		hs = append(hs, b.Header())
+	}
+	return hs
+}
+
+// proofFor returns a proof of b's ith tx, with a copy of the tx.
+func proofFor(t *testing.T, b *blockchain.Block, i int) *Proof {
+	t.Helper()
+	tx := b.Transactions[i]
+	tx.Outputs = append([]utxo.TxOutput(nil), tx.Outputs...)
+	mp, err := blockchain.BuildMerkleProof(b.TxIDs(), tx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := b.Header()
+	return &Proof{TxID: tx.ID, BlockIndex: b.Index, BlockHash: b.Hash, MerkleRoot: mp.MerkleRoot,
+		Index: mp.Index, Path: mp.Path, Header: &h, Tx: &tx}
+}
+
+func TestAppendRejectsBadHeaders(t *testing.T) {
+	p := testParams()
+	c := NewHeaderChainFromParams(p)
+	main := mineBranch(p, c.Tip(), 3, "main")
+	if err := c.Append(headersOf(main[:2])); err != nil {
+		t.Fatal(err)
+	}
+
+	// no proof of work: the first nonce whose hash misses the target
+	lazy := blockchain.NewBlockTemplate(3, main[1].Hash, p.InitialBits(), nil)
+	lazy.Timestamp = main[1].Timestamp.Add(1)
+	for lazy.Hash = lazy.ComputeHash(); blockchain.HashMeetsTarget(lazy.Hash, lazy.Bits); lazy.Hash = lazy.ComputeHash() {
+		lazy.Nonce++
+	}
+	wrongHash := main[2].Header()
+	wrongHash.Nonce++
+
+	tests := []struct {
+		name    string
+		headers []Header
+		want    error
+	}{
+		{"skips a height", []Header{{Index: 5, PreviousHash: main[2].Hash}}, ErrDisconnected},
+		{"unknown parent", []Header{{Index: 3, PreviousHash: "feed"}}, ErrDisconnected},
+		{"batch does not link up", []Header{main[2].Header(), mineBranch(p, main[2].Header(), 2, "gap")[1].Header()}, blockchain.ErrInvalidHeader},
+		{"hash does not match", []Header{wrongHash}, blockchain.ErrInvalidHeader},
+		{"bad proof of work", []Header{lazy.Header()}, blockchain.ErrInvalidHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := c.Append(tt.headers); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+			if c.Height() != 2 || c.Tip().Hash != main[1].Hash {
+				t.Errorf("chain moved to %d %s", c.Height(), c.Tip().Hash)
+			}
+		})
+	}
+
+	// headers already held are skipped
+	if err := c.Append(headersOf(main)); err != nil || c.Height() != 3 {
+		t.Fatalf("height %d, err %v", c.Height(), err)
+	}
+}
+
+func TestAppendFollowsMostWork(t *testing.T) {
+	p := testParams()
+	c := NewHeaderChainFromParams(p)
+	genesis := c.Tip()
+	main := mineBranch(p, genesis, 3, "main")
+	if err := c.Append(headersOf(main)); err != nil {
+		t.Fatal(err)
+	}
+	work := c.ChainWork()
+
+	// a shorter fork, and one as long, carry no more work
+	for _, n := range []int{2, 3} {
+		fork := mineBranch(p, genesis, n, fmt.Sprint("fork-", n))
+		if err := c.Append(headersOf(fork)); !errors.Is(err, ErrLessWork) {
+			t.Errorf("%d-block fork: err = %v, want ErrLessWork", n, err)
+		}
+		if c.Tip().Hash != main[2].Hash || c.ChainWork().Cmp(work) != 0 {
+			t.Errorf("%d-block fork moved the tip to %s", n, c.Tip().Hash)
+		}
+	}
+
+	// a longer fork from block 1 replaces everything above it
+	fork := mineBranch(p, main[0].Header(), 3, "longer")
+	if err := c.Append(headersOf(fork)); err != nil {
+		t.Fatal(err)
+	}
+	if c.Height() != 4 || c.Tip().Hash != fork[2].Hash || c.ChainWork().Cmp(work) <= 0 {
+		t.Fatalf("tip %d %s, want 4 %s with more work", c.Height(), c.Tip().Hash, fork[2].Hash)
+	}
+	for i, want := range []string{main[0].Hash, fork[0].Hash, fork[1].Hash} {
+		if h, _ := c.Header(int64(i + 1)); h.Hash != want {
+			t.Errorf("height %d is %s, want %s", i+1, h.Hash, want)
+		}
+	}
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	p := testParams()
+	c := NewHeaderChainFromParams(p)
+	blocks := mineBranch(p, c.Tip(), 3, "main")
+	if err := c.Append(headersOf(blocks)); err != nil {
+		t.Fatal(err)
+	}
+	other := mineBranch(p, c.Tip(), 1, "unverified")[0]
+
+	if conf, err := c.VerifyInclusion(proofFor(t, blocks[1], 2)); err != nil || conf != 2 {
+		t.Fatalf("confirmations %d, err %v, want 2", conf, err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(p *Proof)
+		want   error
+	}{
+		{"above the verified chain", func(p *Proof) { *p = *proofFor(t, other, 0) }, ErrUnknownBlock},
+		{"negative height", func(p *Proof) { p.BlockIndex = -1 }, ErrUnknownBlock},
+		{"block hash not in the chain", func(p *Proof) { p.BlockHash = blocks[0].Hash }, ErrNotInChain},
+		{"tampered path hash", func(p *Proof) { p.Path[0].Hash = blocks[0].MerkleRoot }, ErrBadProof},
+		{"tampered path side", func(p *Proof) { p.Path[1].Left = !p.Path[1].Left }, ErrBadProof},
+		{"truncated path", func(p *Proof) { p.Path = p.Path[:1] }, ErrBadProof},
+		{"another txid", func(p *Proof) { p.TxID = blocks[1].Transactions[0].ID }, ErrBadProof},
+		// the proof's own root and header are not trusted
+		{"forged root", func(p *Proof) {
+			fake := proofFor(t, other, 0)
+			p.TxID, p.Path, p.MerkleRoot, p.Header.MerkleRoot = fake.TxID, fake.Path, fake.MerkleRoot, fake.MerkleRoot
+		}, ErrBadProof},
+		{"tx body does not hash to the txid", func(p *Proof) { p.Tx.Outputs[0].Amount = 1000 }, ErrBadProof},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := proofFor(t, blocks[1], 2)
+			tt.mutate(pr)
+			if _, err := c.VerifyInclusion(pr); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// a proof without the body still proves the txid
+	pr := proofFor(t, blocks[2], 0)
+	pr.Tx = nil
+	if conf, err := c.VerifyInclusion(pr); err != nil || conf != 1 {
+		t.Errorf("confirmations %d, err %v, want 1", conf, err)
+	}
+}
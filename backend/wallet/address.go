@@ -0,0 +1,191 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Addresses are how wallet IDs are written for people: a Bech32m string
+// (BIP-350) made of the network's prefix, the separator "1", a version, the
+// 32-byte wallet ID and a six-character checksum, e.g. "dwt1q...". The
+// checksum catches any mistyped character and most transpositions, so a typo
+// is refused instead of paying a wallet nobody holds. On chain and in the
+// signed transaction encoding, wallets stay hex IDs.
+
+// AddressVersion is the version of the address payload.
+const AddressVersion = 0
+
+var (
+	// ErrInvalidAddress is returned for a string that is not a well-formed address.
+	ErrInvalidAddress = errors.New("invalid address")
+	// ErrAddressChecksum is returned when an address's checksum does not match, e.g. after a typo.
+	ErrAddressChecksum = errors.New("address checksum mismatch")
+	// ErrAddressNetwork is returned for a valid address of another network.
+	ErrAddressNetwork = errors.New("address is for another network")
+)
+
+const (
+	bech32Charset  = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32mConst   = 0x2bc830a3
+	maxAddressLen  = 90
+	checksumLength = 6
+)
+
+// EncodeAddress writes a hex wallet ID as an address with the given network prefix.
+func EncodeAddress(prefix, walletID string) (string, error) {
+	if err := CheckPrefix(prefix); err != nil {
+		return "", err
+	}
+	id, err := hex.DecodeString(walletID)
+	if err != nil || len(id) != 32 {
+		return "", fmt.Errorf("%w: wallet ID must be 64 hex characters", ErrInvalidAddress)
+	}
+	return encodeBech32m(prefix, append([]byte{AddressVersion}, convertBits(id, 8, 5, true)...)), nil
+}
+
+// DecodeAddress checks an address against the network prefix and its
+// checksum and returns the hex wallet ID it carries.
+func DecodeAddress(prefix, addr string) (string, error) {
+	hrp, data, err := decodeBech32m(addr)
+	if err != nil {
+		return "", err
+	}
+	if hrp != prefix {
+		return "", fmt.Errorf("%w: prefix %q, this network uses %q", ErrAddressNetwork, hrp, prefix)
+	}
+	if len(data) == 0 {
+		return "", fmt.Errorf("%w: no payload", ErrInvalidAddress)
+	}
+	if data[0] != AddressVersion {
+		return "", fmt.Errorf("%w: unknown version %d", ErrInvalidAddress, data[0])
+	}
+	id := convertBits(data[1:], 5, 8, false)
+	if len(id) != 32 {
+		return "", fmt.Errorf("%w: payload is not a 32-byte wallet ID", ErrInvalidAddress)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// CheckPrefix reports whether prefix can start an address: 1 to 20
+// lowercase letters or digits.
+func CheckPrefix(prefix string) error {
+	if len(prefix) == 0 || len(prefix) > 20 {
+		return fmt.Errorf("address prefix must be 1 to 20 characters, not %q", prefix)
+	}
+	for _, c := range prefix {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return fmt.Errorf("address prefix %q must be lowercase letters and digits", prefix)
+		}
+	}
+	return nil
+}
+
+// encodeBech32m writes hrp, the separator and the 5-bit values data followed
+// by their checksum.
+func encodeBech32m(hrp string, data []byte) string {
+	var b strings.Builder
+	b.WriteString(hrp)
+	b.WriteByte('1')
+	for _, v := range append(data, bech32mChecksum(hrp, data)...) {
+		b.WriteByte(bech32Charset[v])
+	}
+	return b.String()
+}
+
+// decodeBech32m checks a Bech32m string as BIP-350 defines it and returns
+// its lowercased human-readable part and its 5-bit values, checksum removed.
+func decodeBech32m(s string) (string, []byte, error) {
+	if len(s) > maxAddressLen {
+		return "", nil, fmt.Errorf("%w: longer than %d characters", ErrInvalidAddress, maxAddressLen)
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, fmt.Errorf("%w: mixed case", ErrInvalidAddress)
+	}
+	s = strings.ToLower(s)
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || len(s)-sep-1 < checksumLength {
+		return "", nil, fmt.Errorf("%w: missing prefix, separator or checksum", ErrInvalidAddress)
+	}
+	hrp := s[:sep]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, fmt.Errorf("%w: prefix character %q not allowed", ErrInvalidAddress, hrp[i])
+		}
+	}
+	data := make([]byte, 0, len(s)-sep-1)
+	for i := sep + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("%w: character %q not allowed", ErrInvalidAddress, s[i])
+		}
+		data = append(data, byte(v))
+	}
+	if bech32Polymod(append(hrpExpand(hrp), data...)) != bech32mConst {
+		return "", nil, ErrAddressChecksum
+	}
+	return hrp, data[:len(data)-checksumLength], nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	res := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		res = append(res, hrp[i]>>5)
+	}
+	res = append(res, 0)
+	for i := 0; i < len(hrp); i++ {
+		res = append(res, hrp[i]&31)
+	}
+	return res
+}
+
+func bech32mChecksum(hrp string, data []byte) []byte {
+	values := append(hrpExpand(hrp), data...)
+	values = append(values, make([]byte, checksumLength)...)
+	mod := bech32Polymod(values) ^ bech32mConst
+	res := make([]byte, checksumLength)
+	for i := range res {
+		res[i] = byte(mod>>uint(5*(5-i))) & 31
+	}
+	return res
+}
+
+// convertBits regroups data from fromBits-bit to toBits-bit groups. When
+// decoding (pad false) leftover bits must be zero padding, else it returns nil.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) []byte {
+	var acc, bits uint
+	maxv := uint(1)<<toBits - 1
+	res := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, v := range data {
+		acc = acc<<fromBits | uint(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			res = append(res, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			res = append(res, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,82 @@
+package wallet
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// BIP-350's Bech32m test vectors.
+var (
+	validBech32m = []string{
+		"A1LQFN3A",
+		"a1lqfn3a",
+		"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6",
+		"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx",
+		"11llllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllludsr8",
+		"split1checkupstagehandshakeupstreamerranterredcaperredlc445v",
+		"?1v759aa",
+	}
+	invalidBech32m = map[string]string{
+		"\x201xj0phk": "prefix character out of range",
+		"\x7f1g6xzxy": "prefix character out of range",
+		"\x801vctc34": "prefix character out of range",
+		"an84characterslonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11d6pts4": "overall max length exceeded",
+		"qyrz8wqd2c9m":  "no separator character",
+		"1qyrz8wqd2c9m": "empty prefix",
+		"y1b0jsk6g":     "invalid data character",
+		"lt1igcx5c0":    "invalid data character",
+		"in1muywd":      "too short checksum",
+		"mm1crxm3i":     "invalid character in checksum",
+		"au1s5cgom":     "invalid character in checksum",
+		"M1VUXWEZ":      "checksum calculated with uppercase form of prefix",
+		"16plkw9":       "empty prefix",
+		"1p2gdwpf":      "empty prefix",
+	}
+)
+
+func TestBech32mVectors(t *testing.T) {
+	for _, s := range validBech32m {
+		hrp, data, err := decodeBech32m(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if got := encodeBech32m(hrp, data); got != strings.ToLower(s) {
+			t.Errorf("%q re-encodes as %q", s, got)
+		}
+	}
+	for s, why := range invalidBech32m {
+		if _, _, err := decodeBech32m(s); err == nil {
+			t.Errorf("%q accepted (%s)", s, why)
+		}
+	}
+}
+
+func TestAddressRoundTrip(t *testing.T) {
+	const id = "7e7f00a5ac7f723600f7a3ef4e79e90ebd6360b2b5b6c05b4bc8f53f7ac5ba3b"
+	addr, err := EncodeAddress("dwt", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{addr, strings.ToUpper(addr)} {
+		if got, err := DecodeAddress("dwt", s); err != nil || got != id {
+			t.Errorf("DecodeAddress(%q) = %s, %v", s, got, err)
+		}
+	}
+
+	// one mistyped character
+	i := len(addr) - 10
+	typo := []byte(addr)
+	typo[i] = bech32Charset[(strings.IndexByte(bech32Charset, addr[i])+1)%32]
+	if _, err := DecodeAddress("dwt", string(typo)); !errors.Is(err, ErrAddressChecksum) {
+		t.Errorf("typo: %v, want ErrAddressChecksum", err)
+	}
+	if _, err := DecodeAddress("tdwt", addr); !errors.Is(err, ErrAddressNetwork) {
+		t.Errorf("other network: %v, want ErrAddressNetwork", err)
+	}
+	// a valid Bech32m string that is not an address
+	if _, err := DecodeAddress("a", "a1lqfn3a"); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("empty payload: %v, want ErrInvalidAddress", err)
+	}
+}
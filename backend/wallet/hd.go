@@ -0,0 +1,247 @@
+// Package wallet derives a user's Ed25519 wallet keys from a BIP-39
+// mnemonic with SLIP-10, so every account and address they hold can be
+// restored from twelve or twenty-four words. Keys never leave the client:
+// the node only learns the public keys and derivation paths it registers.
+package wallet
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/student/decentralized-wallet/internal/crypto"
+	"github.com/tyler-smith/go-bip39"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// Purpose and CoinType are the first two levels of every path, BIP-44
+	// style. The network has no registered SLIP-44 coin type, so it uses 1,
+	// "testnet (all coins)".
+	Purpose  = 44
+	CoinType = 1
+	// Hardened is added to an index for hardened derivation, written i' in paths.
+	Hardened uint32 = 0x80000000
+)
+
+var (
+	// ErrInvalidMnemonic is returned for a phrase with unknown words, the wrong length or a bad checksum.
+	ErrInvalidMnemonic = errors.New("invalid mnemonic")
+	// ErrInvalidPath is returned for a derivation path that does not parse.
+	ErrInvalidPath = errors.New("invalid derivation path")
+	// ErrNotHardened is returned for a non-hardened index: SLIP-10 Ed25519 only defines hardened children.
+	ErrNotHardened = errors.New("ed25519 keys support hardened derivation only")
+)
+
+// NewMnemonic returns a fresh mnemonic of words words: 12, 15, 18, 21 or 24.
+func NewMnemonic(words int) (string, error) {
+	if words < 12 || words > 24 || words%3 != 0 {
+		return "", fmt.Errorf("mnemonic must have 12, 15, 18, 21 or 24 words, not %d", words)
+	}
+	entropy, err := bip39.NewEntropy(words / 3 * 32)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// normalizeMnemonic lowercases the phrase and collapses its whitespace, so
+// a phrase typed back in with stray spaces or capitals still restores.
+func normalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
+
+// ValidateMnemonic checks the phrase's words and checksum.
+func ValidateMnemonic(mnemonic string) error {
+	if _, err := bip39.EntropyFromMnemonic(normalizeMnemonic(mnemonic)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMnemonic, err)
+	}
+	return nil
+}
+
+// NewSeed turns a mnemonic and optional passphrase into the 64-byte BIP-39
+// seed. A different passphrase gives a different, equally valid, wallet.
+func NewSeed(mnemonic, passphrase string) ([]byte, error) {
+	mnemonic = normalizeMnemonic(mnemonic)
+	if err := ValidateMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+	return bip39.NewSeed(mnemonic, norm.NFKD.String(passphrase)), nil
+}
+
+// ExtendedKey is a SLIP-10 Ed25519 private key with its chain code.
+type ExtendedKey struct {
+	key       [32]byte
+	chainCode [32]byte
+}
+
+// NewMasterKey derives the master key from a seed of 16 to 64 bytes.
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, fmt.Errorf("seed must be 16 to 64 bytes, not %d", len(seed))
+	}
+	return newExtendedKey([]byte("ed25519 seed"), seed), nil
+}
+
+func newExtendedKey(hmacKey, data []byte) *ExtendedKey {
+	mac := hmac.New(sha512.New, hmacKey)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+	k := &ExtendedKey{}
+	copy(k.key[:], sum[:32])
+	copy(k.chainCode[:], sum[32:])
+	return k
+}
+
+// Child derives the hardened child at index, which must include Hardened.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if index < Hardened {
+		return nil, fmt.Errorf("%w: index %d", ErrNotHardened, index)
+	}
+	data := make([]byte, 0, 1+32+4)
+	data = append(data, 0)
+	data = append(data, k.key[:]...)
+	data = binary.BigEndian.AppendUint32(data, index)
+	return newExtendedKey(k.chainCode[:], data), nil
+}
+
+// Derive follows path ("m/44'/1'/0'/0'/0'") down from k, which must be the master key.
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range indexes {
+		if k, err = k.Child(i); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// PrivateKey returns the Ed25519 private key; the 32-byte SLIP-10 key is its seed.
+func (k *ExtendedKey) PrivateKey() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(k.key[:])
+}
+
+// PublicKey returns the Ed25519 public key.
+func (k *ExtendedKey) PublicKey() ed25519.PublicKey {
+	return k.PrivateKey().Public().(ed25519.PublicKey)
+}
+
+// ChainCode returns a copy of the chain code.
+func (k *ExtendedKey) ChainCode() []byte {
+	return append([]byte(nil), k.chainCode[:]...)
+}
+
+// ParsePath parses a derivation path such as "m/44'/1'/0'/0'/3'". Every
+// level must be hardened, marked with ' or h.
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("%w: %q must start with m", ErrInvalidPath, path)
+	}
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		hardened := strings.HasSuffix(p, "'") || strings.HasSuffix(p, "h")
+		if hardened {
+			p = p[:len(p)-1]
+		}
+		n, err := strconv.ParseUint(p, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: bad level %q", ErrInvalidPath, path, p)
+		}
+		if !hardened {
+			return nil, fmt.Errorf("%w: %q: level %s", ErrNotHardened, path, p)
+		}
+		indexes = append(indexes, uint32(n)+Hardened)
+	}
+	return indexes, nil
+}
+
+// AddressPath returns the path of address index in account:
+// m/44'/1'/account'/0'/index'.
+func AddressPath(account, index uint32) string {
+	return fmt.Sprintf("m/%d'/%d'/%d'/0'/%d'", Purpose, CoinType, account, index)
+}
+
+// Wallet is the master key of a restored or newly created HD wallet.
+type Wallet struct {
+	master *ExtendedKey
+}
+
+// FromMnemonic restores the wallet a mnemonic and passphrase describe.
+func FromMnemonic(mnemonic, passphrase string) (*Wallet, error) {
+	seed, err := NewSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	return &Wallet{master: master}, nil
+}
+
+// Address is one derived key, with what the node needs to register it.
+type Address struct {
+	Account uint32 `json:"account"`
+	Index   uint32 `json:"index"`
+	Path    string `json:"path"`
+	// PublicKey is base64, as the node's APIs expect it.
+	PublicKey string `json:"public_key"`
+	WalletID  string `json:"wallet_id"`
+
+	key ed25519.PrivateKey
+}
+
+// PrivateKey returns the key that signs for the address.
+func (a *Address) PrivateKey() ed25519.PrivateKey {
+	return a.key
+}
+
+// Sign signs msg, e.g. a transaction's SigningBytes.
+func (a *Address) Sign(msg []byte) []byte {
+	return ed25519.Sign(a.key, msg)
+}
+
+// Address derives address index of account.
+func (w *Wallet) Address(account, index uint32) (*Address, error) {
+	if account >= Hardened || index >= Hardened {
+		return nil, fmt.Errorf("%w: account and index must be below 2^31", ErrInvalidPath)
+	}
+	path := AddressPath(account, index)
+	k, err := w.master.Derive(path)
+	if err != nil {
+		return nil, err
+	}
+	pub := base64.StdEncoding.EncodeToString(k.PublicKey())
+	return &Address{
+		Account:   account,
+		Index:     index,
+		Path:      path,
+		PublicKey: pub,
+		WalletID:  crypto.WalletIDFromPublicKey(pub),
+		key:       k.PrivateKey(),
+	}, nil
+}
+
+// Addresses derives count consecutive addresses of account starting at from,
+// e.g. to register a batch or to scan for used addresses on restore.
+func (w *Wallet) Addresses(account, from, count uint32) ([]*Address, error) {
+	res := make([]*Address, 0, count)
+	for i := uint32(0); i < count; i++ {
+		a, err := w.Address(account, from+i)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	return res, nil
+}
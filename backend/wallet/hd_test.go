@@ -0,0 +1,125 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// BIP-39 test vectors (Trezor's vectors.json), all with passphrase "TREZOR".
+var bip39Vectors = []struct {
+	entropy, mnemonic, seed string
+}{
+	{
+		"00000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+	},
+	{
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+	},
+	{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art",
+		"bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8",
+	},
+}
+
+func TestBIP39Vectors(t *testing.T) {
+	for _, v := range bip39Vectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+		if m, err := bip39.NewMnemonic(entropy); err != nil || m != v.mnemonic {
+			t.Errorf("mnemonic of %s = %q, %v", v.entropy, m, err)
+		}
+		seed, err := NewSeed(v.mnemonic, "TREZOR")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(seed); got != v.seed {
+			t.Errorf("seed of %q\n got %s\nwant %s", v.mnemonic, got, v.seed)
+		}
+		// typed back in with capitals and stray spaces, it is the same wallet
+		seed, err = NewSeed("  "+strings.ToUpper(strings.ReplaceAll(v.mnemonic, " ", "  "))+"\n", "TREZOR")
+		if err != nil || hex.EncodeToString(seed) != v.seed {
+			t.Errorf("normalized seed of %q differs (%v)", v.mnemonic, err)
+		}
+	}
+}
+
+func TestValidateMnemonicRejects(t *testing.T) {
+	for _, m := range []string{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon", // bad checksum
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",           // 11 words
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abuot",   // not a word
+	} {
+		if err := ValidateMnemonic(m); !errors.Is(err, ErrInvalidMnemonic) {
+			t.Errorf("ValidateMnemonic(%q) = %v", m, err)
+		}
+	}
+}
+
+// SLIP-10 test vector 1 for ed25519. Public keys carry SLIP-10's 00 prefix.
+func TestSLIP10Vectors(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		path, chainCode, private, public string
+	}{
+		{"m",
+			"90046a93de5380a72b5e45010748567d5ea02bbf6522f979e05c0d8d8ca9fffb",
+			"2b4be7f19ee27bbf30c667b642d5f4aa69fd169872f8fc3059c08ebae2eb19e7",
+			"00a4b2856bfec510abab89753fac1ac0e1112364e7d250545963f135f2a33188ed"},
+		{"m/0'",
+			"8b59aa11380b624e81507a27fedda59fea6d0b779a778918a2fd3590e16e9c69",
+			"68e0fe46dfb67e368c75379acec591dad19df3cde26e63b93a8e704f1dade7a3",
+			"008c8a13df77a28f3445213a0f432fde644acaa215fc72dcdf300d5efaa85d350c"},
+		{"m/0'/1'",
+			"a320425f77d1b5c2505a6b1b27382b37368ee640e3557c315416801243552f14",
+			"b1d0bad404bf35da785a64ca1ac54b2617211d2777696fbffaf208f746ae84f2",
+			"001932a5270f335bed617d5b935c80aedb1a35bd9fc1e31acafd5372c30f5c1187"},
+		{"m/0'/1'/2'",
+			"2e69929e00b5ab250f49c3fb1c12f252de4fed2c1db88387094a0f8c4c9ccd6c",
+			"92a5b23c0b8a99e37d07df3fb9966917f5d06e02ddbd909c7e184371463e9fc9",
+			"00ae98736566d30ed0e9d2f4486a64bc95740d89c7db33f52121f8ea8f76ff0fc1"},
+		{"m/0'/1'/2'/2'",
+			"8f6d87f93d750e0efccda017d662a1b31a266e4a6f5993b15f5c1f07f74dd5cc",
+			"30d1dc7e5fc04c31219ab25a27ae00b50f6fd66622f6e9c913253d6511d1e662",
+			"008abae2d66361c879b900d204ad2cc4984fa2aa344dd7ddc46007329ac76c429c"},
+		{"m/0'/1'/2'/2'/1000000000'",
+			"68789923a0cac2cd5a29172a475fe9e0fb14cd6adb5ad98a3fa70333e7afa230",
+			"8f94d394a8e8fd6b1bc2f3f49f5c47e385281d5c17e65324b0f62483e37e8793",
+			"003c24da049451555d51a7014a37337aa4e12d41e485abccfa46b47dfb2af54b7a"},
+	} {
+		k, err := master.Derive(v.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(k.ChainCode()); got != v.chainCode {
+			t.Errorf("%s chain code %s, want %s", v.path, got, v.chainCode)
+		}
+		if got := hex.EncodeToString(k.PrivateKey().Seed()); got != v.private {
+			t.Errorf("%s private key %s, want %s", v.path, got, v.private)
+		}
+		if got := "00" + hex.EncodeToString(k.PublicKey()); got != v.public {
+			t.Errorf("%s public key %s, want %s", v.path, got, v.public)
+		}
+	}
+}
+
+func TestDeriveRejectsNonHardened(t *testing.T) {
+	master, err := NewMasterKey(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := master.Derive("m/0'/1"); !errors.Is(err, ErrNotHardened) {
+		t.Errorf("Derive(m/0'/1) = %v, want ErrNotHardened", err)
+	}
+}
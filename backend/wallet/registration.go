@@ -0,0 +1,21 @@
+package wallet
+
+import "encoding/base64"
+
+// RegistrationMessage is what a key signs to prove its holder is the one
+// registering it: the challenge nonce the node issued, bound to the network,
+// the user and the key itself, so a signature made for one registration is
+// useless for any other.
+func RegistrationMessage(chainID, uid, nonce, publicKey string) []byte {
+	return []byte("dwallet wallet registration\n" +
+		"chain: " + chainID + "\n" +
+		"user: " + uid + "\n" +
+		"nonce: " + nonce + "\n" +
+		"key: " + publicKey)
+}
+
+// SignRegistration answers a registration challenge for the address's key
+// and returns the base64 signature the node expects.
+func (a *Address) SignRegistration(chainID, uid, nonce string) string {
+	return base64.StdEncoding.EncodeToString(a.Sign(RegistrationMessage(chainID, uid, nonce, a.PublicKey)))
+}
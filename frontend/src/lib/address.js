@@ -0,0 +1,102 @@
+// Wallet addresses. Must match backend/wallet/address.go:
+//
+//   Bech32m (BIP-350) of the network prefix (e.g. "dwt", from /api/status
+//   address_prefix), the separator "1", version 0 and the 32-byte wallet ID.
+//
+// Users type and share addresses; transactions are still signed over the hex
+// wallet IDs the addresses decode to.
+
+export const ADDRESS_VERSION = 0
+
+const CHARSET = 'qpzry9x8gf2tvdw0s3jn54khce6mua7l'
+const BECH32M_CONST = 0x2bc830a3
+const MAX_LENGTH = 90
+const CHECKSUM_LENGTH = 6
+
+function polymod(values) {
+  const gen = [0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3]
+  let chk = 1
+  for (const v of values) {
+    const top = chk >>> 25
+    chk = (((chk & 0x1ffffff) << 5) ^ v) >>> 0
+    for (let i = 0; i < 5; i++) {
+      if ((top >>> i) & 1) chk = (chk ^ gen[i]) >>> 0
+    }
+  }
+  return chk
+}
+
+function hrpExpand(hrp) {
+  const res = []
+  for (let i = 0; i < hrp.length; i++) res.push(hrp.charCodeAt(i) >> 5)
+  res.push(0)
+  for (let i = 0; i < hrp.length; i++) res.push(hrp.charCodeAt(i) & 31)
+  return res
+}
+
+function checksum(hrp, data) {
+  const mod = polymod([...hrpExpand(hrp), ...data, 0, 0, 0, 0, 0, 0]) ^ BECH32M_CONST
+  const res = []
+  for (let i = 0; i < CHECKSUM_LENGTH; i++) res.push((mod >>> (5 * (5 - i))) & 31)
+  return res
+}
+
+// convertBits regroups fromBits-bit values into toBits-bit values; when
+// decoding (pad false) leftover bits must be zero padding, else it returns null.
+function convertBits(data, fromBits, toBits, pad) {
+  let acc = 0
+  let bits = 0
+  const maxv = (1 << toBits) - 1
+  const res = []
+  for (const v of data) {
+    acc = ((acc << fromBits) | v) & 0xffffff
+    bits += fromBits
+    while (bits >= toBits) {
+      bits -= toBits
+      res.push((acc >> bits) & maxv)
+    }
+  }
+  if (pad) {
+    if (bits > 0) res.push((acc << (toBits - bits)) & maxv)
+  } else if (bits >= fromBits || ((acc << (toBits - bits)) & maxv) !== 0) {
+    return null
+  }
+  return res
+}
+
+// encodeAddress writes a hex wallet ID as an address with the given prefix.
+export function encodeAddress(prefix, walletId) {
+  if (!/^[0-9a-f]{64}$/i.test(walletId || '')) throw new Error('wallet ID must be 64 hex characters')
+  const bytes = walletId.match(/../g).map(h => parseInt(h, 16))
+  const data = [ADDRESS_VERSION, ...convertBits(bytes, 8, 5, true)]
+  return prefix + '1' + [...data, ...checksum(prefix, data)].map(v => CHARSET[v]).join('')
+}
+
+// decodeAddress checks an address against the network prefix and its checksum
+// and returns the hex wallet ID it carries. It throws on anything invalid.
+export function decodeAddress(prefix, address) {
+  let addr = (address || '').trim()
+  if (addr.length > MAX_LENGTH) throw new Error('invalid address: too long')
+  if (addr.toLowerCase() !== addr && addr.toUpperCase() !== addr) throw new Error('invalid address: mixed case')
+  addr = addr.toLowerCase()
+  const sep = addr.lastIndexOf('1')
+  if (sep < 1 || addr.length - sep - 1 < CHECKSUM_LENGTH + 1) {
+    throw new Error('invalid address: missing prefix, separator or checksum')
+  }
+  const hrp = addr.slice(0, sep)
+  const data = []
+  for (const c of addr.slice(sep + 1)) {
+    const v = CHARSET.indexOf(c)
+    if (v < 0) throw new Error(`invalid address: character "${c}" not allowed`)
+    data.push(v)
+  }
+  if (polymod([...hrpExpand(hrp), ...data]) !== BECH32M_CONST) {
+    throw new Error('address checksum mismatch: check it for typos')
+  }
+  if (hrp !== prefix) throw new Error(`address is for another network: prefix "${hrp}", this network uses "${prefix}"`)
+  const payload = data.slice(0, -CHECKSUM_LENGTH)
+  if (payload[0] !== ADDRESS_VERSION) throw new Error(`invalid address: unknown version ${payload[0]}`)
+  const id = convertBits(payload.slice(1), 5, 8, false)
+  if (!id || id.length !== 32) throw new Error('invalid address: payload is not a 32-byte wallet ID')
+  return id.map(b => b.toString(16).padStart(2, '0')).join('')
+}
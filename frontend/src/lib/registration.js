@@ -0,0 +1,14 @@
+// Wallet registration proof. Must produce exactly the same bytes as
+// backend/wallet/registration.go (RegistrationMessage): the key being
+// registered signs the node's challenge nonce, bound to the chain, the
+// user and the key itself.
+
+export function registrationMessage(chainId, uid, nonce, publicKey) {
+  return new TextEncoder().encode(
+    'dwallet wallet registration\n' +
+      'chain: ' + chainId + '\n' +
+      'user: ' + uid + '\n' +
+      'nonce: ' + nonce + '\n' +
+      'key: ' + publicKey
+  )
+}
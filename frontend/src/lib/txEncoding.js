@@ -0,0 +1,101 @@
+// Canonical transaction encoding. Must produce exactly the same bytes as
+// backend/internal/utxo/encoding.go (Transaction.SigningBytes):
+//
+//   "DWTX" magic, version byte, then chain_id, sender, sender_public_key,
+//   nonce (u64), inputs (u32 count + strings), outputs (u32 count + (recipient, int64 amount)),
+//   note, timestamp. Strings are u32 byte length + UTF-8. Integers are big-endian.
+//   Only when an output has a spending condition: u32 count, then for each
+//   (u32 output index, condition), a condition being a kind byte followed by
+//   i64 (after 1, after_time 2, older 3), string (hash 4, signer 5) or
+//   u32 count + conditions (all 6, any 7).
+//
+// The txid is the hex SHA-256 of these bytes and the Ed25519 signature is made
+// over them, so it commits to every input and output including change, the
+// chain ID and the sender's nonce (replay protection).
+
+export const TX_ENCODING_VERSION = 2
+
+const MAGIC = [0x44, 0x57, 0x54, 0x58] // "DWTX"
+
+const CONDITION_KINDS = ['after', 'after_time', 'older', 'hash', 'signer', 'all', 'any']
+
+export function encodeTransaction(tx) {
+  const enc = new TextEncoder()
+  const parts = []
+  let size = 0
+  const push = (bytes) => {
+    parts.push(bytes)
+    size += bytes.length
+  }
+  const u32 = (v) => {
+    const b = new Uint8Array(4)
+    new DataView(b.buffer).setUint32(0, v, false)
+    push(b)
+  }
+  const u64 = (v) => {
+    const b = new Uint8Array(8)
+    new DataView(b.buffer).setBigUint64(0, BigInt(v || 0), false)
+    push(b)
+  }
+  const i64 = (v) => {
+    const b = new Uint8Array(8)
+    new DataView(b.buffer).setBigInt64(0, BigInt(v), false)
+    push(b)
+  }
+  const str = (s) => {
+    const b = enc.encode(s || '')
+    u32(b.length)
+    push(b)
+  }
+
+  push(new Uint8Array(MAGIC))
+  push(new Uint8Array([TX_ENCODING_VERSION]))
+  str(tx.chain_id)
+  str(tx.sender)
+  str(tx.sender_public_key)
+  u64(tx.nonce)
+  const inputs = tx.inputs || []
+  u32(inputs.length)
+  inputs.forEach(str)
+  const outputs = tx.outputs || []
+  u32(outputs.length)
+  for (const o of outputs) {
+    str(o.recipient)
+    i64(o.amount)
+  }
+  str(tx.note)
+  str(tx.timestamp)
+  const cond = (c) => {
+    const kind = CONDITION_KINDS.find(k => c[k] !== undefined && c[k] !== null)
+    push(new Uint8Array([CONDITION_KINDS.indexOf(kind) + 1]))
+    if (kind === 'hash' || kind === 'signer') {
+      str(c[kind])
+    } else if (kind === 'all' || kind === 'any') {
+      u32(c[kind].length)
+      c[kind].forEach(cond)
+    } else {
+      i64(c[kind])
+    }
+  }
+  const conditioned = outputs.map((o, i) => [i, o.condition]).filter(([, c]) => c)
+  if (conditioned.length > 0) {
+    u32(conditioned.length)
+    for (const [i, c] of conditioned) {
+      u32(i)
+      cond(c)
+    }
+  }
+
+  const out = new Uint8Array(size)
+  let off = 0
+  for (const p of parts) {
+    out.set(p, off)
+    off += p.length
+  }
+  return out
+}
+
+export async function computeTxId(tx) {
+  const hash = await crypto.subtle.digest('SHA-256', encodeTransaction(tx))
+  return Array.from(new Uint8Array(hash)).map(b => b.toString(16).padStart(2, '0')).join('')
+}
@@ -0,0 +1,18 @@
+// Run with `npm test`. The vectors are shared with the backend's encoder
+// (backend/internal/utxo/encoding_test.go), so both must produce these bytes.
+import { test } from 'node:test'
+import assert from 'node:assert/strict'
+import { readFileSync } from 'node:fs'
+import { encodeTransaction, computeTxId } from './txEncoding.js'
+
+const vectors = JSON.parse(readFileSync(
+  new URL('../../../backend/internal/utxo/testdata/tx_vectors.json', import.meta.url), 'utf8'))
+
+const hex = (bytes) => Array.from(bytes).map(b => b.toString(16).padStart(2, '0')).join('')
+
+for (const v of vectors) {
+  test(v.name, async () => {
+    assert.equal(hex(encodeTransaction(v.tx)), v.encoding)
+    assert.equal(await computeTxId(v.tx), v.txid)
+  })
+}
@@ -14,7 +14,10 @@ export default function Admin() {
   const [fundStatus, setFundStatus] = useState('')
   
   // Mine State
+  const [minerWallet, setMinerWallet] = useState('')
   const [mineStatus, setMineStatus] = useState('')
+  const [minerInterval, setMinerInterval] = useState('')
+  const [minerState, setMinerState] = useState(null)
   
   // Validate State
   const [validateStatus, setValidateStatus] = useState('')
@@ -52,21 +55,35 @@ export default function Admin() {
   const mine = async () => {
     setMineStatus('Mining pending transactions...')
     try {
-      const result = await callApi('/api/admin/mine', { method: 'POST', body: JSON.stringify({}) })
+      // empty miner_wallet falls back to the node's MINER_WALLET
+      const result = await callApi('/api/admin/mine', { method: 'POST', body: JSON.stringify({ miner_wallet: minerWallet.trim() }) })
       setMineStatus('✓ Block mined: ' + JSON.stringify(result))
     } catch (e) {
       setMineStatus('✗ Error: ' + String(e))
     }
   }
 
+  // background miner: start/stop and poll its status
+  const minerAction = async (action) => {
+    try {
+      const opts = action === 'status'
+        ? {}
+        : { method: 'POST', body: JSON.stringify({ miner_wallet: minerWallet.trim(), interval_seconds: Number(minerInterval) || 0 }) }
+      setMinerState(await callApi('/api/admin/miner/' + action, opts))
+    } catch (e) {
+      setMineStatus('✗ Error: ' + String(e))
+    }
+  }
+
   const validateChain = async () => {
     setValidateStatus('Validating blockchain...')
     try {
       const result = await callApi('/api/admin/validate_chain', { method: 'POST', body: JSON.stringify({}) })
       if (result.ok) {
-        setValidateStatus('✓ Blockchain is valid')
+        setValidateStatus('✓ Blockchain is valid (' + result.blocks_checked + ' blocks, ' + result.txs_checked + ' txs)')
       } else {
-        setValidateStatus('✗ Blockchain has issues: ' + result.problems.join(', '))
+        const problems = (result.problems || []).map(p => (p.tx_id ? 'tx ' + p.tx_id.substring(0, 8) + ': ' : '') + p.reason)
+        setValidateStatus('✗ Blockchain invalid at height ' + result.first_invalid_height + ': ' + problems.join(', '))
       }
     } catch (e) {
       setValidateStatus('✗ Error: ' + String(e))
@@ -193,9 +210,20 @@ export default function Admin() {
                 
                 <div className="bg-blue-50 border border-blue-200 rounded-lg p-4">
                   <p className="text-blue-900 text-sm">
-                    <strong>Process:</strong> Gathers pending transactions → Calculates Merkle root → Solves PoW puzzle → Creates block → Moves transactions to confirmed
+                    <strong>Process:</strong> Gathers pending transactions → Adds coinbase (subsidy + fees) → Calculates Merkle root → Solves PoW puzzle → Creates block → Moves transactions to confirmed
                   </p>
                 </div>
+
+                <div>
+                  <label className="block text-sm font-medium text-slate-700 mb-1">Miner Wallet ID (reward recipient)</label>
+                  <input
+                    type="text"
+                    value={minerWallet}
+                    onChange={e => setMinerWallet(e.target.value)}
+                    placeholder="Leave empty to use the node's MINER_WALLET"
+                    className="w-full px-3 py-2 border border-slate-300 rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500"
+                  />
+                </div>
                 
                 <button
                   onClick={mine}
@@ -211,6 +239,33 @@ export default function Admin() {
                     {mineStatus}
                   </div>
                 )}
+
+                <div className="border-t border-slate-200 pt-4 space-y-3">
+                  <h3 className="font-semibold text-slate-900">Background Miner</h3>
+                  <p className="text-slate-600 text-sm">Mines whenever transactions arrive, and every interval (even empty blocks) if one is set.</p>
+                  <input
+                    type="number"
+                    min="0"
+                    value={minerInterval}
+                    onChange={e => setMinerInterval(e.target.value)}
+                    placeholder="Interval in seconds (0 = only when txs arrive)"
+                    className="w-full px-3 py-2 border border-slate-300 rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500"
+                  />
+                  <div className="flex gap-2">
+                    <button onClick={() => minerAction('start')} className="px-4 py-2 bg-green-600 text-white rounded-lg hover:bg-green-700 transition">Start</button>
+                    <button onClick={() => minerAction('stop')} className="px-4 py-2 bg-red-600 text-white rounded-lg hover:bg-red-700 transition">Stop</button>
+                    <button onClick={() => minerAction('status')} className="px-4 py-2 bg-slate-200 text-slate-800 rounded-lg hover:bg-slate-300 transition">Refresh</button>
+                  </div>
+                  {minerState && (
+                    <div className="p-3 rounded-lg bg-slate-50 border border-slate-200 text-sm font-mono space-y-1">
+                      <div>{minerState.running ? '● running' : '○ stopped'} · {Math.round(minerState.hash_rate)} H/s · {minerState.blocks_mined} blocks</div>
+                      {minerState.template && (
+                        <div>mining #{minerState.template.height} ({minerState.template.tx_count} txs, reward {minerState.template.reward})</div>
+                      )}
+                      {minerState.last_error && <div className="text-red-700">{minerState.last_error}</div>}
+                    </div>
+                  )}
+                </div>
               </div>
             )}
 
@@ -53,10 +53,23 @@ export default function BlockExplorer() {
               <div><strong>Hash:</strong> {selected.hash}</div>
               <div><strong>Previous:</strong> {selected.previous_hash}</div>
               <div><strong>Merkle Root:</strong> {selected.merkle_root}</div>
+              <div><strong>Nonce:</strong> {selected.nonce} · <strong>Target bits:</strong> {Number(selected.bits).toString(16).padStart(8, '0')} · <strong>Version:</strong> {selected.version}</div>
               <div className="mt-2"><strong>Transactions:</strong>
                 <ul className="mt-1 list-disc pl-6">
                   {(selected.transactions || []).map((t, i) => (
-                    <li key={i}>{String(t)}</li>
+                    <li key={t.id || i}>
+                      <div className="font-mono text-xs">{t.id}</div>
+                      <div className="text-xs text-slate-600">
+                        {t.sender === 'coinbase' ? 'Coinbase (block reward)' : <>From: {t.sender} · Fee: {t.fee || 0} units</>}
+                      </div>
+                      <ul className="text-xs text-slate-600 pl-4">
+                        {(t.outputs || []).map((o, j) => (
+                          <li key={j}>
+                            #{j} → {o.recipient}: {o.amount} units{o.recipient === t.sender ? ' (change)' : ''}
+                          </li>
+                        ))}
+                      </ul>
+                    </li>
                   ))}
                 </ul>
               </div>
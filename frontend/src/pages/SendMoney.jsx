@@ -5,13 +5,19 @@ import useApi from '../hooks/useApi'
 import useEncryption from '../hooks/useEncryption'
 import UnlockWallet from '../components/UnlockWallet'
 import Spinner from '../components/Spinner'
+import { encodeTransaction } from '../lib/txEncoding'
+import { encodeAddress, decodeAddress } from '../lib/address'
 
 export default function SendMoney() {
   const [walletId, setWalletId] = useState('')
+  const [addressPrefix, setAddressPrefix] = useState('')
   const [utxos, setUtxos] = useState([])
   const [receiver, setReceiver] = useState('')
   const [amount, setAmount] = useState('')
+  // additional recipients for batch payments / bill splitting
+  const [extraRecipients, setExtraRecipients] = useState([])
   const [note, setNote] = useState('')
+  const [fee, setFee] = useState('')
   const [status, setStatus] = useState('')
   const [showUnlock, setShowUnlock] = useState(false)
   const [decryptedKey, setDecryptedKey] = useState('')
@@ -29,10 +35,12 @@ export default function SendMoney() {
       const hashArray = Array.from(new Uint8Array(hashBuffer))
       const hex = hashArray.map(b => b.toString(16).padStart(2, '0')).join('')
       setWalletId(hex)
-      // fetch utxos
+      // fetch utxos and the network's address prefix
       try {
         const j = await callApi('/api/wallets/' + hex)
         setUtxos(j.utxos || [])
+        const node = await callApi('/api/status')
+        setAddressPrefix(node.address_prefix)
       } catch (e) {
         console.error('Failed to fetch UTXOs:', e)
       }
@@ -67,42 +75,79 @@ export default function SendMoney() {
 
       const privateKey = naclUtil.decodeBase64(privB64)
       const timestamp = new Date().toISOString()
-      const amt = parseInt(amount, 10)
+      // replay protection: bind to this node's chain and the wallet's next nonce
+      const node = await callApi('/api/status')
+      const wallet = await callApi('/api/wallets/' + walletId)
+      const chainId = node.chain_id
+      const nonce = wallet.next_nonce
 
-      if (isNaN(amt) || amt <= 0) throw new Error('Amount must be a positive number')
-      if (!receiver.trim()) throw new Error('Receiver wallet ID is required')
-
-      const payload = [walletId, receiver, String(amt), timestamp, note].join('|')
-      const msg = naclUtil.decodeUTF8(payload)
-      const sig = nacl.sign.detached(msg, privateKey)
-      const sigB64 = naclUtil.encodeBase64(sig)
+      // requested outputs, in the order entered, paid to addresses
+      const payments = [{ recipient: receiver, amount }, ...extraRecipients].map(r => ({
+        recipient: r.recipient.trim(),
+        amount: parseInt(r.amount, 10),
+      }))
+      for (const p of payments) {
+        if (isNaN(p.amount) || p.amount <= 0) throw new Error('Amount must be a positive number')
+        if (!p.recipient) throw new Error('Receiver address is required')
+      }
+      // the signature covers the wallet IDs the addresses decode to; a typo fails here
+      const signedPayments = payments.map(p => ({
+        recipient: decodeAddress(node.address_prefix, p.recipient),
+        amount: p.amount,
+      }))
+      const feeAmt = fee.trim() ? parseInt(fee, 10) : 0
+      if (isNaN(feeAmt) || feeAmt < 0) throw new Error('Fee must be zero or a positive number')
+      const amt = payments.reduce((sum, p) => sum + p.amount, 0) + feeAmt
 
-      // pick inputs: simple greedy selection
+      // pick inputs: simple greedy selection, leaving outputs with spending conditions alone
       let total = 0
       const inputs = []
       for (const u of utxos) {
+        if (u.condition) continue
         inputs.push(u.id)
         total += u.amount
         if (total >= amt) break
       }
       if (total < amt) throw new Error('Insufficient funds')
 
+      // outputs mirror the server: recipients in order, then change (minus fee) back to us
+      const outputs = [...signedPayments]
+      if (total > amt) outputs.push({ recipient: walletId, amount: total - amt })
+
+      const senderPublicKey = localStorage.getItem('wallet_public_key')
+      const msg = encodeTransaction({
+        chain_id: chainId,
+        nonce,
+        sender: walletId,
+        sender_public_key: senderPublicKey,
+        inputs,
+        outputs,
+        note,
+        timestamp,
+      })
+      const sig = nacl.sign.detached(msg, privateKey)
+      const sigB64 = naclUtil.encodeBase64(sig)
+
       const body = {
         sender: walletId,
-        receiver,
-        amount: amt,
+        outputs: payments,
+        fee: feeAmt,
         note,
         timestamp,
-        sender_public_key: localStorage.getItem('wallet_public_key'),
+        sender_public_key: senderPublicKey,
         signature: sigB64,
         inputs,
+        chain_id: chainId,
+        nonce,
       }
 
       const j = await callApi('/api/tx/send', { method: 'POST', body: JSON.stringify(body) })
       setStatus('✓ Transaction submitted: ' + j.tx_id)
       setReceiver('')
       setAmount('')
+      setExtraRecipients([])
       setNote('')
+      setFee('')
       setDecryptedKey('') // Clear decrypted key after use
     } catch (e) {
       setStatus('❌ Error: ' + String(e))
@@ -111,6 +156,11 @@ export default function SendMoney() {
 
   const balance = utxos.reduce((sum, u) => sum + u.amount, 0)
 
+  const updateExtra = (i, field, value) => {
+    setExtraRecipients(list => list.map((r, j) => (j === i ? { ...r, [field]: value } : r)))
+    setStatus('')
+  }
+
   return (
     <div className="max-w-2xl mx-auto">
       {showUnlock && (
@@ -155,8 +205,10 @@ export default function SendMoney() {
               <div className="bg-slate-50 border border-slate-200 rounded-lg p-4">
                 <div className="grid grid-cols-2 gap-4">
                   <div>
-                    <p className="text-xs text-slate-600 font-medium">From Wallet ID</p>
-                    <p className="text-sm font-mono mt-1">{walletId.substring(0, 16)}...</p>
+                    <p className="text-xs text-slate-600 font-medium">Your Address</p>
+                    <p className="text-sm font-mono mt-1 break-all">
+                      {addressPrefix ? encodeAddress(addressPrefix, walletId) : walletId.substring(0, 16) + '...'}
+                    </p>
                   </div>
                   <div>
                     <p className="text-xs text-slate-600 font-medium">Balance</p>
@@ -168,7 +220,7 @@ export default function SendMoney() {
               {/* Transaction Form */}
               <div className="space-y-4">
                 <div>
-                  <label className="block text-sm font-semibold text-slate-700 mb-2">Receiver Wallet ID *</label>
+                  <label className="block text-sm font-semibold text-slate-700 mb-2">Receiver Address *</label>
                   <input
                     type="text"
                     className="w-full px-4 py-2 border border-slate-300 rounded-lg focus:ring-2 focus:ring-purple-500 focus:border-transparent transition"
@@ -177,7 +229,7 @@ export default function SendMoney() {
                       setReceiver(e.target.value)
                       setStatus('')
                     }}
-                    placeholder="Enter receiver's wallet ID"
+                    placeholder={`Enter receiver's address (${addressPrefix || 'dwt'}1...)`}
                   />
                 </div>
 
@@ -196,6 +248,58 @@ export default function SendMoney() {
                   />
                 </div>
 
+                {extraRecipients.map((r, i) => (
+                  <div key={i} className="flex gap-2 items-end">
+                    <div className="flex-1">
+                      <label className="block text-sm font-semibold text-slate-700 mb-2">Recipient {i + 2}</label>
+                      <input
+                        type="text"
+                        className="w-full px-4 py-2 border border-slate-300 rounded-lg focus:ring-2 focus:ring-purple-500 focus:border-transparent transition"
+                        value={r.recipient}
+                        onChange={e => updateExtra(i, 'recipient', e.target.value)}
+                        placeholder="Address"
+                      />
+                    </div>
+                    <div className="w-32">
+                      <input
+                        type="number"
+                        className="w-full px-4 py-2 border border-slate-300 rounded-lg focus:ring-2 focus:ring-purple-500 focus:border-transparent transition"
+                        value={r.amount}
+                        onChange={e => updateExtra(i, 'amount', e.target.value)}
+                        placeholder="Amount"
+                        min="1"
+                      />
+                    </div>
+                    <button
+                      type="button"
+                      onClick={() => setExtraRecipients(list => list.filter((_, j) => j !== i))}
+                      className="px-3 py-2 text-sm text-red-600 hover:bg-red-50 rounded-lg"
+                    >
+                      Remove
+                    </button>
+                  </div>
+                ))}
+
+                <button
+                  type="button"
+                  onClick={() => setExtraRecipients(list => [...list, { recipient: '', amount: '' }])}
+                  className="text-sm text-purple-600 hover:text-purple-700 font-semibold"
+                >
+                  + Add recipient
+                </button>
+
+                <div>
+                  <label className="block text-sm font-semibold text-slate-700 mb-2">Fee (optional)</label>
+                  <input
+                    type="number"
+                    className="w-full px-4 py-2 border border-slate-300 rounded-lg focus:ring-2 focus:ring-purple-500 focus:border-transparent transition"
+                    value={fee}
+                    onChange={e => setFee(e.target.value)}
+                    placeholder="Paid to the miner; higher fees confirm sooner"
+                    min="0"
+                  />
+                </div>
+
                 <div>
                   <label className="block text-sm font-semibold text-slate-700 mb-2">Note (optional)</label>
                   <input
@@ -243,7 +347,7 @@ export default function SendMoney() {
                   <div key={u.id} className="bg-slate-50 p-3 rounded border border-slate-200 text-xs">
                     <div className="flex justify-between items-center">
                       <span className="font-mono">{u.id.substring(0, 16)}...</span>
-                      <span className="font-semibold text-green-600">{u.amount} units</span>
+                      <span className="font-semibold text-green-600">{u.amount} units{u.condition ? ' (locked)' : ''}</span>
                     </div>
                   </div>
                 ))}
@@ -256,7 +360,7 @@ export default function SendMoney() {
       {/* Info Box */}
       <div className="mt-6 bg-amber-50 border border-amber-200 rounded-lg p-4">
         <p className="text-sm text-amber-900">
-          <span className="font-semibold">⚠️ Important:</span> Once a transaction is sent, it cannot be reversed. Double-check the receiver's wallet ID and amount before confirming.
+          <span className="font-semibold">⚠️ Important:</span> Once a transaction is sent, it cannot be reversed. Double-check the receiver's address and amount before confirming.
         </p>
       </div>
     </div>
@@ -5,6 +5,7 @@ import useApi from '../hooks/useApi'
 import useEncryption from '../hooks/useEncryption'
 import Spinner from '../components/Spinner'
 import { COLOR_PRIMARY } from '../config'
+import { registrationMessage } from '../lib/registration'
 
 export default function WalletGen() {
   const [pub, setPub] = useState('')
@@ -94,12 +95,19 @@ export default function WalletGen() {
     }
     setStatus('Registering wallet...')
     try {
-      const j = await callApi('/api/wallets/register', { method: 'POST', body: JSON.stringify({ public_key: pub, wallet_id: walletId }) })
+      // prove we hold the key: sign the node's one-time challenge with it
+      const ch = await callApi('/api/wallets/challenge', { method: 'POST' })
+      const msg = registrationMessage(ch.chain_id, ch.user_id, ch.nonce, pub)
+      const signature = naclUtil.encodeBase64(nacl.sign.detached(msg, naclUtil.decodeBase64(priv)))
+      const j = await callApi('/api/wallets/register', {
+        method: 'POST',
+        body: JSON.stringify({ public_key: pub, wallet_id: walletId, nonce: ch.nonce, signature }),
+      })
       // Verify the wallet ID matches what backend returns
       if (j.wallet_id !== walletId) {
         console.warn('Wallet ID mismatch:', walletId, 'vs', j.wallet_id)
       }
-      setStatus('✓ Wallet registered successfully with ID: ' + j.wallet_id.slice(0, 12) + '...')
+      setStatus('✓ Wallet registered. Receive payments at ' + (j.address || j.wallet_id))
     } catch (e) {
       setStatus('Failed: ' + String(e))
     }